@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/criticalstack/e2d/pkg/client"
+	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
+)
+
+// clientOptions are the options used by commands that connect to the gRPC
+// service of a running e2d instance. The certificates are the same peer
+// certificates provided to run, since they have client auth key usage.
+type clientOptions struct {
+	ClientAddr string        `env:"E2D_CLIENT_ADDR"`
+	CACert     string        `env:"E2D_CA_CERT"`
+	PeerCert   string        `env:"E2D_PEER_CERT"`
+	PeerKey    string        `env:"E2D_PEER_KEY"`
+	Timeout    time.Duration `env:"E2D_CLIENT_TIMEOUT"`
+}
+
+// maintenanceTimeout is the default timeout of commands for maintenance
+// operations, which allows for the snapshot the instance takes first when run
+// with --snapshot-before-maintenance.
+const maintenanceTimeout = 10 * time.Minute
+
+// addFlags adds the flags for connecting to the e2d instance. The timeout
+// defaults to 10s, unless o.Timeout is already set.
+func (o *clientOptions) addFlags(fs *pflag.FlagSet) {
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	fs.StringVar(&o.ClientAddr, "client-addr", "127.0.0.1:2379", "etcd client address of the e2d instance")
+	fs.StringVar(&o.CACert, "ca-cert", "", "etcd trusted ca certificate")
+	fs.StringVar(&o.PeerCert, "peer-cert", "", "etcd peer certificate")
+	fs.StringVar(&o.PeerKey, "peer-key", "", "etcd peer private key")
+	fs.DurationVar(&o.Timeout, "timeout", timeout, "timeout for requests to the e2d instance")
+}
+
+// newManagerClient connects to the Manager gRPC service of an e2d instance.
+// The returned connection must be closed by the caller.
+func newManagerClient(ctx context.Context, o *clientOptions) (e2dpb.ManagerClient, *grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithBlock()}
+	sc := client.SecurityConfig{
+		CertFile:      o.PeerCert,
+		KeyFile:       o.PeerKey,
+		TrustedCAFile: o.CACert,
+	}
+	if sc.Enabled() {
+		tlsConfig, err := sc.TLSInfo().ClientConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, o.ClientAddr, opts...)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot connect to %s", o.ClientAddr)
+	}
+	return e2dpb.NewManagerClient(conn), conn, nil
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/cmdutil"
+	"github.com/criticalstack/e2d/pkg/log"
+)
+
+func newLeaveCmd() *cobra.Command {
+	o := &clientOptions{Timeout: maintenanceTimeout}
+
+	cmd := &cobra.Command{
+		Use:   "leave",
+		Short: "gracefully remove a running e2d instance from the cluster",
+		Long: `Gracefully remove a running e2d instance from the cluster. Leadership is
+transferred if the instance is the leader, the member is removed from the etcd
+cluster and peers are notified over gossip that the member is leaving, so it is
+not treated as a failure. The instance then shuts down.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			c, conn, err := newManagerClient(ctx, o)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			defer conn.Close()
+
+			ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+			defer cancel()
+
+			resp, err := c.Leave(ctx, &types.Empty{})
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			fmt.Println(resp.Msg)
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	if err := cmdutil.SetEnvs(o); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+
+	return cmd
+}
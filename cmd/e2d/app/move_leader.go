@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/cmdutil"
+	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
+)
+
+func newMoveLeaderCmd() *cobra.Command {
+	o := &clientOptions{Timeout: maintenanceTimeout}
+
+	cmd := &cobra.Command{
+		Use:   "move-leader [name]",
+		Short: "transfer leadership of the cluster to another member",
+		Long: `Transfer leadership of the cluster to the member with the given name. When no
+name is given, the healthiest running member is chosen. The request can be sent
+to any member of the cluster and is forwarded to the current leader.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			req := &e2dpb.MoveLeaderRequest{}
+			if len(args) > 0 {
+				req.Name = args[0]
+			}
+
+			ctx := context.Background()
+			c, conn, err := newManagerClient(ctx, o)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			defer conn.Close()
+
+			ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+			defer cancel()
+
+			resp, err := c.MoveLeader(ctx, req)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			fmt.Printf("leadership moved from %s to %s\n", resp.PreviousLeader, resp.Leader)
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	if err := cmdutil.SetEnvs(o); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+
+	return cmd
+}
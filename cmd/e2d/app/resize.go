@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/cmdutil"
+	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
+)
+
+func newResizeCmd() *cobra.Command {
+	o := &clientOptions{Timeout: maintenanceTimeout}
+
+	cmd := &cobra.Command{
+		Use:   "resize <cluster-size>",
+		Short: "change the required cluster size of a running cluster",
+		Long: `Change the required cluster size of a running multi-node cluster. The size
+can only be changed by 2 members at a time, and is stored in the cluster-info
+and broadcast to all peers, so members do not need to be reconfigured with the
+new size before they are next restarted. Growing the cluster only raises the
+number of members it expects, new nodes must still be started to join it.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			size, err := strconv.Atoi(args[0])
+			if err != nil {
+				log.Fatalf("invalid cluster size %#v: %v", args[0], err)
+			}
+
+			ctx := context.Background()
+			c, conn, err := newManagerClient(ctx, o)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			defer conn.Close()
+
+			ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+			defer cancel()
+
+			resp, err := c.Resize(ctx, &e2dpb.ResizeRequest{ClusterSize: int32(size)})
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			fmt.Printf("cluster resized from %d to %d\n", resp.PreviousClusterSize, resp.ClusterSize)
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	if err := cmdutil.SetEnvs(o); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+
+	return cmd
+}
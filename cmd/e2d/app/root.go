@@ -18,6 +18,12 @@ func NewRootCmd() *cobra.Command {
 	cmd.AddCommand(
 		newCompletionCmd(cmd),
 		newRunCmd(),
+		newStatusCmd(),
+		newMembersCmd(),
+		newLeaveCmd(),
+		newResizeCmd(),
+		newMoveLeaderCmd(),
+		newSnapshotCmd(),
 		newPKICmd(),
 		newVersionCmd(),
 	)
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"go.uber.org/zap/zapcore"
-	"math"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,6 +14,7 @@ import (
 	"github.com/criticalstack/e2d/pkg/log"
 	"github.com/criticalstack/e2d/pkg/manager"
 	"github.com/criticalstack/e2d/pkg/snapshot"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -40,21 +41,40 @@ type runOptions struct {
 	HealthCheckInterval time.Duration `env:"E2D_HEALTH_CHECK_INTERVAL"`
 	HealthCheckTimeout  time.Duration `env:"E2D_HEALTH_CHECK_TIMEOUT"`
 
+	DisableLearnerJoin      bool          `env:"E2D_DISABLE_LEARNER_JOIN"`
+	LearnerPromotionTimeout time.Duration `env:"E2D_LEARNER_PROMOTION_TIMEOUT"`
+
 	PeerDiscovery string `env:"E2D_PEER_DISCOVERY"`
 
-	SnapshotBackupURL     string        `env:"E2D_SNAPSHOT_BACKUP_URL"`
-	SnapshotCompression   bool          `env:"E2D_SNAPSHOT_COMPRESSION"`
-	SnapshotEncryption    bool          `env:"E2D_SNAPSHOT_ENCRYPTION"`
-	SnapshotInterval      time.Duration `env:"E2D_SNAPSHOT_INTERVAL"`
-	SnapshotRetentionTime time.Duration `env:"E2D_SNAPSHOT_RETENTION_TIME"`
+	MetricsAddr string `env:"E2D_METRICS_ADDR"`
+
+	NotifyWebhookURL    string `env:"E2D_NOTIFY_WEBHOOK_URL"`
+	NotifyWebhookSecret string `env:"E2D_NOTIFY_WEBHOOK_SECRET"`
 
-	AWSAccessKey       string `env:"E2D_AWS_ACCESS_KEY"`
-	AWSSecretKey       string `env:"E2D_AWS_SECRET_KEY"`
-	AWSRoleSessionName string `env:"E2D_AWS_ROLE_SESSION_NAME"`
+	SnapshotBackupURL         string        `env:"E2D_SNAPSHOT_BACKUP_URL"`
+	SnapshotCompression       string        `env:"E2D_SNAPSHOT_COMPRESSION"`
+	SnapshotEncryption        bool          `env:"E2D_SNAPSHOT_ENCRYPTION"`
+	SnapshotKeyFile           string        `env:"E2D_SNAPSHOT_KEY_FILE"`
+	SnapshotKeys              string        `env:"E2D_SNAPSHOT_KEYS"`
+	SnapshotKeyProvider       string        `env:"E2D_SNAPSHOT_KEY_PROVIDER"`
+	SnapshotInterval          time.Duration `env:"E2D_SNAPSHOT_INTERVAL"`
+	SnapshotRetentionTime     time.Duration `env:"E2D_SNAPSHOT_RETENTION_TIME"`
+	SnapshotRetainHourly      int           `env:"E2D_SNAPSHOT_RETAIN_HOURLY"`
+	SnapshotRetainDaily       int           `env:"E2D_SNAPSHOT_RETAIN_DAILY"`
+	SnapshotRetainWeekly      int           `env:"E2D_SNAPSHOT_RETAIN_WEEKLY"`
+	SnapshotRetainMonthly     int           `env:"E2D_SNAPSHOT_RETAIN_MONTHLY"`
+	SnapshotRestoreFrom       string        `env:"E2D_SNAPSHOT_RESTORE_FROM"`
+	SnapshotRestoreRevision   int64         `env:"E2D_SNAPSHOT_RESTORE_REVISION"`
+	SnapshotSegmentInterval   time.Duration `env:"E2D_SNAPSHOT_SEGMENT_INTERVAL"`
+	SnapshotTimeout           time.Duration `env:"E2D_SNAPSHOT_TIMEOUT"`
+	SnapshotTimeoutPerGiB     time.Duration `env:"E2D_SNAPSHOT_TIMEOUT_PER_GIB"`
+	SnapshotScratchDir        string        `env:"E2D_SNAPSHOT_SCRATCH_DIR"`
+	SnapshotBeforeMaintenance bool          `env:"E2D_SNAPSHOT_BEFORE_MAINTENANCE"`
 
-	DOAccessToken  string `env:"E2D_DO_ACCESS_TOKEN"`
-	DOSpacesKey    string `env:"E2D_DO_SPACES_KEY"`
-	DOSpacesSecret string `env:"E2D_DO_SPACES_SECRET"`
+	DOAccessToken string `env:"E2D_DO_ACCESS_TOKEN"`
+
+	snapshotBackendOptions
+	snapshotKeyProviderOptions
 }
 
 func newRunCmd() *cobra.Command {
@@ -82,20 +102,38 @@ func newRunCmd() *cobra.Command {
 				log.Fatal("unable to set up snapshot provider", zap.Error(err))
 			}
 
+			keyProvider, err := o.newKeyProvider(o.SnapshotKeyProvider)
+			if err != nil {
+				log.Fatal("unable to set up snapshot key provider", zap.Error(err))
+			}
+
 			m, err := manager.New(&manager.Config{
-				Name:                o.Name,
-				Dir:                 o.DataDir,
-				Host:                o.Host,
-				ClientAddr:          o.ClientAddr,
-				PeerAddr:            o.PeerAddr,
-				GossipAddr:          o.GossipAddr,
-				BootstrapAddrs:      baddrs,
-				RequiredClusterSize: o.RequiredClusterSize,
-				SnapshotInterval:    o.SnapshotInterval,
-				SnapshotCompression: o.SnapshotCompression,
-				SnapshotEncryption:  o.SnapshotEncryption,
-				HealthCheckInterval: o.HealthCheckInterval,
-				HealthCheckTimeout:  o.HealthCheckTimeout,
+				Name:                      o.Name,
+				Dir:                       o.DataDir,
+				Host:                      o.Host,
+				ClientAddr:                o.ClientAddr,
+				PeerAddr:                  o.PeerAddr,
+				GossipAddr:                o.GossipAddr,
+				BootstrapAddrs:            baddrs,
+				RequiredClusterSize:       o.RequiredClusterSize,
+				SnapshotInterval:          o.SnapshotInterval,
+				SnapshotCompression:       snapshotCompression(o.SnapshotCompression),
+				SnapshotEncryption:        o.SnapshotEncryption,
+				SnapshotKeyFile:           o.SnapshotKeyFile,
+				SnapshotKeys:              o.SnapshotKeys,
+				SnapshotKeyProvider:       keyProvider,
+				SnapshotRestoreFrom:       o.SnapshotRestoreFrom,
+				SnapshotRestoreRevision:   o.SnapshotRestoreRevision,
+				SnapshotSegmentInterval:   o.SnapshotSegmentInterval,
+				SnapshotRetention:         o.snapshotRetentionPolicy(),
+				SnapshotTimeout:           o.SnapshotTimeout,
+				SnapshotTimeoutPerGiB:     o.SnapshotTimeoutPerGiB,
+				SnapshotScratchDir:        o.SnapshotScratchDir,
+				SnapshotBeforeMaintenance: o.SnapshotBeforeMaintenance,
+				HealthCheckInterval:       o.HealthCheckInterval,
+				HealthCheckTimeout:        o.HealthCheckTimeout,
+				DisableLearnerJoin:        o.DisableLearnerJoin,
+				LearnerPromotionTimeout:   o.LearnerPromotionTimeout,
 				ClientSecurity: client.SecurityConfig{
 					CertFile:      o.ServerCert,
 					KeyFile:       o.ServerKey,
@@ -106,11 +144,14 @@ func newRunCmd() *cobra.Command {
 					KeyFile:       o.PeerKey,
 					TrustedCAFile: o.CACert,
 				},
-				CACertFile:  o.CACert,
-				CAKeyFile:   o.CAKey,
-				PeerGetter:  peerGetter,
-				Snapshotter: snapshotter,
-				Debug:       globalOptions.verbose,
+				MetricsAddr:         o.MetricsAddr,
+				NotifyWebhookURL:    o.NotifyWebhookURL,
+				NotifyWebhookSecret: o.NotifyWebhookSecret,
+				CACertFile:          o.CACert,
+				CAKeyFile:           o.CAKey,
+				PeerGetter:          peerGetter,
+				Snapshotter:         snapshotter,
+				Debug:               globalOptions.verbose,
 			})
 			if err != nil {
 				log.Fatalf("%+v", err)
@@ -136,29 +177,53 @@ func newRunCmd() *cobra.Command {
 	cmd.Flags().StringVar(&o.ServerKey, "server-key", "", "etcd server private key")
 
 	cmd.Flags().StringVar(&o.BootstrapAddrs, "bootstrap-addrs", "", "initial addresses used for node discovery")
-	cmd.Flags().IntVarP(&o.RequiredClusterSize, "required-cluster-size", "n", 1, "size of the etcd cluster should be {1,3,5}")
+	cmd.Flags().IntVarP(&o.RequiredClusterSize, "required-cluster-size", "n", 1, "size of the etcd cluster, must be an odd number (e.g. 1, 3, 5, 7)")
 
 	cmd.Flags().DurationVar(&o.HealthCheckInterval, "health-check-interval", 1*time.Minute, "")
 	cmd.Flags().DurationVar(&o.HealthCheckTimeout, "health-check-timeout", 5*time.Minute, "")
 
+	cmd.Flags().BoolVar(&o.DisableLearnerJoin, "disable-learner-join", false, "join existing clusters as a voting member instead of as a learner")
+	cmd.Flags().DurationVar(&o.LearnerPromotionTimeout, "learner-promotion-timeout", 5*time.Minute, "maximum time for a learner to catch up with the leader before it is removed")
+
 	cmd.Flags().StringVar(&o.PeerDiscovery, "peer-discovery", "", "which method {aws-autoscaling-group,ec2-tags,do-tags} to use to discover peers")
 
+	cmd.Flags().StringVar(&o.MetricsAddr, "metrics-addr", "", "address used to serve prometheus metrics on /metrics (disabled if empty)")
+
+	cmd.Flags().StringVar(&o.NotifyWebhookURL, "notify-webhook-url", "", "url that cluster lifecycle events are posted to as JSON (disabled if empty)")
+	cmd.Flags().StringVar(&o.NotifyWebhookSecret, "notify-webhook-secret", "", "shared secret used to sign webhook notifications with HMAC-SHA256")
+
 	cmd.Flags().DurationVar(&o.SnapshotInterval, "snapshot-interval", 25*time.Minute, "frequency of etcd snapshots")
 	cmd.Flags().StringVar(&o.SnapshotBackupURL, "snapshot-url", "", "an absolute path to shared filesystem directory (like file:///tmp/etcd-backups/) or cloud storage bucket (like s3://etcd-backups/mycluster/) for snapshot backups. snapshots will be named etcd.snapshot.<timestamp>, and a file etcd.snapshot.LATEST will point to the most recent snapshot.")
-	cmd.Flags().BoolVar(&o.SnapshotCompression, "snapshot-compression", false, "compression snapshots with gzip")
+	cmd.Flags().StringVar(&o.SnapshotCompression, "snapshot-compression", "", "compress snapshots with the named codec, one of gzip, zstd or lz4 given as --snapshot-compression=zstd (gzip when given without a codec)")
+	cmd.Flags().Lookup("snapshot-compression").NoOptDefVal = snapshotutil.Gzip.Name()
 	cmd.Flags().BoolVar(&o.SnapshotEncryption, "snapshot-encryption", false, "encrypt snapshots with aes-256")
+	cmd.Flags().StringVar(&o.SnapshotKeyFile, "snapshot-key-file", "", "file containing base64 encoded snapshot encryption keys, one per line, the first of which encrypts new snapshots (keys can also be given with E2D_SNAPSHOT_KEYS, defaults to a key derived from the ca key)")
+	cmd.Flags().StringVar(&o.SnapshotKeyProvider, "snapshot-key-provider", "local", "key provider that wraps the data key of each encrypted snapshot: local (the snapshot keys), vault (a Vault transit key) or aws-kms (an AWS KMS key)")
 	cmd.Flags().DurationVar(&o.SnapshotRetentionTime, "snapshot-retention-time", 24*time.Hour, "maximum age of a snapshot before it is deleted, set this to nonzero to enable retention support")
-
-	cmd.Flags().StringVar(&o.AWSAccessKey, "aws-access-key", "", "")
-	cmd.Flags().StringVar(&o.AWSSecretKey, "aws-secret-key", "", "")
-	cmd.Flags().StringVar(&o.AWSRoleSessionName, "aws-role-session-name", "", "")
+	cmd.Flags().IntVar(&o.SnapshotRetainHourly, "snapshot-retain-hourly", 0, "number of hourly snapshots to keep (replaces --snapshot-retention-time when any --snapshot-retain-* flag is set)")
+	cmd.Flags().IntVar(&o.SnapshotRetainDaily, "snapshot-retain-daily", 0, "number of daily snapshots to keep")
+	cmd.Flags().IntVar(&o.SnapshotRetainWeekly, "snapshot-retain-weekly", 0, "number of weekly snapshots to keep")
+	cmd.Flags().IntVar(&o.SnapshotRetainMonthly, "snapshot-retain-monthly", 0, "number of monthly snapshots to keep")
+	cmd.Flags().StringVar(&o.SnapshotRestoreFrom, "snapshot-restore-from", "", "restore from the snapshot with this name, or the newest snapshot taken at or before this time (unix timestamp or RFC3339), instead of the latest snapshot when creating a new cluster (ignored when the data-dir exists, remove once recovered and use e2d snapshot restore for one-off restores)")
+	cmd.Flags().Int64Var(&o.SnapshotRestoreRevision, "snapshot-restore-revision", 0, "replay segments up to this revision when restoring, rather than every stored segment")
+	cmd.Flags().DurationVar(&o.SnapshotSegmentInterval, "snapshot-segment-interval", 0, "how often to ship the changes since the last snapshot backup as incremental segments (disabled when zero)")
+	cmd.Flags().DurationVar(&o.SnapshotTimeout, "snapshot-timeout", 1*time.Minute, "base amount of time allowed to save a snapshot backup")
+	cmd.Flags().DurationVar(&o.SnapshotTimeoutPerGiB, "snapshot-timeout-per-gib", 5*time.Minute, "additional time allowed to save a snapshot backup for every GiB of the etcd database")
+	cmd.Flags().StringVar(&o.SnapshotScratchDir, "snapshot-scratch-dir", "", "directory used to hold the downloaded snapshot while restoring (defaults to the system temp directory)")
+	cmd.Flags().BoolVar(&o.SnapshotBeforeMaintenance, "snapshot-before-maintenance", false, "take a snapshot before leave, restart, resize and move-leader requests, refusing them if it fails")
 
 	cmd.Flags().StringVar(&o.DOAccessToken, "do-access-token", "", "DigitalOcean personal access token")
-	cmd.Flags().StringVar(&o.DOSpacesKey, "do-spaces-key", "", "DigitalOcean spaces access key")
-	cmd.Flags().StringVar(&o.DOSpacesSecret, "do-spaces-secret", "", "DigitalOcean spaces secret")
+	o.snapshotBackendOptions.addFlags(cmd.Flags())
+	o.snapshotKeyProviderOptions.addFlags(cmd.Flags())
 	if err := cmdutil.SetEnvs(o); err != nil {
 		log.Debug("cannot set environment variables", zap.Error(err))
 	}
+	if err := cmdutil.SetEnvs(&o.snapshotBackendOptions); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+	if err := cmdutil.SetEnvs(&o.snapshotKeyProviderOptions); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
 
 	return cmd
 }
@@ -230,6 +295,31 @@ func getInitialBootstrapAddrs(o *runOptions, peerGetter discovery.PeerGetter) ([
 	return baddrs, nil
 }
 
+// snapshotCompression returns the name of the snapshot compression codec,
+// which was a boolean enabling gzip in older versions of e2d, e.g. with
+// E2D_SNAPSHOT_COMPRESSION=true, so every spelling of a boolean is accepted.
+func snapshotCompression(s string) string {
+	if strings.ToLower(s) == "none" {
+		return ""
+	}
+	if enabled, err := strconv.ParseBool(s); err == nil {
+		if enabled {
+			return snapshotutil.Gzip.Name()
+		}
+		return ""
+	}
+	return s
+}
+
+func (o *runOptions) snapshotRetentionPolicy() snapshot.RetentionPolicy {
+	return snapshot.RetentionPolicy{
+		Hourly:  o.SnapshotRetainHourly,
+		Daily:   o.SnapshotRetainDaily,
+		Weekly:  o.SnapshotRetainWeekly,
+		Monthly: o.SnapshotRetainMonthly,
+	}
+}
+
 func getSnapshotProvider(o *runOptions) (snapshot.Snapshotter, error) {
 	if o.SnapshotBackupURL == "" {
 		return nil, nil
@@ -239,25 +329,13 @@ func getSnapshotProvider(o *runOptions) (snapshot.Snapshotter, error) {
 		return nil, err
 	}
 
-	switch u.Type {
-	case snapshot.FileType:
-		return snapshot.NewFileSnapshotter(u.Path, o.SnapshotRetentionTime)
-	case snapshot.S3Type:
-		origSnapshotRetentionDays := o.SnapshotRetentionTime.Hours() / 24
-		snapshotRetentionDays := int64(math.Ceil(origSnapshotRetentionDays))
-		if int64(origSnapshotRetentionDays) != snapshotRetentionDays {
-			log.Warn("S3 retention time rounded to the nearest day",
-				zap.Float64("original-days", origSnapshotRetentionDays),
-				zap.Int64("new-days", snapshotRetentionDays),
-			)
-		}
-		return snapshot.NewAmazonSnapshotter(&snapshot.AmazonConfig{
-			RoleSessionName: o.AWSRoleSessionName,
-			Bucket:          u.Bucket,
-			Key:             u.Path,
-			RetentionDays:   snapshotRetentionDays,
-		})
-	default:
-		return nil, errors.Errorf("unsupported snapshot url format: %#v", o.SnapshotBackupURL)
+	// the retention policy is applied by the manager after each snapshot, so
+	// time-based retention in the snapshotter must be disabled or it could
+	// delete snapshots the policy is meant to keep
+	retentionTime := o.SnapshotRetentionTime
+	if !o.snapshotRetentionPolicy().IsZero() {
+		retentionTime = 0
 	}
+
+	return o.snapshotBackendOptions.newSnapshotter(u, retentionTime)
 }
@@ -0,0 +1,26 @@
+package app
+
+import "testing"
+
+func TestSnapshotCompression(t *testing.T) {
+	cases := map[string]string{
+		"":     "",
+		"none": "",
+		"None": "",
+		"gzip": "gzip",
+		"lz4":  "lz4",
+		"zstd": "zstd",
+	}
+	// older versions of e2d parsed the setting with strconv.ParseBool
+	for _, s := range []string{"1", "t", "T", "TRUE", "true", "True"} {
+		cases[s] = "gzip"
+	}
+	for _, s := range []string{"0", "f", "F", "FALSE", "false", "False"} {
+		cases[s] = ""
+	}
+	for s, expected := range cases {
+		if codec := snapshotCompression(s); codec != expected {
+			t.Errorf("%#v: expected %#v, received %#v", s, expected, codec)
+		}
+	}
+}
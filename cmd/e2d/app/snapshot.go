@@ -0,0 +1,949 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	etcdsnapshot "go.etcd.io/etcd/clientv3/snapshot"
+	"go.etcd.io/etcd/embed"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/criticalstack/e2d/pkg/cmdutil"
+	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/criticalstack/e2d/pkg/snapshot"
+	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
+)
+
+// snapshotBackendOptions are the credentials and settings for the storage
+// backends that snapshots can be saved to, shared by run and the snapshot
+// commands.
+type snapshotBackendOptions struct {
+	AWSAccessKey       string `env:"E2D_AWS_ACCESS_KEY"`
+	AWSSecretKey       string `env:"E2D_AWS_SECRET_KEY"`
+	AWSRoleSessionName string `env:"E2D_AWS_ROLE_SESSION_NAME"`
+
+	S3Endpoint       string `env:"E2D_S3_ENDPOINT"`
+	S3Region         string `env:"E2D_S3_REGION"`
+	S3ForcePathStyle bool   `env:"E2D_S3_FORCE_PATH_STYLE"`
+
+	DOSpacesKey    string `env:"E2D_DO_SPACES_KEY"`
+	DOSpacesSecret string `env:"E2D_DO_SPACES_SECRET"`
+
+	GCSCredentialsFile string `env:"E2D_GCS_CREDENTIALS_FILE"`
+	GCSEndpoint        string `env:"E2D_GCS_ENDPOINT"`
+
+	AzureStorageAccount  string `env:"E2D_AZURE_STORAGE_ACCOUNT"`
+	AzureStorageKey      string `env:"E2D_AZURE_STORAGE_KEY"`
+	AzureStorageSASToken string `env:"E2D_AZURE_STORAGE_SAS_TOKEN"`
+	AzureStorageEndpoint string `env:"E2D_AZURE_STORAGE_ENDPOINT"`
+
+	HTTPBearerToken string `env:"E2D_HTTP_BEARER_TOKEN"`
+	HTTPUsername    string `env:"E2D_HTTP_USERNAME"`
+	HTTPPassword    string `env:"E2D_HTTP_PASSWORD"`
+	HTTPCACert      string `env:"E2D_HTTP_CA_CERT"`
+}
+
+func (o *snapshotBackendOptions) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.AWSAccessKey, "aws-access-key", "", "static access key for s3:// snapshot urls (defaults to the instance credentials)")
+	fs.StringVar(&o.AWSSecretKey, "aws-secret-key", "", "static secret key for s3:// snapshot urls")
+	fs.StringVar(&o.AWSRoleSessionName, "aws-role-session-name", "", "")
+
+	fs.StringVar(&o.S3Endpoint, "s3-endpoint", "", "url of an S3-compatible service (e.g. MinIO or Ceph RGW) used for s3:// snapshot urls instead of AWS S3")
+	fs.StringVar(&o.S3Region, "s3-region", "", "region of the S3 bucket (defaults to the EC2 instance region, or us-east-1 with --s3-endpoint)")
+	fs.BoolVar(&o.S3ForcePathStyle, "s3-force-path-style", false, "use path-style addressing (<endpoint>/<bucket>) for the S3 bucket, needed by most self-hosted S3-compatible services")
+
+	fs.StringVar(&o.DOSpacesKey, "do-spaces-key", "", "DigitalOcean spaces access key")
+	fs.StringVar(&o.DOSpacesSecret, "do-spaces-secret", "", "DigitalOcean spaces secret")
+
+	fs.StringVar(&o.GCSCredentialsFile, "gcs-credentials-file", "", "Google Cloud service account key file (defaults to application default credentials)")
+	fs.StringVar(&o.GCSEndpoint, "gcs-endpoint", "", "override the Google Cloud Storage endpoint for an emulator, requests are not authenticated when set")
+
+	fs.StringVar(&o.AzureStorageAccount, "azure-storage-account", "", "Azure storage account name")
+	fs.StringVar(&o.AzureStorageKey, "azure-storage-key", "", "Azure storage account key")
+	fs.StringVar(&o.AzureStorageSASToken, "azure-storage-sas-token", "", "Azure storage shared access signature, used instead of the account key")
+	fs.StringVar(&o.AzureStorageEndpoint, "azure-storage-endpoint", "", "override the Azure blob service endpoint (e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite)")
+
+	fs.StringVar(&o.HTTPBearerToken, "http-bearer-token", "", "bearer token for http(s):// snapshot urls")
+	fs.StringVar(&o.HTTPUsername, "http-username", "", "basic authentication username for http(s):// snapshot urls")
+	fs.StringVar(&o.HTTPPassword, "http-password", "", "basic authentication password for http(s):// snapshot urls")
+	fs.StringVar(&o.HTTPCACert, "http-ca-cert", "", "ca certificate used to verify the server for https:// snapshot urls (defaults to the system roots)")
+}
+
+// newSnapshotter returns the Snapshotter for the storage backend of the
+// provided url. Snapshots older than retentionTime are deleted by the
+// backend, which is disabled when zero.
+func (o *snapshotBackendOptions) newSnapshotter(u *snapshot.URL, retentionTime time.Duration) (snapshot.Snapshotter, error) {
+	switch u.Type {
+	case snapshot.FileType:
+		return snapshot.NewFileSnapshotter(u.Path, retentionTime)
+	case snapshot.S3Type:
+		cfg := &snapshot.AmazonConfig{
+			RoleSessionName: o.AWSRoleSessionName,
+			Bucket:          u.Bucket,
+			Key:             u.Path,
+			RetentionTime:   retentionTime,
+			Endpoint:        o.S3Endpoint,
+			Region:          o.S3Region,
+			ForcePathStyle:  o.S3ForcePathStyle,
+			AccessKey:       o.AWSAccessKey,
+			SecretKey:       o.AWSSecretKey,
+		}
+
+		// DigitalOcean Spaces urls include the endpoint, and use the spaces
+		// credentials
+		if u.Endpoint != "" {
+			cfg.Endpoint = u.Endpoint
+			cfg.AccessKey = o.DOSpacesKey
+			cfg.SecretKey = o.DOSpacesSecret
+		}
+		return snapshot.NewAmazonSnapshotter(cfg)
+	case snapshot.GCSType:
+		return snapshot.NewGCSSnapshotter(&snapshot.GCSConfig{
+			Bucket:          u.Bucket,
+			Key:             u.Path,
+			Endpoint:        o.GCSEndpoint,
+			CredentialsFile: o.GCSCredentialsFile,
+			RetentionTime:   retentionTime,
+		})
+	case snapshot.HTTPType:
+		return snapshot.NewHTTPSnapshotter(&snapshot.HTTPConfig{
+			URL:           u.Endpoint + "/" + u.Path,
+			BearerToken:   o.HTTPBearerToken,
+			Username:      o.HTTPUsername,
+			Password:      o.HTTPPassword,
+			CAFile:        o.HTTPCACert,
+			RetentionTime: retentionTime,
+		})
+	case snapshot.AzureType:
+		return snapshot.NewAzureSnapshotter(&snapshot.AzureConfig{
+			Account:       o.AzureStorageAccount,
+			Container:     u.Bucket,
+			Key:           u.Path,
+			AccountKey:    o.AzureStorageKey,
+			SASToken:      o.AzureStorageSASToken,
+			Endpoint:      o.AzureStorageEndpoint,
+			RetentionTime: retentionTime,
+		})
+	default:
+		return nil, errors.Errorf("unsupported snapshot url type: %v", u.Type)
+	}
+}
+
+// snapshotKeyProviderOptions are the settings for the external key managers
+// that can wrap the data keys of encrypted snapshots, shared by run and the
+// snapshot commands. The Vault token has no flag, so that it does not appear
+// in the process list, and falls back to VAULT_TOKEN like the vault cli.
+type snapshotKeyProviderOptions struct {
+	VaultAddr         string `env:"E2D_VAULT_ADDR"`
+	VaultToken        string `env:"E2D_VAULT_TOKEN"`
+	VaultNamespace    string `env:"E2D_VAULT_NAMESPACE"`
+	VaultTransitMount string `env:"E2D_VAULT_TRANSIT_MOUNT"`
+	VaultTransitKey   string `env:"E2D_VAULT_TRANSIT_KEY"`
+	VaultCACert       string `env:"E2D_VAULT_CA_CERT"`
+
+	KMSKeyID    string `env:"E2D_KMS_KEY_ID"`
+	KMSRegion   string `env:"E2D_KMS_REGION"`
+	KMSEndpoint string `env:"E2D_KMS_ENDPOINT"`
+}
+
+func (o *snapshotKeyProviderOptions) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.VaultAddr, "vault-addr", "", "url of the Vault server for the vault snapshot key provider")
+	fs.StringVar(&o.VaultNamespace, "vault-namespace", "", "Vault Enterprise namespace of the transit mount")
+	fs.StringVar(&o.VaultTransitMount, "vault-transit-mount", "transit", "path the Vault transit secrets engine is mounted at")
+	fs.StringVar(&o.VaultTransitKey, "vault-transit-key", "", "name of the Vault transit key that wraps snapshot data keys")
+	fs.StringVar(&o.VaultCACert, "vault-ca-cert", "", "ca certificate used to verify the Vault server (defaults to the system roots)")
+
+	fs.StringVar(&o.KMSKeyID, "kms-key-id", "", "id, arn or alias of the AWS KMS key that wraps snapshot data keys for the aws-kms snapshot key provider")
+	fs.StringVar(&o.KMSRegion, "kms-region", "", "region of the AWS KMS key (defaults to the EC2 instance region)")
+	fs.StringVar(&o.KMSEndpoint, "kms-endpoint", "", "override the AWS KMS endpoint")
+}
+
+// newKeyProvider returns the external key provider with the provided name,
+// or nil for the local provider, which wraps data keys with the snapshot keys
+// themselves.
+func (o *snapshotKeyProviderOptions) newKeyProvider(name string) (crypto.KeyProvider, error) {
+	switch name {
+	case "", crypto.LocalKeyProvider:
+		return nil, nil
+	case crypto.VaultKeyProvider:
+		token := o.VaultToken
+		if token == "" {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		return crypto.NewVaultTransit(&crypto.VaultConfig{
+			Address:   o.VaultAddr,
+			Token:     token,
+			Namespace: o.VaultNamespace,
+			Mount:     o.VaultTransitMount,
+			Key:       o.VaultTransitKey,
+			CAFile:    o.VaultCACert,
+		})
+	case crypto.KMSKeyProvider:
+		return crypto.NewAmazonKMS(&crypto.KMSConfig{
+			KeyID:    o.KMSKeyID,
+			Region:   o.KMSRegion,
+			Endpoint: o.KMSEndpoint,
+		})
+	default:
+		return nil, errors.Errorf("unsupported snapshot key provider: %#v", name)
+	}
+}
+
+// snapshotOptions are the options used by commands that work with stored
+// snapshots directly, rather than through a running e2d instance.
+type snapshotOptions struct {
+	snapshotBackendOptions
+	snapshotKeyProviderOptions
+
+	CAKey       string `env:"E2D_CA_KEY"`
+	KeyFile     string `env:"E2D_SNAPSHOT_KEY_FILE"`
+	Keys        string `env:"E2D_SNAPSHOT_KEYS"`
+	KeyProvider string `env:"E2D_SNAPSHOT_KEY_PROVIDER"`
+	ScratchDir  string `env:"E2D_SNAPSHOT_SCRATCH_DIR"`
+}
+
+func (o *snapshotOptions) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.CAKey, "ca-key", "", "etcd ca key, needed for snapshots encrypted with the key derived from it")
+	fs.StringVar(&o.KeyFile, "key-file", "", "file containing base64 encoded snapshot encryption keys, one per line, needed for snapshots encrypted with them (keys can also be given with E2D_SNAPSHOT_KEYS)")
+	fs.StringVar(&o.KeyProvider, "key-provider", "local", "key provider that wraps snapshot data keys, needed for snapshots wrapped by it (local, vault or aws-kms)")
+	fs.StringVar(&o.ScratchDir, "scratch-dir", "", "directory used to hold the downloaded snapshot (defaults to the system temp directory)")
+	o.snapshotBackendOptions.addFlags(fs)
+	o.snapshotKeyProviderOptions.addFlags(fs)
+}
+
+// keyring returns the keys used to decrypt snapshots, the same as e2d run.
+// The first key from the key file or E2D_SNAPSHOT_KEYS is the active key,
+// otherwise it is the key derived from the CA key. Data keys are wrapped by
+// the external key provider instead when one is set.
+func (o *snapshotOptions) keyring() (*crypto.Keyring, error) {
+	keys, err := o.localKeyring()
+	if err != nil {
+		return nil, err
+	}
+	p, err := o.newKeyProvider(o.KeyProvider)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		if keys == nil {
+			keys = crypto.NewKeyring(nil)
+		}
+		keys.SetProvider(p)
+	}
+	return keys, nil
+}
+
+func (o *snapshotOptions) localKeyring() (*crypto.Keyring, error) {
+	var keys *crypto.Keyring
+	var err error
+	switch {
+	case o.KeyFile != "" && o.Keys != "":
+		return nil, errors.New("cannot provide both --key-file and E2D_SNAPSHOT_KEYS")
+	case o.KeyFile != "":
+		keys, err = crypto.ReadKeyFile(o.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read snapshot key file: %#v", o.KeyFile)
+		}
+	case o.Keys != "":
+		keys, err = crypto.ParseKeys([]byte(o.Keys))
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot parse snapshot keys")
+		}
+	}
+	if o.CAKey == "" {
+		return keys, nil
+	}
+	data, err := ioutil.ReadFile(o.CAKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.NewKeyFromCAKey(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse ca key file: %#v", o.CAKey)
+	}
+	if keys == nil {
+		return crypto.NewKeyring(key), nil
+	}
+	keys.SetLegacy(key)
+	return keys, nil
+}
+
+// newSnapshotter returns the Snapshotter for a snapshot url. Retention is
+// never enabled, since that would modify the backend (e.g. the S3 lifecycle
+// rules).
+func (o *snapshotOptions) newSnapshotter(rawurl string) (snapshot.Snapshotter, error) {
+	u, err := snapshot.ParseSnapshotBackupURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return o.snapshotBackendOptions.newSnapshotter(u, 0)
+}
+
+func (o *snapshotOptions) setEnvs() {
+	if err := cmdutil.SetEnvs(o); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+	if err := cmdutil.SetEnvs(&o.snapshotBackendOptions); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+	if err := cmdutil.SetEnvs(&o.snapshotKeyProviderOptions); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+}
+
+// load returns the snapshot identified by id, or the latest snapshot when id
+// is empty, along with the name of the snapshot that was loaded.
+func (o *snapshotOptions) load(rawurl, id string) (io.ReadCloser, string, error) {
+	s, err := o.newSnapshotter(rawurl)
+	if err != nil {
+		return nil, "", err
+	}
+	name, err := snapshot.Resolve(s, id)
+	if err != nil {
+		return nil, "", err
+	}
+	r, err := s.LoadAt(name)
+	return r, name, err
+}
+
+// extract loads a snapshot and writes the decoded etcd database to path,
+// then verifies it.
+func (o *snapshotOptions) extract(rawurl, id, path string) (*snapshotVerifyResult, error) {
+	keys, err := o.keyring()
+	if err != nil {
+		return nil, err
+	}
+	r, id, err := o.load(rawurl, id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if err := snapshot.Extract(r, keys, path); err != nil {
+		switch errors.Cause(err) {
+		case snapshotutil.ErrNoEncryptionKey:
+			return nil, errors.Wrap(err, "snapshot is encrypted, the key must be provided with --key-file or --ca-key")
+		case snapshotutil.ErrUnknownKey:
+			return nil, errors.Wrap(err, "snapshot is encrypted with a key or key provider that was not provided")
+		}
+		return nil, err
+	}
+	status, err := snapshot.VerifyDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotVerifyResult{URL: rawurl, Snapshot: id, DatabaseStatus: status}, nil
+}
+
+// extractTemp is the same as extract, but writes the etcd database to a
+// temporary file in the scratch dir. The returned cleanup function removes
+// it.
+func (o *snapshotOptions) extractTemp(rawurl, id string) (*snapshotVerifyResult, string, func(), error) {
+	f, err := ioutil.TempFile(o.ScratchDir, "snapshot.load")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	f.Close()
+	cleanup := func() { os.Remove(f.Name()) }
+	result, err := o.extract(rawurl, id, f.Name())
+	if err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+	return result, f.Name(), cleanup, nil
+}
+
+// replay brings the etcd database at path, extracted from the snapshot at
+// rawurl, forward with the segments that follow on from it, up to the target
+// revision (or every segment when zero). The result is updated to describe
+// the replayed database. Missing revisions only stop the replay early, the
+// same as when e2d run restores.
+func (o *snapshotOptions) replay(rawurl, path string, result *snapshotVerifyResult, target int64) (*snapshot.ReplayStatus, error) {
+	keys, err := o.keyring()
+	if err != nil {
+		return nil, err
+	}
+	s, err := o.newSnapshotter(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := snapshot.ReplaySegments(s, keys, path, result.Snapshot, result.DatabaseStatus, target)
+	if err != nil {
+		if errors.Cause(err) != snapshot.ErrSegmentGap {
+			return nil, err
+		}
+		log.Warn("cannot replay every segment", zap.Int64("revision", rs.Revision), zap.Error(err))
+	} else if rs.Segments == 0 {
+		return rs, nil
+	}
+	if result.DatabaseStatus, err = snapshot.VerifyDatabase(path); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+const snapshotFlagUsage = "use the snapshot with this name, or the newest snapshot taken at or before this time (unix timestamp or RFC3339), instead of the latest snapshot"
+
+func newSnapshotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "manage snapshot backups",
+	}
+
+	cmd.AddCommand(
+		newSnapshotSaveCmd(),
+		newSnapshotListCmd(),
+		newSnapshotDownloadCmd(),
+		newSnapshotRestoreCmd(),
+		newSnapshotVerifyCmd(),
+		newSnapshotRekeyCmd(),
+	)
+	return cmd
+}
+
+type snapshotSaveOptions struct {
+	clientOptions
+
+	Output string
+}
+
+func newSnapshotSaveCmd() *cobra.Command {
+	o := &snapshotSaveOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "save",
+		Short: "take a snapshot backup now",
+		Long: `Take a snapshot backup now, rather than waiting for the next scheduled one.
+The request can be sent to any member and is forwarded to the leader, which
+takes the snapshot and saves it to its snapshot backup url. A snapshot is taken
+even if nothing has changed since the last one.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			c, conn, err := newManagerClient(ctx, &o.clientOptions)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			defer conn.Close()
+
+			// the time allowed to save a snapshot depends upon its size, so
+			// that is left to the server
+			resp, err := c.Snapshot(ctx, &types.Empty{})
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			if err := printOutput(o.Output, resp, func(w io.Writer) {
+				fmt.Fprintf(w, "SNAPSHOT:\t%s\n", resp.Name)
+				fmt.Fprintf(w, "CREATED:\t%s\n", resp.Created.Format(time.RFC3339))
+				fmt.Fprintf(w, "REVISION:\t%d\n", resp.Revision)
+				fmt.Fprintf(w, "LEADER:\t%s\n", resp.Leader)
+				fmt.Fprintf(w, "SIZE:\t%s\n", formatBytes(resp.StoredSize))
+				fmt.Fprintf(w, "SHA256:\t%s\n", resp.Sha256)
+			}); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&o.Output, "output", "o", outputTable, "output format {table,json,yaml}")
+	if err := cmdutil.SetEnvs(&o.clientOptions); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+
+	return cmd
+}
+
+type snapshotListOptions struct {
+	snapshotOptions
+
+	Output string
+}
+
+func newSnapshotListCmd() *cobra.Command {
+	o := &snapshotListOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "list <url>",
+		Short: "list the snapshot backups stored at a url",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			s, err := o.newSnapshotter(args[0])
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			snapshots, err := s.List()
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			if err := printOutput(o.Output, snapshots, func(w io.Writer) {
+				fmt.Fprintln(w, "NAME\tCREATED\tSIZE")
+				for _, snap := range snapshots {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", snap.Name, snap.Timestamp.Format(time.RFC3339), formatBytes(snap.Size))
+				}
+			}); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		},
+	}
+
+	o.snapshotBackendOptions.addFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&o.Output, "output", "o", outputTable, "output format {table,json,yaml}")
+	o.setEnvs()
+
+	return cmd
+}
+
+type snapshotDownloadOptions struct {
+	snapshotOptions
+
+	Snapshot     string
+	Revision     int64
+	SkipSegments bool
+}
+
+func newSnapshotDownloadCmd() *cobra.Command {
+	o := &snapshotDownloadOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "download <url> <file>",
+		Short: "download a snapshot backup as an etcd database file",
+		Long: `Download a snapshot backup as an etcd database file. The snapshot is
+decompressed and decrypted, so the file can be used with snapshot restore or
+etcdctl snapshot restore. The latest snapshot is downloaded unless --snapshot
+is given.
+
+Any segments stored since the snapshot was taken are replayed on top of it, the
+same as snapshot restore. The replayed database no longer ends with the hash
+etcd adds to snapshots, so etcdctl must restore it with --skip-hash-check.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			result, rs, err := o.download(args[0], args[1])
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			fmt.Printf("downloaded %s snapshot to %s (revision %d, %d keys)\n", result.Snapshot, args[1], result.Revision, result.TotalKeys)
+			if rs != nil && rs.Segments > 0 {
+				fmt.Printf("replayed %d segments from revision %d\n", rs.Segments, rs.StartRevision)
+			}
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.Snapshot, "snapshot", "", snapshotFlagUsage)
+	cmd.Flags().Int64Var(&o.Revision, "revision", 0, "replay segments up to this revision, rather than every stored segment")
+	cmd.Flags().BoolVar(&o.SkipSegments, "skip-segments", false, "download the snapshot as-is, without replaying segments")
+	o.setEnvs()
+
+	return cmd
+}
+
+// download writes the etcd database of the snapshot to path, returning the
+// downloaded snapshot and the segments replayed on top of it, if any. It is
+// written to a temporary file next to path and only renamed into place once
+// verified, so a failed download never leaves a partial database behind.
+func (o *snapshotDownloadOptions) download(rawurl, path string) (*snapshotVerifyResult, *snapshot.ReplayStatus, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return nil, nil, err
+	}
+	f.Close()
+	var rs *snapshot.ReplayStatus
+	result, err := o.extract(rawurl, o.Snapshot, f.Name())
+	if err == nil && !o.SkipSegments && (o.Snapshot == "" || o.Revision != 0) {
+		rs, err = o.replay(rawurl, f.Name(), result, o.Revision)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+	return result, rs, nil
+}
+
+type snapshotRestoreOptions struct {
+	snapshotOptions
+
+	Snapshot       string
+	DataDir        string
+	Name           string
+	PeerURL        string
+	InitialCluster string
+	Revision       int64
+	SkipSegments   bool
+}
+
+func newSnapshotRestoreCmd() *cobra.Command {
+	o := &snapshotRestoreOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "restore <url|file>",
+		Short: "restore an etcd data-dir from a snapshot backup",
+		Long: `Restore an etcd data-dir from a snapshot backup, or from an etcd database
+file written by snapshot download, while e2d is not running. The snapshot is
+verified before the data-dir is created, and the data-dir must not already
+exist. Every member of the cluster must be restored from the same database
+with the same --initial-cluster, and the peer urls must match those used by
+e2d run (https when peer certificates are used).
+
+Any segments stored since the snapshot was taken are replayed on top of it, up
+to --revision when given. Segments are only replayed onto an older snapshot
+chosen with --snapshot when --revision is also given. To restore more than one
+member, download the snapshot once with snapshot download and restore every
+member from the file, so they are all seeded from the same replayed database.
+
+Unlike the automatic restore done by e2d run when creating a new cluster, the
+volatile keys are kept and no snapshot marker is placed.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			result, rs, err := o.restore(args[0])
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			fmt.Printf("restored %s snapshot to %s (revision %d, %d keys)\n", result.Snapshot, o.DataDir, result.Revision, result.TotalKeys)
+			if rs != nil && rs.Segments > 0 {
+				fmt.Printf("replayed %d segments from revision %d\n", rs.Segments, rs.StartRevision)
+			}
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.Snapshot, "snapshot", "", snapshotFlagUsage)
+	cmd.Flags().StringVar(&o.DataDir, "data-dir", "data", "etcd data-dir to create")
+	cmd.Flags().StringVar(&o.Name, "name", "", "name of the member being restored")
+	cmd.Flags().StringVar(&o.PeerURL, "peer-url", "http://127.0.0.1:2380", "peer url of the member being restored")
+	cmd.Flags().StringVar(&o.InitialCluster, "initial-cluster", "", "comma separated name=peer-url of every member (defaults to a single member)")
+	cmd.Flags().Int64Var(&o.Revision, "revision", 0, "replay segments up to this revision, rather than every stored segment")
+	cmd.Flags().BoolVar(&o.SkipSegments, "skip-segments", false, "restore the snapshot as-is, without replaying segments")
+	o.setEnvs()
+
+	return cmd
+}
+
+// restore creates the data-dir from the snapshot stored at rawurl, or the
+// etcd database file at rawurl, returning the restored snapshot and the
+// segments replayed on top of it, if any.
+func (o *snapshotRestoreOptions) restore(rawurl string) (*snapshotVerifyResult, *snapshot.ReplayStatus, error) {
+	if o.Name == "" {
+		return nil, nil, errors.New("must provide --name")
+	}
+	if o.InitialCluster == "" {
+		o.InitialCluster = o.Name + "=" + o.PeerURL
+	}
+	var result *snapshotVerifyResult
+	var rs *snapshot.ReplayStatus
+	path := rawurl
+	if _, err := snapshot.ParseSnapshotBackupURL(rawurl); errors.Cause(err) == snapshot.ErrInvalidScheme {
+		// a downloaded database has already had any segments replayed
+		status, err := snapshot.VerifyDatabase(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = &snapshotVerifyResult{URL: rawurl, Snapshot: filepath.Base(path), DatabaseStatus: status}
+	} else {
+		var cleanup func()
+		result, path, cleanup, err = o.extractTemp(rawurl, o.Snapshot)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer cleanup()
+
+		if !o.SkipSegments && (o.Snapshot == "" || o.Revision != 0) {
+			rs, err = o.replay(rawurl, path, result, o.Revision)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err := etcdsnapshot.NewV3(nil).Restore(etcdsnapshot.RestoreConfig{
+		SnapshotPath:        path,
+		Name:                o.Name,
+		OutputDataDir:       o.DataDir,
+		PeerURLs:            []string{o.PeerURL},
+		InitialCluster:      o.InitialCluster,
+		InitialClusterToken: embed.NewConfig().InitialClusterToken,
+		SkipHashCheck:       !result.HashChecked,
+	}); err != nil {
+		return nil, nil, err
+	}
+	return result, rs, nil
+}
+
+type snapshotVerifyOptions struct {
+	snapshotOptions
+
+	Snapshot string
+	Output   string
+}
+
+// snapshotVerifyResult is the output of snapshot verify.
+type snapshotVerifyResult struct {
+	URL      string `json:"url"`
+	Snapshot string `json:"snapshot"`
+	*snapshot.DatabaseStatus
+}
+
+func newSnapshotVerifyCmd() *cobra.Command {
+	o := &snapshotVerifyOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "verify <url>",
+		Short: "check that a snapshot backup can be restored",
+		Long: `Check that a snapshot backup can be restored. The snapshot is downloaded and
+verified against its manifest, decompressed and decrypted (which checks its
+message authentication), and the etcd database is checked the same as it would
+be when restoring. The latest snapshot is verified unless --snapshot is given.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if globalOptions.verbose {
+				log.SetLevel(zapcore.DebugLevel)
+			}
+			result, _, cleanup, err := o.extractTemp(args[0], o.Snapshot)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			cleanup()
+
+			if err := printOutput(o.Output, result, func(w io.Writer) {
+				fmt.Fprintf(w, "URL:\t%s\n", result.URL)
+				fmt.Fprintf(w, "SNAPSHOT:\t%s\n", result.Snapshot)
+				fmt.Fprintf(w, "REVISION:\t%d\n", result.Revision)
+				fmt.Fprintf(w, "KEYS:\t%d\n", result.TotalKeys)
+				fmt.Fprintf(w, "SIZE:\t%s\n", formatBytes(result.Size))
+				fmt.Fprintf(w, "HASH:\t%x\n", result.Hash)
+				fmt.Fprintf(w, "HASH CHECKED:\t%t\n", result.HashChecked)
+			}); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.Snapshot, "snapshot", "", snapshotFlagUsage)
+	cmd.Flags().StringVarP(&o.Output, "output", "o", outputTable, "output format {table,json,yaml}")
+	o.setEnvs()
+
+	return cmd
+}
+
+type snapshotRekeyOptions struct {
+	snapshotOptions
+
+	SkipSegments bool
+}
+
+func newSnapshotRekeyCmd() *cobra.Command {
+	o := &snapshotRekeyOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "rekey <url>",
+		Short: "re-encrypt the snapshot backups stored at a url with the active key",
+		Long: `Re-encrypt the snapshot backups and segments stored at a url with the active
+snapshot key, which is the first key from --key-file or E2D_SNAPSHOT_KEYS, or
+the key of the external --key-provider when one is set. The other keys, and
+the key derived from --ca-key, are used to decrypt them. Once rekeyed, the old
+keys are no longer needed to restore, so e.g. the CA can be rotated, or
+snapshots can be moved to an external key manager.
+
+Each snapshot is downloaded and verified the same as snapshot verify before it
+is replaced, keeping its name and manifest. Snapshots saved by older versions
+of e2d without a manifest are given one. Snapshots and segments that are not
+encrypted, or are already encrypted with the active key, are left as is. A
+snapshot is never left without a matching manifest while it is replaced, so an
+interrupted rekey can be run again, which also finishes replacing the snapshot
+it was interrupted on.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if globalOptions.verbose {
+				log.SetLevel(zapcore.DebugLevel)
+			}
+			if o.KeyFile == "" && o.Keys == "" && (o.KeyProvider == "" || o.KeyProvider == crypto.LocalKeyProvider) {
+				log.Fatal("must provide the keys to rekey with, using --key-file, E2D_SNAPSHOT_KEYS or --key-provider")
+			}
+			keys, err := o.keyring()
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			s, err := o.newSnapshotter(args[0])
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			snapshots, err := s.List()
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			rekeyed := 0
+			for _, snap := range snapshots {
+				ok, err := o.rekeySnapshot(args[0], s, snap, keys)
+				if err != nil {
+					log.Fatalf("cannot rekey snapshot %s: %+v", snap.Name, err)
+				}
+				if ok {
+					fmt.Printf("rekeyed snapshot %s\n", snap.Name)
+					rekeyed++
+				}
+			}
+			segments := 0
+			if !o.SkipSegments {
+				stored, err := s.ListSegments()
+				if err != nil {
+					log.Fatalf("%+v", err)
+				}
+				for _, seg := range stored {
+					ok, err := rekeySegment(s, seg, keys)
+					if err != nil {
+						log.Fatalf("cannot rekey segment %s: %+v", seg.Name, err)
+					}
+					if ok {
+						segments++
+					}
+				}
+			}
+			fmt.Printf("rekeyed %d snapshots and %d segments with key %s\n", rekeyed, segments, keys.KeyID())
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	cmd.Flags().BoolVar(&o.SkipSegments, "skip-segments", false, "only rekey snapshots, leaving segments as is")
+	o.setEnvs()
+
+	return cmd
+}
+
+// rekeySnapshot replaces the snapshot with one encrypted with the active key,
+// unless it is not encrypted or already is. A snapshot whose replacement was
+// interrupted is always replaced again, which finishes the replacement. The
+// snapshot is extracted to the scratch dir first, so it is verified before
+// the stored snapshot is replaced.
+func (o *snapshotOptions) rekeySnapshot(rawurl string, s snapshot.Snapshotter, snap *snapshot.Snapshot, keys *crypto.Keyring) (bool, error) {
+	m, err := s.LoadManifest(snap.Name)
+	if err != nil {
+		return false, err
+	}
+	if m == nil {
+		m, err = legacyManifest(s, snap)
+		if err != nil {
+			return false, err
+		}
+	}
+	if !m.Encrypted || (m.KeyID == keys.KeyID().String() && !m.Replacing()) {
+		return false, nil
+	}
+	// snapshots compressed by older versions of e2d do not name their codec,
+	// which was always gzip
+	var codec snapshotutil.Codec
+	if m.Compressed {
+		codecName := m.Compression
+		if codecName == "" {
+			codecName = snapshotutil.Gzip.Name()
+		}
+		codec, err = snapshotutil.LookupCodec(codecName)
+		if err != nil {
+			return false, err
+		}
+	}
+	result, path, cleanup, err := o.extractTemp(rawurl, snap.Name)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+	if m.DatabaseSize == 0 {
+		m.Revision = result.Revision
+		m.DatabaseSize = result.Size
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	r := snapshotutil.NewEncrypterReadCloser(f, keys)
+	if codec != nil {
+		r = snapshotutil.NewCompressorReadCloser(r, codec)
+	}
+	m.KeyID = keys.KeyID().String()
+	if err := s.Replace(context.Background(), r, m); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// legacyManifest returns a manifest for a snapshot saved by an older version
+// of e2d without one, detecting how it was compressed and encrypted from the
+// snapshot itself. Once rekeyed, the snapshot is stored with the manifest.
+func legacyManifest(s snapshot.Snapshotter, snap *snapshot.Snapshot) (*snapshot.Manifest, error) {
+	r, err := s.LoadAt(snap.Name)
+	if err != nil {
+		return nil, err
+	}
+	codec := snapshotutil.DetectCodec(&r)
+	dec := snapshotutil.NewDecompressorReadCloser(r)
+	id, encrypted := snapshotutil.EncryptionKeyID(&dec)
+	dec.Close()
+
+	m := &snapshot.Manifest{
+		Name:       snap.Name,
+		Created:    snap.Timestamp,
+		Compressed: codec != nil,
+		Encrypted:  encrypted,
+	}
+	if codec != nil {
+		m.Compression = codec.Name()
+	}
+	if id != (crypto.KeyID{}) {
+		m.KeyID = id.String()
+	}
+	return m, nil
+}
+
+// rekeySegment replaces a segment with one encrypted with the active key,
+// unless it is not encrypted or already is. Segments are small enough to be
+// decoded in memory, which also verifies them before they are replaced.
+func rekeySegment(s snapshot.Snapshotter, seg *snapshot.Segment, keys *crypto.Keyring) (bool, error) {
+	r, err := s.LoadSegment(seg.Name)
+	if err != nil {
+		return false, err
+	}
+	codec := snapshotutil.DetectCodec(&r)
+	dec := snapshotutil.NewDecompressorReadCloser(r)
+	id, encrypted := snapshotutil.EncryptionKeyID(&dec)
+	dec.Close()
+	if !encrypted || id == keys.KeyID() {
+		return false, nil
+	}
+
+	events, err := snapshot.ReadSegment(s, seg.Name, keys)
+	if err != nil {
+		return false, err
+	}
+	data, err := snapshot.EncodeSegment(events)
+	if err != nil {
+		return false, err
+	}
+	enc := snapshotutil.NewEncrypterReadCloser(ioutil.NopCloser(bytes.NewReader(data)), keys)
+	if codec != nil {
+		enc = snapshotutil.NewCompressorReadCloser(enc, codec)
+	}
+	defer enc.Close()
+
+	err = s.SaveSegment(context.Background(), enc, &snapshot.Segment{
+		ClusterID:     seg.ClusterID,
+		Base:          seg.Base,
+		StartRevision: seg.StartRevision,
+		EndRevision:   seg.EndRevision,
+		Size:          int64(len(data)),
+	})
+	return err == nil, err
+}
@@ -0,0 +1,333 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	"go.etcd.io/etcd/lease"
+	"go.etcd.io/etcd/mvcc"
+	"go.etcd.io/etcd/mvcc/backend"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
+	"github.com/criticalstack/e2d/pkg/snapshot"
+	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
+)
+
+// captureStdout returns what f writes to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		done <- data
+	}()
+	f()
+	w.Close()
+	return string(<-done)
+}
+
+// newTestDatabase writes an etcd database containing the provided keys to
+// path.
+func newTestDatabase(t *testing.T, path string, keys ...string) {
+	t.Helper()
+
+	be := backend.NewDefaultBackend(path)
+	lessor := lease.NewLessor(zap.NewNop(), be, lease.LessorConfig{MinLeaseTTL: math.MaxInt64})
+	mvs := mvcc.NewStore(zap.NewNop(), be, lessor, nil, mvcc.StoreConfig{})
+	for _, k := range keys {
+		mvs.Put([]byte(k), []byte(k), lease.NoLease)
+	}
+	mvs.Commit()
+	mvs.Close()
+	lessor.Stop()
+	be.Close()
+}
+
+// newTestSnapshots saves a snapshot of an etcd database containing the
+// provided keys to a new file snapshot backup in dir, returning its url.
+func newTestSnapshots(t *testing.T, dir string, keys ...string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "db")
+	newTestDatabase(t, path, keys...)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := snapshot.NewFileSnapshotter(filepath.Join(dir, "snapshots"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(context.Background(), f, nil); err != nil {
+		t.Fatal(err)
+	}
+	return "file://" + filepath.Join(dir, "snapshots") + "/"
+}
+
+func TestSnapshotList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := newTestSnapshots(t, dir, "a")
+	cmd := newSnapshotListCmd()
+	cmd.SetArgs([]string{u, "-o", "json"})
+	out := captureStdout(t, func() {
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var snapshots []*snapshot.Snapshot
+	if err := json.Unmarshal([]byte(out), &snapshots); err != nil {
+		t.Fatalf("cannot parse output %q: %v", out, err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Size == 0 {
+		t.Fatalf("expected 1 snapshot, received %q", out)
+	}
+}
+
+func TestSnapshotDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := newTestSnapshots(t, dir, "a", "b")
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(out, "etcd.db")
+	cmd := newSnapshotDownloadCmd()
+	cmd.SetArgs([]string{u, path})
+	captureStdout(t, func() {
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	status, err := snapshot.VerifyDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.TotalKeys != 2 {
+		t.Fatalf("expected 2 keys, received %d", status.TotalKeys)
+	}
+
+	// a failed download must not leave a partial file behind
+	o := &snapshotDownloadOptions{Snapshot: "1"}
+	if _, _, err := o.download(u, filepath.Join(out, "missing.db")); err == nil {
+		t.Fatal("expected error downloading a missing snapshot")
+	}
+	files, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "etcd.db" {
+		t.Fatalf("expected only etcd.db to be downloaded, received %d files", len(files))
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := newTestSnapshots(t, dir, "a", "b", "c")
+	scratch := filepath.Join(dir, "scratch")
+	if err := os.Mkdir(scratch, 0700); err != nil {
+		t.Fatal(err)
+	}
+	dataDir := filepath.Join(dir, "data")
+	cmd := newSnapshotRestoreCmd()
+	cmd.SetArgs([]string{u, "--name", "node1", "--data-dir", dataDir, "--scratch-dir", scratch})
+	captureStdout(t, func() {
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	status, err := snapshot.VerifyDatabase(filepath.Join(dataDir, "member", "snap", "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the restored membership adds to the keys, but keeps the revision
+	if status.Revision != 4 {
+		t.Fatalf("expected revision 4, received %d", status.Revision)
+	}
+
+	// the data-dir already exists, which must fail after the snapshot was
+	// extracted, and still remove it from the scratch dir
+	o := &snapshotRestoreOptions{
+		snapshotOptions: snapshotOptions{ScratchDir: scratch},
+		Name:            "node1",
+		DataDir:         dataDir,
+		PeerURL:         "http://127.0.0.1:2380",
+	}
+	if _, _, err := o.restore(u); err == nil {
+		t.Fatal("expected error restoring to an existing data-dir")
+	}
+	o.Name = ""
+	if _, _, err := o.restore(u); err == nil {
+		t.Fatal("expected error restoring without a name")
+	}
+	files, err := ioutil.ReadDir(scratch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected the scratch dir to be empty, received %d files", len(files))
+	}
+
+	// a downloaded database is restored as is
+	path := filepath.Join(dir, "etcd.db")
+	if _, _, err := (&snapshotDownloadOptions{}).download(u, path); err != nil {
+		t.Fatal(err)
+	}
+	o.Name = "node1"
+	o.DataDir = filepath.Join(dir, "data2")
+	result, rs, err := o.restore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs != nil || result.Revision != 4 {
+		t.Fatalf("expected revision 4 without replaying, received %d", result.Revision)
+	}
+	if _, err := os.Stat(filepath.Join(o.DataDir, "member", "snap", "db")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotRekey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "db")
+	newTestDatabase(t, path, "a")
+	s, err := snapshot.NewFileSnapshotter(filepath.Join(dir, "snapshots"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey, newKey := crypto.NewEncryptionKey(), crypto.NewEncryptionKey()
+	saved := make([]*snapshot.Manifest, 0)
+	for i := 0; i < 2; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := crypto.NewKeyring(oldKey)
+		m := &snapshot.Manifest{Encrypted: true, KeyID: keys.KeyID().String()}
+		if err := s.Save(context.Background(), snapshotutil.NewEncrypterReadCloser(f, keys), m); err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, m)
+	}
+
+	// the first snapshot was saved by an older version of e2d, without a
+	// manifest
+	if err := os.Remove(filepath.Join(dir, "snapshots", saved[0].Name+".manifest")); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "snapshot.keys")
+	if err := ioutil.WriteFile(keyFile, []byte(crypto.EncodeKey(newKey)+"\n"+crypto.EncodeKey(oldKey)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	u := "file://" + filepath.Join(dir, "snapshots") + "/"
+	cmd := newSnapshotRekeyCmd()
+	cmd.SetArgs([]string{u, "--key-file", keyFile, "--scratch-dir", dir})
+	captureStdout(t, func() {
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// both snapshots can be restored with only the new key, and have a
+	// manifest recording it
+	id := crypto.NewKeyring(newKey).KeyID().String()
+	o := &snapshotOptions{Keys: crypto.EncodeKey(newKey), ScratchDir: dir}
+	for _, m := range saved {
+		manifest, err := s.LoadManifest(m.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest == nil || manifest.KeyID != id || manifest.Replacing() {
+			t.Fatalf("expected %s to be rekeyed with %s, received manifest %+v", m.Name, id, manifest)
+		}
+		_, _, cleanup, err := o.extractTemp(u, m.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cleanup()
+	}
+}
+
+// snapshotServer is a Manager service that only takes snapshots.
+type snapshotServer struct {
+	e2dpb.ManagerServer
+
+	resp *e2dpb.SnapshotResponse
+}
+
+func (s *snapshotServer) Snapshot(ctx context.Context, _ *types.Empty) (*e2dpb.SnapshotResponse, error) {
+	return s.resp, nil
+}
+
+func TestSnapshotSave(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &e2dpb.SnapshotResponse{
+		Name:       "etcd.snapshot.1591012800.000000000",
+		Created:    time.Unix(1591012800, 0).UTC(),
+		Revision:   10,
+		Leader:     "node1",
+		StoredSize: 1024,
+		Sha256:     "abc",
+	}
+	srv := grpc.NewServer()
+	e2dpb.RegisterManagerServer(srv, &snapshotServer{resp: expected})
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	cmd := newSnapshotSaveCmd()
+	cmd.SetArgs([]string{"--client-addr", l.Addr().String(), "-o", "json"})
+	out := captureStdout(t, func() {
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var resp e2dpb.SnapshotResponse
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("cannot parse output %q: %v", out, err)
+	}
+	if resp.Name != expected.Name || resp.Revision != expected.Revision || !resp.Created.Equal(expected.Created) {
+		t.Fatalf("expected %+v, received %+v", expected, resp)
+	}
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/cmdutil"
+	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
+)
+
+type statusOptions struct {
+	clientOptions
+
+	Output string
+}
+
+func getStatus(o *statusOptions) (*e2dpb.StatusResponse, error) {
+	ctx := context.Background()
+	c, conn, err := newManagerClient(ctx, &o.clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+
+	return c.Status(ctx, &types.Empty{})
+}
+
+func newStatusCmd() *cobra.Command {
+	o := &statusOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "show the status of the cluster from a running e2d instance",
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := getStatus(o)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			if err := printOutput(o.Output, resp, func(w io.Writer) {
+				printStatusTable(w, resp)
+			}); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&o.Output, "output", "o", outputTable, "output format {table,json,yaml}")
+	if err := cmdutil.SetEnvs(&o.clientOptions); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+
+	return cmd
+}
+
+func newMembersCmd() *cobra.Command {
+	o := &statusOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "members",
+		Short: "list the members of the cluster from a running e2d instance",
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := getStatus(o)
+			if err != nil {
+				log.Fatalf("%+v", err)
+			}
+			if err := printOutput(o.Output, resp.Members, func(w io.Writer) {
+				printMembersTable(w, resp.Members)
+			}); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		},
+	}
+
+	o.addFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&o.Output, "output", "o", outputTable, "output format {table,json,yaml}")
+	if err := cmdutil.SetEnvs(&o.clientOptions); err != nil {
+		log.Debug("cannot set environment variables", zap.Error(err))
+	}
+
+	return cmd
+}
+
+func printStatusTable(w io.Writer, resp *e2dpb.StatusResponse) {
+	suspects := "none"
+	if len(resp.Suspects) > 0 {
+		suspects = strings.Join(resp.Suspects, ",")
+	}
+	// every member that has been leader reports the last snapshot it took,
+	// so the newest of them is shown
+	snapshot := "none"
+	var snapshotRev int64
+	for _, m := range resp.Members {
+		if m.LastSnapshotTime != nil && m.LastSnapshotRevision > snapshotRev {
+			snapshotRev = m.LastSnapshotRevision
+			snapshot = fmt.Sprintf("revision %d at %s by %s", m.LastSnapshotRevision, m.LastSnapshotTime.Format(time.RFC3339), m.Name)
+		}
+	}
+	fmt.Fprintf(w, "NAME:\t%s\n", resp.Name)
+	fmt.Fprintf(w, "CLUSTER ID:\t%x\n", resp.ClusterID)
+	fmt.Fprintf(w, "REQUIRED CLUSTER SIZE:\t%d\n", resp.RequiredClusterSize)
+	fmt.Fprintf(w, "QUORUM:\t%t\n", resp.HasQuorum)
+	fmt.Fprintf(w, "SUSPECTS:\t%s\n", suspects)
+	fmt.Fprintf(w, "LAST SNAPSHOT:\t%s\n", snapshot)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "NAME\tID\tGOSSIP\tLEADER\tLEARNER\tTERM\tINDEX\tAPPLIED\tDB SIZE\tERROR")
+	for _, m := range resp.Members {
+		fmt.Fprintf(w, "%s\t%x\t%s\t%t\t%t\t%d\t%d\t%d\t%s\t%s\n",
+			m.Name,
+			m.Id,
+			m.GossipStatus,
+			m.IsLeader,
+			m.IsLearner,
+			m.RaftTerm,
+			m.RaftIndex,
+			m.RaftAppliedIndex,
+			formatBytes(m.DbSize),
+			m.Error,
+		)
+	}
+}
+
+func printMembersTable(w io.Writer, members []*e2dpb.MemberStatus) {
+	fmt.Fprintln(w, "NAME\tID\tPEER URLS\tCLIENT URLS\tGOSSIP\tLEADER\tLEARNER")
+	for _, m := range members {
+		fmt.Fprintf(w, "%s\t%x\t%s\t%s\t%s\t%t\t%t\n",
+			m.Name,
+			m.Id,
+			strings.Join(m.PeerURLs, ","),
+			strings.Join(m.ClientURLs, ","),
+			m.GossipStatus,
+			m.IsLeader,
+			m.IsLearner,
+		)
+	}
+}
@@ -2,12 +2,17 @@ package manager
 
 import (
 	"context"
+	"net/url"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+	"go.etcd.io/etcd/etcdserver/etcdserverpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/criticalstack/e2d/pkg/client"
+	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
 )
 
 type Client struct {
@@ -35,16 +40,7 @@ func (c *Client) members(ctx context.Context) (map[string]*Member, error) {
 
 	members := make(map[string]*Member)
 	for _, member := range resp.Members {
-		m := &Member{
-			ID:   member.ID,
-			Name: member.Name,
-		}
-		if len(member.ClientURLs) > 0 {
-			m.ClientURL = member.ClientURLs[0]
-		}
-		if len(member.PeerURLs) > 0 {
-			m.PeerURL = member.PeerURLs[0]
-		}
+		m := newMemberFromPB(member)
 		members[m.Name] = m
 	}
 	return members, nil
@@ -58,17 +54,65 @@ func (c *Client) addMember(ctx context.Context, peerURL string) (*Member, error)
 	if err != nil {
 		return nil, err
 	}
-	m := &Member{
-		ID:   resp.Member.ID,
-		Name: resp.Member.Name,
+	return newMemberFromPB(resp.Member), nil
+}
+
+// addLearner adds a new non-voting member to the cluster. Learners receive
+// raft log updates but do not count towards quorum until promoted.
+func (c *Client) addLearner(ctx context.Context, peerURL string) (*Member, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	resp, err := c.MemberAddAsLearner(ctx, []string{peerURL})
+	if err != nil {
+		return nil, err
 	}
-	if len(resp.Member.ClientURLs) > 0 {
-		m.ClientURL = resp.Member.ClientURLs[0]
+	return newMemberFromPB(resp.Member), nil
+}
+
+func (c *Client) promoteMember(ctx context.Context, id uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	if _, err := c.MemberPromote(ctx, id); err != nil {
+		return errors.Wrap(err, "PromoteMember")
 	}
-	if len(resp.Member.PeerURLs) > 0 {
-		m.PeerURL = resp.Member.PeerURLs[0]
+	return nil
+}
+
+// leaderAppliedIndex returns the applied index reported by the current
+// leader of the cluster.
+func (c *Client) leaderAppliedIndex(ctx context.Context) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	resp, err := c.MemberList(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var leaderID uint64
+	for _, ep := range c.Endpoints() {
+		sresp, err := c.Status(ctx, ep)
+		if err != nil {
+			continue
+		}
+		leaderID = sresp.Leader
+		break
 	}
-	return m, nil
+	if leaderID == 0 {
+		return 0, errors.New("cannot determine cluster leader")
+	}
+	for _, member := range resp.Members {
+		if member.ID != leaderID || len(member.ClientURLs) == 0 {
+			continue
+		}
+		sresp, err := c.Status(ctx, member.ClientURLs[0])
+		if err != nil {
+			return 0, err
+		}
+		return sresp.RaftAppliedIndex, nil
+	}
+	return 0, errors.Errorf("cannot find leader %x", leaderID)
 }
 
 func (c *Client) removeMember(ctx context.Context, id uint64) error {
@@ -81,6 +125,20 @@ func (c *Client) removeMember(ctx context.Context, id uint64) error {
 	return nil
 }
 
+func newMemberFromPB(member *etcdserverpb.Member) *Member {
+	m := &Member{
+		ID:   member.ID,
+		Name: member.Name,
+	}
+	if len(member.ClientURLs) > 0 {
+		m.ClientURL = member.ClientURLs[0]
+	}
+	if len(member.PeerURLs) > 0 {
+		m.PeerURL = member.PeerURLs[0]
+	}
+	return m
+}
+
 func (c *Client) removeMemberLocked(ctx context.Context, member *Member) error {
 	unlock, err := c.Lock(member.Name, 10*time.Second)
 	if err != nil {
@@ -93,3 +151,27 @@ func (c *Client) removeMemberLocked(ctx context.Context, member *Member) error {
 
 	return c.removeMember(ctx, member.ID)
 }
+
+// newManagerClient connects to the Manager service of the member with the
+// provided client url. The returned connection must be closed by the caller.
+func newManagerClient(ctx context.Context, clientURL string, sc client.SecurityConfig) (e2dpb.ManagerClient, *grpc.ClientConn, error) {
+	u, err := url.Parse(clientURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := []grpc.DialOption{grpc.WithBlock()}
+	if sc.Enabled() {
+		tlsConfig, err := sc.TLSInfo().ClientConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	conn, err := grpc.DialContext(ctx, u.Host, opts...)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot connect to %s", u.Host)
+	}
+	return e2dpb.NewManagerClient(conn), conn, nil
+}
@@ -2,13 +2,8 @@
 package manager
 
 import (
-	"bytes"
-	"crypto/sha512"
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/url"
@@ -21,6 +16,8 @@ import (
 	"github.com/criticalstack/e2d/pkg/log"
 	"github.com/criticalstack/e2d/pkg/netutil"
 	"github.com/criticalstack/e2d/pkg/snapshot"
+	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
 	"github.com/pkg/errors"
 	bolt "go.etcd.io/bbolt"
 	"go.uber.org/zap"
@@ -76,18 +73,78 @@ type Config struct {
 	// interval for creating etcd snapshots
 	SnapshotInterval time.Duration
 
-	// use gzip compression for snapshot backup
-	SnapshotCompression bool
+	// name of the codec used to compress snapshot backups and segments, e.g.
+	// "gzip" or "zstd" (disabled if empty). Compressed snapshots are always
+	// detected when restoring, whatever the codec.
+	SnapshotCompression string
 
 	// use aes-256 encryption for snapshot backup
 	SnapshotEncryption bool
 
+	// file containing the keys used for snapshot encryption, in the format
+	// read by crypto.ParseKeys. The first key encrypts new snapshots, while
+	// every key can decrypt them. When neither this nor SnapshotKeys is set,
+	// the key is derived from the CA key.
+	SnapshotKeyFile string
+
+	// keys used for snapshot encryption, the same as the contents of
+	// SnapshotKeyFile
+	SnapshotKeys string
+
+	// external key manager that wraps the data key of each encrypted
+	// snapshot, e.g. crypto.VaultTransit or crypto.AmazonKMS, instead of the
+	// snapshot keys. The snapshot keys can still decrypt snapshots saved
+	// before it was set.
+	SnapshotKeyProvider crypto.KeyProvider
+
+	// which snapshots to keep after each snapshot backup, in addition to the
+	// most recent one (disabled when zero)
+	SnapshotRetention snapshot.RetentionPolicy
+
+	// restore from the snapshot identified by name or point in time, rather
+	// than the latest snapshot, when creating a new cluster. It is only used
+	// for the first restore, and ignored when the data directory exists.
+	SnapshotRestoreFrom string
+
+	// how often the changes made since the last snapshot backup are shipped
+	// as incremental segments, while the member is leader (disabled when
+	// zero)
+	SnapshotSegmentInterval time.Duration
+
+	// replay segments up to this revision when restoring from a snapshot,
+	// rather than every stored segment. Segments are only replayed onto an
+	// older snapshot chosen with SnapshotRestoreFrom when this is set.
+	SnapshotRestoreRevision int64
+
+	// amount of time allowed to save a snapshot backup, which is
+	// SnapshotTimeout plus SnapshotTimeoutPerGiB for every GiB of the etcd
+	// database, so that large databases are not cut off
+	SnapshotTimeout       time.Duration
+	SnapshotTimeoutPerGiB time.Duration
+
+	// directory the downloaded snapshot is written to while restoring, which
+	// defaults to the system temp directory and cannot be within Dir
+	SnapshotScratchDir string
+
+	// take a snapshot before maintenance operations requested through the
+	// Manager service (leave, restart, resize and move-leader), which are
+	// refused if the snapshot cannot be saved
+	SnapshotBeforeMaintenance bool
+
 	// how often to perform a health check
 	HealthCheckInterval time.Duration
 
 	// time until an unreachable member is considered unhealthy
 	HealthCheckTimeout time.Duration
 
+	// add new members as voting members immediately rather than first adding
+	// them as learners
+	DisableLearnerJoin bool
+
+	// amount of time a learner has to catch up with the leader and be promoted
+	// before it is removed from the cluster
+	LearnerPromotionTimeout time.Duration
+
 	// configures authentication/transport security for clients
 	ClientSecurity client.SecurityConfig
 
@@ -100,11 +157,21 @@ type Config struct {
 	// configures the level of the logger used by etcd
 	EtcdLogLevel zapcore.Level
 
+	// address used to serve prometheus metrics, disabled when empty
+	MetricsAddr string
+
+	// url that lifecycle events are sent to as JSON, disabled when empty
+	NotifyWebhookURL string
+
+	// shared secret used to sign webhook notifications with HMAC-SHA256
+	NotifyWebhookSecret string
+
 	discovery.PeerGetter
 	snapshot.Snapshotter
 
-	gossipSecretKey       []byte
-	snapshotEncryptionKey *[32]byte
+	gossipSecretKey []byte
+	snapshotKeys    *crypto.Keyring
+	snapshotCodec   snapshotutil.Codec
 
 	Debug bool
 }
@@ -117,6 +184,12 @@ func (c *Config) validate() error {
 	if c.SnapshotInterval == 0 {
 		c.SnapshotInterval = 1 * time.Minute
 	}
+	if c.SnapshotTimeout == 0 {
+		c.SnapshotTimeout = 1 * time.Minute
+	}
+	if c.SnapshotTimeoutPerGiB == 0 {
+		c.SnapshotTimeoutPerGiB = 5 * time.Minute
+	}
 	if c.HealthCheckInterval == 0 {
 		c.HealthCheckInterval = 1 * time.Minute
 	}
@@ -126,6 +199,9 @@ func (c *Config) validate() error {
 	if c.BootstrapTimeout == 0 {
 		c.BootstrapTimeout = 30 * time.Minute
 	}
+	if c.LearnerPromotionTimeout == 0 {
+		c.LearnerPromotionTimeout = 5 * time.Minute
+	}
 	for i, baddr := range c.BootstrapAddrs {
 		addr, err := netutil.FixUnspecifiedHostAddr(baddr)
 		if err != nil {
@@ -190,42 +266,89 @@ func (c *Config) validate() error {
 		return errors.Wrapf(err, "cannot split GossipAddr: %#v", c.GossipAddr)
 	}
 
-	// both memberlist security and snapshot encryption are implicitly based
-	// upon the CA key
+	// memberlist security is implicitly based upon the CA key, as is snapshot
+	// encryption unless dedicated snapshot keys are provided
+	switch {
+	case c.SnapshotKeyFile != "" && c.SnapshotKeys != "":
+		return errors.New("cannot provide both snapshot key file and snapshot keys")
+	case c.SnapshotKeyFile != "":
+		c.snapshotKeys, err = crypto.ReadKeyFile(c.SnapshotKeyFile)
+		if err != nil {
+			return errors.Wrapf(err, "cannot read snapshot key file: %#v", c.SnapshotKeyFile)
+		}
+	case c.SnapshotKeys != "":
+		c.snapshotKeys, err = crypto.ParseKeys([]byte(c.SnapshotKeys))
+		if err != nil {
+			return errors.Wrap(err, "cannot parse snapshot keys")
+		}
+	}
 	if c.CAKeyFile != "" {
 		data, err := ioutil.ReadFile(c.CAKeyFile)
 		if err != nil {
 			return err
 		}
-		block, _ := pem.Decode(data)
-		if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		key, err := crypto.NewKeyFromCAKey(data)
+		if err != nil {
 			return errors.Wrapf(err, "cannot parse ca key file: %#v", c.CAKeyFile)
 		}
-		h := sha512.New512_256()
-		if _, err := h.Write(block.Bytes); err != nil {
-			return err
+		c.gossipSecretKey = key[:]
+
+		// snapshots encrypted before dedicated snapshot keys were provided
+		// can still be decrypted
+		if c.snapshotKeys == nil {
+			c.snapshotKeys = crypto.NewKeyring(key)
+		} else {
+			c.snapshotKeys.SetLegacy(key)
 		}
-		key := [32]byte{}
-		if _, err := io.ReadFull(bytes.NewReader(h.Sum(nil)), key[:]); err != nil {
-			return err
+	}
+
+	if c.SnapshotCompression != "" {
+		c.snapshotCodec, err = snapshotutil.LookupCodec(c.SnapshotCompression)
+		if err != nil {
+			return errors.Wrap(err, "invalid snapshot compression")
 		}
-		c.gossipSecretKey = key[:]
-		c.snapshotEncryptionKey = &key
 	}
 
-	if c.SnapshotEncryption && c.CAKeyFile == "" {
-		return errors.New("must provide ca key for snapshot encryption")
+	if c.SnapshotKeyProvider != nil {
+		if c.snapshotKeys == nil {
+			c.snapshotKeys = crypto.NewKeyring(nil)
+		}
+		c.snapshotKeys.SetProvider(c.SnapshotKeyProvider)
+	}
+
+	if c.NotifyWebhookURL != "" {
+		u, err := url.Parse(c.NotifyWebhookURL)
+		if err != nil {
+			return errors.Wrapf(err, "invalid webhook url: %#v", c.NotifyWebhookURL)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return errors.Errorf("webhook url must use http or https: %#v", c.NotifyWebhookURL)
+		}
+	}
+
+	if c.SnapshotEncryption && !c.snapshotKeys.CanEncrypt() {
+		return errors.New("must provide snapshot keys, a snapshot key provider or ca key for snapshot encryption")
+	}
+	if r := c.SnapshotRetention; r.Hourly < 0 || r.Daily < 0 || r.Weekly < 0 || r.Monthly < 0 {
+		return errors.Errorf("snapshot retention counts cannot be negative: %+v", r)
+	}
+	if c.SnapshotScratchDir != "" {
+		// the data-dir is removed before a snapshot is restored into it
+		if within, err := isWithinDir(c.SnapshotScratchDir, c.Dir); err != nil {
+			return err
+		} else if within {
+			return errors.Errorf("snapshot scratch dir cannot be within the data-dir: %#v", c.SnapshotScratchDir)
+		}
 	}
 
 	if len(c.BootstrapAddrs) == 0 && c.RequiredClusterSize > 1 {
 		return errors.New("must provide at least 1 BootstrapAddrs when not a single-host cluster")
 	}
-	switch c.RequiredClusterSize {
-	case 0:
+	if c.RequiredClusterSize == 0 {
 		c.RequiredClusterSize = 1
-	case 1, 3, 5:
-	default:
-		return errors.New("value of RequiredClusterSize must be 1, 3, or 5")
+	}
+	if err := validateClusterSize(c.RequiredClusterSize); err != nil {
+		return err
 	}
 	if c.Name == "" {
 		if name, err := getExistingNameFromDataDir(filepath.Join(c.Dir, "member/snap/db"), c.PeerURL); err == nil {
@@ -239,6 +362,39 @@ func (c *Config) validate() error {
 	return nil
 }
 
+// snapshotTimeout returns the amount of time allowed to save a snapshot of an
+// etcd database of the provided size.
+func (c *Config) snapshotTimeout(size int64) time.Duration {
+	return c.SnapshotTimeout + time.Duration(float64(c.SnapshotTimeoutPerGiB)*float64(size)/(1<<30))
+}
+
+// isWithinDir checks whether path is dir, or a path within dir.
+func isWithinDir(path, dir string) (bool, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false, nil
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+// validateClusterSize ensures that n is a valid cluster size. Only positive,
+// odd cluster sizes are allowed since an even number of members increases the
+// quorum size without improving the failure tolerance of the cluster.
+func validateClusterSize(n int) error {
+	if n < 1 || n%2 == 0 {
+		return errors.Errorf("value of RequiredClusterSize must be a positive odd number, received %d", n)
+	}
+	return nil
+}
+
 // shortName returns a shorter, lowercase version of the node name. The intent
 // is to make log reading easier.
 func shortName(name string) string {
@@ -2,9 +2,17 @@ package manager
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/criticalstack/e2d/pkg/netutil"
+	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
 )
 
 func TestConfigUnspecifiedAddr(t *testing.T) {
@@ -34,3 +42,190 @@ func TestConfigUnspecifiedAddr(t *testing.T) {
 		t.Fatalf("BootstrapAddr unspecified address not fixed: %v", cfg.BootstrapAddrs[0])
 	}
 }
+
+func TestConfigRequiredClusterSize(t *testing.T) {
+	cases := []struct {
+		size     int
+		expected int
+		err      bool
+	}{
+		{size: 0, expected: 1},
+		{size: 1, expected: 1},
+		{size: 3, expected: 3},
+		{size: 5, expected: 5},
+		{size: 7, expected: 7},
+		{size: 9, expected: 9},
+		{size: 2, err: true},
+		{size: 4, err: true},
+		{size: -1, err: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("size-%d", tc.size), func(t *testing.T) {
+			cfg := &Config{
+				ClientAddr:          "127.0.0.1:2379",
+				PeerAddr:            "127.0.0.1:2380",
+				GossipAddr:          "127.0.0.1:7980",
+				BootstrapAddrs:      []string{"127.0.0.1:7981"},
+				RequiredClusterSize: tc.size,
+			}
+			err := cfg.validate()
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error for RequiredClusterSize %d", tc.size)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.RequiredClusterSize != tc.expected {
+				t.Fatalf("expected RequiredClusterSize %d, received %d", tc.expected, cfg.RequiredClusterSize)
+			}
+		})
+	}
+}
+
+func TestConfigSnapshotScratchDir(t *testing.T) {
+	cases := []struct {
+		dir string
+		err bool
+	}{
+		{dir: "/var/tmp"},
+		{dir: "data-scratch"},
+		{dir: "data", err: true},
+		{dir: "data/scratch", err: true},
+		{dir: "./data/../data/scratch", err: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.dir, func(t *testing.T) {
+			cfg := &Config{
+				Dir:                "data",
+				ClientAddr:         "127.0.0.1:2379",
+				PeerAddr:           "127.0.0.1:2380",
+				GossipAddr:         "127.0.0.1:7980",
+				SnapshotScratchDir: tc.dir,
+			}
+			err := cfg.validate()
+			if tc.err && err == nil {
+				t.Fatalf("expected error for SnapshotScratchDir %#v", tc.dir)
+			}
+			if !tc.err && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestConfigSnapshotTimeout(t *testing.T) {
+	cfg := &Config{
+		ClientAddr: "127.0.0.1:2379",
+		PeerAddr:   "127.0.0.1:2380",
+		GossipAddr: "127.0.0.1:7980",
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if d := cfg.snapshotTimeout(0); d != 1*time.Minute {
+		t.Fatalf("expected timeout %v, received %v", 1*time.Minute, d)
+	}
+	if d := cfg.snapshotTimeout(2 << 30); d != 11*time.Minute {
+		t.Fatalf("expected timeout %v, received %v", 11*time.Minute, d)
+	}
+}
+
+func TestConfigSnapshotCompression(t *testing.T) {
+	for _, name := range []string{"", "gzip", "zstd", "lz4", "bzip2"} {
+		cfg := &Config{
+			ClientAddr:          "127.0.0.1:2379",
+			PeerAddr:            "127.0.0.1:2380",
+			GossipAddr:          "127.0.0.1:7980",
+			SnapshotCompression: name,
+		}
+		err := cfg.validate()
+		if name == "bzip2" {
+			if errors.Cause(err) != snapshotutil.ErrUnknownCodec {
+				t.Fatalf("expected ErrUnknownCodec, received %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (name == "") != (cfg.snapshotCodec == nil) || (name != "" && cfg.snapshotCodec.Name() != name) {
+			t.Fatalf("unexpected codec for %#v: %v", name, cfg.snapshotCodec)
+		}
+	}
+}
+
+func TestConfigSnapshotKeys(t *testing.T) {
+	active, old := crypto.NewEncryptionKey(), crypto.NewEncryptionKey()
+	keys := crypto.EncodeKey(active) + "\n" + crypto.EncodeKey(old) + "\n"
+
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "snapshot.keys")
+	if err := ioutil.WriteFile(keyFile, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	vault, err := crypto.NewVaultTransit(&crypto.VaultConfig{Address: "http://127.0.0.1:8200", Key: "snapshots"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		encryption bool
+		keyFile    string
+		keys       string
+		provider   crypto.KeyProvider
+		err        bool
+	}{
+		{name: "none"},
+		{name: "no keys", encryption: true, err: true},
+		{name: "keys", encryption: true, keys: keys},
+		{name: "key file", encryption: true, keyFile: keyFile},
+		{name: "both", encryption: true, keyFile: keyFile, keys: keys, err: true},
+		{name: "invalid", encryption: true, keys: "secret", err: true},
+		{name: "provider", encryption: true, provider: vault},
+		{name: "provider with keys", encryption: true, keys: keys, provider: vault},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{
+				ClientAddr:          "127.0.0.1:2379",
+				PeerAddr:            "127.0.0.1:2380",
+				GossipAddr:          "127.0.0.1:7980",
+				SnapshotEncryption:  tc.encryption,
+				SnapshotKeyFile:     tc.keyFile,
+				SnapshotKeys:        tc.keys,
+				SnapshotKeyProvider: tc.provider,
+			}
+			err := cfg.validate()
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.provider != nil {
+				if cfg.snapshotKeys.KeyID() != vault.KeyID() || (tc.keys != "") != (cfg.snapshotKeys.Len() == 2) {
+					t.Fatalf("unexpected snapshot keys: key %s", cfg.snapshotKeys.KeyID())
+				}
+				return
+			}
+			if tc.encryption && (cfg.snapshotKeys.ActiveID() != crypto.NewKeyID(active) || cfg.snapshotKeys.Len() != 2) {
+				t.Fatalf("unexpected snapshot keys: active key %s", cfg.snapshotKeys.ActiveID())
+			}
+		})
+	}
+}
@@ -8,16 +8,19 @@ import (
 	fmt "fmt"
 	_ "github.com/gogo/protobuf/gogoproto"
 	proto "github.com/gogo/protobuf/proto"
+	github_com_gogo_protobuf_types "github.com/gogo/protobuf/types"
 	types "github.com/gogo/protobuf/types"
 	grpc "google.golang.org/grpc"
 	io "io"
 	math "math"
+	time "time"
 )
 
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto.Marshal
 var _ = fmt.Errorf
 var _ = math.Inf
+var _ = time.Kitchen
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the proto package it is being compiled against.
@@ -25,25 +28,42 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
 
-type HealthResponse struct {
-	Status               string   `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+type MemberStatus struct {
+	Id         uint64   `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name       string   `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	PeerURLs   []string `protobuf:"bytes,3,rep,name=peerURLs,proto3" json:"peerURLs,omitempty"`
+	ClientURLs []string `protobuf:"bytes,4,rep,name=clientURLs,proto3" json:"clientURLs,omitempty"`
+	// status of the member in the gossip network
+	GossipStatus     string `protobuf:"bytes,5,opt,name=gossipStatus,proto3" json:"gossipStatus,omitempty"`
+	IsLeader         bool   `protobuf:"varint,6,opt,name=isLeader,proto3" json:"isLeader,omitempty"`
+	IsLearner        bool   `protobuf:"varint,7,opt,name=isLearner,proto3" json:"isLearner,omitempty"`
+	RaftTerm         uint64 `protobuf:"varint,8,opt,name=raftTerm,proto3" json:"raftTerm,omitempty"`
+	RaftIndex        uint64 `protobuf:"varint,9,opt,name=raftIndex,proto3" json:"raftIndex,omitempty"`
+	RaftAppliedIndex uint64 `protobuf:"varint,10,opt,name=raftAppliedIndex,proto3" json:"raftAppliedIndex,omitempty"`
+	DbSize           int64  `protobuf:"varint,11,opt,name=dbSize,proto3" json:"dbSize,omitempty"`
+	DbSizeInUse      int64  `protobuf:"varint,12,opt,name=dbSizeInUse,proto3" json:"dbSizeInUse,omitempty"`
+	// revision and time of the last snapshot backup taken by this member
+	LastSnapshotRevision int64      `protobuf:"varint,13,opt,name=lastSnapshotRevision,proto3" json:"lastSnapshotRevision,omitempty"`
+	LastSnapshotTime     *time.Time `protobuf:"bytes,14,opt,name=lastSnapshotTime,proto3,stdtime" json:"lastSnapshotTime,omitempty"`
+	// set when the member status could not be retrieved
+	Error                string   `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *HealthResponse) Reset()         { *m = HealthResponse{} }
-func (m *HealthResponse) String() string { return proto.CompactTextString(m) }
-func (*HealthResponse) ProtoMessage()    {}
-func (*HealthResponse) Descriptor() ([]byte, []int) {
+func (m *MemberStatus) Reset()         { *m = MemberStatus{} }
+func (m *MemberStatus) String() string { return proto.CompactTextString(m) }
+func (*MemberStatus) ProtoMessage()    {}
+func (*MemberStatus) Descriptor() ([]byte, []int) {
 	return fileDescriptor_d6214d299197430f, []int{0}
 }
-func (m *HealthResponse) XXX_Unmarshal(b []byte) error {
+func (m *MemberStatus) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
 }
-func (m *HealthResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+func (m *MemberStatus) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
 	if deterministic {
-		return xxx_messageInfo_HealthResponse.Marshal(b, m, deterministic)
+		return xxx_messageInfo_MemberStatus.Marshal(b, m, deterministic)
 	} else {
 		b = b[:cap(b)]
 		n, err := m.MarshalTo(b)
@@ -53,25 +73,215 @@ func (m *HealthResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, erro
 		return b[:n], nil
 	}
 }
-func (m *HealthResponse) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_HealthResponse.Merge(m, src)
+func (m *MemberStatus) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MemberStatus.Merge(m, src)
 }
-func (m *HealthResponse) XXX_Size() int {
+func (m *MemberStatus) XXX_Size() int {
 	return m.Size()
 }
-func (m *HealthResponse) XXX_DiscardUnknown() {
-	xxx_messageInfo_HealthResponse.DiscardUnknown(m)
+func (m *MemberStatus) XXX_DiscardUnknown() {
+	xxx_messageInfo_MemberStatus.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_HealthResponse proto.InternalMessageInfo
+var xxx_messageInfo_MemberStatus proto.InternalMessageInfo
 
-func (m *HealthResponse) GetStatus() string {
+func (m *MemberStatus) GetId() uint64 {
 	if m != nil {
-		return m.Status
+		return m.Id
+	}
+	return 0
+}
+
+func (m *MemberStatus) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *MemberStatus) GetPeerURLs() []string {
+	if m != nil {
+		return m.PeerURLs
+	}
+	return nil
+}
+
+func (m *MemberStatus) GetClientURLs() []string {
+	if m != nil {
+		return m.ClientURLs
+	}
+	return nil
+}
+
+func (m *MemberStatus) GetGossipStatus() string {
+	if m != nil {
+		return m.GossipStatus
+	}
+	return ""
+}
+
+func (m *MemberStatus) GetIsLeader() bool {
+	if m != nil {
+		return m.IsLeader
+	}
+	return false
+}
+
+func (m *MemberStatus) GetIsLearner() bool {
+	if m != nil {
+		return m.IsLearner
+	}
+	return false
+}
+
+func (m *MemberStatus) GetRaftTerm() uint64 {
+	if m != nil {
+		return m.RaftTerm
+	}
+	return 0
+}
+
+func (m *MemberStatus) GetRaftIndex() uint64 {
+	if m != nil {
+		return m.RaftIndex
+	}
+	return 0
+}
+
+func (m *MemberStatus) GetRaftAppliedIndex() uint64 {
+	if m != nil {
+		return m.RaftAppliedIndex
+	}
+	return 0
+}
+
+func (m *MemberStatus) GetDbSize() int64 {
+	if m != nil {
+		return m.DbSize
+	}
+	return 0
+}
+
+func (m *MemberStatus) GetDbSizeInUse() int64 {
+	if m != nil {
+		return m.DbSizeInUse
+	}
+	return 0
+}
+
+func (m *MemberStatus) GetLastSnapshotRevision() int64 {
+	if m != nil {
+		return m.LastSnapshotRevision
+	}
+	return 0
+}
+
+func (m *MemberStatus) GetLastSnapshotTime() *time.Time {
+	if m != nil {
+		return m.LastSnapshotTime
+	}
+	return nil
+}
+
+func (m *MemberStatus) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type StatusResponse struct {
+	// name of the member that handled the request
+	Name                string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	ClusterID           uint64 `protobuf:"varint,2,opt,name=clusterID,proto3" json:"clusterID,omitempty"`
+	RequiredClusterSize int32  `protobuf:"varint,3,opt,name=requiredClusterSize,proto3" json:"requiredClusterSize,omitempty"`
+	// whether the member that handled the request can see a majority of
+	// running members
+	HasQuorum bool `protobuf:"varint,4,opt,name=hasQuorum,proto3" json:"hasQuorum,omitempty"`
+	// members that are suspected of having failed and will be removed if they
+	// do not recover
+	Suspects             []string        `protobuf:"bytes,5,rep,name=suspects,proto3" json:"suspects,omitempty"`
+	Members              []*MemberStatus `protobuf:"bytes,6,rep,name=members,proto3" json:"members,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *StatusResponse) Reset()         { *m = StatusResponse{} }
+func (m *StatusResponse) String() string { return proto.CompactTextString(m) }
+func (*StatusResponse) ProtoMessage()    {}
+func (*StatusResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6214d299197430f, []int{1}
+}
+func (m *StatusResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *StatusResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_StatusResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *StatusResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StatusResponse.Merge(m, src)
+}
+func (m *StatusResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *StatusResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_StatusResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StatusResponse proto.InternalMessageInfo
+
+func (m *StatusResponse) GetName() string {
+	if m != nil {
+		return m.Name
 	}
 	return ""
 }
 
+func (m *StatusResponse) GetClusterID() uint64 {
+	if m != nil {
+		return m.ClusterID
+	}
+	return 0
+}
+
+func (m *StatusResponse) GetRequiredClusterSize() int32 {
+	if m != nil {
+		return m.RequiredClusterSize
+	}
+	return 0
+}
+
+func (m *StatusResponse) GetHasQuorum() bool {
+	if m != nil {
+		return m.HasQuorum
+	}
+	return false
+}
+
+func (m *StatusResponse) GetSuspects() []string {
+	if m != nil {
+		return m.Suspects
+	}
+	return nil
+}
+
+func (m *StatusResponse) GetMembers() []*MemberStatus {
+	if m != nil {
+		return m.Members
+	}
+	return nil
+}
+
 type RestartResponse struct {
 	Msg                  string   `protobuf:"bytes,1,opt,name=msg,proto3" json:"msg,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -83,7 +293,7 @@ func (m *RestartResponse) Reset()         { *m = RestartResponse{} }
 func (m *RestartResponse) String() string { return proto.CompactTextString(m) }
 func (*RestartResponse) ProtoMessage()    {}
 func (*RestartResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_d6214d299197430f, []int{1}
+	return fileDescriptor_d6214d299197430f, []int{2}
 }
 func (m *RestartResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -119,245 +329,2788 @@ func (m *RestartResponse) GetMsg() string {
 	return ""
 }
 
-func init() {
-	proto.RegisterType((*HealthResponse)(nil), "e2dpb.HealthResponse")
-	proto.RegisterType((*RestartResponse)(nil), "e2dpb.RestartResponse")
+type Event struct {
+	Type string    `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Time time.Time `protobuf:"bytes,2,opt,name=time,proto3,stdtime" json:"time"`
+	// name of the member the event relates to
+	Name                 string   `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	Revision             int64    `protobuf:"varint,4,opt,name=revision,proto3" json:"revision,omitempty"`
+	Error                string   `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func init() { proto.RegisterFile("e2dpb.proto", fileDescriptor_d6214d299197430f) }
-
-var fileDescriptor_d6214d299197430f = []byte{
-	// 224 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4e, 0x35, 0x4a, 0x29,
-	0x48, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x05, 0x73, 0xa4, 0xa4, 0xd3, 0xf3, 0xf3,
-	0xd3, 0x73, 0x52, 0xf5, 0xc1, 0x82, 0x49, 0xa5, 0x69, 0xfa, 0xa9, 0xb9, 0x05, 0x25, 0x95, 0x10,
-	0x35, 0x52, 0xba, 0xe9, 0x99, 0x25, 0x19, 0xa5, 0x49, 0x7a, 0xc9, 0xf9, 0xb9, 0xfa, 0xe9, 0xf9,
-	0xe9, 0xf9, 0x08, 0x55, 0x20, 0x1e, 0x98, 0x03, 0x66, 0x41, 0x94, 0x2b, 0x69, 0x70, 0xf1, 0x79,
-	0xa4, 0x26, 0xe6, 0x94, 0x64, 0x04, 0xa5, 0x16, 0x17, 0xe4, 0xe7, 0x15, 0xa7, 0x0a, 0x89, 0x71,
-	0xb1, 0x15, 0x97, 0x24, 0x96, 0x94, 0x16, 0x4b, 0x30, 0x2a, 0x30, 0x6a, 0x70, 0x06, 0x41, 0x79,
-	0x4a, 0xca, 0x5c, 0xfc, 0x41, 0xa9, 0xc5, 0x25, 0x89, 0x45, 0x25, 0x70, 0xa5, 0x02, 0x5c, 0xcc,
-	0xb9, 0xc5, 0xe9, 0x50, 0x75, 0x20, 0xa6, 0x51, 0x23, 0x23, 0x17, 0xbb, 0x6f, 0x62, 0x5e, 0x62,
-	0x7a, 0x6a, 0x91, 0x90, 0x25, 0x17, 0x1b, 0xc4, 0x68, 0x21, 0x31, 0x3d, 0x88, 0x8b, 0xf5, 0x60,
-	0x6e, 0xd1, 0x73, 0x05, 0xb9, 0x58, 0x4a, 0x54, 0x0f, 0xe2, 0x3b, 0x54, 0x17, 0x28, 0x31, 0x08,
-	0x59, 0x73, 0xb1, 0x43, 0xed, 0xc2, 0xa9, 0x57, 0x0c, 0xaa, 0x17, 0xcd, 0x4d, 0x4a, 0x0c, 0x4e,
-	0x3c, 0x27, 0x1e, 0xc9, 0x31, 0x5e, 0x78, 0x24, 0xc7, 0xf8, 0xe0, 0x91, 0x1c, 0x63, 0x12, 0x1b,
-	0x58, 0x9f, 0x31, 0x20, 0x00, 0x00, 0xff, 0xff, 0x42, 0x22, 0x08, 0x7d, 0x49, 0x01, 0x00, 0x00,
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+func (*Event) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6214d299197430f, []int{3}
 }
-
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
-
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
-
-// ManagerClient is the client API for Manager service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
-type ManagerClient interface {
-	Health(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*HealthResponse, error)
-	Restart(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*RestartResponse, error)
+func (m *Event) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
 }
-
-type managerClient struct {
-	cc *grpc.ClientConn
+func (m *Event) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Event.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
 }
-
-func NewManagerClient(cc *grpc.ClientConn) ManagerClient {
-	return &managerClient{cc}
+func (m *Event) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Event.Merge(m, src)
 }
-
-func (c *managerClient) Health(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*HealthResponse, error) {
-	out := new(HealthResponse)
-	err := c.cc.Invoke(ctx, "/e2dpb.Manager/Health", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
+func (m *Event) XXX_Size() int {
+	return m.Size()
+}
+func (m *Event) XXX_DiscardUnknown() {
+	xxx_messageInfo_Event.DiscardUnknown(m)
 }
 
-func (c *managerClient) Restart(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*RestartResponse, error) {
-	out := new(RestartResponse)
-	err := c.cc.Invoke(ctx, "/e2dpb.Manager/Restart", in, out, opts...)
-	if err != nil {
-		return nil, err
+var xxx_messageInfo_Event proto.InternalMessageInfo
+
+func (m *Event) GetType() string {
+	if m != nil {
+		return m.Type
 	}
-	return out, nil
+	return ""
 }
 
-// ManagerServer is the server API for Manager service.
-type ManagerServer interface {
-	Health(context.Context, *types.Empty) (*HealthResponse, error)
-	Restart(context.Context, *types.Empty) (*RestartResponse, error)
+func (m *Event) GetTime() time.Time {
+	if m != nil {
+		return m.Time
+	}
+	return time.Time{}
 }
 
-func RegisterManagerServer(s *grpc.Server, srv ManagerServer) {
-	s.RegisterService(&_Manager_serviceDesc, srv)
+func (m *Event) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
 }
 
-func _Manager_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(types.Empty)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(ManagerServer).Health(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/e2dpb.Manager/Health",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(ManagerServer).Health(ctx, req.(*types.Empty))
+func (m *Event) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
 	}
-	return interceptor(ctx, in, info, handler)
+	return 0
 }
 
-func _Manager_Restart_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(types.Empty)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(ManagerServer).Restart(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/e2dpb.Manager/Restart",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(ManagerServer).Restart(ctx, req.(*types.Empty))
+func (m *Event) GetError() string {
+	if m != nil {
+		return m.Error
 	}
-	return interceptor(ctx, in, info, handler)
+	return ""
 }
 
-var _Manager_serviceDesc = grpc.ServiceDesc{
-	ServiceName: "e2dpb.Manager",
-	HandlerType: (*ManagerServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "Health",
-			Handler:    _Manager_Health_Handler,
-		},
-		{
-			MethodName: "Restart",
-			Handler:    _Manager_Restart_Handler,
-		},
-	},
-	Streams:  []grpc.StreamDesc{},
-	Metadata: "e2dpb.proto",
+type LeaveResponse struct {
+	Msg                  string   `protobuf:"bytes,1,opt,name=msg,proto3" json:"msg,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *HealthResponse) Marshal() (dAtA []byte, err error) {
-	size := m.Size()
-	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
-	if err != nil {
-		return nil, err
+func (m *LeaveResponse) Reset()         { *m = LeaveResponse{} }
+func (m *LeaveResponse) String() string { return proto.CompactTextString(m) }
+func (*LeaveResponse) ProtoMessage()    {}
+func (*LeaveResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6214d299197430f, []int{4}
+}
+func (m *LeaveResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *LeaveResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_LeaveResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
 	}
-	return dAtA[:n], nil
+}
+func (m *LeaveResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaveResponse.Merge(m, src)
+}
+func (m *LeaveResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *LeaveResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaveResponse.DiscardUnknown(m)
 }
 
-func (m *HealthResponse) MarshalTo(dAtA []byte) (int, error) {
-	var i int
-	_ = i
-	var l int
-	_ = l
-	if len(m.Status) > 0 {
-		dAtA[i] = 0xa
-		i++
-		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Status)))
-		i += copy(dAtA[i:], m.Status)
-	}
-	if m.XXX_unrecognized != nil {
-		i += copy(dAtA[i:], m.XXX_unrecognized)
+var xxx_messageInfo_LeaveResponse proto.InternalMessageInfo
+
+func (m *LeaveResponse) GetMsg() string {
+	if m != nil {
+		return m.Msg
 	}
-	return i, nil
+	return ""
 }
 
-func (m *RestartResponse) Marshal() (dAtA []byte, err error) {
-	size := m.Size()
-	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
-	if err != nil {
-		return nil, err
+type MoveLeaderRequest struct {
+	// name of the member to transfer leadership to, when empty the healthiest
+	// running member is chosen
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MoveLeaderRequest) Reset()         { *m = MoveLeaderRequest{} }
+func (m *MoveLeaderRequest) String() string { return proto.CompactTextString(m) }
+func (*MoveLeaderRequest) ProtoMessage()    {}
+func (*MoveLeaderRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6214d299197430f, []int{5}
+}
+func (m *MoveLeaderRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *MoveLeaderRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_MoveLeaderRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
 	}
-	return dAtA[:n], nil
+}
+func (m *MoveLeaderRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MoveLeaderRequest.Merge(m, src)
+}
+func (m *MoveLeaderRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *MoveLeaderRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MoveLeaderRequest.DiscardUnknown(m)
 }
 
-func (m *RestartResponse) MarshalTo(dAtA []byte) (int, error) {
-	var i int
-	_ = i
-	var l int
-	_ = l
-	if len(m.Msg) > 0 {
-		dAtA[i] = 0xa
-		i++
-		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Msg)))
-		i += copy(dAtA[i:], m.Msg)
+var xxx_messageInfo_MoveLeaderRequest proto.InternalMessageInfo
+
+func (m *MoveLeaderRequest) GetName() string {
+	if m != nil {
+		return m.Name
 	}
-	if m.XXX_unrecognized != nil {
-		i += copy(dAtA[i:], m.XXX_unrecognized)
+	return ""
+}
+
+type MoveLeaderResponse struct {
+	PreviousLeader       string   `protobuf:"bytes,1,opt,name=previousLeader,proto3" json:"previousLeader,omitempty"`
+	Leader               string   `protobuf:"bytes,2,opt,name=leader,proto3" json:"leader,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MoveLeaderResponse) Reset()         { *m = MoveLeaderResponse{} }
+func (m *MoveLeaderResponse) String() string { return proto.CompactTextString(m) }
+func (*MoveLeaderResponse) ProtoMessage()    {}
+func (*MoveLeaderResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6214d299197430f, []int{6}
+}
+func (m *MoveLeaderResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *MoveLeaderResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_MoveLeaderResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
 	}
-	return i, nil
+}
+func (m *MoveLeaderResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MoveLeaderResponse.Merge(m, src)
+}
+func (m *MoveLeaderResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *MoveLeaderResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_MoveLeaderResponse.DiscardUnknown(m)
 }
 
-func encodeVarintE2Dpb(dAtA []byte, offset int, v uint64) int {
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
+var xxx_messageInfo_MoveLeaderResponse proto.InternalMessageInfo
+
+func (m *MoveLeaderResponse) GetPreviousLeader() string {
+	if m != nil {
+		return m.PreviousLeader
 	}
-	dAtA[offset] = uint8(v)
-	return offset + 1
+	return ""
 }
-func (m *HealthResponse) Size() (n int) {
-	if m == nil {
-		return 0
+
+func (m *MoveLeaderResponse) GetLeader() string {
+	if m != nil {
+		return m.Leader
 	}
-	var l int
-	_ = l
-	l = len(m.Status)
-	if l > 0 {
-		n += 1 + l + sovE2Dpb(uint64(l))
+	return ""
+}
+
+type ResizeRequest struct {
+	ClusterSize          int32    `protobuf:"varint,1,opt,name=clusterSize,proto3" json:"clusterSize,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ResizeRequest) Reset()         { *m = ResizeRequest{} }
+func (m *ResizeRequest) String() string { return proto.CompactTextString(m) }
+func (*ResizeRequest) ProtoMessage()    {}
+func (*ResizeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6214d299197430f, []int{7}
+}
+func (m *ResizeRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ResizeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ResizeRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
 	}
-	if m.XXX_unrecognized != nil {
-		n += len(m.XXX_unrecognized)
+}
+func (m *ResizeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResizeRequest.Merge(m, src)
+}
+func (m *ResizeRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *ResizeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResizeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResizeRequest proto.InternalMessageInfo
+
+func (m *ResizeRequest) GetClusterSize() int32 {
+	if m != nil {
+		return m.ClusterSize
 	}
-	return n
+	return 0
 }
 
-func (m *RestartResponse) Size() (n int) {
-	if m == nil {
-		return 0
+type ResizeResponse struct {
+	PreviousClusterSize  int32    `protobuf:"varint,1,opt,name=previousClusterSize,proto3" json:"previousClusterSize,omitempty"`
+	ClusterSize          int32    `protobuf:"varint,2,opt,name=clusterSize,proto3" json:"clusterSize,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ResizeResponse) Reset()         { *m = ResizeResponse{} }
+func (m *ResizeResponse) String() string { return proto.CompactTextString(m) }
+func (*ResizeResponse) ProtoMessage()    {}
+func (*ResizeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6214d299197430f, []int{8}
+}
+func (m *ResizeResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ResizeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ResizeResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
 	}
-	var l int
-	_ = l
-	l = len(m.Msg)
-	if l > 0 {
-		n += 1 + l + sovE2Dpb(uint64(l))
+}
+func (m *ResizeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResizeResponse.Merge(m, src)
+}
+func (m *ResizeResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *ResizeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResizeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResizeResponse proto.InternalMessageInfo
+
+func (m *ResizeResponse) GetPreviousClusterSize() int32 {
+	if m != nil {
+		return m.PreviousClusterSize
 	}
-	if m.XXX_unrecognized != nil {
-		n += len(m.XXX_unrecognized)
+	return 0
+}
+
+func (m *ResizeResponse) GetClusterSize() int32 {
+	if m != nil {
+		return m.ClusterSize
+	}
+	return 0
+}
+
+type SnapshotResponse struct {
+	// name of the saved snapshot in the snapshot backup
+	Name     string    `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Created  time.Time `protobuf:"bytes,2,opt,name=created,proto3,stdtime" json:"created"`
+	Revision int64     `protobuf:"varint,3,opt,name=revision,proto3" json:"revision,omitempty"`
+	// name of the member that took the snapshot
+	Leader string `protobuf:"bytes,4,opt,name=leader,proto3" json:"leader,omitempty"`
+	// size and checksum of the stored snapshot
+	StoredSize           int64    `protobuf:"varint,5,opt,name=storedSize,proto3" json:"storedSize,omitempty"`
+	Sha256               string   `protobuf:"bytes,6,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SnapshotResponse) Reset()         { *m = SnapshotResponse{} }
+func (m *SnapshotResponse) String() string { return proto.CompactTextString(m) }
+func (*SnapshotResponse) ProtoMessage()    {}
+func (*SnapshotResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_d6214d299197430f, []int{9}
+}
+func (m *SnapshotResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SnapshotResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SnapshotResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *SnapshotResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SnapshotResponse.Merge(m, src)
+}
+func (m *SnapshotResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *SnapshotResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SnapshotResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SnapshotResponse proto.InternalMessageInfo
+
+func (m *SnapshotResponse) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *SnapshotResponse) GetCreated() time.Time {
+	if m != nil {
+		return m.Created
+	}
+	return time.Time{}
+}
+
+func (m *SnapshotResponse) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+func (m *SnapshotResponse) GetLeader() string {
+	if m != nil {
+		return m.Leader
+	}
+	return ""
+}
+
+func (m *SnapshotResponse) GetStoredSize() int64 {
+	if m != nil {
+		return m.StoredSize
+	}
+	return 0
+}
+
+func (m *SnapshotResponse) GetSha256() string {
+	if m != nil {
+		return m.Sha256
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*MemberStatus)(nil), "e2dpb.MemberStatus")
+	proto.RegisterType((*StatusResponse)(nil), "e2dpb.StatusResponse")
+	proto.RegisterType((*RestartResponse)(nil), "e2dpb.RestartResponse")
+	proto.RegisterType((*Event)(nil), "e2dpb.Event")
+	proto.RegisterType((*LeaveResponse)(nil), "e2dpb.LeaveResponse")
+	proto.RegisterType((*MoveLeaderRequest)(nil), "e2dpb.MoveLeaderRequest")
+	proto.RegisterType((*MoveLeaderResponse)(nil), "e2dpb.MoveLeaderResponse")
+	proto.RegisterType((*ResizeRequest)(nil), "e2dpb.ResizeRequest")
+	proto.RegisterType((*ResizeResponse)(nil), "e2dpb.ResizeResponse")
+	proto.RegisterType((*SnapshotResponse)(nil), "e2dpb.SnapshotResponse")
+}
+
+func init() { proto.RegisterFile("e2dpb.proto", fileDescriptor_d6214d299197430f) }
+
+var fileDescriptor_d6214d299197430f = []byte{
+	// 859 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x55, 0xcb, 0x6e, 0x23, 0x45,
+	0x14, 0x75, 0xb9, 0xdb, 0xaf, 0x6b, 0xc7, 0x13, 0x2a, 0x99, 0x50, 0x98, 0x51, 0xd2, 0x34, 0x12,
+	0x58, 0x48, 0xe3, 0x04, 0x23, 0x5e, 0x42, 0x20, 0x31, 0x61, 0x16, 0x91, 0x92, 0x05, 0x9d, 0x8c,
+	0x58, 0xb7, 0xdd, 0x77, 0xec, 0x96, 0xdc, 0x8f, 0xa9, 0xaa, 0x8e, 0x98, 0x59, 0xf3, 0x01, 0xec,
+	0xf9, 0x09, 0x3e, 0x63, 0x96, 0x88, 0x3d, 0x0f, 0xe5, 0x4b, 0x50, 0x55, 0xf5, 0xcb, 0x8f, 0x0c,
+	0x9a, 0x5d, 0xdd, 0x73, 0xef, 0xa9, 0xea, 0xba, 0xe7, 0xdc, 0x6a, 0xe8, 0xe3, 0x34, 0x48, 0x67,
+	0x93, 0x94, 0x27, 0x32, 0xa1, 0x2d, 0x1d, 0x8c, 0xde, 0x5f, 0x24, 0xc9, 0x62, 0x85, 0xa7, 0x1a,
+	0x9c, 0x65, 0xcf, 0x4f, 0x31, 0x4a, 0xe5, 0x4b, 0x53, 0x33, 0x3a, 0xd9, 0x4c, 0xca, 0x30, 0x42,
+	0x21, 0xfd, 0x28, 0xcd, 0x0b, 0x1e, 0x2f, 0x42, 0xb9, 0xcc, 0x66, 0x93, 0x79, 0x12, 0x9d, 0x2e,
+	0x92, 0x45, 0x52, 0x55, 0xaa, 0x48, 0x07, 0x7a, 0x65, 0xca, 0xdd, 0x5f, 0x6c, 0x18, 0x5c, 0x61,
+	0x34, 0x43, 0x7e, 0x2d, 0x7d, 0x99, 0x09, 0x3a, 0x84, 0x66, 0x18, 0x30, 0xe2, 0x90, 0xb1, 0xed,
+	0x35, 0xc3, 0x80, 0x52, 0xb0, 0x63, 0x3f, 0x42, 0xd6, 0x74, 0xc8, 0xb8, 0xe7, 0xe9, 0x35, 0x1d,
+	0x41, 0x37, 0x45, 0xe4, 0xcf, 0xbc, 0x4b, 0xc1, 0x2c, 0xc7, 0x1a, 0xf7, 0xbc, 0x32, 0xa6, 0xc7,
+	0x00, 0xf3, 0x55, 0x88, 0xb1, 0xd4, 0x59, 0x5b, 0x67, 0x6b, 0x08, 0x75, 0x61, 0xb0, 0x48, 0x84,
+	0x08, 0x53, 0x73, 0x1e, 0x6b, 0xe9, 0x7d, 0xd7, 0x30, 0xb5, 0x7f, 0x28, 0x2e, 0xd1, 0x0f, 0x90,
+	0xb3, 0xb6, 0x43, 0xc6, 0x5d, 0xaf, 0x8c, 0xe9, 0x23, 0xe8, 0xe9, 0x35, 0x8f, 0x91, 0xb3, 0x8e,
+	0x4e, 0x56, 0x80, 0x62, 0x72, 0xff, 0xb9, 0xbc, 0x41, 0x1e, 0xb1, 0xae, 0xbe, 0x43, 0x19, 0x2b,
+	0xa6, 0x5a, 0x5f, 0xc4, 0x01, 0xfe, 0xcc, 0x7a, 0x3a, 0x59, 0x01, 0xf4, 0x13, 0xd8, 0x57, 0xc1,
+	0xf7, 0x69, 0xba, 0x0a, 0x31, 0x30, 0x45, 0xa0, 0x8b, 0xb6, 0x70, 0x7a, 0x04, 0xed, 0x60, 0x76,
+	0x1d, 0xbe, 0x42, 0xd6, 0x77, 0xc8, 0xd8, 0xf2, 0xf2, 0x88, 0x3a, 0xd0, 0x37, 0xab, 0x8b, 0xf8,
+	0x99, 0x40, 0x36, 0xd0, 0xc9, 0x3a, 0x44, 0xa7, 0x70, 0xb8, 0xf2, 0x85, 0xbc, 0x8e, 0xfd, 0x54,
+	0x2c, 0x13, 0xe9, 0xe1, 0x6d, 0x28, 0xc2, 0x24, 0x66, 0x7b, 0xba, 0x74, 0x67, 0x8e, 0x5e, 0xc2,
+	0x7e, 0x1d, 0xbf, 0x09, 0x23, 0x64, 0x43, 0x87, 0x8c, 0xfb, 0xd3, 0xd1, 0xc4, 0xb8, 0x61, 0x52,
+	0x68, 0x3c, 0xb9, 0x29, 0xdc, 0xf0, 0xc4, 0xfe, 0xf5, 0x9f, 0x13, 0xe2, 0x6d, 0x31, 0xe9, 0x21,
+	0xb4, 0x90, 0xf3, 0x84, 0xb3, 0x07, 0xba, 0xf1, 0x26, 0x70, 0xff, 0x22, 0x30, 0x34, 0xcd, 0xf7,
+	0x50, 0xa4, 0x49, 0x2c, 0xb0, 0x14, 0x9e, 0xd4, 0x84, 0x7f, 0x04, 0xbd, 0xf9, 0x2a, 0x13, 0x12,
+	0xf9, 0xc5, 0x0f, 0xda, 0x11, 0xb6, 0x57, 0x01, 0xf4, 0x0c, 0x0e, 0x38, 0xbe, 0xc8, 0x42, 0x8e,
+	0xc1, 0xb9, 0x01, 0x75, 0x8f, 0x2c, 0x87, 0x8c, 0x5b, 0xde, 0xae, 0x94, 0xda, 0x6f, 0xe9, 0x8b,
+	0x1f, 0xb3, 0x84, 0x67, 0x11, 0xb3, 0x8d, 0x98, 0x25, 0xa0, 0xc4, 0x14, 0x99, 0x48, 0x71, 0x2e,
+	0x95, 0x4d, 0xb4, 0xcd, 0x8a, 0x98, 0x3e, 0x86, 0x4e, 0xa4, 0x6d, 0x2b, 0x58, 0xdb, 0xb1, 0xc6,
+	0xfd, 0xe9, 0xc1, 0xc4, 0x8c, 0x52, 0xdd, 0xcc, 0x5e, 0x51, 0xe3, 0x7e, 0x08, 0x0f, 0x3c, 0xd5,
+	0x18, 0x2e, 0xcb, 0xfb, 0xed, 0x83, 0x15, 0x89, 0x45, 0x7e, 0x3d, 0xb5, 0x74, 0x7f, 0x23, 0xd0,
+	0x7a, 0x7a, 0x8b, 0xb1, 0x54, 0x77, 0x97, 0x2f, 0xd3, 0xf2, 0xee, 0x6a, 0x4d, 0xbf, 0x02, 0x5b,
+	0xcd, 0x1a, 0x6b, 0xfe, 0x6f, 0xeb, 0xbb, 0xaf, 0xff, 0x3e, 0x69, 0xe8, 0xf6, 0x6b, 0x46, 0xd9,
+	0x49, 0x6b, 0x7d, 0x84, 0x78, 0x21, 0xbe, 0xad, 0xc5, 0x2f, 0xe3, 0x4a, 0xa2, 0x56, 0x5d, 0xa2,
+	0x0f, 0x60, 0xef, 0x12, 0xfd, 0x5b, 0x7c, 0xc3, 0x05, 0x3e, 0x86, 0x77, 0xae, 0x92, 0x5b, 0x34,
+	0x93, 0xe2, 0xe1, 0x8b, 0x0c, 0x85, 0xdc, 0xa5, 0xa3, 0x7b, 0x03, 0xb4, 0x5e, 0x98, 0x6f, 0xf8,
+	0x11, 0x0c, 0x53, 0xf5, 0x11, 0x49, 0x56, 0x0c, 0x9f, 0xe1, 0x6c, 0xa0, 0xca, 0xfe, 0x2b, 0x93,
+	0x37, 0x8f, 0x42, 0x1e, 0xb9, 0x9f, 0xc2, 0x9e, 0x87, 0x22, 0x7c, 0x85, 0xc5, 0xd1, 0x0e, 0xf4,
+	0xe7, 0x35, 0x23, 0x10, 0x6d, 0x84, 0x3a, 0xe4, 0x06, 0x30, 0x2c, 0x28, 0xf9, 0x47, 0x9c, 0xc1,
+	0x41, 0x71, 0xdc, 0xf9, 0x16, 0x77, 0x57, 0x6a, 0xf3, 0x94, 0xe6, 0xf6, 0x29, 0x7f, 0x12, 0xd8,
+	0xaf, 0xc6, 0xea, 0x0d, 0xfe, 0xfe, 0x0e, 0x3a, 0x73, 0x8e, 0xbe, 0xc4, 0xe0, 0xad, 0x64, 0x2e,
+	0x48, 0x6b, 0xaa, 0x5a, 0x1b, 0xaa, 0x56, 0x5d, 0xb3, 0xeb, 0x5d, 0x53, 0x0f, 0xa6, 0x90, 0x09,
+	0xc7, 0x40, 0x7f, 0x7d, 0x4b, 0xb3, 0x6a, 0x88, 0xe2, 0x89, 0xa5, 0x3f, 0xfd, 0xfc, 0x0b, 0xfd,
+	0x14, 0xf6, 0xbc, 0x3c, 0x9a, 0xfe, 0x6e, 0x41, 0xe7, 0xca, 0x8f, 0xfd, 0x05, 0x72, 0xfa, 0x35,
+	0xb4, 0xf3, 0xa7, 0xf3, 0x68, 0xeb, 0x83, 0x9f, 0xaa, 0xbf, 0xc7, 0xe8, 0x61, 0x3e, 0x1e, 0xeb,
+	0x43, 0xee, 0x36, 0xe8, 0x37, 0xd0, 0xc9, 0x27, 0xe3, 0x5e, 0xee, 0x51, 0xce, 0xdd, 0x98, 0x20,
+	0xb7, 0x41, 0xbf, 0x84, 0xb6, 0x91, 0x8f, 0x1e, 0x56, 0x35, 0x95, 0x01, 0x46, 0x0f, 0x37, 0xd0,
+	0x1a, 0xb1, 0xa5, 0xcd, 0x7c, 0xef, 0x99, 0xc5, 0x7e, 0x6b, 0x96, 0x77, 0x1b, 0xf4, 0x1c, 0xa0,
+	0x72, 0x2e, 0x65, 0xc5, 0xd0, 0x6f, 0xba, 0x7e, 0xf4, 0xde, 0x8e, 0x4c, 0xed, 0xf4, 0xfe, 0x4f,
+	0xbe, 0x9c, 0x2f, 0xf5, 0xb0, 0xdf, 0xdf, 0xb3, 0x41, 0xbe, 0x87, 0x2e, 0x73, 0x1b, 0x67, 0x84,
+	0x7e, 0x0b, 0xdd, 0xc2, 0x47, 0xf7, 0xb2, 0xde, 0x2d, 0x3a, 0xbd, 0x61, 0x38, 0xb7, 0xf1, 0x64,
+	0xf0, 0xfa, 0xee, 0x98, 0xfc, 0x71, 0x77, 0x4c, 0xfe, 0xbd, 0x3b, 0x26, 0xb3, 0xb6, 0x26, 0x7e,
+	0xf6, 0xdf, 0x00, 0x36, 0x4a, 0xc2, 0xe1, 0x04, 0x08, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// ManagerClient is the client API for Manager service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ManagerClient interface {
+	Status(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*StatusResponse, error)
+	Restart(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*RestartResponse, error)
+	Resize(ctx context.Context, in *ResizeRequest, opts ...grpc.CallOption) (*ResizeResponse, error)
+	Leave(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*LeaveResponse, error)
+	MoveLeader(ctx context.Context, in *MoveLeaderRequest, opts ...grpc.CallOption) (*MoveLeaderResponse, error)
+	WatchEvents(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (Manager_WatchEventsClient, error)
+	Snapshot(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*SnapshotResponse, error)
+}
+
+type managerClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewManagerClient(cc *grpc.ClientConn) ManagerClient {
+	return &managerClient{cc}
+}
+
+func (c *managerClient) Status(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/e2dpb.Manager/Status", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managerClient) Restart(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*RestartResponse, error) {
+	out := new(RestartResponse)
+	err := c.cc.Invoke(ctx, "/e2dpb.Manager/Restart", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managerClient) Resize(ctx context.Context, in *ResizeRequest, opts ...grpc.CallOption) (*ResizeResponse, error) {
+	out := new(ResizeResponse)
+	err := c.cc.Invoke(ctx, "/e2dpb.Manager/Resize", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managerClient) Leave(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*LeaveResponse, error) {
+	out := new(LeaveResponse)
+	err := c.cc.Invoke(ctx, "/e2dpb.Manager/Leave", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managerClient) MoveLeader(ctx context.Context, in *MoveLeaderRequest, opts ...grpc.CallOption) (*MoveLeaderResponse, error) {
+	out := new(MoveLeaderResponse)
+	err := c.cc.Invoke(ctx, "/e2dpb.Manager/MoveLeader", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managerClient) WatchEvents(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (Manager_WatchEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Manager_serviceDesc.Streams[0], "/e2dpb.Manager/WatchEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &managerWatchEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Manager_WatchEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type managerWatchEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *managerWatchEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *managerClient) Snapshot(ctx context.Context, in *types.Empty, opts ...grpc.CallOption) (*SnapshotResponse, error) {
+	out := new(SnapshotResponse)
+	err := c.cc.Invoke(ctx, "/e2dpb.Manager/Snapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ManagerServer is the server API for Manager service.
+type ManagerServer interface {
+	Status(context.Context, *types.Empty) (*StatusResponse, error)
+	Restart(context.Context, *types.Empty) (*RestartResponse, error)
+	Resize(context.Context, *ResizeRequest) (*ResizeResponse, error)
+	Leave(context.Context, *types.Empty) (*LeaveResponse, error)
+	MoveLeader(context.Context, *MoveLeaderRequest) (*MoveLeaderResponse, error)
+	WatchEvents(*types.Empty, Manager_WatchEventsServer) error
+	Snapshot(context.Context, *types.Empty) (*SnapshotResponse, error)
+}
+
+func RegisterManagerServer(s *grpc.Server, srv ManagerServer) {
+	s.RegisterService(&_Manager_serviceDesc, srv)
+}
+
+func _Manager_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(types.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/e2dpb.Manager/Status",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).Status(ctx, req.(*types.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Manager_Restart_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(types.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).Restart(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/e2dpb.Manager/Restart",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).Restart(ctx, req.(*types.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Manager_Resize_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResizeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).Resize(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/e2dpb.Manager/Resize",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).Resize(ctx, req.(*ResizeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Manager_Leave_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(types.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).Leave(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/e2dpb.Manager/Leave",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).Leave(ctx, req.(*types.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Manager_MoveLeader_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveLeaderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).MoveLeader(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/e2dpb.Manager/MoveLeader",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).MoveLeader(ctx, req.(*MoveLeaderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Manager_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(types.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ManagerServer).WatchEvents(m, &managerWatchEventsServer{stream})
+}
+
+type Manager_WatchEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type managerWatchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *managerWatchEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Manager_Snapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(types.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).Snapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/e2dpb.Manager/Snapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).Snapshot(ctx, req.(*types.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Manager_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "e2dpb.Manager",
+	HandlerType: (*ManagerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Status",
+			Handler:    _Manager_Status_Handler,
+		},
+		{
+			MethodName: "Restart",
+			Handler:    _Manager_Restart_Handler,
+		},
+		{
+			MethodName: "Resize",
+			Handler:    _Manager_Resize_Handler,
+		},
+		{
+			MethodName: "Leave",
+			Handler:    _Manager_Leave_Handler,
+		},
+		{
+			MethodName: "MoveLeader",
+			Handler:    _Manager_MoveLeader_Handler,
+		},
+		{
+			MethodName: "Snapshot",
+			Handler:    _Manager_Snapshot_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _Manager_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "e2dpb.proto",
+}
+
+func (m *MemberStatus) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MemberStatus) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Id != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.Id))
+	}
+	if len(m.Name) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Name)))
+		i += copy(dAtA[i:], m.Name)
+	}
+	if len(m.PeerURLs) > 0 {
+		for _, s := range m.PeerURLs {
+			dAtA[i] = 0x1a
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if len(m.ClientURLs) > 0 {
+		for _, s := range m.ClientURLs {
+			dAtA[i] = 0x22
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if len(m.GossipStatus) > 0 {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.GossipStatus)))
+		i += copy(dAtA[i:], m.GossipStatus)
+	}
+	if m.IsLeader {
+		dAtA[i] = 0x30
+		i++
+		if m.IsLeader {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.IsLearner {
+		dAtA[i] = 0x38
+		i++
+		if m.IsLearner {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.RaftTerm != 0 {
+		dAtA[i] = 0x40
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.RaftTerm))
+	}
+	if m.RaftIndex != 0 {
+		dAtA[i] = 0x48
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.RaftIndex))
+	}
+	if m.RaftAppliedIndex != 0 {
+		dAtA[i] = 0x50
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.RaftAppliedIndex))
+	}
+	if m.DbSize != 0 {
+		dAtA[i] = 0x58
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.DbSize))
+	}
+	if m.DbSizeInUse != 0 {
+		dAtA[i] = 0x60
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.DbSizeInUse))
+	}
+	if m.LastSnapshotRevision != 0 {
+		dAtA[i] = 0x68
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.LastSnapshotRevision))
+	}
+	if m.LastSnapshotTime != nil {
+		dAtA[i] = 0x72
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(github_com_gogo_protobuf_types.SizeOfStdTime(*m.LastSnapshotTime)))
+		n1, err := github_com_gogo_protobuf_types.StdTimeMarshalTo(*m.LastSnapshotTime, dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n1
+	}
+	if len(m.Error) > 0 {
+		dAtA[i] = 0x7a
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Error)))
+		i += copy(dAtA[i:], m.Error)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *StatusResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *StatusResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Name) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Name)))
+		i += copy(dAtA[i:], m.Name)
+	}
+	if m.ClusterID != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.ClusterID))
+	}
+	if m.RequiredClusterSize != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.RequiredClusterSize))
+	}
+	if m.HasQuorum {
+		dAtA[i] = 0x20
+		i++
+		if m.HasQuorum {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if len(m.Suspects) > 0 {
+		for _, s := range m.Suspects {
+			dAtA[i] = 0x2a
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if len(m.Members) > 0 {
+		for _, msg := range m.Members {
+			dAtA[i] = 0x32
+			i++
+			i = encodeVarintE2Dpb(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *RestartResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *RestartResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Msg) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Msg)))
+		i += copy(dAtA[i:], m.Msg)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *Event) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Event) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Type) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Type)))
+		i += copy(dAtA[i:], m.Type)
+	}
+	dAtA[i] = 0x12
+	i++
+	i = encodeVarintE2Dpb(dAtA, i, uint64(github_com_gogo_protobuf_types.SizeOfStdTime(m.Time)))
+	n2, err := github_com_gogo_protobuf_types.StdTimeMarshalTo(m.Time, dAtA[i:])
+	if err != nil {
+		return 0, err
+	}
+	i += n2
+	if len(m.Name) > 0 {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Name)))
+		i += copy(dAtA[i:], m.Name)
+	}
+	if m.Revision != 0 {
+		dAtA[i] = 0x20
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.Revision))
+	}
+	if len(m.Error) > 0 {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Error)))
+		i += copy(dAtA[i:], m.Error)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *LeaveResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *LeaveResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Msg) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Msg)))
+		i += copy(dAtA[i:], m.Msg)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *MoveLeaderRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MoveLeaderRequest) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Name) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Name)))
+		i += copy(dAtA[i:], m.Name)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *MoveLeaderResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MoveLeaderResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.PreviousLeader) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.PreviousLeader)))
+		i += copy(dAtA[i:], m.PreviousLeader)
+	}
+	if len(m.Leader) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Leader)))
+		i += copy(dAtA[i:], m.Leader)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *ResizeRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ResizeRequest) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.ClusterSize != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.ClusterSize))
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *ResizeResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ResizeResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.PreviousClusterSize != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.PreviousClusterSize))
+	}
+	if m.ClusterSize != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.ClusterSize))
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *SnapshotResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SnapshotResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Name) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Name)))
+		i += copy(dAtA[i:], m.Name)
+	}
+	dAtA[i] = 0x12
+	i++
+	i = encodeVarintE2Dpb(dAtA, i, uint64(github_com_gogo_protobuf_types.SizeOfStdTime(m.Created)))
+	n3, err := github_com_gogo_protobuf_types.StdTimeMarshalTo(m.Created, dAtA[i:])
+	if err != nil {
+		return 0, err
+	}
+	i += n3
+	if m.Revision != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.Revision))
+	}
+	if len(m.Leader) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Leader)))
+		i += copy(dAtA[i:], m.Leader)
+	}
+	if m.StoredSize != 0 {
+		dAtA[i] = 0x28
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(m.StoredSize))
+	}
+	if len(m.Sha256) > 0 {
+		dAtA[i] = 0x32
+		i++
+		i = encodeVarintE2Dpb(dAtA, i, uint64(len(m.Sha256)))
+		i += copy(dAtA[i:], m.Sha256)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func encodeVarintE2Dpb(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func (m *MemberStatus) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Id != 0 {
+		n += 1 + sovE2Dpb(uint64(m.Id))
+	}
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if len(m.PeerURLs) > 0 {
+		for _, s := range m.PeerURLs {
+			l = len(s)
+			n += 1 + l + sovE2Dpb(uint64(l))
+		}
+	}
+	if len(m.ClientURLs) > 0 {
+		for _, s := range m.ClientURLs {
+			l = len(s)
+			n += 1 + l + sovE2Dpb(uint64(l))
+		}
+	}
+	l = len(m.GossipStatus)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.IsLeader {
+		n += 2
+	}
+	if m.IsLearner {
+		n += 2
+	}
+	if m.RaftTerm != 0 {
+		n += 1 + sovE2Dpb(uint64(m.RaftTerm))
+	}
+	if m.RaftIndex != 0 {
+		n += 1 + sovE2Dpb(uint64(m.RaftIndex))
+	}
+	if m.RaftAppliedIndex != 0 {
+		n += 1 + sovE2Dpb(uint64(m.RaftAppliedIndex))
+	}
+	if m.DbSize != 0 {
+		n += 1 + sovE2Dpb(uint64(m.DbSize))
+	}
+	if m.DbSizeInUse != 0 {
+		n += 1 + sovE2Dpb(uint64(m.DbSizeInUse))
+	}
+	if m.LastSnapshotRevision != 0 {
+		n += 1 + sovE2Dpb(uint64(m.LastSnapshotRevision))
+	}
+	if m.LastSnapshotTime != nil {
+		l = github_com_gogo_protobuf_types.SizeOfStdTime(*m.LastSnapshotTime)
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	l = len(m.Error)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *StatusResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.ClusterID != 0 {
+		n += 1 + sovE2Dpb(uint64(m.ClusterID))
+	}
+	if m.RequiredClusterSize != 0 {
+		n += 1 + sovE2Dpb(uint64(m.RequiredClusterSize))
+	}
+	if m.HasQuorum {
+		n += 2
+	}
+	if len(m.Suspects) > 0 {
+		for _, s := range m.Suspects {
+			l = len(s)
+			n += 1 + l + sovE2Dpb(uint64(l))
+		}
+	}
+	if len(m.Members) > 0 {
+		for _, e := range m.Members {
+			l = e.Size()
+			n += 1 + l + sovE2Dpb(uint64(l))
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *RestartResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Msg)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *Event) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Type)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	l = github_com_gogo_protobuf_types.SizeOfStdTime(m.Time)
+	n += 1 + l + sovE2Dpb(uint64(l))
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.Revision != 0 {
+		n += 1 + sovE2Dpb(uint64(m.Revision))
+	}
+	l = len(m.Error)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *LeaveResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Msg)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *MoveLeaderRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *MoveLeaderResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.PreviousLeader)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	l = len(m.Leader)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *ResizeRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.ClusterSize != 0 {
+		n += 1 + sovE2Dpb(uint64(m.ClusterSize))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *ResizeResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.PreviousClusterSize != 0 {
+		n += 1 + sovE2Dpb(uint64(m.PreviousClusterSize))
+	}
+	if m.ClusterSize != 0 {
+		n += 1 + sovE2Dpb(uint64(m.ClusterSize))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *SnapshotResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	l = github_com_gogo_protobuf_types.SizeOfStdTime(m.Created)
+	n += 1 + l + sovE2Dpb(uint64(l))
+	if m.Revision != 0 {
+		n += 1 + sovE2Dpb(uint64(m.Revision))
+	}
+	l = len(m.Leader)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.StoredSize != 0 {
+		n += 1 + sovE2Dpb(uint64(m.StoredSize))
+	}
+	l = len(m.Sha256)
+	if l > 0 {
+		n += 1 + l + sovE2Dpb(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func sovE2Dpb(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozE2Dpb(x uint64) (n int) {
+	return sovE2Dpb(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *MemberStatus) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowE2Dpb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MemberStatus: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MemberStatus: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			m.Id = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Id |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PeerURLs", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PeerURLs = append(m.PeerURLs, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ClientURLs", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ClientURLs = append(m.ClientURLs, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field GossipStatus", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.GossipStatus = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IsLeader", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.IsLeader = bool(v != 0)
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IsLearner", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.IsLearner = bool(v != 0)
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RaftTerm", wireType)
+			}
+			m.RaftTerm = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RaftTerm |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RaftIndex", wireType)
+			}
+			m.RaftIndex = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RaftIndex |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RaftAppliedIndex", wireType)
+			}
+			m.RaftAppliedIndex = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RaftAppliedIndex |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DbSize", wireType)
+			}
+			m.DbSize = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.DbSize |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DbSizeInUse", wireType)
+			}
+			m.DbSizeInUse = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.DbSizeInUse |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LastSnapshotRevision", wireType)
+			}
+			m.LastSnapshotRevision = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LastSnapshotRevision |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 14:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LastSnapshotTime", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.LastSnapshotTime == nil {
+				m.LastSnapshotTime = new(time.Time)
+			}
+			if err := github_com_gogo_protobuf_types.StdTimeUnmarshal(m.LastSnapshotTime, dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 15:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Error", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Error = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipE2Dpb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *StatusResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowE2Dpb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: StatusResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: StatusResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ClusterID", wireType)
+			}
+			m.ClusterID = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ClusterID |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RequiredClusterSize", wireType)
+			}
+			m.RequiredClusterSize = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RequiredClusterSize |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field HasQuorum", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.HasQuorum = bool(v != 0)
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Suspects", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Suspects = append(m.Suspects, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Members", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Members = append(m.Members, &MemberStatus{})
+			if err := m.Members[len(m.Members)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipE2Dpb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *RestartResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowE2Dpb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: RestartResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: RestartResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Msg", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Msg = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipE2Dpb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Event) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowE2Dpb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Event: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Event: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Type", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Type = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Time", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := github_com_gogo_protobuf_types.StdTimeUnmarshal(&m.Time, dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Revision", wireType)
+			}
+			m.Revision = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Revision |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Error", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Error = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipE2Dpb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LeaveResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowE2Dpb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LeaveResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LeaveResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Msg", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Msg = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipE2Dpb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *MoveLeaderRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowE2Dpb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MoveLeaderRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MoveLeaderRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipE2Dpb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *MoveLeaderResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowE2Dpb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MoveLeaderResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MoveLeaderResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PreviousLeader", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PreviousLeader = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Leader", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Leader = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipE2Dpb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
 	}
-	return n
-}
 
-func sovE2Dpb(x uint64) (n int) {
-	for {
-		n++
-		x >>= 7
-		if x == 0 {
-			break
-		}
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
 	}
-	return n
-}
-func sozE2Dpb(x uint64) (n int) {
-	return sovE2Dpb(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+	return nil
 }
-func (m *HealthResponse) Unmarshal(dAtA []byte) error {
+func (m *ResizeRequest) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -380,17 +3133,17 @@ func (m *HealthResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: HealthResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: ResizeRequest: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: HealthResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: ResizeRequest: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Status", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ClusterSize", wireType)
 			}
-			var stringLen uint64
+			m.ClusterSize = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowE2Dpb
@@ -400,24 +3153,103 @@ func (m *HealthResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
+				m.ClusterSize |= int32(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+		default:
+			iNdEx = preIndex
+			skippy, err := skipE2Dpb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
 				return ErrInvalidLengthE2Dpb
 			}
-			postIndex := iNdEx + intStringLen
-			if postIndex < 0 {
+			if (iNdEx + skippy) < 0 {
 				return ErrInvalidLengthE2Dpb
 			}
-			if postIndex > l {
+			if (iNdEx + skippy) > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Status = string(dAtA[iNdEx:postIndex])
-			iNdEx = postIndex
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ResizeResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowE2Dpb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ResizeResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ResizeResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PreviousClusterSize", wireType)
+			}
+			m.PreviousClusterSize = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.PreviousClusterSize |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ClusterSize", wireType)
+			}
+			m.ClusterSize = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ClusterSize |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipE2Dpb(dAtA[iNdEx:])
@@ -443,7 +3275,7 @@ func (m *HealthResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *RestartResponse) Unmarshal(dAtA []byte) error {
+func (m *SnapshotResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -466,15 +3298,15 @@ func (m *RestartResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: RestartResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: SnapshotResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: RestartResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: SnapshotResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Msg", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -502,7 +3334,142 @@ func (m *RestartResponse) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Msg = string(dAtA[iNdEx:postIndex])
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Created", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := github_com_gogo_protobuf_types.StdTimeUnmarshal(&m.Created, dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Revision", wireType)
+			}
+			m.Revision = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Revision |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Leader", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Leader = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StoredSize", wireType)
+			}
+			m.StoredSize = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StoredSize |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Sha256", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowE2Dpb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthE2Dpb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Sha256 = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
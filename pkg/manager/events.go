@@ -0,0 +1,168 @@
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/log"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// MemberJoined is sent when a peer joins the gossip network.
+	MemberJoined EventType = iota + 1
+
+	// MemberSuspected is sent when a peer leaves the gossip network
+	// unexpectedly and will be removed if it does not recover.
+	MemberSuspected
+
+	// MemberLeft is sent when a peer gracefully leaves the cluster.
+	MemberLeft
+
+	// MemberRemoved is sent when this member removes a failed peer from the
+	// etcd cluster.
+	MemberRemoved
+
+	// QuorumLost is sent when this member can no longer see a majority of
+	// running members.
+	QuorumLost
+
+	// QuorumRegained is sent when this member can once again see a majority
+	// of running members.
+	QuorumRegained
+
+	// SnapshotSaved is sent when a snapshot backup is saved successfully.
+	SnapshotSaved
+
+	// SnapshotFailed is sent when a snapshot backup cannot be saved.
+	SnapshotFailed
+
+	// SnapshotRestored is sent when this member is restored from a snapshot
+	// backup.
+	SnapshotRestored
+
+	// Restarted is sent when the etcd server of this member is restarted.
+	Restarted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case MemberJoined:
+		return "MemberJoined"
+	case MemberSuspected:
+		return "MemberSuspected"
+	case MemberLeft:
+		return "MemberLeft"
+	case MemberRemoved:
+		return "MemberRemoved"
+	case QuorumLost:
+		return "QuorumLost"
+	case QuorumRegained:
+		return "QuorumRegained"
+	case SnapshotSaved:
+		return "SnapshotSaved"
+	case SnapshotFailed:
+		return "SnapshotFailed"
+	case SnapshotRestored:
+		return "SnapshotRestored"
+	case Restarted:
+		return "Restarted"
+	}
+	return "Unknown"
+}
+
+// Event describes a change in cluster membership or in the lifecycle of a
+// Manager.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// name of the member the event relates to
+	Name string
+
+	// etcd revision, set for snapshot events
+	Revision int64
+
+	// set when the event describes a failure
+	Err error
+}
+
+// eventBus broadcasts events to all current subscribers. Events are never
+// allowed to block the sender, so a subscriber that is not keeping up will
+// miss events once its buffer is full.
+type eventBus struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]chan Event
+
+	// called for each event dropped by a subscriber that is not keeping up
+	onDrop func(Event)
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[int]chan Event)}
+}
+
+func (b *eventBus) subscribe(size int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+	ch := make(chan Event, size)
+	b.subs[id] = ch
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if ch, ok := b.subs[id]; ok {
+			close(ch)
+			delete(b.subs, id)
+		}
+	}
+}
+
+func (b *eventBus) publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Warn("subscriber buffer full, dropping event",
+				zap.String("event", ev.Type.String()),
+				zap.String("name", shortName(ev.Name)),
+			)
+			if b.onDrop != nil {
+				b.onDrop(ev)
+			}
+		}
+	}
+}
+
+// close closes the channels of all current subscribers.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, ch := range b.subs {
+		close(ch)
+		delete(b.subs, id)
+	}
+}
+
+// Subscribe returns a channel that receives all events broadcast by the
+// Manager, buffering up to size events. The returned function must be called
+// to unsubscribe once the channel is no longer being read. The channel is
+// closed when unsubscribing or when the Manager is stopped.
+func (m *Manager) Subscribe(size int) (<-chan Event, func()) {
+	return m.events.subscribe(size)
+}
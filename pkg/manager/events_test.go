@@ -0,0 +1,49 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestEventBus(t *testing.T) {
+	b := newEventBus()
+	var dropped []Event
+	b.onDrop = func(ev Event) {
+		dropped = append(dropped, ev)
+	}
+	ch1, unsubscribe1 := b.subscribe(1)
+	ch2, unsubscribe2 := b.subscribe(1)
+	defer unsubscribe2()
+
+	b.publish(Event{Type: MemberRemoved, Name: "node1"})
+
+	// buffer is full, so this event is dropped rather than blocking
+	b.publish(Event{Type: SnapshotFailed, Err: errors.New("failed")})
+	if len(dropped) != 2 || dropped[0].Type != SnapshotFailed {
+		t.Fatalf("expected event to be dropped by both subscribers, received %+v", dropped)
+	}
+
+	for _, ch := range []<-chan Event{ch1, ch2} {
+		ev := <-ch
+		if ev.Type != MemberRemoved || ev.Name != "node1" {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+		if ev.Time.IsZero() {
+			t.Fatal("expected event time to be set")
+		}
+	}
+
+	unsubscribe1()
+	if _, ok := <-ch1; ok {
+		t.Fatal("expected channel to be closed after unsubscribing")
+	}
+
+	// unsubscribing more than once is safe
+	unsubscribe1()
+
+	b.close()
+	if _, ok := <-ch2; ok {
+		t.Fatal("expected channel to be closed after closing bus")
+	}
+}
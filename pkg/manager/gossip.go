@@ -28,6 +28,7 @@ const (
 	Unknown NodeStatus = iota
 	Pending
 	Running
+	Leaving
 )
 
 func (s NodeStatus) String() string {
@@ -38,6 +39,8 @@ func (s NodeStatus) String() string {
 		return "Pending"
 	case Running:
 		return "Running"
+	case Leaving:
+		return "Leaving"
 	}
 	return ""
 }
@@ -71,6 +74,7 @@ type memberlister interface {
 	LocalNode() *memberlist.Node
 	Members() []*memberlist.Node
 	NumMembers() int
+	Leave(time.Duration) error
 	Shutdown() error
 }
 
@@ -92,6 +96,10 @@ func (noopMemberlist) NumMembers() int {
 	return 0
 }
 
+func (noopMemberlist) Leave(time.Duration) error {
+	return nil
+}
+
 func (noopMemberlist) Shutdown() error {
 	return nil
 }
@@ -128,6 +136,9 @@ type gossipConfig struct {
 	GossipPort int
 	SecretKey  []byte
 	Debug      bool
+
+	// called when a peer broadcasts a change to the required cluster size
+	OnClusterSizeChange func(int)
 }
 
 type gossip struct {
@@ -139,7 +150,11 @@ type gossip struct {
 	broadcasts *memberlist.TransmitLimitedQueue
 	mu         sync.RWMutex
 	nodes      map[string]NodeStatus
+	restores   map[string]string
+	snapshots  map[string]*snapshotMsg
 	self       *Member
+
+	onClusterSizeChange func(int)
 }
 
 func newGossip(cfg *gossipConfig) *gossip {
@@ -151,16 +166,19 @@ func newGossip(cfg *gossipConfig) *gossip {
 	c.SecretKey = cfg.SecretKey
 
 	g := &gossip{
-		m:      &noopMemberlist{},
-		config: c,
-		events: make(chan memberlist.NodeEvent, 100),
-		nodes:  make(map[string]NodeStatus),
+		m:         &noopMemberlist{},
+		config:    c,
+		events:    make(chan memberlist.NodeEvent, 100),
+		nodes:     make(map[string]NodeStatus),
+		restores:  make(map[string]string),
+		snapshots: make(map[string]*snapshotMsg),
 		self: &Member{
 			Name:       cfg.Name,
 			ClientURL:  cfg.ClientURL,
 			PeerURL:    cfg.PeerURL,
 			GossipAddr: fmt.Sprintf("%s:%d", cfg.GossipHost, cfg.GossipPort),
 		},
+		onClusterSizeChange: cfg.OnClusterSizeChange,
 	}
 	g.broadcasts = &memberlist.TransmitLimitedQueue{
 		NumNodes: func() int {
@@ -187,13 +205,29 @@ func (g *gossip) Shutdown() error {
 	return nil
 }
 
+// Leave broadcasts the intent to leave the gossip network to all currently
+// known members. It should be followed by a call to Shutdown.
+func (g *gossip) Leave(timeout time.Duration) error {
+	return g.m.Leave(timeout)
+}
+
+// status returns the last known NodeStatus for the member with the given
+// name.
+func (g *gossip) status(name string) NodeStatus {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.nodes[name]
+}
+
 // Start attempts to join a gossip network using the given bootstrap addresses.
 func (g *gossip) Start(ctx context.Context, baddrs []string) error {
 	m, err := memberlist.Create(g.config)
 	if err != nil {
 		return err
 	}
+	g.mu.Lock()
 	g.m = m
+	g.mu.Unlock()
 	if err := g.Update(Unknown); err != nil {
 		return err
 	}
@@ -244,11 +278,75 @@ func (m *msg) Invalidates(other memberlist.Broadcast) bool { return false }
 func (m *msg) Message() []byte                             { return m.data }
 func (m *msg) Finished()                                   {}
 
+// msgType identifies the kind of message sent over the gossip network.
+//
+// Status messages are sent as a gob encoded statusMsg without a type, the same
+// as older versions of e2d, so that members can be upgraded one at a time.
+// Every other message is prefixed with its type, chosen from the bytes that
+// can never start a gob stream (which begins with a length encoded as either a
+// byte below 0x80, or a negated byte count from 0xf8), so older members fail
+// to decode and ignore them.
+type msgType byte
+
+const (
+	statusMsgType      msgType = 0
+	clusterSizeMsgType msgType = 0x80
+	restoreMsgType     msgType = 0x81
+	snapshotMsgType    msgType = 0x82
+)
+
+// isTaggedMsg reports whether b is the type prefix of a message.
+func isTaggedMsg(b byte) bool {
+	return b >= 0x80 && b < 0xf8
+}
+
 type statusMsg struct {
 	Name   string
 	Status NodeStatus
 }
 
+type clusterSizeMsg struct {
+	Name                string
+	RequiredClusterSize int
+}
+
+type restoreMsg struct {
+	Name     string
+	Snapshot string
+}
+
+type snapshotMsg struct {
+	Name     string
+	Snapshot string
+	Revision int64
+	Created  time.Time
+}
+
+// localState is the state exchanged with another member when joining or
+// during periodic push/pull syncs, for the state that is otherwise only
+// broadcast once and would be missed by members joining later.
+type localState struct {
+	Snapshots []*snapshotMsg
+}
+
+func encodeMsg(t msgType, v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if t != statusMsgType {
+		b.WriteByte(byte(t))
+	}
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decodeMsg(data []byte) (msgType, *bytes.Reader) {
+	if isTaggedMsg(data[0]) {
+		return msgType(data[0]), bytes.NewReader(data[1:])
+	}
+	return statusMsgType, bytes.NewReader(data)
+}
+
 // Update uses the provided NodeStatus to updates the node metadata and
 // broadcast the updated NodeStatus to all currently known members.
 func (g *gossip) Update(status NodeStatus) error {
@@ -261,14 +359,72 @@ func (g *gossip) Update(status NodeStatus) error {
 		return err
 	}
 	g.m.LocalNode().Meta = data
-	var b bytes.Buffer
-	if err := gob.NewEncoder(&b).Encode(statusMsg{Name: g.self.Name, Status: status}); err != nil {
+	b, err := encodeMsg(statusMsgType, statusMsg{Name: g.self.Name, Status: status})
+	if err != nil {
+		return err
+	}
+	g.broadcasts.QueueBroadcast(&msg{b})
+	return nil
+}
+
+// UpdateClusterSize broadcasts a change in the required cluster size to all
+// currently known members.
+func (g *gossip) UpdateClusterSize(n int) error {
+	b, err := encodeMsg(clusterSizeMsgType, clusterSizeMsg{Name: g.self.Name, RequiredClusterSize: n})
+	if err != nil {
+		return err
+	}
+	g.broadcasts.QueueBroadcast(&msg{b})
+	return nil
+}
+
+// AnnounceRestore broadcasts the snapshot this member restored a new cluster
+// from to all currently known members, so they can restore the same snapshot.
+// An empty snapshot announces that this member did not restore a snapshot.
+func (g *gossip) AnnounceRestore(snapshot string) error {
+	g.mu.Lock()
+	g.restores[g.self.Name] = snapshot
+	g.mu.Unlock()
+	b, err := encodeMsg(restoreMsgType, restoreMsg{Name: g.self.Name, Snapshot: snapshot})
+	if err != nil {
 		return err
 	}
-	g.broadcasts.QueueBroadcast(&msg{b.Bytes()})
+	g.broadcasts.QueueBroadcast(&msg{b})
 	return nil
 }
 
+// restored returns the snapshot the member with the given name announced it
+// restored from, and whether it has announced one yet.
+func (g *gossip) restored(name string) (string, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	snapshot, ok := g.restores[name]
+	return snapshot, ok
+}
+
+// AnnounceSnapshot broadcasts the last snapshot backup saved by this member,
+// and the revision it was taken at, to all currently known members.
+func (g *gossip) AnnounceSnapshot(snapshot string, rev int64) error {
+	n := &snapshotMsg{Name: g.self.Name, Snapshot: snapshot, Revision: rev, Created: time.Now()}
+	g.mu.Lock()
+	g.snapshots[g.self.Name] = n
+	g.mu.Unlock()
+	b, err := encodeMsg(snapshotMsgType, n)
+	if err != nil {
+		return err
+	}
+	g.broadcasts.QueueBroadcast(&msg{b})
+	return nil
+}
+
+// lastSnapshot returns the last snapshot backup announced by the member with
+// the given name, or nil if it has not announced one.
+func (g *gossip) lastSnapshot(name string) *snapshotMsg {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.snapshots[name]
+}
+
 // Events returns a read-only channel of memberlist events.
 func (g *gossip) Events() <-chan memberlist.NodeEvent { return g.events }
 
@@ -327,23 +483,93 @@ func (g *gossip) NotifyMsg(data []byte) {
 	if len(data) == 0 {
 		return
 	}
-	var n statusMsg
-	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&n); err != nil {
-		log.Debugf("cannot unmarshal: %v", err)
-		return
+	t, r := decodeMsg(data)
+	switch t {
+	case statusMsgType:
+		var n statusMsg
+		if err := gob.NewDecoder(r).Decode(&n); err != nil {
+			log.Debugf("cannot unmarshal: %v", err)
+			return
+		}
+		g.mu.Lock()
+		g.nodes[n.Name] = n.Status
+		g.mu.Unlock()
+	case clusterSizeMsgType:
+		var n clusterSizeMsg
+		if err := gob.NewDecoder(r).Decode(&n); err != nil {
+			log.Debugf("cannot unmarshal: %v", err)
+			return
+		}
+		log.Debugf("received cluster size %d from %v", n.RequiredClusterSize, shortName(n.Name))
+		if g.onClusterSizeChange != nil {
+			g.onClusterSizeChange(n.RequiredClusterSize)
+		}
+	case restoreMsgType:
+		var n restoreMsg
+		if err := gob.NewDecoder(r).Decode(&n); err != nil {
+			log.Debugf("cannot unmarshal: %v", err)
+			return
+		}
+		log.Debugf("received restored snapshot %#v from %v", n.Snapshot, shortName(n.Name))
+		g.mu.Lock()
+		g.restores[n.Name] = n.Snapshot
+		g.mu.Unlock()
+	case snapshotMsgType:
+		var n snapshotMsg
+		if err := gob.NewDecoder(r).Decode(&n); err != nil {
+			log.Debugf("cannot unmarshal: %v", err)
+			return
+		}
+		log.Debugf("received last snapshot %#v from %v", n.Snapshot, shortName(n.Name))
+		g.mu.Lock()
+		g.mergeSnapshot(&n)
+		g.mu.Unlock()
+	default:
+		log.Debugf("received unknown message type: %#x", byte(t))
 	}
-	g.mu.Lock()
-	g.nodes[n.Name] = n.Status
-	g.mu.Unlock()
 }
 
 func (g *gossip) GetBroadcasts(overhead, limit int) [][]byte {
 	return g.broadcasts.GetBroadcasts(overhead, limit)
 }
 
+// mergeSnapshot records the last snapshot announced by a member, unless a
+// later one is already known. The caller must hold the lock.
+func (g *gossip) mergeSnapshot(n *snapshotMsg) {
+	if last, ok := g.snapshots[n.Name]; !ok || n.Revision >= last.Revision {
+		g.snapshots[n.Name] = n
+	}
+}
+
 func (g *gossip) LocalState(join bool) []byte {
-	return nil
+	g.mu.RLock()
+	state := localState{Snapshots: make([]*snapshotMsg, 0, len(g.snapshots))}
+	for _, n := range g.snapshots {
+		state.Snapshots = append(state.Snapshots, n)
+	}
+	g.mu.RUnlock()
+
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(state); err != nil {
+		log.Debugf("cannot marshal local state: %v", err)
+		return nil
+	}
+	return b.Bytes()
 }
 
 func (g *gossip) MergeRemoteState(buf []byte, join bool) {
+	// older members do not send any state
+	if len(buf) == 0 {
+		return
+	}
+	var state localState
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&state); err != nil {
+		log.Debugf("cannot unmarshal remote state: %v", err)
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, n := range state.Snapshots {
+		g.mergeSnapshot(n)
+	}
 }
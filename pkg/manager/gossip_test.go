@@ -2,7 +2,9 @@
 package manager
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
 	"testing"
 	"time"
 
@@ -35,6 +37,120 @@ func TestMemberEncodeDecode(t *testing.T) {
 	}
 }
 
+func TestGossipNotifyMsg(t *testing.T) {
+	var size int
+	g := newGossip(&gossipConfig{
+		Name: "node1",
+		OnClusterSizeChange: func(n int) {
+			size = n
+		},
+	})
+
+	data, err := encodeMsg(statusMsgType, statusMsg{Name: "node2", Status: Running})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.NotifyMsg(data)
+	if status := g.nodes["node2"]; status != Running {
+		t.Fatalf("expected status %v, received %v", Running, status)
+	}
+
+	data, err = encodeMsg(clusterSizeMsgType, clusterSizeMsg{Name: "node2", RequiredClusterSize: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.NotifyMsg(data)
+	if size != 7 {
+		t.Fatalf("expected cluster size 7, received %d", size)
+	}
+
+	// older members decode every message as a statusMsg, so they must fail
+	// to decode other messages rather than misreading them
+	var n statusMsg
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&n); err == nil {
+		t.Fatalf("expected older members to ignore cluster size message, received %+v", n)
+	}
+
+	data, err = encodeMsg(snapshotMsgType, snapshotMsg{Name: "node2", Snapshot: "etcd.snapshot.2000", Revision: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.NotifyMsg(data)
+
+	// a snapshot received out of order does not replace a later one
+	data, err = encodeMsg(snapshotMsgType, snapshotMsg{Name: "node2", Snapshot: "etcd.snapshot.1000", Revision: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.NotifyMsg(data)
+	if last := g.lastSnapshot("node2"); last == nil || last.Snapshot != "etcd.snapshot.2000" || last.Revision != 20 {
+		t.Fatalf("expected last snapshot etcd.snapshot.2000 at revision 20, received %+v", last)
+	}
+}
+
+func TestGossipNotifyMsgLegacy(t *testing.T) {
+	g := newGossip(&gossipConfig{Name: "node1"})
+
+	// status messages sent by older members have no type
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(statusMsg{Name: "node2", Status: Pending}); err != nil {
+		t.Fatal(err)
+	}
+	g.NotifyMsg(b.Bytes())
+	if status := g.nodes["node2"]; status != Pending {
+		t.Fatalf("expected status %v, received %v", Pending, status)
+	}
+
+	// and status messages are still sent without a type, so older members
+	// can read them
+	data, err := encodeMsg(statusMsgType, statusMsg{Name: "node3", Status: Running})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isTaggedMsg(data[0]) {
+		t.Fatalf("expected status message without a type, received %#x", data[0])
+	}
+	var n statusMsg
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Name != "node3" || n.Status != Running {
+		t.Fatalf("expected node3 running, received %+v", n)
+	}
+}
+
+func TestGossipSnapshotStateOnJoin(t *testing.T) {
+	g1 := newGossip(&gossipConfig{
+		Name:       "node1",
+		GossipHost: "127.0.0.1",
+		GossipPort: 7990,
+	})
+	defer g1.Shutdown()
+	if err := g1.Start(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := g1.AnnounceSnapshot("etcd.snapshot.2000", 20); err != nil {
+		t.Fatal(err)
+	}
+
+	// node2 joins after the snapshot was announced, so it only learns of it
+	// from the state exchanged when joining
+	g2 := newGossip(&gossipConfig{
+		Name:       "node2",
+		GossipHost: "127.0.0.1",
+		GossipPort: 7991,
+	})
+	defer g2.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := g2.Start(ctx, []string{"127.0.0.1:7990"}); err != nil {
+		t.Fatal(err)
+	}
+	if last := g2.lastSnapshot("node1"); last == nil || last.Snapshot != "etcd.snapshot.2000" || last.Revision != 20 {
+		t.Fatalf("expected last snapshot etcd.snapshot.2000 at revision 20, received %+v", last)
+	}
+}
+
 func TestGossipDelegate(t *testing.T) {
 	t.Skip()
 	g1 := newGossip(&gossipConfig{
@@ -44,7 +160,7 @@ func TestGossipDelegate(t *testing.T) {
 	defer g1.Shutdown()
 	go func() {
 		if err := g1.Start(context.Background(), []string{":7981"}); err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 	}()
 	g2 := newGossip(&gossipConfig{
@@ -54,7 +170,7 @@ func TestGossipDelegate(t *testing.T) {
 	defer g2.Shutdown()
 	go func() {
 		if err := g2.Start(context.Background(), []string{":7980"}); err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 	}()
 	g3 := newGossip(&gossipConfig{
@@ -64,7 +180,7 @@ func TestGossipDelegate(t *testing.T) {
 	defer g3.Shutdown()
 	go func() {
 		if err := g3.Start(context.Background(), []string{":7981"}); err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 	}()
 
@@ -0,0 +1,188 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/etcdserver/api/membership"
+	"go.etcd.io/etcd/pkg/types"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/criticalstack/e2d/pkg/client"
+	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
+)
+
+var errNotLeader = errors.New("member is not the leader")
+
+// runningMemberNames returns the names of the members that are Running in the
+// gossip network.
+func (m *Manager) runningMemberNames() map[string]bool {
+	running := make(map[string]bool)
+	for _, member := range m.gossip.runningMembers() {
+		running[member.Name] = true
+	}
+	return running
+}
+
+// checkTransferee returns why leadership cannot be transferred to the
+// member, or nil if it can. Raft ignores a transfer to a learner, and a
+// transfer to a member that is not running cannot complete, so only started,
+// voting members that are Running in the gossip network can take over.
+func checkTransferee(member *membership.Member, running map[string]bool) error {
+	switch {
+	case member.IsLearner:
+		return errors.Errorf("cannot transfer leadership to %s: member is a learner", member.Name)
+	case !member.IsStarted() || len(member.ClientURLs) == 0:
+		return errors.Errorf("cannot transfer leadership to %s: member has not started", member.Name)
+	case !running[member.Name]:
+		return errors.Errorf("cannot transfer leadership to %s: member is not running", member.Name)
+	}
+	return nil
+}
+
+// selectTransferee finds the healthiest peer to transfer leadership to. Only
+// members accepted by checkTransferee are considered, and of those the member
+// reporting the highest applied index is chosen.
+func (m *Manager) selectTransferee(ctx context.Context) (uint64, error) {
+	running := m.runningMemberNames()
+
+	c, err := newClient(&client.Config{
+		ClientURLs:     []string{m.cfg.ClientURL.String()},
+		SecurityConfig: m.cfg.PeerSecurity,
+		Timeout:        1 * time.Second,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	var (
+		transferee   uint64
+		appliedIndex uint64
+	)
+	for _, member := range m.etcd.Server.Cluster().Members() {
+		if member.ID == m.etcd.Server.ID() || checkTransferee(member, running) != nil {
+			continue
+		}
+		sctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+		resp, err := c.Status(sctx, member.ClientURLs[0])
+		cancel()
+		if err != nil {
+			log.Debugf("[%v]: cannot get status of member %v: %v", shortName(m.cfg.Name), shortName(member.Name), err)
+			continue
+		}
+		if transferee == 0 || resp.RaftAppliedIndex > appliedIndex {
+			transferee = uint64(member.ID)
+			appliedIndex = resp.RaftAppliedIndex
+		}
+	}
+	if transferee == 0 {
+		return 0, errors.New("cannot find a healthy member to transfer leadership to")
+	}
+	return transferee, nil
+}
+
+// moveLeader transfers leadership from this member to the member with the
+// provided name. If name is empty, the healthiest running peer is selected.
+// The ID of the new leader is returned.
+func (m *Manager) moveLeader(ctx context.Context, name string) (uint64, error) {
+	if !m.etcd.isLeader() {
+		return 0, errNotLeader
+	}
+	var transferee uint64
+	if name == "" {
+		var err error
+		transferee, err = m.selectTransferee(ctx)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		var err error
+		transferee, err = m.etcd.lookupMember(name)
+		if err != nil {
+			return 0, err
+		}
+		member := m.etcd.Server.Cluster().Member(types.ID(transferee))
+		if member == nil {
+			return 0, errors.Wrap(errCannotFindMember, name)
+		}
+		if member.ID != m.etcd.Server.ID() {
+			if err := checkTransferee(member, m.runningMemberNames()); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if transferee == uint64(m.etcd.Server.ID()) {
+		return transferee, nil
+	}
+	log.Info("transferring leadership",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.Stringer("transferee", types.ID(transferee)),
+	)
+	if err := m.etcd.Server.MoveLeader(ctx, m.etcd.Server.Lead(), transferee); err != nil {
+		return 0, errors.Wrapf(err, "cannot transfer leadership to %s", types.ID(transferee))
+	}
+	log.Info("leadership transferred",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.Stringer("leader", types.ID(transferee)),
+	)
+	return transferee, nil
+}
+
+// MoveLeader transfers leadership to the member with the provided name, or to
+// the healthiest running peer if name is empty. Leadership can only be
+// transferred by the leader, so when this member is not the leader the
+// request is forwarded to it.
+func (m *Manager) MoveLeader(ctx context.Context, name string) (*e2dpb.MoveLeaderResponse, error) {
+	if !m.etcd.isRunning() {
+		return nil, errors.New("etcd is not running")
+	}
+	if !m.etcd.isLeader() {
+		c, conn, err := m.dialLeader(ctx, "move-leader")
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+
+		req := &e2dpb.MoveLeaderRequest{Name: name}
+		return c.MoveLeader(metadata.AppendToOutgoingContext(ctx, forwardedKey, m.cfg.Name), req)
+	}
+	resp := &e2dpb.MoveLeaderResponse{
+		PreviousLeader: m.memberName(m.etcd.Server.Lead()),
+	}
+	if err := m.snapshotBeforeMaintenance("move-leader"); err != nil {
+		return nil, err
+	}
+	id, err := m.moveLeader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	resp.Leader = m.memberName(id)
+	return resp, nil
+}
+
+// handoffLeadership makes a best-effort attempt to transfer leadership away
+// from this member before it is stopped or restarted, which avoids waiting
+// for an election timeout on the remaining members.
+func (m *Manager) handoffLeadership() {
+	if m.cfg.RequiredClusterSize == 1 || !m.etcd.isLeader() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := m.moveLeader(ctx, ""); err != nil {
+		log.Info("cannot hand off leadership", zap.String("name", shortName(m.cfg.Name)), zap.Error(err))
+	}
+}
+
+func (m *Manager) memberName(id uint64) string {
+	if member := m.etcd.Server.Cluster().Member(types.ID(id)); member != nil && member.Name != "" {
+		return member.Name
+	}
+	return fmt.Sprintf("%x", id)
+}
@@ -0,0 +1,36 @@
+package manager
+
+import (
+	"testing"
+
+	"go.etcd.io/etcd/etcdserver/api/membership"
+)
+
+func TestCheckTransferee(t *testing.T) {
+	newMember := func(name string, learner bool, clientURLs ...string) *membership.Member {
+		return &membership.Member{
+			ID:             1,
+			RaftAttributes: membership.RaftAttributes{PeerURLs: []string{"http://127.0.0.1:2380"}, IsLearner: learner},
+			Attributes:     membership.Attributes{Name: name, ClientURLs: clientURLs},
+		}
+	}
+	running := map[string]bool{"node1": true, "node2": true}
+	tests := []struct {
+		name        string
+		member      *membership.Member
+		expectedErr bool
+	}{
+		{name: "running voter", member: newMember("node1", false, "http://127.0.0.1:2379")},
+		{name: "learner", member: newMember("node2", true, "http://127.0.0.1:2379"), expectedErr: true},
+		{name: "not started", member: newMember("", false), expectedErr: true},
+		{name: "not running", member: newMember("node3", false, "http://127.0.0.1:2379"), expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTransferee(tt.member, running)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("expected error %t, received %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
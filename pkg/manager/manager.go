@@ -2,16 +2,23 @@ package manager
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	gogotypes "github.com/gogo/protobuf/types"
 	"github.com/hashicorp/memberlist"
 	"github.com/pkg/errors"
+	"go.etcd.io/etcd/pkg/types"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
+	"github.com/criticalstack/e2d/pkg/buildinfo"
 	"github.com/criticalstack/e2d/pkg/client"
 	"github.com/criticalstack/e2d/pkg/log"
 	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
@@ -30,7 +37,20 @@ type Manager struct {
 	cluster     *clusterMembership
 	snapshotter snapshot.Snapshotter
 
-	removeCh chan string
+	// serializes saving snapshots, and protects the name and revision of the
+	// last snapshot saved by this member
+	snapshotMu         sync.Mutex
+	latestSnapshotName string
+	latestSnapshotRev  int64
+
+	events *eventBus
+
+	// set when this member is leaving the cluster
+	leaving uint64
+	// closed once this member has left the cluster and shut down
+	left chan struct{}
+
+	metrics *metrics
 }
 
 // New creates a new instance of Manager.
@@ -53,17 +73,19 @@ func New(cfg *Config) (*Manager, error) {
 			Debug:               cfg.Debug,
 			EnableLocalListener: true,
 		}),
-		gossip: newGossip(&gossipConfig{
-			Name:       cfg.Name,
-			ClientURL:  cfg.ClientURL.String(),
-			PeerURL:    cfg.PeerURL.String(),
-			GossipHost: cfg.GossipHost,
-			GossipPort: cfg.GossipPort,
-			SecretKey:  cfg.gossipSecretKey,
-		}),
-		removeCh:    make(chan string, 10),
+		events:      newEventBus(),
 		snapshotter: cfg.Snapshotter,
+		left:        make(chan struct{}),
 	}
+	m.gossip = newGossip(&gossipConfig{
+		Name:                cfg.Name,
+		ClientURL:           cfg.ClientURL.String(),
+		PeerURL:             cfg.PeerURL.String(),
+		GossipHost:          cfg.GossipHost,
+		GossipPort:          cfg.GossipPort,
+		SecretKey:           cfg.gossipSecretKey,
+		OnClusterSizeChange: m.onClusterSizeChange,
+	})
 	m.ctx, m.cancel = context.WithCancel(context.Background())
 	m.cluster = newClusterMembership(m.ctx, m.cfg.HealthCheckTimeout, func(name string) error {
 		log.Debug("removing member ...",
@@ -77,16 +99,14 @@ func New(cfg *Config) (*Manager, error) {
 			zap.String("name", shortName(m.cfg.Name)),
 			zap.String("removed", shortName(name)),
 		)
-
-		// TODO(chris): this is mostly used for testing atm and
-		// should evolve in the future to be part of a more
-		// complete event broadcast system
-		select {
-		case m.removeCh <- name:
-		default:
-		}
+		m.metrics.memberRemovals.Inc()
+		m.events.publish(Event{Type: MemberRemoved, Name: name})
 		return nil
 	})
+	m.metrics = newMetrics(m)
+	m.events.onDrop = func(Event) {
+		m.metrics.eventsDropped.Inc()
+	}
 	m.etcd.cfg.ServiceRegister = func(s *grpc.Server) {
 		e2dpb.RegisterManagerServer(s, &ManagerService{m})
 	}
@@ -96,10 +116,7 @@ func New(cfg *Config) (*Manager, error) {
 // HardStop stops all services and cleans up the Manager state. Unlike
 // GracefulStop, it does not attempt to gracefully shutdown etcd.
 func (m *Manager) HardStop() {
-	if m.removeCh != nil {
-		close(m.removeCh)
-		m.removeCh = nil
-	}
+	m.events.close()
 	m.cancel()
 	m.ctx, m.cancel = context.WithCancel(context.Background())
 	log.Debug("attempting hard stop of etcd server ...")
@@ -114,10 +131,8 @@ func (m *Manager) HardStop() {
 // GracefulStop stops all services and cleans up the Manager state. It attempts
 // to gracefully shutdown etcd by waiting for gRPC calls in-flight to finish.
 func (m *Manager) GracefulStop() {
-	if m.removeCh != nil {
-		close(m.removeCh)
-		m.removeCh = nil
-	}
+	m.handoffLeadership()
+	m.events.close()
 	m.cancel()
 	m.ctx, m.cancel = context.WithCancel(context.Background())
 	log.Debug("attempting graceful stop of etcd server ...")
@@ -137,10 +152,15 @@ func (m *Manager) Restart() error {
 		}
 		peers = append(peers, &Peer{member.Name, member.PeerURLs[0]})
 	}
+	m.handoffLeadership()
 	ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
 	defer cancel()
 
-	return m.etcd.restart(ctx, peers)
+	if err := m.etcd.restart(ctx, peers); err != nil {
+		return err
+	}
+	m.events.publish(Event{Type: Restarted, Name: m.cfg.Name})
+	return nil
 }
 
 func (m *Manager) restoreFromSnapshot(peers []*Peer) (bool, error) {
@@ -148,23 +168,148 @@ func (m *Manager) restoreFromSnapshot(peers []*Peer) (bool, error) {
 		return false, nil
 	}
 
-	r, err := m.snapshotter.Load()
+	// the pinned snapshot is only used once, so that a later total loss
+	// within the same process recovers from the latest snapshot instead
+	restoreFrom := m.cfg.SnapshotRestoreFrom
+	m.cfg.SnapshotRestoreFrom = ""
+	if restoreFrom != "" {
+		log.Warn("restoring from pinned snapshot instead of the latest snapshot, remove --snapshot-restore-from once the cluster is recovered and use `e2d snapshot restore` for one-off restores",
+			zap.String("name", shortName(m.cfg.Name)),
+			zap.String("restore-from", restoreFrom),
+		)
+	}
+
+	// segments are replayed on top of the latest snapshot, but only onto an
+	// older snapshot when a revision to restore to is also given
+	replay := restoreFrom == "" || m.cfg.SnapshotRestoreRevision != 0
+	if !replay || len(peers) == 1 {
+		_, err := m.loadSnapshot(restoreFrom, replay, peers)
+		return err == nil, err
+	}
+
+	// Every member must be seeded from the same database, so the segments
+	// are only replayed by one of them, which saves the result as a new
+	// snapshot for the others to restore. The member with the lowest name
+	// is chosen, since every member agrees on it without needing etcd.
+	seed := restoreSeed(peers)
+	if seed != m.cfg.Name {
+		name, err := m.waitForRestore(seed)
+		if err != nil {
+			return false, err
+		}
+		_, err = m.loadSnapshot(name, false, peers)
+		return err == nil, err
+	}
+	name, err := m.loadSnapshot(restoreFrom, true, peers)
+	if err != nil {
+		// the other members start without a snapshot as well
+		name = ""
+	}
+	if err := m.gossip.AnnounceRestore(name); err != nil {
+		log.Error("cannot announce restored snapshot", zap.Error(err))
+	}
+	return err == nil, err
+}
+
+// restoreSeed returns the name of the member that replays the segments when
+// a cluster is restored from a snapshot.
+func restoreSeed(peers []*Peer) string {
+	seed := peers[0].Name
+	for _, p := range peers[1:] {
+		if p.Name < seed {
+			seed = p.Name
+		}
+	}
+	return seed
+}
+
+// waitForRestore waits for the seed member to announce the snapshot it
+// restored from, returning ErrSnapshotNotFound if it did not restore one.
+func (m *Manager) waitForRestore(seed string) (string, error) {
+	log.Info("waiting for seed member to restore snapshot",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.String("seed", shortName(seed)),
+	)
+	ctx, cancel := context.WithTimeout(m.ctx, m.cfg.BootstrapTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if name, ok := m.gossip.restored(seed); ok {
+			if name == "" {
+				return "", errors.Wrapf(snapshot.ErrSnapshotNotFound, "seed member %s did not restore a snapshot", shortName(seed))
+			}
+			return name, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", errors.Wrapf(ctx.Err(), "seed member %s did not restore a snapshot", shortName(seed))
+		}
+	}
+}
+
+// loadSnapshot restores the etcd data-dir from the snapshot identified by id
+// (see snapshot.Resolve), replaying the stored segments on top of it when
+// replay is set. It returns the name of the snapshot the data-dir was restored
+// from, which for more than one peer is a new snapshot of the replayed
+// database, so the other members can restore the same database.
+func (m *Manager) loadSnapshot(id string, replay bool, peers []*Peer) (string, error) {
+	name, err := snapshot.Resolve(m.snapshotter, id)
 	if err != nil {
-		return false, err
+		return "", err
+	}
+	if id != "" {
+		log.Info("restoring from specific snapshot",
+			zap.String("name", shortName(m.cfg.Name)),
+			zap.String("restore-from", id),
+			zap.String("snapshot", name),
+		)
+	}
+	r, err := m.snapshotter.LoadAt(name)
+	if err != nil {
+		return "", err
 	}
 	defer r.Close()
 
 	log.Debugf("[%v]: attempting snapshot restore with members: %s", shortName(m.cfg.Name), peers)
-	tmpFile, err := ioutil.TempFile("", "snapshot.load")
+
+	// the snapshot is streamed from the backend, decoded and written to a
+	// single file, since etcd can only restore from a file on disk
+	tmpFile, err := ioutil.TempFile(m.cfg.SnapshotScratchDir, "snapshot.load")
 	if err != nil {
-		return false, err
+		return "", err
 	}
-	defer tmpFile.Close()
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
 
-	r = snapshotutil.NewGunzipReadCloser(r)
-	r = snapshotutil.NewDecrypterReadCloser(r, m.cfg.snapshotEncryptionKey)
-	if _, err := io.Copy(tmpFile, r); err != nil {
-		return false, err
+	if err := snapshot.Extract(r, m.cfg.snapshotKeys, tmpFile.Name()); err != nil {
+		return "", err
+	}
+
+	// the snapshot is verified before the data-dir is removed, so a corrupt
+	// snapshot does not take any existing data with it
+	status, err := snapshot.VerifyDatabase(tmpFile.Name())
+	if err != nil {
+		return "", err
+	}
+	if !status.HashChecked {
+		log.Warn("snapshot database has no hash, skipping hash check", zap.String("name", shortName(m.cfg.Name)))
+	}
+	if replay {
+		var replayed bool
+		status, replayed, err = m.replaySegments(tmpFile.Name(), name, status)
+		if err != nil {
+			return "", err
+		}
+		if replayed && len(peers) > 1 {
+			name, err = m.saveReplayedSnapshot(tmpFile.Name(), name, status)
+			if err != nil {
+				return "", err
+			}
+		}
 	}
 
 	// if the process is restarted, this will fail if the data-dir already
@@ -172,12 +317,49 @@ func (m *Manager) restoreFromSnapshot(peers []*Peer) (bool, error) {
 	if err := os.RemoveAll(m.cfg.Dir); err != nil {
 		log.Errorf("cannot remove data-dir: %v", err)
 	}
-	log.Infof("loading snapshot from: %#v", tmpFile.Name())
-	if err := m.etcd.restoreSnapshot(tmpFile.Name(), peers); err != nil {
-		return false, err
+	log.Info("loading snapshot",
+		zap.String("path", tmpFile.Name()),
+		zap.String("snapshot", name),
+		zap.Int64("revision", status.Revision),
+		zap.Int("keys", status.TotalKeys),
+	)
+	if err := m.etcd.restoreSnapshot(tmpFile.Name(), !status.HashChecked, peers); err != nil {
+		return "", err
 	}
 	log.Infof("successfully loaded snapshot from: %#v", tmpFile.Name())
-	return true, nil
+	return name, nil
+}
+
+// saveReplayedSnapshot saves the database at path, restored from the base
+// snapshot and brought forward by replaying segments, as a new snapshot, and
+// returns its name.
+func (m *Manager) saveReplayedSnapshot(path, base string, status *snapshot.DatabaseStatus) (string, error) {
+	bm, err := m.snapshotter.LoadManifest(base)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	manifest := &snapshot.Manifest{
+		Revision:     status.Revision,
+		DatabaseSize: status.Size,
+	}
+	if bm != nil {
+		manifest.ClusterID = bm.ClusterID
+	}
+	ctx, cancel := context.WithTimeout(m.ctx, m.cfg.snapshotTimeout(status.Size))
+	defer cancel()
+	if err := m.snapshotter.Save(ctx, m.encodeSnapshot(f, manifest), manifest); err != nil {
+		return "", errors.Wrap(err, "cannot save replayed snapshot")
+	}
+	log.Info("wrote replayed snapshot to backup",
+		zap.String("snapshot", manifest.Name),
+		zap.String("base", base),
+		zap.Int64("revision", status.Revision),
+	)
+	return manifest.Name, nil
 }
 
 // startEtcdCluster starts a new etcd cluster with the provided peers. The list
@@ -192,6 +374,11 @@ func (m *Manager) startEtcdCluster(peers []*Peer) error {
 	restored, err := m.restoreFromSnapshot(peers)
 	if err != nil {
 		log.Error("cannot restore snapshot", zap.Error(err))
+		m.metrics.snapshotRestores.WithLabelValues("failure").Inc()
+	}
+	if restored {
+		m.metrics.snapshotRestores.WithLabelValues("success").Inc()
+		m.events.publish(Event{Type: SnapshotRestored, Name: m.cfg.Name})
 	}
 	ctx, cancel := context.WithTimeout(m.ctx, 5*time.Minute)
 	defer cancel()
@@ -257,6 +444,20 @@ func (m *Manager) joinEtcdCluster(peerURL string) error {
 		return err
 	}
 
+	// The cluster-info is checked before this member is started or added to
+	// the cluster, so a misconfigured member is rejected before it can
+	// become a voting member. It also picks up the size of a cluster that has
+	// been resized since this member last started.
+	cluster, err := m.etcd.readClusterInfo(ctx, peerURL)
+	if err != nil {
+		return errors.Wrap(err, "cannot read cluster-info")
+	}
+	if cluster != nil {
+		if err := m.etcd.checkClusterInfo(cluster); err != nil {
+			return err
+		}
+	}
+
 	// In cases where the existing cluster identifies this instance to already
 	// be a member of the cluster, we attempt to start right away. This case
 	// happens when restarting a node and specifying the previous node name.
@@ -269,7 +470,12 @@ func (m *Manager) joinEtcdCluster(peerURL string) error {
 		}
 		log.Infof("%s is already considered a member, attempting to start ...", m.cfg.Name)
 		if err := m.etcd.joinExisting(ctx, peers); err == nil {
-			return nil
+			// a member restarting before being promoted will still be a
+			// learner and must finish catching up
+			if !m.etcd.Server.IsLearner() {
+				return nil
+			}
+			return m.promoteLearner(c, members[m.cfg.Name])
 		}
 		log.Infof("%s is already considered a member, but failed to start, attempting to remove ...", m.cfg.Name)
 		if err := c.removeMemberLocked(ctx, members[m.cfg.Name]); err != nil {
@@ -287,10 +493,20 @@ func (m *Manager) joinEtcdCluster(peerURL string) error {
 	}
 	defer unlock()
 
-	member, err := c.addMember(ctx, m.cfg.PeerURL.String())
+	var member *Member
+	if m.cfg.DisableLearnerJoin {
+		member, err = c.addMember(ctx, m.cfg.PeerURL.String())
+	} else {
+		member, err = c.addLearner(ctx, m.cfg.PeerURL.String())
+	}
 	if err != nil {
 		return err
 	}
+	log.Info("added member",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.String("id", fmt.Sprintf("%x", member.ID)),
+		zap.Bool("learner", !m.cfg.DisableLearnerJoin),
+	)
 
 	// The name will not be available immediately after adding a new member.
 	// Since the member missing is this member, we can safely use the local
@@ -305,9 +521,86 @@ func (m *Manager) joinEtcdCluster(peerURL string) error {
 		}
 		return err
 	}
+	if m.cfg.DisableLearnerJoin {
+		return nil
+	}
+	return m.promoteLearner(c, member)
+}
+
+// promoteLearner waits for the local learner member to catch up with the
+// applied index of the cluster leader and then promotes it to a voting
+// member. If the learner cannot be promoted before LearnerPromotionTimeout,
+// the local etcd server is stopped and the learner is removed from the
+// cluster.
+func (m *Manager) promoteLearner(c *Client, member *Member) error {
+	ctx, cancel := context.WithTimeout(m.ctx, m.cfg.LearnerPromotionTimeout)
+	defer cancel()
+
+	log.Info("waiting for learner to catch up with leader",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.String("id", fmt.Sprintf("%x", member.ID)),
+		zap.Duration("timeout", m.cfg.LearnerPromotionTimeout),
+	)
+	if err := m.waitLearnerPromoted(ctx, c, member); err != nil {
+		log.Error("cannot promote learner, removing member",
+			zap.String("name", shortName(m.cfg.Name)),
+			zap.String("id", fmt.Sprintf("%x", member.ID)),
+			zap.Error(err),
+		)
+		m.etcd.hardStop()
+		<-m.etcd.Server.StopNotify()
+		if err := c.removeMember(m.ctx, member.ID); err != nil {
+			log.Debug("unable to remove member", zap.Error(err))
+		}
+		return errors.Wrap(err, "cannot promote learner")
+	}
+	log.Info("learner promoted to voting member",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.String("id", fmt.Sprintf("%x", member.ID)),
+	)
+
+	// the cluster-info was checked through the peer before joining, but is
+	// checked again once the member is able to serve linearizable requests
+	if err := m.etcd.writeClusterInfo(ctx); err != nil {
+		return errors.Wrap(err, "cannot write cluster-info")
+	}
 	return nil
 }
 
+func (m *Manager) waitLearnerPromoted(ctx context.Context, c *Client, member *Member) error {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			leaderIndex, err := c.leaderAppliedIndex(ctx)
+			if err != nil {
+				log.Debugf("[%v]: cannot get leader applied index: %v", shortName(m.cfg.Name), err)
+				continue
+			}
+			appliedIndex := m.etcd.Server.AppliedIndex()
+			if appliedIndex < leaderIndex {
+				log.Debug("learner catching up",
+					zap.String("name", shortName(m.cfg.Name)),
+					zap.Uint64("applied-index", appliedIndex),
+					zap.Uint64("leader-applied-index", leaderIndex),
+				)
+				continue
+			}
+			if err := c.promoteMember(ctx, member.ID); err != nil {
+				log.Debugf("[%v]: cannot promote learner: %v", shortName(m.cfg.Name), err)
+				continue
+			}
+			return nil
+		case <-m.etcd.Server.StopNotify():
+			return errors.New("etcd server stopped")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 func (m *Manager) startOrJoinEtcdCluster() error {
 	ctx, cancel := context.WithTimeout(m.ctx, m.cfg.BootstrapTimeout)
 	defer cancel()
@@ -338,7 +631,7 @@ func (m *Manager) startOrJoinEtcdCluster() error {
 				return nil
 			}
 			log.Debugf("[%v]: cluster currently has %d members", shortName(m.cfg.Name), len(m.gossip.Members()))
-			if len(m.gossip.Members()) < m.cfg.RequiredClusterSize {
+			if len(m.gossip.Members()) < m.etcd.requiredClusterSize() {
 				continue
 			}
 			if err := m.gossip.Update(Pending); err != nil {
@@ -348,7 +641,7 @@ func (m *Manager) startOrJoinEtcdCluster() error {
 			// when enough members are reporting in as pending, it means that a
 			// majority of members were unable to connect to an existing
 			// cluster
-			if len(m.gossip.pendingMembers()) < m.cfg.RequiredClusterSize {
+			if len(m.gossip.pendingMembers()) < m.etcd.requiredClusterSize() {
 				log.Debugf("[%v]: members pending: %d", shortName(m.cfg.Name), len(m.gossip.pendingMembers()))
 				continue
 			}
@@ -385,11 +678,20 @@ func (m *Manager) runMembershipCleanup() {
 			// partition takes place that minority partition(s) will not
 			// attempt to change cluster membership. Only members in Running
 			// status are considered.
-			if !m.cluster.ensureQuorum(len(m.gossip.runningMembers()) > m.cfg.RequiredClusterSize/2) {
+			hadQuorum := m.cluster.quorum()
+			hasQuorum := m.cluster.ensureQuorum(len(m.gossip.runningMembers()) > m.etcd.requiredClusterSize()/2)
+			if hasQuorum && !hadQuorum {
+				m.events.publish(Event{Type: QuorumRegained, Name: m.cfg.Name})
+			}
+			if !hasQuorum {
+				if hadQuorum {
+					m.metrics.quorumLost.Inc()
+					m.events.publish(Event{Type: QuorumLost, Name: m.cfg.Name})
+				}
 				log.Info("not enough members are healthy to remove other members",
 					zap.String("name", shortName(m.cfg.Name)),
 					zap.Int("gossip-members", len(m.gossip.runningMembers())),
-					zap.Int("required-cluster-size", m.cfg.RequiredClusterSize),
+					zap.Int("required-cluster-size", m.etcd.requiredClusterSize()),
 				)
 			}
 
@@ -434,8 +736,18 @@ func (m *Manager) runMembershipCleanup() {
 				}
 
 				m.cluster.removeSuspect(member.Name)
+				m.events.publish(Event{Type: MemberJoined, Name: member.Name})
 			case memberlist.NodeLeave:
+				// A member that announced it was leaving has already removed
+				// itself from the etcd cluster, so it is not considered a
+				// failure.
+				if member.Status == Leaving || m.gossip.status(member.Name) == Leaving {
+					log.Debugf("[%v]: member left: %#v", shortName(m.cfg.Name), member.Name)
+					m.events.publish(Event{Type: MemberLeft, Name: member.Name})
+					continue
+				}
 				m.cluster.addSuspect(member.Name)
+				m.events.publish(Event{Type: MemberSuspected, Name: member.Name})
 			case memberlist.NodeUpdate:
 			}
 		case <-m.ctx.Done():
@@ -454,8 +766,6 @@ func (m *Manager) runSnapshotter() {
 	ticker := time.NewTicker(m.cfg.SnapshotInterval)
 	defer ticker.Stop()
 
-	var latestRev int64
-
 	for {
 		select {
 		case <-ticker.C:
@@ -467,30 +777,19 @@ func (m *Manager) runSnapshotter() {
 				log.Debug("not leader, skipping snapshot backup")
 				continue
 			}
-			log.Debug("starting snapshot backup")
-			snapshotData, snapshotSize, rev, err := m.etcd.createSnapshot(latestRev)
-			if err != nil {
-				log.Info("skipping snapshot, etcd revision hasn't changed since last snapshot",
-					zap.String("name", shortName(m.cfg.Name)),
-					zap.Error(err),
-				)
-				continue
-			}
-			if m.cfg.SnapshotEncryption {
-				snapshotData = snapshotutil.NewEncrypterReadCloser(snapshotData, m.cfg.snapshotEncryptionKey, snapshotSize)
-			}
-			if m.cfg.SnapshotCompression {
-				snapshotData = snapshotutil.NewGzipReadCloser(snapshotData)
-			}
-			if err := m.snapshotter.Save(snapshotData); err != nil {
+			if _, err := m.saveSnapshot(m.ctx, false); err != nil {
+				if errors.Cause(err) == errSnapshotUnchanged {
+					log.Info("skipping snapshot, etcd revision hasn't changed since last snapshot",
+						zap.String("name", shortName(m.cfg.Name)),
+						zap.Error(err),
+					)
+					continue
+				}
 				log.Error("cannot save snapshot",
 					zap.String("name", shortName(m.cfg.Name)),
 					zap.Error(err),
 				)
-				continue
 			}
-			latestRev = rev
-			log.Infof("wrote snapshot (rev %d) to backup", latestRev)
 		case <-m.ctx.Done():
 			log.Debug("stopping snapshotter")
 			return
@@ -498,6 +797,410 @@ func (m *Manager) runSnapshotter() {
 	}
 }
 
+// encodeSnapshot compresses and encrypts an etcd database as configured,
+// before it is saved as a snapshot, and fills in the details of the encoding
+// in the manifest it is saved with.
+func (m *Manager) encodeSnapshot(r io.ReadCloser, manifest *snapshot.Manifest) io.ReadCloser {
+	manifest.Leader = m.cfg.Name
+	manifest.Version = buildinfo.Version
+	if m.cfg.SnapshotEncryption {
+		r = snapshotutil.NewEncrypterReadCloser(r, m.cfg.snapshotKeys)
+		manifest.Encrypted = true
+		manifest.KeyID = m.cfg.snapshotKeys.KeyID().String()
+	}
+	if m.cfg.snapshotCodec != nil {
+		r = snapshotutil.NewCompressorReadCloser(r, m.cfg.snapshotCodec)
+		manifest.Compressed = true
+		manifest.Compression = m.cfg.snapshotCodec.Name()
+	}
+	return r
+}
+
+var errSnapshotUnchanged = errors.New("etcd revision has not changed since the last snapshot")
+
+// saveSnapshot takes a snapshot of the etcd database and saves it to the
+// snapshot backup. Unless forced, nothing is saved if the cluster has not
+// changed since the last snapshot saved by this member. It is used both by
+// the snapshotter and for requested snapshots, which are serialized so only
+// one snapshot is taken at a time.
+func (m *Manager) saveSnapshot(ctx context.Context, force bool) (*snapshot.Manifest, error) {
+	if m.snapshotter == nil {
+		return nil, errors.New("snapshotting disabled: no snapshot backup set")
+	}
+	m.snapshotMu.Lock()
+	defer m.snapshotMu.Unlock()
+
+	log.Debug("starting snapshot backup")
+	start := time.Now()
+	minRevision := m.latestSnapshotRev
+	if force {
+		minRevision = 0
+	}
+	snapshotData, snapshotSize, rev, err := m.etcd.createSnapshot(minRevision)
+	if err != nil {
+		if errors.Cause(err) == errSnapshotUnchanged {
+			return nil, err
+		}
+		m.metrics.snapshotFailures.Inc()
+		m.events.publish(Event{Type: SnapshotFailed, Name: m.cfg.Name, Revision: rev, Err: err})
+		return nil, errors.Wrap(err, "cannot create snapshot")
+	}
+	manifest := &snapshot.Manifest{
+		Revision:     rev,
+		ClusterID:    m.etcd.Server.Cluster().ID().String(),
+		DatabaseSize: snapshotSize,
+	}
+	cr := &countingReadCloser{ReadCloser: m.encodeSnapshot(snapshotData, manifest)}
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.snapshotTimeout(snapshotSize))
+	err = m.snapshotter.Save(ctx, cr, manifest)
+	cancel()
+	if err != nil {
+		m.metrics.snapshotFailures.Inc()
+		m.events.publish(Event{Type: SnapshotFailed, Name: m.cfg.Name, Revision: rev, Err: err})
+		return nil, err
+	}
+	m.metrics.snapshotDuration.Observe(time.Since(start).Seconds())
+	m.metrics.snapshotSize.Set(float64(cr.n))
+	m.metrics.snapshotLastSaved.SetToCurrentTime()
+	m.latestSnapshotName = manifest.Name
+	m.latestSnapshotRev = rev
+	log.Info("wrote snapshot to backup",
+		zap.String("snapshot", manifest.Name),
+		zap.Int64("revision", rev),
+		zap.String("sha256", manifest.SHA256),
+	)
+	m.events.publish(Event{Type: SnapshotSaved, Name: m.cfg.Name, Revision: rev})
+	if err := m.gossip.AnnounceSnapshot(manifest.Name, rev); err != nil {
+		log.Debug("cannot announce last snapshot", zap.Error(err))
+	}
+	if _, err := snapshot.ApplyRetention(m.snapshotter, m.cfg.SnapshotRetention); err != nil {
+		log.Error("cannot apply snapshot retention policy",
+			zap.String("name", shortName(m.cfg.Name)),
+			zap.Error(err),
+		)
+	}
+	if m.cfg.SnapshotSegmentInterval > 0 {
+		if _, err := snapshot.PruneSegments(m.snapshotter); err != nil {
+			log.Error("cannot prune segments",
+				zap.String("name", shortName(m.cfg.Name)),
+				zap.Error(err),
+			)
+		}
+	}
+	return manifest, nil
+}
+
+// forwardedKey is the gRPC metadata key set on requests forwarded to the
+// leader, so they are never forwarded again.
+const forwardedKey = "e2d-forwarded"
+
+// Snapshot takes a snapshot of the etcd database immediately and saves it to
+// the snapshot backup, even if the revision has not changed since the last
+// snapshot. Snapshots are always taken by the leader, so when this member is
+// not the leader the request is forwarded to it.
+func (m *Manager) Snapshot(ctx context.Context) (*e2dpb.SnapshotResponse, error) {
+	if !m.etcd.isRunning() {
+		return nil, errors.New("etcd is not running")
+	}
+	if m.etcd.isLeader() {
+		manifest, err := m.saveSnapshot(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		return &e2dpb.SnapshotResponse{
+			Name:       manifest.Name,
+			Created:    manifest.Created,
+			Revision:   manifest.Revision,
+			Leader:     manifest.Leader,
+			StoredSize: manifest.Size,
+			Sha256:     manifest.SHA256,
+		}, nil
+	}
+	c, conn, err := m.dialLeader(ctx, "snapshot")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return c.Snapshot(metadata.AppendToOutgoingContext(ctx, forwardedKey, m.cfg.Name), &gogotypes.Empty{})
+}
+
+// dialLeader connects to the manager service of the leader, for forwarding a
+// request that only the leader can handle. Requests that were already
+// forwarded are rejected, since the leader they were forwarded to is no
+// longer the leader.
+func (m *Manager) dialLeader(ctx context.Context, op string) (e2dpb.ManagerClient, *grpc.ClientConn, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(forwardedKey)) > 0 {
+		return nil, nil, errors.Errorf("cannot forward %s request, leadership is changing", op)
+	}
+	lead := m.etcd.Server.Lead()
+	leader := m.etcd.Server.Cluster().Member(types.ID(lead))
+	if leader == nil || len(leader.ClientURLs) == 0 {
+		return nil, nil, errors.Errorf("cannot find leader %x", lead)
+	}
+	log.Debug("forwarding request to leader",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.String("leader", shortName(leader.Name)),
+		zap.String("operation", op),
+	)
+	dctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return newManagerClient(dctx, leader.ClientURLs[0], m.cfg.PeerSecurity)
+}
+
+// snapshotBeforeMaintenance takes a snapshot before a maintenance operation
+// (e.g. leaving the cluster) when SnapshotBeforeMaintenance is set. The
+// operation must not continue if this fails. The snapshot is not bound by the
+// request for the operation, whose deadline is usually too short to save it,
+// but by the time allowed to save a snapshot of the size of the database.
+func (m *Manager) snapshotBeforeMaintenance(op string) error {
+	if !m.cfg.SnapshotBeforeMaintenance {
+		return nil
+	}
+	log.Info("taking snapshot before maintenance",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.String("operation", op),
+	)
+	ctx, cancel := context.WithTimeout(m.ctx, m.cfg.snapshotTimeout(m.etcd.Server.Backend().Size()))
+	defer cancel()
+	resp, err := m.Snapshot(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "cannot take snapshot before %s", op)
+	}
+	log.Info("took snapshot before maintenance",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.String("operation", op),
+		zap.String("snapshot", resp.Name),
+		zap.Int64("revision", resp.Revision),
+	)
+	return nil
+}
+
+func (m *Manager) isLeaving() bool {
+	return atomic.LoadUint64(&m.leaving) == 1
+}
+
+// canLeave checks whether this member is currently able to leave the cluster.
+func (m *Manager) canLeave() error {
+	if !m.etcd.isRunning() {
+		return errors.New("etcd is not running")
+	}
+	if m.cfg.RequiredClusterSize == 1 {
+		return errors.New("cannot leave a single-node cluster")
+	}
+	if m.isLeaving() {
+		return errors.New("member is already leaving the cluster")
+	}
+	return nil
+}
+
+// Leave gracefully removes this member from the cluster. Peers are notified
+// over gossip that this member is leaving so that it is not treated as a
+// failure, leadership is transferred if this member is the leader, and the
+// member is removed from the etcd cluster before shutting down.
+func (m *Manager) Leave(ctx context.Context) error {
+	if err := m.leaveCluster(ctx); err != nil {
+		return err
+	}
+	m.shutdownAfterLeave()
+	return nil
+}
+
+// leaveCluster removes this member from the cluster, without shutting down.
+func (m *Manager) leaveCluster(ctx context.Context) error {
+	if err := m.canLeave(); err != nil {
+		return err
+	}
+	if !atomic.CompareAndSwapUint64(&m.leaving, 0, 1) {
+		return errors.New("member is already leaving the cluster")
+	}
+	log.Info("leaving cluster", zap.String("name", shortName(m.cfg.Name)))
+	if err := m.gossip.Update(Leaving); err != nil {
+		log.Debugf("[%v]: cannot update member metadata: %v", shortName(m.cfg.Name), err)
+	}
+	if err := m.removeSelf(ctx); err != nil {
+		atomic.StoreUint64(&m.leaving, 0)
+		if err := m.gossip.Update(Running); err != nil {
+			log.Debugf("[%v]: cannot update member metadata: %v", shortName(m.cfg.Name), err)
+		}
+		return err
+	}
+	log.Info("member removed from cluster", zap.String("name", shortName(m.cfg.Name)))
+	return nil
+}
+
+// shutdownAfterLeave shuts down this member once it has been removed from the
+// cluster by leaveCluster.
+func (m *Manager) shutdownAfterLeave() {
+	defer close(m.left)
+
+	if err := m.gossip.Leave(5 * time.Second); err != nil {
+		log.Debug("gossip leave failed", zap.Error(err))
+	}
+	m.GracefulStop()
+}
+
+func (m *Manager) removeSelf(ctx context.Context) error {
+	if m.etcd.isLeader() {
+		if _, err := m.moveLeader(ctx, ""); err != nil {
+			return err
+		}
+	}
+	if err := m.etcd.removeMember(ctx, m.cfg.Name); err != nil {
+		// etcd stops the server once the removal of this member is applied,
+		// which can cause the request to fail even though it succeeded
+		select {
+		case <-m.etcd.Server.StopNotify():
+			return nil
+		default:
+		}
+		return err
+	}
+	return nil
+}
+
+// Resize changes the required cluster size of a running multi-node cluster.
+// The size can only be changed by 2 at a time to ensure that the cluster
+// remains at an odd size, and the change is rejected if this member cannot
+// currently see a majority of running members. The new size is persisted in
+// the cluster-info and broadcast to all peers over gossip.
+//
+// Growing the cluster only raises the number of members the cluster expects,
+// new nodes must still be started (with the new size) to join it. Shrinking
+// the cluster allows members to be stopped, with the remaining peers removing
+// them from the etcd membership once they are considered unhealthy.
+func (m *Manager) Resize(ctx context.Context, size int) error {
+	if !m.etcd.isRunning() {
+		return errors.New("etcd is not running")
+	}
+	if m.cfg.RequiredClusterSize == 1 {
+		return errors.New("cannot resize a single-node cluster")
+	}
+	if err := validateClusterSize(size); err != nil {
+		return err
+	}
+	current := m.etcd.requiredClusterSize()
+	if size == current {
+		return nil
+	}
+	if size == 1 {
+		return errors.New("cannot resize a multi-node cluster to a single-node cluster")
+	}
+	if d := size - current; d != 2 && d != -2 {
+		return errors.Errorf("cluster size can only be changed by 2 members at a time, current size is %d", current)
+	}
+	if len(m.gossip.runningMembers()) <= current/2 {
+		return errors.Errorf("cannot resize cluster without quorum, %d of %d members running", len(m.gossip.runningMembers()), current)
+	}
+	if size > current {
+		// growing raises the quorum, so the cluster must already be at its
+		// full size with every member started, otherwise the new quorum may
+		// be more than the existing members can provide
+		members := m.etcd.Server.Cluster().Members()
+		if len(members) != current {
+			return errors.Errorf("cannot grow cluster with %d of %d members", len(members), current)
+		}
+		for _, member := range members {
+			if !member.IsStarted() {
+				return errors.Errorf("cannot grow cluster while member %s has not started", member.ID)
+			}
+		}
+	}
+	if err := m.etcd.updateClusterInfo(ctx, current, size); err != nil {
+		return err
+	}
+	m.etcd.setRequiredClusterSize(size)
+	if err := m.gossip.UpdateClusterSize(size); err != nil {
+		return errors.Wrap(err, "cannot broadcast cluster size")
+	}
+	log.Info("cluster resized",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.Int("from", current),
+		zap.Int("to", size),
+	)
+	return nil
+}
+
+// onClusterSizeChange is called when a peer broadcasts a new required cluster
+// size over gossip. The size stored in the cluster-info is preferred, and the
+// broadcast size is only used when it cannot be read (e.g. because etcd is
+// not yet running on this member).
+func (m *Manager) onClusterSizeChange(size int) {
+	if err := validateClusterSize(size); err != nil {
+		log.Debugf("[%v]: ignoring cluster size change: %v", shortName(m.cfg.Name), err)
+		return
+	}
+
+	// gossip messages must not block, so the cluster-info is read separately
+	go func() {
+		ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+		defer cancel()
+
+		if err := m.syncClusterSize(ctx); err != nil {
+			log.Debugf("[%v]: cannot read cluster size from cluster-info: %v", shortName(m.cfg.Name), err)
+			m.setRequiredClusterSize(size)
+		}
+	}()
+}
+
+// syncClusterSize sets the required cluster size to the size stored in the
+// cluster-info, which changes when the cluster is resized.
+func (m *Manager) syncClusterSize(ctx context.Context) error {
+	if !m.etcd.isRunning() {
+		return errors.New("etcd is not running")
+	}
+	if m.etcd.Server.IsLearner() {
+		return errors.New("learners cannot read cluster-info")
+	}
+	cluster, err := m.etcd.readClusterInfo(ctx, m.cfg.ClientURL.String())
+	if err != nil {
+		return err
+	}
+	if cluster == nil {
+		return errors.New("cluster-info not found")
+	}
+	m.setRequiredClusterSize(cluster.RequiredClusterSize)
+	return nil
+}
+
+func (m *Manager) setRequiredClusterSize(size int) {
+	current := m.etcd.requiredClusterSize()
+	if size == current {
+		return
+	}
+	m.etcd.setRequiredClusterSize(size)
+	log.Info("required cluster size changed",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.Int("from", current),
+		zap.Int("to", size),
+	)
+}
+
+// runClusterSizeSync periodically syncs the required cluster size with the
+// cluster-info, so members that missed the broadcast of a resize still pick
+// up the new size.
+func (m *Manager) runClusterSizeSync() {
+	if m.cfg.RequiredClusterSize == 1 {
+		return
+	}
+	ticker := time.NewTicker(m.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(m.ctx, 10*time.Second)
+			if err := m.syncClusterSize(ctx); err != nil {
+				log.Debugf("[%v]: cannot sync cluster size: %v", shortName(m.cfg.Name), err)
+			}
+			cancel()
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
 // Run starts and manages an etcd node based upon the provided configuration.
 // In the case of a fault, or if the manager is otherwise stopped, this method
 // exits.
@@ -506,6 +1209,39 @@ func (m *Manager) Run() error {
 		return errors.New("etcd is already running")
 	}
 
+	// a pinned snapshot is meant for recovering a cluster that has lost its
+	// data, so it is ignored by a member that still has a data directory
+	if m.cfg.SnapshotRestoreFrom != "" {
+		if _, err := os.Lstat(m.cfg.Dir); err == nil {
+			log.Warn("data directory exists, ignoring pinned snapshot",
+				zap.String("name", shortName(m.cfg.Name)),
+				zap.String("restore-from", m.cfg.SnapshotRestoreFrom),
+				zap.String("dir", m.cfg.Dir),
+			)
+			m.cfg.SnapshotRestoreFrom = ""
+		}
+	}
+
+	// metrics are served before starting, so that bootstrapping and restoring
+	// from snapshot can be observed, and a bad address fails before etcd is
+	// started
+	if m.cfg.MetricsAddr != "" {
+		srv, err := m.serveMetrics(m.cfg.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
+	}
+
+	// subscribe before starting so that events during startup, such as
+	// restoring from snapshot, are also delivered
+	if m.cfg.NotifyWebhookURL != "" {
+		events, unsubscribe := m.Subscribe(100)
+		defer unsubscribe()
+		w := newWebhookNotifier(m.cfg.NotifyWebhookURL, m.cfg.NotifyWebhookSecret, m.cfg.Name)
+		go w.run(m.ctx, events)
+	}
+
 	switch m.cfg.RequiredClusterSize {
 	case 1:
 		// a single-node etcd cluster does not require gossip or need to wait for
@@ -532,7 +1268,7 @@ func (m *Manager) Run() error {
 			}
 		}
 
-	case 3, 5:
+	default:
 		// all multi-node clusters require the gossip network to be started
 		if err := m.gossip.Start(m.ctx, m.cfg.BootstrapAddrs); err != nil {
 			return err
@@ -551,7 +1287,9 @@ func (m *Manager) Run() error {
 
 	// cluster is ready so start maintenance loops
 	go m.runMembershipCleanup()
+	go m.runClusterSizeSync()
 	go m.runSnapshotter()
+	go m.runSegmentShipper()
 
 	for {
 		select {
@@ -564,6 +1302,12 @@ func (m *Manager) Run() error {
 				time.Sleep(1 * time.Second)
 				continue
 			}
+			if m.isLeaving() {
+				// wait for the shutdown, so a Leave request still being
+				// handled can finish
+				<-m.left
+				return nil
+			}
 			if m.cfg.RequiredClusterSize == 1 {
 				return nil
 			}
@@ -2,21 +2,25 @@
 package manager
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/cloudflare/cfssl/csr"
+	"github.com/gogo/protobuf/types"
 	"go.uber.org/zap/zapcore"
 
 	"github.com/criticalstack/e2d/pkg/client"
 	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
 	"github.com/criticalstack/e2d/pkg/netutil"
 	"github.com/criticalstack/e2d/pkg/pki"
 	"github.com/criticalstack/e2d/pkg/snapshot"
@@ -91,17 +95,17 @@ func (n *testCluster) startAll() {
 
 func (n *testCluster) saveSnapshot(name string) {
 	node := n.lookupNode(name)
-	data, size, _, err := node.etcd.createSnapshot(0)
+	data, _, rev, err := node.etcd.createSnapshot(0)
 	if err != nil {
 		n.t.Fatal(err)
 	}
 	if node.cfg.SnapshotEncryption {
-		data = snapshotutil.NewEncrypterReadCloser(data, node.cfg.snapshotEncryptionKey, size)
+		data = snapshotutil.NewEncrypterReadCloser(data, node.cfg.snapshotKeys)
 	}
-	if node.cfg.SnapshotCompression {
-		data = snapshotutil.NewGzipReadCloser(data)
+	if node.cfg.snapshotCodec != nil {
+		data = snapshotutil.NewCompressorReadCloser(data, node.cfg.snapshotCodec)
 	}
-	if err := node.snapshotter.Save(data); err != nil {
+	if err := node.snapshotter.Save(context.Background(), data, &snapshot.Manifest{Revision: rev}); err != nil {
 		n.t.Fatal(err)
 	}
 }
@@ -134,10 +138,12 @@ func (n *testCluster) waitRemoved(removed string, nodes ...string) {
 	var wg sync.WaitGroup
 	for _, name := range nodes {
 		wg.Add(1)
+		events, unsubscribe := n.lookupNode(name).Subscribe(10)
 		go func(name string) {
 			defer wg.Done()
-			for removedNode := range n.lookupNode(name).removeCh {
-				if removedNode == removed {
+			defer unsubscribe()
+			for ev := range events {
+				if ev.Type == MemberRemoved && ev.Name == removed {
 					return
 				}
 			}
@@ -200,7 +206,7 @@ func newSecureTestClient(addr, certFile, clientCertFile, clientKeyFile string) *
 }
 
 func newFileSnapshotter(path string) *snapshot.FileSnapshotter {
-	s, _ := snapshot.NewFileSnapshotter(path)
+	s, _ := snapshot.NewFileSnapshotter(path, 0)
 	return s
 }
 
@@ -439,7 +445,7 @@ func TestManagerRestoreClusterFromSnapshotCompression(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "zstd",
 	})
 	c.addNode("node2", &Config{
 		ClientAddr:          ":2479",
@@ -450,7 +456,7 @@ func TestManagerRestoreClusterFromSnapshotCompression(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "zstd",
 	})
 	c.addNode("node3", &Config{
 		ClientAddr:          ":2579",
@@ -461,7 +467,7 @@ func TestManagerRestoreClusterFromSnapshotCompression(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "zstd",
 	})
 
 	c.startAll()
@@ -503,7 +509,7 @@ func TestManagerRestoreClusterFromSnapshotCompression(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "zstd",
 	})
 	c.addNode("node5", &Config{
 		ClientAddr:          ":2479",
@@ -514,7 +520,7 @@ func TestManagerRestoreClusterFromSnapshotCompression(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "zstd",
 	})
 	c.addNode("node6", &Config{
 		ClientAddr:          ":2579",
@@ -525,7 +531,7 @@ func TestManagerRestoreClusterFromSnapshotCompression(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "zstd",
 	})
 	c.start("node4", "node5", "node6")
 	c.wait("node4", "node5", "node6")
@@ -573,7 +579,7 @@ func TestManagerRestoreClusterFromSnapshotEncryption(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "gzip",
 		SnapshotEncryption:  true,
 		ClientSecurity: client.SecurityConfig{
 			CertFile:      serverCertFile,
@@ -597,7 +603,7 @@ func TestManagerRestoreClusterFromSnapshotEncryption(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "gzip",
 		SnapshotEncryption:  true,
 		ClientSecurity: client.SecurityConfig{
 			CertFile:      serverCertFile,
@@ -621,7 +627,7 @@ func TestManagerRestoreClusterFromSnapshotEncryption(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "gzip",
 		SnapshotEncryption:  true,
 		ClientSecurity: client.SecurityConfig{
 			CertFile:      serverCertFile,
@@ -676,7 +682,7 @@ func TestManagerRestoreClusterFromSnapshotEncryption(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "gzip",
 		ClientSecurity: client.SecurityConfig{
 			CertFile:      serverCertFile,
 			KeyFile:       serverKeyFile,
@@ -699,7 +705,7 @@ func TestManagerRestoreClusterFromSnapshotEncryption(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "gzip",
 		ClientSecurity: client.SecurityConfig{
 			CertFile:      serverCertFile,
 			KeyFile:       serverKeyFile,
@@ -722,7 +728,7 @@ func TestManagerRestoreClusterFromSnapshotEncryption(t *testing.T) {
 		HealthCheckInterval: 1 * time.Second,
 		HealthCheckTimeout:  10 * time.Second,
 		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
-		SnapshotCompression: true,
+		SnapshotCompression: "gzip",
 		ClientSecurity: client.SecurityConfig{
 			CertFile:      serverCertFile,
 			KeyFile:       serverKeyFile,
@@ -749,6 +755,81 @@ func TestManagerRestoreClusterFromSnapshotEncryption(t *testing.T) {
 	}
 }
 
+func TestManagerSnapshotForwardedToLeader(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
+	})
+	c.addNode("node2", &Config{
+		ClientAddr:          ":2479",
+		PeerAddr:            ":2480",
+		GossipAddr:          ":7981",
+		BootstrapAddrs:      []string{":7980"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
+	})
+	c.addNode("node3", &Config{
+		ClientAddr:          ":2579",
+		PeerAddr:            ":2580",
+		GossipAddr:          ":7982",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
+	})
+
+	c.startAll()
+	c.wait("node1", "node2", "node3")
+	leader := c.leader()
+	var follower *Manager
+	for _, node := range c.nodes {
+		if node != leader {
+			follower = node
+			break
+		}
+	}
+
+	// the revision does not change between snapshots, but requested
+	// snapshots are always taken
+	names := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		resp, err := follower.Snapshot(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Leader != leader.cfg.Name {
+			t.Fatalf("expected snapshot taken by %#v, received %#v", leader.cfg.Name, resp.Leader)
+		}
+		names[resp.Name] = true
+	}
+	snapshots, err := newFileSnapshotter("testdata/snapshots").List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || len(snapshots) != 2 {
+		t.Fatalf("expected 2 snapshots, received %d (%d listed)", len(names), len(snapshots))
+	}
+}
+
 func TestManagerSingleNodeRestart(t *testing.T) {
 	if !*testLong {
 		t.Skip()
@@ -1285,6 +1366,226 @@ func TestManagerDeleteVolatile(t *testing.T) {
 	cl.Close()
 }
 
+func TestManagerRestoreFromSnapshotSegments(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:              ":2379",
+		PeerAddr:                ":2380",
+		GossipAddr:              ":7980",
+		BootstrapAddrs:          []string{":7981"},
+		RequiredClusterSize:     1,
+		HealthCheckInterval:     1 * time.Second,
+		HealthCheckTimeout:      10 * time.Second,
+		SnapshotSegmentInterval: 500 * time.Millisecond,
+		Snapshotter:             newFileSnapshotter("testdata/snapshots"),
+	})
+
+	c.start("node1")
+	c.wait("node1")
+	cl := newTestClient(":2379")
+	if err := cl.Set("testkey1", "testvalue1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.lookupNode("node1").Snapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes after the snapshot are only in the segments
+	if err := cl.Set("testkey2", "testvalue2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.Delete(context.Background(), "testkey1"); err != nil {
+		t.Fatal(err)
+	}
+	cl.Close()
+	time.Sleep(2 * time.Second)
+	segments, err := newFileSnapshotter("testdata/snapshots").ListSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) == 0 {
+		t.Fatal("expected segments to be shipped")
+	}
+	c.stop("node1")
+
+	// need to wait a bit to ensure the port is free to bind
+	time.Sleep(1 * time.Second)
+
+	c.addNode("node2", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 1,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
+	})
+	c.start("node2")
+	c.wait("node2")
+	cl = newTestClient(":2379")
+	defer cl.Close()
+	v, err := cl.Get("testkey2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "testvalue2" {
+		t.Fatalf("expected %#v, received %#v", "testvalue2", string(v))
+	}
+	if n, err := cl.Count("testkey1"); err != nil || n != 0 {
+		t.Fatalf("expected testkey1 to be deleted, received %d keys (%v)", n, err)
+	}
+}
+
+func TestManagerRestoreClusterFromSnapshotSegments(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:              ":2379",
+		PeerAddr:                ":2380",
+		GossipAddr:              ":7980",
+		BootstrapAddrs:          []string{":7981"},
+		RequiredClusterSize:     3,
+		HealthCheckInterval:     1 * time.Second,
+		HealthCheckTimeout:      10 * time.Second,
+		SnapshotSegmentInterval: 500 * time.Millisecond,
+		Snapshotter:             newFileSnapshotter("testdata/snapshots"),
+	})
+	c.addNode("node2", &Config{
+		ClientAddr:              ":2479",
+		PeerAddr:                ":2480",
+		GossipAddr:              ":7981",
+		BootstrapAddrs:          []string{":7980"},
+		RequiredClusterSize:     3,
+		HealthCheckInterval:     1 * time.Second,
+		HealthCheckTimeout:      10 * time.Second,
+		SnapshotSegmentInterval: 500 * time.Millisecond,
+		Snapshotter:             newFileSnapshotter("testdata/snapshots"),
+	})
+	c.addNode("node3", &Config{
+		ClientAddr:              ":2579",
+		PeerAddr:                ":2580",
+		GossipAddr:              ":7982",
+		BootstrapAddrs:          []string{":7981"},
+		RequiredClusterSize:     3,
+		HealthCheckInterval:     1 * time.Second,
+		HealthCheckTimeout:      10 * time.Second,
+		SnapshotSegmentInterval: 500 * time.Millisecond,
+		Snapshotter:             newFileSnapshotter("testdata/snapshots"),
+	})
+
+	c.startAll()
+	c.wait("node1", "node2", "node3")
+	cl := newTestClient(":2479")
+	if err := cl.Set("testkey1", "testvalue1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.leader().Snapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes after the snapshot are only in the segments
+	if err := cl.Set("testkey2", "testvalue2"); err != nil {
+		t.Fatal(err)
+	}
+	cl.Close()
+	time.Sleep(2 * time.Second)
+	s := newFileSnapshotter("testdata/snapshots")
+	snapshots, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := s.ListSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) == 0 {
+		t.Fatal("expected segments to be shipped")
+	}
+	for _, seg := range segments {
+		if seg.Base != snapshots[len(snapshots)-1].Name || seg.ClusterID == "" {
+			t.Fatalf("expected segment to follow on from %s, received %+v", snapshots[len(snapshots)-1].Name, seg)
+		}
+	}
+	c.stop("node1")
+	c.stop("node2")
+	c.stop("node3")
+
+	// need to wait a bit to ensure the port is free to bind
+	time.Sleep(1 * time.Second)
+
+	c.addNode("node4", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
+	})
+	c.addNode("node5", &Config{
+		ClientAddr:          ":2479",
+		PeerAddr:            ":2480",
+		GossipAddr:          ":7981",
+		BootstrapAddrs:      []string{":7980"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
+	})
+	c.addNode("node6", &Config{
+		ClientAddr:          ":2579",
+		PeerAddr:            ":2580",
+		GossipAddr:          ":7982",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
+	})
+	c.start("node4", "node5", "node6")
+	c.wait("node4", "node5", "node6")
+
+	// the segments are replayed once, by node4, and saved as a new snapshot
+	// the other members restore
+	restored, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(snapshots)+1 {
+		t.Fatalf("expected the replayed snapshot to be saved once, received %d snapshots after %d", len(restored), len(snapshots))
+	}
+	for _, addr := range []string{":2379", ":2479", ":2579"} {
+		cl := newTestClient(addr)
+		v, err := cl.Get("testkey2")
+		cl.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(v) != "testvalue2" {
+			t.Fatalf("%s: expected %#v, received %#v", addr, "testvalue2", string(v))
+		}
+	}
+}
+
 func TestManagerServerRestartCertRenewal(t *testing.T) {
 	if !*testLong {
 		t.Skip()
@@ -1480,3 +1781,589 @@ func TestManagerRollingUpdate(t *testing.T) {
 		t.Fatalf("expected %#v, received %#v", testValue1, string(v))
 	}
 }
+
+func TestManagerResize(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  15 * time.Second,
+	})
+	c.addNode("node2", &Config{
+		ClientAddr:          ":2479",
+		PeerAddr:            ":2480",
+		GossipAddr:          ":7981",
+		BootstrapAddrs:      []string{":7980"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  15 * time.Second,
+	})
+	c.addNode("node3", &Config{
+		ClientAddr:          ":2579",
+		PeerAddr:            ":2580",
+		GossipAddr:          ":7982",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  15 * time.Second,
+	})
+
+	c.startAll()
+	c.wait("node1", "node2", "node3")
+	fmt.Println("ready")
+
+	waitClusterSize := func(size int, names ...string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for _, name := range names {
+			for c.lookupNode(name).etcd.requiredClusterSize() != size {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected %s to have cluster size %d, received %d", name, size, c.lookupNode(name).etcd.requiredClusterSize())
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+	}
+
+	// a resize needs a majority of members to be seen running over gossip
+	for _, node := range c.nodes {
+		for len(node.gossip.runningMembers()) != 3 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	ctx := context.Background()
+	node1 := c.lookupNode("node1")
+	for _, size := range []int{4, 7, 1} {
+		if err := node1.Resize(ctx, size); err == nil {
+			t.Fatalf("expected resize to %d to fail", size)
+		}
+	}
+	if err := node1.Resize(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+	waitClusterSize(5, "node1", "node2", "node3")
+	cluster, err := node1.etcd.readClusterInfo(ctx, node1.cfg.ClientURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.RequiredClusterSize != 5 || cluster.Resized.IsZero() {
+		t.Fatalf("expected cluster-info to be resized to 5, received %+v", cluster)
+	}
+
+	// the cluster cannot grow again until it has all 5 members
+	if err := node1.Resize(ctx, 7); err == nil {
+		t.Fatal("expected resize to 7 with 3 of 5 members to fail")
+	}
+
+	// shrinking back from another member
+	if err := c.lookupNode("node2").Resize(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	waitClusterSize(3, "node1", "node2", "node3")
+
+	// a node restarted with the size the cluster had before being resized
+	// uses the size from the cluster-info
+	c.stop("node3")
+	c.addNode("node3", &Config{
+		ClientAddr:          ":2579",
+		PeerAddr:            ":2580",
+		GossipAddr:          ":7982",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 5,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  15 * time.Second,
+	})
+	c.start("node3")
+	c.wait("node1", "node2", "node3")
+	waitClusterSize(3, "node3")
+}
+
+func TestManagerLearnerJoin(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+	})
+	c.addNode("node2", &Config{
+		ClientAddr:          ":2479",
+		PeerAddr:            ":2480",
+		GossipAddr:          ":7981",
+		BootstrapAddrs:      []string{":7980"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+	})
+	c.addNode("node3", &Config{
+		ClientAddr:          ":2579",
+		PeerAddr:            ":2580",
+		GossipAddr:          ":7982",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+	})
+
+	c.startAll()
+	c.wait("node1", "node2", "node3")
+	fmt.Println("ready")
+
+	c.addNode("node4", &Config{
+		ClientAddr:          ":2679",
+		PeerAddr:            ":2680",
+		GossipAddr:          ":7983",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+	})
+
+	// watch the membership for node4 being added as a learner, since it is
+	// promoted as soon as it has caught up with the leader
+	node1 := c.lookupNode("node1")
+	node4 := c.lookupNode("node4")
+	sawLearner := make(chan bool, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			for _, member := range node1.etcd.Server.Cluster().Members() {
+				if len(member.PeerURLs) > 0 && member.PeerURLs[0] == node4.cfg.PeerURL.String() {
+					sawLearner <- member.IsLearner
+					return
+				}
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	c.start("node4")
+	c.wait("node4")
+	if !<-sawLearner {
+		t.Fatal("expected node4 to be added as a learner")
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for node4.etcd.Server.IsLearner() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected node4 to be promoted")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, member := range node1.etcd.Server.Cluster().Members() {
+		if member.IsLearner {
+			t.Fatalf("expected all members to be voting members, %s is a learner", member.Name)
+		}
+	}
+	cluster, err := node4.etcd.readClusterInfo(context.Background(), node4.cfg.ClientURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster == nil || cluster.RequiredClusterSize != 3 {
+		t.Fatalf("expected cluster-info with cluster size 3, received %+v", cluster)
+	}
+}
+
+func TestManagerJoinIncorrectClusterSize(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+	})
+	c.addNode("node2", &Config{
+		ClientAddr:          ":2479",
+		PeerAddr:            ":2480",
+		GossipAddr:          ":7981",
+		BootstrapAddrs:      []string{":7980"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+	})
+	c.addNode("node3", &Config{
+		ClientAddr:          ":2579",
+		PeerAddr:            ":2580",
+		GossipAddr:          ":7982",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+	})
+
+	c.startAll()
+	c.wait("node1", "node2", "node3")
+	fmt.Println("ready")
+
+	// the node never starts etcd, so it is not added to the test cluster
+	// which would try to stop it
+	node4, err := New(&Config{
+		Name:                "node4",
+		Dir:                 filepath.Join("testdata", "node4"),
+		ClientAddr:          ":2679",
+		PeerAddr:            ":2680",
+		GossipAddr:          ":7983",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 5,
+		BootstrapTimeout:    10 * time.Second,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node4.gossip.Shutdown(); err != nil {
+			t.Log(err)
+		}
+	}()
+	if err := node4.Run(); err == nil {
+		t.Fatal("expected node4 with incorrect cluster size to fail to join")
+	}
+
+	// the node is rejected before it is added, rather than after being
+	// promoted to a voting member
+	node1 := c.lookupNode("node1")
+	if n := len(node1.etcd.Server.Cluster().Members()); n != 3 {
+		t.Fatalf("expected 3 members, received %d", n)
+	}
+}
+
+func TestManagerLeave(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  5 * time.Second,
+	})
+	c.addNode("node2", &Config{
+		ClientAddr:          ":2479",
+		PeerAddr:            ":2480",
+		GossipAddr:          ":7981",
+		BootstrapAddrs:      []string{":7980"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  5 * time.Second,
+	})
+	c.addNode("node3", &Config{
+		ClientAddr:          ":2579",
+		PeerAddr:            ":2580",
+		GossipAddr:          ":7982",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  5 * time.Second,
+	})
+
+	c.startAll()
+	c.wait("node1", "node2", "node3")
+	fmt.Println("ready")
+
+	leave := func(name string) (*e2dpb.LeaveResponse, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		mc, conn, err := newManagerClient(ctx, c.lookupNode(name).cfg.ClientURL.String(), client.SecurityConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return mc.Leave(ctx, &types.Empty{})
+	}
+
+	// leadership can only be handed off to members seen running over gossip
+	for _, node := range c.nodes {
+		for len(node.gossip.runningMembers()) != 3 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	// the member must already be removed when the request returns, etcd
+	// rejects removing members until peers have been connected for a while
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		_, err := leave("node3")
+		if err == nil {
+			break
+		}
+		if !strings.Contains(err.Error(), "unhealthy cluster") || time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(1 * time.Second)
+	}
+	for _, member := range c.lookupNode("node1").etcd.Server.Cluster().Members() {
+		if member.Name == "node3" {
+			t.Fatal("expected node3 to be removed before leave returned")
+		}
+	}
+
+	// without quorum the removal fails, which must be returned to the caller
+	c.stop("node1")
+	if _, err := leave("node2"); err == nil {
+		t.Fatal("expected leave to fail without quorum")
+	}
+	node2 := c.lookupNode("node2")
+	if node2.isLeaving() {
+		t.Fatal("expected node2 to no longer be leaving after failing to leave")
+	}
+	if !node2.etcd.isRunning() {
+		t.Fatal("expected node2 to still be running after failing to leave")
+	}
+}
+
+func TestManagerMoveLeaderForwardedToLeader(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  5 * time.Second,
+	})
+	c.addNode("node2", &Config{
+		ClientAddr:          ":2479",
+		PeerAddr:            ":2480",
+		GossipAddr:          ":7981",
+		BootstrapAddrs:      []string{":7980"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  5 * time.Second,
+	})
+	c.addNode("node3", &Config{
+		ClientAddr:          ":2579",
+		PeerAddr:            ":2580",
+		GossipAddr:          ":7982",
+		BootstrapAddrs:      []string{":7981"},
+		RequiredClusterSize: 3,
+		HealthCheckInterval: 1 * time.Second,
+		HealthCheckTimeout:  5 * time.Second,
+	})
+
+	c.startAll()
+	c.wait("node1", "node2", "node3")
+	fmt.Println("ready")
+
+	// the transferee is selected from members seen running over gossip
+	for _, node := range c.nodes {
+		for len(node.gossip.runningMembers()) != 3 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	var followers []*Manager
+	for _, node := range c.nodes {
+		if !node.etcd.isLeader() {
+			followers = append(followers, node)
+		}
+	}
+	if len(followers) != 2 {
+		t.Fatalf("expected 2 followers, received %d", len(followers))
+	}
+	previous := c.leader()
+
+	// ask one follower to move leadership to the other follower
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mc, conn, err := newManagerClient(ctx, followers[0].cfg.ClientURL.String(), client.SecurityConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	resp, err := mc.MoveLeader(ctx, &e2dpb.MoveLeaderRequest{Name: followers[1].cfg.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.PreviousLeader != previous.cfg.Name || resp.Leader != followers[1].cfg.Name {
+		t.Fatalf("expected leadership moved from %s to %s, received %s to %s", previous.cfg.Name, followers[1].cfg.Name, resp.PreviousLeader, resp.Leader)
+	}
+	if !followers[1].etcd.isLeader() {
+		t.Fatalf("expected %s to be the leader", followers[1].cfg.Name)
+	}
+
+	// without a name, the leader chooses the transferee
+	resp, err = followers[0].MoveLeader(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.PreviousLeader != followers[1].cfg.Name || resp.Leader == followers[1].cfg.Name {
+		t.Fatalf("expected leadership moved from %s, received %s to %s", followers[1].cfg.Name, resp.PreviousLeader, resp.Leader)
+	}
+}
+
+func TestManagerSnapshotRPC(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		RequiredClusterSize: 1,
+		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
+	})
+	c.startAll()
+	c.wait("node1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mc, conn, err := newManagerClient(ctx, c.lookupNode("node1").cfg.ClientURL.String(), client.SecurityConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	resp, err := mc.Snapshot(ctx, &types.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the response describes the stored snapshot
+	snapshots, err := newFileSnapshotter("testdata/snapshots").List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name != resp.Name {
+		t.Fatalf("expected snapshot %s to be listed, received %d snapshots", resp.Name, len(snapshots))
+	}
+	if resp.Leader != "node1" || resp.Revision == 0 || resp.StoredSize != snapshots[0].Size || resp.Sha256 == "" {
+		t.Fatalf("expected response to match snapshot %+v, received %+v", snapshots[0], resp)
+	}
+}
+
+func TestManagerSnapshotSkippedWhenIdle(t *testing.T) {
+	if !*testLong {
+		t.Skip()
+	}
+	if err := os.RemoveAll("testdata"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("testdata/snapshots", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCluster(t)
+	defer c.cleanup()
+
+	c.addNode("node1", &Config{
+		ClientAddr:          ":2379",
+		PeerAddr:            ":2380",
+		GossipAddr:          ":7980",
+		RequiredClusterSize: 1,
+		SnapshotInterval:    1 * time.Second,
+		Snapshotter:         newFileSnapshotter("testdata/snapshots"),
+	})
+	c.startAll()
+	c.wait("node1")
+
+	// the latest symlink points to a new file for every snapshot saved
+	latest := func() string {
+		name, _ := os.Readlink("testdata/snapshots/etcd.snapshot.LATEST")
+		return name
+	}
+	var first string
+	for i := 0; i < 30 && first == ""; i++ {
+		time.Sleep(100 * time.Millisecond)
+		first = latest()
+	}
+	if first == "" {
+		t.Fatal("expected snapshot to be saved")
+	}
+
+	// the following ticks find the cluster unchanged, since saving a snapshot
+	// does not write to etcd
+	time.Sleep(2500 * time.Millisecond)
+	if name := latest(); name != first {
+		t.Fatalf("expected no snapshot of the idle cluster, received %s", name)
+	}
+
+	// changes are still snapshotted on the next tick
+	cl := newTestClient(":2379")
+	defer cl.Close()
+	if err := cl.Set("testkey1", "testvalue1"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if latest() != first {
+			return
+		}
+	}
+	t.Fatal("expected snapshot of the changed cluster")
+}
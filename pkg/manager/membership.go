@@ -2,6 +2,7 @@ package manager
 
 import (
 	"context"
+	"sort"
 	"sync"
 	"time"
 
@@ -63,6 +64,18 @@ func (c *clusterMembership) removeSuspect(name string) {
 	c.mu.Unlock()
 }
 
+func (c *clusterMembership) suspectList() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.suspects))
+	for name := range c.suspects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (c *clusterMembership) removeMember(name string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -77,6 +90,12 @@ func (c *clusterMembership) removeMember(name string) error {
 	return nil
 }
 
+func (c *clusterMembership) quorum() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hasQuorum
+}
+
 func (c *clusterMembership) ensureQuorum(q bool) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
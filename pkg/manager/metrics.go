@@ -0,0 +1,197 @@
+package manager
+
+import (
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/criticalstack/e2d/pkg/log"
+)
+
+const metricsNamespace = "e2d"
+
+// metrics contains the e2d-level metrics for a Manager. A separate registry
+// is used for each Manager, allowing more than one Manager to exist in the
+// same process (e.g. during testing), while the metrics of the embedded etcd
+// are registered with the default registry.
+type metrics struct {
+	registry *prometheus.Registry
+
+	memberRemovals    prometheus.Counter
+	quorumLost        prometheus.Counter
+	snapshotDuration  prometheus.Histogram
+	snapshotSize      prometheus.Gauge
+	snapshotLastSaved prometheus.Gauge
+	snapshotFailures  prometheus.Counter
+	snapshotRestores  *prometheus.CounterVec
+	segmentRevision   prometheus.Gauge
+	segmentFailures   prometheus.Counter
+	eventsDropped     prometheus.Counter
+}
+
+func newMetrics(m *Manager) *metrics {
+	mm := &metrics{
+		registry: prometheus.NewRegistry(),
+		memberRemovals: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "membership",
+			Name:      "removals_total",
+			Help:      "Total number of members removed from the etcd cluster after failing health checks.",
+		}),
+		quorumLost: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "membership",
+			Name:      "quorum_lost_total",
+			Help:      "Total number of times this member lost sight of a majority of running members.",
+		}),
+		snapshotDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "snapshot",
+			Name:      "save_duration_seconds",
+			Help:      "Time taken to create and save a snapshot backup.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+		}),
+		snapshotSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "snapshot",
+			Name:      "last_size_bytes",
+			Help:      "Size of the last saved snapshot backup, after compression and encryption.",
+		}),
+		snapshotLastSaved: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "snapshot",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successfully saved snapshot backup.",
+		}),
+		snapshotFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "snapshot",
+			Name:      "save_failures_total",
+			Help:      "Total number of snapshot backups that failed to save.",
+		}),
+		snapshotRestores: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "snapshot",
+			Name:      "restores_total",
+			Help:      "Total number of attempts to restore from a snapshot backup by result.",
+		}, []string{"result"}),
+		segmentRevision: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "snapshot",
+			Name:      "segment_last_revision",
+			Help:      "The last etcd revision saved in an incremental segment.",
+		}),
+		segmentFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "snapshot",
+			Name:      "segment_failures_total",
+			Help:      "Total number of incremental segments that failed to save.",
+		}),
+		eventsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "events",
+			Name:      "dropped_total",
+			Help:      "Total number of events dropped by subscribers that were not keeping up, such as the webhook notifier.",
+		}),
+	}
+	mm.registry.MustRegister(
+		mm.memberRemovals,
+		mm.quorumLost,
+		mm.snapshotDuration,
+		mm.snapshotSize,
+		mm.snapshotLastSaved,
+		mm.snapshotFailures,
+		mm.snapshotRestores,
+		mm.segmentRevision,
+		mm.segmentFailures,
+		mm.eventsDropped,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "membership",
+			Name:      "suspects",
+			Help:      "Number of members currently suspected of having failed.",
+		}, func() float64 {
+			return float64(len(m.cluster.suspectList()))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "required_cluster_size",
+			Help:      "The required number of members in the cluster.",
+		}, func() float64 {
+			return float64(m.etcd.requiredClusterSize())
+		}),
+		&gossipCollector{
+			g: m.gossip,
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(metricsNamespace, "gossip", "members"),
+				"Number of members in the gossip network by status.",
+				[]string{"status"}, nil,
+			),
+		},
+	)
+	return mm
+}
+
+// gossipCollector collects the number of gossip members for each NodeStatus
+// at the time of the scrape.
+type gossipCollector struct {
+	g    *gossip
+	desc *prometheus.Desc
+}
+
+func (c *gossipCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *gossipCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[NodeStatus]int{
+		Unknown: 0,
+		Pending: 0,
+		Running: 0,
+		Leaving: 0,
+	}
+	for _, member := range c.g.Members() {
+		counts[member.Status]++
+	}
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), status.String())
+	}
+}
+
+// serveMetrics starts an HTTP server exposing the e2d metrics, along with the
+// metrics of the embedded etcd, on the /metrics path of the provided address.
+func (m *Manager) serveMetrics(addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot listen for metrics on %s", addr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.Gatherers{
+		prometheus.DefaultGatherer,
+		m.metrics.registry,
+	}, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Errorf("metrics server failed: %v", err)
+		}
+	}()
+	log.Infof("serving metrics on %s", l.Addr())
+	return srv, nil
+}
+
+// countingReadCloser counts the bytes read from the underlying ReadCloser.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
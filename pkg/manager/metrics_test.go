@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	m, err := New(&Config{
+		ClientAddr: "127.0.0.1:2379",
+		PeerAddr:   "127.0.0.1:2380",
+		GossipAddr: "127.0.0.1:7980",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.cancel()
+
+	m.metrics.memberRemovals.Inc()
+	mfs, err := m.metrics.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, metric := range mf.GetMetric() {
+			names[mf.GetName()] += metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+		}
+	}
+	expected := map[string]float64{
+		"e2d_membership_removals_total":               1,
+		"e2d_membership_quorum_lost_total":            0,
+		"e2d_membership_suspects":                     0,
+		"e2d_required_cluster_size":                   1,
+		"e2d_gossip_members":                          0,
+		"e2d_snapshot_save_failures_total":            0,
+		"e2d_snapshot_last_size_bytes":                0,
+		"e2d_snapshot_last_success_timestamp_seconds": 0,
+		"e2d_snapshot_segment_last_revision":          0,
+		"e2d_snapshot_segment_failures_total":         0,
+		"e2d_events_dropped_total":                    0,
+	}
+	for name, v := range expected {
+		got, ok := names[name]
+		if !ok {
+			t.Errorf("metric %s not found", name)
+			continue
+		}
+		if got != v {
+			t.Errorf("metric %s: expected %v, received %v", name, v, got)
+		}
+	}
+}
+
+func TestCountingReadCloser(t *testing.T) {
+	r := &countingReadCloser{ReadCloser: ioutil.NopCloser(strings.NewReader("snapshot data"))}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if r.n != int64(len("snapshot data")) {
+		t.Fatalf("expected %d bytes, received %d", len("snapshot data"), r.n)
+	}
+}
@@ -0,0 +1,292 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/mvcc"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/criticalstack/e2d/pkg/snapshot"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
+)
+
+// maxSegmentSize is the amount of buffered changes at which a segment is
+// shipped without waiting for the next SnapshotSegmentInterval, so a busy
+// cluster does not buffer an unbounded amount of changes in memory.
+const maxSegmentSize = 64 << 20
+
+// runSegmentShipper ships the changes made to etcd after the last snapshot
+// backup as incremental segments every SnapshotSegmentInterval. This allows a
+// restore to be brought forward to within SnapshotSegmentInterval of a
+// failure, rather than SnapshotInterval, without shipping the entire database
+// each time. Like snapshots, segments are only shipped by the leader.
+func (m *Manager) runSegmentShipper() {
+	if m.snapshotter == nil || m.cfg.SnapshotSegmentInterval == 0 {
+		return
+	}
+
+	log.Debug("starting segment shipper")
+	ticker := time.NewTicker(m.cfg.SnapshotSegmentInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if m.etcd.isRestarting() || !m.etcd.isRunning() || !m.etcd.isLeader() {
+				continue
+			}
+			if err := m.shipSegments(); err != nil {
+				if m.ctx.Err() != nil {
+					return
+				}
+				m.metrics.segmentFailures.Inc()
+				log.Error("cannot ship segments",
+					zap.String("name", shortName(m.cfg.Name)),
+					zap.Error(err),
+				)
+			}
+		case <-m.ctx.Done():
+			log.Debug("stopping segment shipper")
+			return
+		}
+	}
+}
+
+// latestSnapshot returns the name and revision of the last snapshot backup
+// taken by the cluster, which may have been taken by another member when
+// leadership has changed since. The revision of a snapshot taken by another
+// member is read from its manifest, so snapshots saved by older versions of
+// e2d without one, or taken of another cluster, are ignored.
+func (m *Manager) latestSnapshot() (string, int64, error) {
+	m.snapshotMu.Lock()
+	name, rev := m.latestSnapshotName, m.latestSnapshotRev
+	m.snapshotMu.Unlock()
+
+	latest, err := m.snapshotter.Latest()
+	if err != nil {
+		if errors.Cause(err) == snapshot.ErrSnapshotNotFound {
+			return name, rev, nil
+		}
+		return "", 0, err
+	}
+	if latest == name {
+		return name, rev, nil
+	}
+	manifest, err := m.snapshotter.LoadManifest(latest)
+	if err != nil {
+		return "", 0, err
+	}
+	if manifest != nil && manifest.ClusterID == m.etcd.Server.Cluster().ID().String() && manifest.Revision > rev {
+		name, rev = latest, manifest.Revision
+	}
+	return name, rev, nil
+}
+
+// segmentStart returns the snapshot the next segment follows on from, which
+// is the last snapshot backup, and the revision it starts after, which is the
+// end of the last segment stored for that snapshot or the revision of the
+// snapshot, whichever is later. Segments stored for any other snapshot or
+// cluster, such as those left behind by a restore to an older snapshot, are
+// ignored. Segments can only be replayed on top of a snapshot, so it returns
+// an empty name if there has not been one.
+func (m *Manager) segmentStart() (string, int64, error) {
+	base, rev, err := m.latestSnapshot()
+	if err != nil || base == "" {
+		return "", 0, err
+	}
+	segments, err := m.snapshotter.ListSegments()
+	if err != nil {
+		return "", 0, err
+	}
+	clusterID := m.etcd.Server.Cluster().ID().String()
+	for _, seg := range segments {
+		if seg.Base != base || seg.ClusterID != clusterID {
+			continue
+		}
+		if seg.EndRevision > rev {
+			rev = seg.EndRevision
+		}
+	}
+	return base, rev, nil
+}
+
+// shipSegments watches every change made after the start revision and ships
+// them as segments, until this member is no longer the leader or a new
+// snapshot is taken, after which the next segments follow on from it. Each
+// response from the watch contains whole revisions, so a revision is never
+// split across segments. If shipping fails, the changes that were not
+// shipped are watched again on the next attempt, since the start revision is
+// always taken from what has been stored.
+func (m *Manager) shipSegments() error {
+	base, start, err := m.segmentStart()
+	if err != nil {
+		return err
+	}
+	if base == "" {
+		log.Debug("no snapshot backup to ship segments after, waiting for the next snapshot")
+		return nil
+	}
+
+	ws := m.etcd.Server.Watchable().NewWatchStream()
+	defer ws.Close()
+
+	// an empty range end watches every key starting from the key
+	if _, err := ws.Watch(mvcc.AutoWatchID, []byte{0}, []byte{}, start+1); err != nil {
+		return errors.Wrap(err, "cannot watch for changes")
+	}
+	log.Info("shipping segments",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.String("snapshot", base),
+		zap.Int64("revision", start),
+	)
+
+	ticker := time.NewTicker(m.cfg.SnapshotSegmentInterval)
+	defer ticker.Stop()
+
+	events := make([]mvccpb.Event, 0)
+	size := 0
+	flush := func(ctx context.Context) error {
+		if len(events) == 0 {
+			return nil
+		}
+		end := events[len(events)-1].Kv.ModRevision
+		if err := m.saveSegment(ctx, base, start, end, events); err != nil {
+			return err
+		}
+		start = end
+		events = events[:0]
+		size = 0
+		return nil
+	}
+	for {
+		select {
+		case resp, ok := <-ws.Chan():
+			if !ok {
+				return errors.New("watch closed")
+			}
+			if resp.CompactRevision != 0 {
+				// the changes needed are no longer available, so a new
+				// snapshot is taken for the next segment to start after
+				log.Warn("changes since the last segment have been compacted, taking snapshot",
+					zap.String("name", shortName(m.cfg.Name)),
+					zap.Int64("revision", start),
+					zap.Int64("compact-revision", resp.CompactRevision),
+				)
+				_, err := m.saveSnapshot(m.ctx, true)
+				return err
+			}
+			for _, ev := range resp.Events {
+				events = append(events, ev)
+				size += ev.Size()
+			}
+			if size >= maxSegmentSize {
+				if err := flush(m.ctx); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if err := flush(m.ctx); err != nil {
+				return err
+			}
+			if m.etcd.isRestarting() || !m.etcd.isRunning() || !m.etcd.isLeader() {
+				log.Info("stopping shipping segments", zap.String("name", shortName(m.cfg.Name)))
+				return nil
+			}
+			m.snapshotMu.Lock()
+			latest := m.latestSnapshotName
+			m.snapshotMu.Unlock()
+			if latest != "" && latest != base {
+				log.Debug("snapshot taken, following on from it", zap.String("snapshot", latest))
+				return nil
+			}
+		case <-m.ctx.Done():
+			// ship what has been buffered so far before stopping
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return flush(ctx)
+		}
+	}
+}
+
+// saveSegment encodes the provided events as a segment, compressing and
+// encrypting it the same as snapshots, and saves it to the snapshot backup.
+func (m *Manager) saveSegment(ctx context.Context, base string, start, end int64, events []mvccpb.Event) error {
+	data, err := snapshot.EncodeSegment(events)
+	if err != nil {
+		return err
+	}
+	var r io.ReadCloser = ioutil.NopCloser(bytes.NewReader(data))
+	if m.cfg.SnapshotEncryption {
+		r = snapshotutil.NewEncrypterReadCloser(r, m.cfg.snapshotKeys)
+	}
+	if m.cfg.snapshotCodec != nil {
+		r = snapshotutil.NewCompressorReadCloser(r, m.cfg.snapshotCodec)
+	}
+	defer r.Close()
+
+	seg := &snapshot.Segment{
+		ClusterID:     m.etcd.Server.Cluster().ID().String(),
+		Base:          base,
+		StartRevision: start,
+		EndRevision:   end,
+		Size:          int64(len(data)),
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.snapshotTimeout(seg.Size))
+	defer cancel()
+	if err := m.snapshotter.SaveSegment(ctx, r, seg); err != nil {
+		return err
+	}
+	m.metrics.segmentRevision.Set(float64(end))
+	log.Debug("wrote segment to backup",
+		zap.String("segment", seg.Name),
+		zap.Int("events", len(events)),
+		zap.Int64("size", seg.Size),
+	)
+	return nil
+}
+
+// replaySegments brings the database restored from a snapshot forward with
+// the segments stored since, up to SnapshotRestoreRevision when set, and
+// reports whether any were replayed. If a revision is missing from the stored
+// segments, the database is restored up to the revision before it, since
+// restoring what is available is preferable to not restoring at all.
+func (m *Manager) replaySegments(path, base string, status *snapshot.DatabaseStatus) (*snapshot.DatabaseStatus, bool, error) {
+	rs, err := snapshot.ReplaySegments(m.snapshotter, m.cfg.snapshotKeys, path, base, status, m.cfg.SnapshotRestoreRevision)
+	if err != nil {
+		if errors.Cause(err) != snapshot.ErrSegmentGap {
+			return nil, false, err
+		}
+		log.Warn("cannot replay every segment",
+			zap.String("name", shortName(m.cfg.Name)),
+			zap.Int64("revision", rs.Revision),
+			zap.Error(err),
+		)
+	} else if rs.Segments == 0 {
+		return status, false, nil
+	}
+	if target := m.cfg.SnapshotRestoreRevision; target != 0 && rs.Revision < target {
+		log.Warn("snapshot restore revision not reached",
+			zap.String("name", shortName(m.cfg.Name)),
+			zap.Int64("revision", rs.Revision),
+			zap.Int64("restore-revision", target),
+		)
+	}
+	log.Info("replayed segments",
+		zap.String("name", shortName(m.cfg.Name)),
+		zap.Int("segments", rs.Segments),
+		zap.Int64("snapshot-revision", rs.StartRevision),
+		zap.Int64("revision", rs.Revision),
+	)
+	status, err = snapshot.VerifyDatabase(path)
+	if err != nil {
+		return nil, false, err
+	}
+	return status, rs.Revision > rs.StartRevision, nil
+}
@@ -3,6 +3,7 @@ package manager
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -76,13 +77,26 @@ type server struct {
 	started uint64
 	// set when server is being restarted
 	restarting uint64
+	// the current required cluster size, which is initially set from the
+	// server configuration but can change when the cluster is resized
+	clusterSize int64
 
 	// mu is used to coordinate potentially unsafe access to etcd
 	mu sync.Mutex
 }
 
 func newServer(cfg *serverConfig) *server {
-	return &server{cfg: cfg}
+	s := &server{cfg: cfg}
+	s.setRequiredClusterSize(cfg.RequiredClusterSize)
+	return s
+}
+
+func (s *server) requiredClusterSize() int {
+	return int(atomic.LoadInt64(&s.clusterSize))
+}
+
+func (s *server) setRequiredClusterSize(n int) {
+	atomic.StoreInt64(&s.clusterSize, int64(n))
 }
 
 func (s *server) isRestarting() bool {
@@ -188,7 +202,7 @@ func validatePeers(peers []*Peer, requiredClusterSize int) error {
 }
 
 func (s *server) startEtcd(ctx context.Context, state string, peers []*Peer) error {
-	if err := validatePeers(peers, s.cfg.RequiredClusterSize); err != nil {
+	if err := validatePeers(peers, s.requiredClusterSize()); err != nil {
 		return err
 	}
 
@@ -246,7 +260,7 @@ func (s *server) startEtcd(ctx context.Context, state string, peers []*Peer) err
 		zap.String("dir", s.cfg.Dir),
 		zap.String("cluster-state", cfg.ClusterState),
 		zap.String("initial-cluster", cfg.InitialCluster),
-		zap.Int("required-cluster-size", s.cfg.RequiredClusterSize),
+		zap.Int("required-cluster-size", s.requiredClusterSize()),
 		zap.Bool("debug", s.cfg.Debug),
 	)
 	var err error
@@ -256,10 +270,16 @@ func (s *server) startEtcd(ctx context.Context, state string, peers []*Peer) err
 	}
 	select {
 	case <-s.Server.ReadyNotify():
-		if err := s.writeClusterInfo(ctx); err != nil {
-			return errors.Wrap(err, "cannot write cluster-info")
+		// Learners cannot serve linearizable requests, so writing the
+		// cluster-info is deferred until the member has been promoted.
+		if s.Server.IsLearner() {
+			log.Info("Server started as learner, skipping cluster-info")
+		} else {
+			if err := s.writeClusterInfo(ctx); err != nil {
+				return errors.Wrap(err, "cannot write cluster-info")
+			}
+			log.Debug("write cluster-info successful!")
 		}
-		log.Debug("write cluster-info successful!")
 		atomic.StoreUint64(&s.started, 1)
 		log.Info("Server is ready!")
 
@@ -285,12 +305,17 @@ func (s *server) joinExisting(ctx context.Context, peers []*Peer) error {
 	return s.startEtcd(ctx, embed.ClusterStateFlagExisting, peers)
 }
 
+// newSnapshotReadCloser streams the database snapshot followed by its sha256
+// hash, the same as the etcd maintenance API, so that it can be verified when
+// restored.
 func newSnapshotReadCloser(snapshot backend.Snapshot) io.ReadCloser {
 	pr, pw := io.Pipe()
 	go func() {
-		n, err := snapshot.WriteTo(pw)
+		h := sha256.New()
+		n, err := snapshot.WriteTo(io.MultiWriter(pw, h))
 		if err == nil {
 			log.Infof("wrote database snapshot out [total bytes: %d]", n)
+			_, err = pw.Write(h.Sum(nil))
 		}
 		_ = pw.CloseWithError(err)
 		snapshot.Close()
@@ -302,17 +327,17 @@ func (s *server) createSnapshot(minRevision int64) (io.ReadCloser, int64, int64,
 	// Get the current revision and compare with the minimum requested revision.
 	revision := s.Etcd.Server.KV().Rev()
 	if revision <= minRevision {
-		return nil, 0, revision, errors.Errorf("member revision too old, wanted %d, received: %d", minRevision, revision)
+		return nil, 0, revision, errors.Wrapf(errSnapshotUnchanged, "member revision too old, wanted %d, received: %d", minRevision, revision)
 	}
 	sp := s.Etcd.Server.Backend().Snapshot()
 	if sp == nil {
 		return nil, 0, revision, errors.New("no snappy")
 	}
-	return newSnapshotReadCloser(sp), sp.Size(), revision, nil
+	return newSnapshotReadCloser(sp), sp.Size() + sha256.Size, revision, nil
 }
 
-func (s *server) restoreSnapshot(snapshotFilename string, peers []*Peer) error {
-	if err := validatePeers(peers, s.cfg.RequiredClusterSize); err != nil {
+func (s *server) restoreSnapshot(snapshotFilename string, skipHashCheck bool, peers []*Peer) error {
+	if err := validatePeers(peers, s.requiredClusterSize()); err != nil {
 		return err
 	}
 	snapshotMgr := snapshot.NewV3(nil)
@@ -337,7 +362,7 @@ func (s *server) restoreSnapshot(snapshotFilename string, peers []*Peer) error {
 		InitialCluster: initialClusterStringFromPeers(peers),
 
 		InitialClusterToken: embed.NewConfig().InitialClusterToken,
-		SkipHashCheck:       true,
+		SkipHashCheck:       skipHashCheck,
 	})
 }
 
@@ -383,6 +408,11 @@ type Cluster struct {
 	ID                  int `e2db:"id"`
 	Created             time.Time
 	RequiredClusterSize int
+
+	// Resized is set when the RequiredClusterSize was changed by resizing
+	// the cluster, after which it takes precedence over the size members are
+	// configured with.
+	Resized time.Time
 }
 
 // writeClusterInfo attempts to write basic cluster info whenever a server
@@ -391,17 +421,7 @@ type Cluster struct {
 // snapshot. This is because the cluster requirements could change for the
 // restored cluster (e.g. going from RequiredClusterSize 1 -> 3).
 func (s *server) writeClusterInfo(ctx context.Context) error {
-	// NOTE(chrism): As the naming can be confusing it is worth pointing out
-	// that the ClientSecurity field is specifying the server certs and NOT the
-	// client certs. Since the server certs do not have client auth key usage,
-	// we need to use the peer certs here (they have client auth key usage).
-	db, err := e2db.New(ctx, &e2db.Config{
-		ClientAddr: s.cfg.ClientURL.String(),
-		CAFile:     s.cfg.PeerSecurity.TrustedCAFile,
-		CertFile:   s.cfg.PeerSecurity.CertFile,
-		KeyFile:    s.cfg.PeerSecurity.KeyFile,
-		Namespace:  string(volatilePrefix),
-	})
+	db, err := s.newClusterInfoDB(ctx)
 	if err != nil {
 		return err
 	}
@@ -414,21 +434,100 @@ func (s *server) writeClusterInfo(ctx context.Context) error {
 		}
 
 		if cluster != nil {
-			// check RequiredClusterSize for discrepancies
-			if cluster.RequiredClusterSize != s.cfg.RequiredClusterSize {
-				return errors.Errorf("server %s attempted to join cluster with incorrect RequiredClusterSize, cluster expects %d, this server is configured with %d", s.cfg.Name, cluster.RequiredClusterSize, s.cfg.RequiredClusterSize)
-			}
-			return nil
+			return s.checkClusterInfo(cluster)
 		}
 
 		return tx.Insert(&Cluster{
 			ID:                  1,
 			Created:             time.Now(),
-			RequiredClusterSize: s.cfg.RequiredClusterSize,
+			RequiredClusterSize: s.requiredClusterSize(),
 		})
 	})
 }
 
+// checkClusterInfo checks the RequiredClusterSize of this server against the
+// cluster-info for discrepancies. The size stored in the cluster-info is used
+// instead when the cluster has been resized, since members are not expected
+// to be reconfigured with the new size before they are next restarted.
+func (s *server) checkClusterInfo(cluster *Cluster) error {
+	if cluster.RequiredClusterSize == s.requiredClusterSize() {
+		return nil
+	}
+	if cluster.Resized.IsZero() {
+		return errors.Errorf("server %s attempted to join cluster with incorrect RequiredClusterSize, cluster expects %d, this server is configured with %d", s.cfg.Name, cluster.RequiredClusterSize, s.requiredClusterSize())
+	}
+	log.Warn("cluster was resized, using the required cluster size from cluster-info",
+		zap.String("name", shortName(s.cfg.Name)),
+		zap.Int("configured", s.requiredClusterSize()),
+		zap.Int("required-cluster-size", cluster.RequiredClusterSize),
+		zap.Time("resized", cluster.Resized),
+	)
+	s.setRequiredClusterSize(cluster.RequiredClusterSize)
+	return nil
+}
+
+// readClusterInfo returns the cluster-info stored by the etcd member with the
+// provided client url, or nil if there is none.
+func (s *server) readClusterInfo(ctx context.Context, clientURL string) (*Cluster, error) {
+	db, err := s.newClusterInfoDBAt(ctx, clientURL)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var cluster *Cluster
+	if err := db.Table(new(Cluster)).Find("ID", 1, &cluster); err != nil {
+		if errors.Cause(err) == e2db.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// updateClusterInfo changes the RequiredClusterSize stored in the cluster-info
+// record. The update is only performed if the stored value still matches the
+// expected previous value, which prevents concurrent resize attempts from
+// clobbering each other.
+func (s *server) updateClusterInfo(ctx context.Context, from, to int) error {
+	db, err := s.newClusterInfoDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Table(new(Cluster)).Tx(func(tx *e2db.Tx) error {
+		var cluster *Cluster
+		if err := tx.Find("ID", 1, &cluster); err != nil {
+			return err
+		}
+		if cluster.RequiredClusterSize != from {
+			return errors.Errorf("cluster-info RequiredClusterSize changed, expected %d, received %d", from, cluster.RequiredClusterSize)
+		}
+		cluster.RequiredClusterSize = to
+		cluster.Resized = time.Now()
+		return tx.Update(cluster)
+	})
+}
+
+func (s *server) newClusterInfoDB(ctx context.Context) (*e2db.DB, error) {
+	return s.newClusterInfoDBAt(ctx, s.cfg.ClientURL.String())
+}
+
+func (s *server) newClusterInfoDBAt(ctx context.Context, clientURL string) (*e2db.DB, error) {
+	// NOTE(chrism): As the naming can be confusing it is worth pointing out
+	// that the ClientSecurity field is specifying the server certs and NOT the
+	// client certs. Since the server certs do not have client auth key usage,
+	// we need to use the peer certs here (they have client auth key usage).
+	return e2db.New(ctx, &e2db.Config{
+		ClientAddr: clientURL,
+		CAFile:     s.cfg.PeerSecurity.TrustedCAFile,
+		CertFile:   s.cfg.PeerSecurity.CertFile,
+		KeyFile:    s.cfg.PeerSecurity.KeyFile,
+		Namespace:  string(volatilePrefix),
+	})
+}
+
 var (
 	// volatilePrefix is the key prefix used for keys that will NOT be
 	// preserved after a cluster is recovered from snapshot
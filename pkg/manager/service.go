@@ -2,12 +2,14 @@ package manager
 
 import (
 	"context"
+	"sort"
+	"time"
 
 	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
 	"github.com/criticalstack/e2d/pkg/client"
-	"github.com/criticalstack/e2d/pkg/e2db"
 	"github.com/criticalstack/e2d/pkg/log"
 	"github.com/criticalstack/e2d/pkg/manager/e2dpb"
 )
@@ -16,42 +18,67 @@ type ManagerService struct {
 	m *Manager
 }
 
-func (s *ManagerService) Health(ctx context.Context, _ *types.Empty) (*e2dpb.HealthResponse, error) {
-	resp := &e2dpb.HealthResponse{
-		Status: "not great, bob",
+func (s *ManagerService) Status(ctx context.Context, _ *types.Empty) (*e2dpb.StatusResponse, error) {
+	if !s.m.etcd.isRunning() {
+		return nil, errors.New("etcd is not running")
 	}
-	db, err := e2db.New(ctx, &e2db.Config{
-		ClientAddr: s.m.cfg.ClientURL.String(),
-		CAFile:     s.m.cfg.PeerSecurity.TrustedCAFile,
-		CertFile:   s.m.cfg.PeerSecurity.CertFile,
-		KeyFile:    s.m.cfg.PeerSecurity.KeyFile,
-		Namespace:  string(volatilePrefix),
-	})
-	if err != nil {
-		return resp, err
+	resp := &e2dpb.StatusResponse{
+		Name:                s.m.cfg.Name,
+		ClusterID:           uint64(s.m.etcd.Server.Cluster().ID()),
+		RequiredClusterSize: int32(s.m.etcd.requiredClusterSize()),
+		Suspects:            s.m.cluster.suspectList(),
 	}
-	defer db.Close()
-
-	var cluster *Cluster
-	if err := db.Table(new(Cluster)).Find("ID", 1, &cluster); err != nil {
-		return resp, err
+	if s.m.cfg.RequiredClusterSize == 1 {
+		resp.HasQuorum = true
+	} else {
+		resp.HasQuorum = len(s.m.gossip.runningMembers()) > s.m.etcd.requiredClusterSize()/2
 	}
-	c, err := client.New(&client.Config{
+
+	c, err := newClient(&client.Config{
 		ClientURLs:     []string{s.m.cfg.ClientURL.String()},
 		SecurityConfig: s.m.cfg.PeerSecurity,
+		Timeout:        1 * time.Second,
 	})
 	if err != nil {
-		return resp, err
-	}
-	if err := c.IsHealthy(ctx); err != nil {
-		return resp, err
+		return nil, err
 	}
-	cresp, err := c.MemberList(ctx)
-	if err != nil {
-		return resp, err
-	}
-	if len(cresp.Members) >= cluster.RequiredClusterSize {
-		resp.Status = "It cool"
+	defer c.Close()
+
+	members := s.m.etcd.Server.Cluster().Members()
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+	for _, member := range members {
+		ms := &e2dpb.MemberStatus{
+			Id:           uint64(member.ID),
+			Name:         member.Name,
+			PeerURLs:     member.PeerURLs,
+			ClientURLs:   member.ClientURLs,
+			GossipStatus: s.m.gossip.status(member.Name).String(),
+			IsLearner:    member.IsLearner,
+		}
+		if last := s.m.gossip.lastSnapshot(member.Name); last != nil {
+			ms.LastSnapshotRevision = last.Revision
+			ms.LastSnapshotTime = &last.Created
+		}
+		resp.Members = append(resp.Members, ms)
+		if len(member.ClientURLs) == 0 {
+			ms.Error = "member has not started"
+			continue
+		}
+		sctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+		sresp, err := c.Status(sctx, member.ClientURLs[0])
+		cancel()
+		if err != nil {
+			ms.Error = err.Error()
+			continue
+		}
+		ms.IsLeader = sresp.Leader == uint64(member.ID)
+		ms.RaftTerm = sresp.RaftTerm
+		ms.RaftIndex = sresp.RaftIndex
+		ms.RaftAppliedIndex = sresp.RaftAppliedIndex
+		ms.DbSize = sresp.DbSize
+		ms.DbSizeInUse = sresp.DbSizeInUse
 	}
 	return resp, nil
 }
@@ -64,6 +91,9 @@ func (s *ManagerService) Restart(ctx context.Context, _ *types.Empty) (*e2dpb.Re
 		resp.Msg = "a restart is already in progress"
 		return resp, nil
 	}
+	if err := s.m.snapshotBeforeMaintenance("restart"); err != nil {
+		return nil, err
+	}
 	go func() {
 		if err := s.m.Restart(); err != nil {
 			log.Debug("remote restart failed", zap.Error(err))
@@ -71,3 +101,73 @@ func (s *ManagerService) Restart(ctx context.Context, _ *types.Empty) (*e2dpb.Re
 	}()
 	return resp, nil
 }
+
+func (s *ManagerService) Resize(ctx context.Context, req *e2dpb.ResizeRequest) (*e2dpb.ResizeResponse, error) {
+	resp := &e2dpb.ResizeResponse{
+		PreviousClusterSize: int32(s.m.etcd.requiredClusterSize()),
+	}
+	if err := s.m.snapshotBeforeMaintenance("resize"); err != nil {
+		return nil, err
+	}
+	if err := s.m.Resize(ctx, int(req.ClusterSize)); err != nil {
+		return nil, err
+	}
+	resp.ClusterSize = int32(s.m.etcd.requiredClusterSize())
+	return resp, nil
+}
+
+func (s *ManagerService) Leave(ctx context.Context, _ *types.Empty) (*e2dpb.LeaveResponse, error) {
+	if err := s.m.canLeave(); err != nil {
+		return nil, err
+	}
+	if err := s.m.snapshotBeforeMaintenance("leave"); err != nil {
+		return nil, err
+	}
+
+	if err := s.m.leaveCluster(ctx); err != nil {
+		return nil, err
+	}
+
+	// Shutting down waits for requests in-flight to finish, including this
+	// one, so only the shutdown happens asynchronously.
+	go s.m.shutdownAfterLeave()
+	return &e2dpb.LeaveResponse{
+		Msg: "member removed from cluster, shutting down ...",
+	}, nil
+}
+
+func (s *ManagerService) MoveLeader(ctx context.Context, req *e2dpb.MoveLeaderRequest) (*e2dpb.MoveLeaderResponse, error) {
+	return s.m.MoveLeader(ctx, req.Name)
+}
+
+func (s *ManagerService) Snapshot(ctx context.Context, _ *types.Empty) (*e2dpb.SnapshotResponse, error) {
+	return s.m.Snapshot(ctx)
+}
+
+func (s *ManagerService) WatchEvents(_ *types.Empty, stream e2dpb.Manager_WatchEventsServer) error {
+	events, unsubscribe := s.m.Subscribe(100)
+	defer unsubscribe()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			resp := &e2dpb.Event{
+				Type:     ev.Type.String(),
+				Time:     ev.Time,
+				Name:     ev.Name,
+				Revision: ev.Revision,
+			}
+			if ev.Err != nil {
+				resp.Error = ev.Err.Error()
+			}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
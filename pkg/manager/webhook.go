@@ -0,0 +1,176 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/log"
+)
+
+const (
+	// webhookSignatureHeader contains the hex encoded HMAC-SHA256 of the
+	// request body when a webhook secret is configured.
+	webhookSignatureHeader = "X-E2d-Signature"
+
+	defaultWebhookRetries = 5
+
+	// defaultWebhookTimeout bounds the time spent delivering a single
+	// notification, including retries, so a slow or failing webhook does not
+	// hold up the notifications queued behind it
+	defaultWebhookTimeout = 30 * time.Second
+)
+
+// webhookPayload is the JSON body sent to the webhook for each event.
+type webhookPayload struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// name of the member sending the notification
+	Sender string `json:"sender"`
+
+	// name of the member the event relates to
+	Name     string `json:"name,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type webhookNotifier struct {
+	url    string
+	secret []byte
+	sender string
+	client *http.Client
+
+	// number of attempts to deliver a notification
+	retries int
+
+	// initial time to wait between attempts, doubled after each attempt
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	// total time allowed to deliver a notification, across all attempts
+	timeout time.Duration
+}
+
+func newWebhookNotifier(url, secret, sender string) *webhookNotifier {
+	w := &webhookNotifier{
+		url:        url,
+		sender:     sender,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		retries:    defaultWebhookRetries,
+		backoff:    1 * time.Second,
+		maxBackoff: 30 * time.Second,
+		timeout:    defaultWebhookTimeout,
+	}
+	if secret != "" {
+		w.secret = []byte(secret)
+	}
+	return w
+}
+
+// sign returns the signature of the provided body as the hex encoded
+// HMAC-SHA256 using the shared secret.
+func (w *webhookNotifier) sign(body []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notify delivers the event to the webhook, retrying with exponential backoff
+// when the request fails or the webhook responds with a server error, for up
+// to the notifier timeout.
+func (w *webhookNotifier) notify(ctx context.Context, ev Event) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	p := webhookPayload{
+		Type:     ev.Type.String(),
+		Time:     ev.Time,
+		Sender:   w.sender,
+		Name:     ev.Name,
+		Revision: ev.Revision,
+	}
+	if ev.Err != nil {
+		p.Error = ev.Err.Error()
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.backoff
+	for i := 0; ; i++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || i+1 >= w.retries {
+			return err
+		}
+		log.Debug("webhook notification failed, retrying ...",
+			zap.String("event", p.Type),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "last attempt failed: %v", err)
+		}
+		backoff *= 2
+		if backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+// post sends a single request to the webhook. The returned bool indicates
+// whether the request can be retried.
+func (w *webhookNotifier) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != nil {
+		req.Header.Set(webhookSignatureHeader, w.sign(body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, errors.Errorf("webhook responded with status %d", resp.StatusCode)
+	default:
+		return false, errors.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+}
+
+// run delivers events to the webhook until the events channel is closed.
+func (w *webhookNotifier) run(ctx context.Context, events <-chan Event) {
+	for ev := range events {
+		if err := w.notify(ctx, ev); err != nil {
+			log.Error("cannot send webhook notification",
+				zap.String("event", ev.Type.String()),
+				zap.Error(err),
+			)
+		}
+	}
+}
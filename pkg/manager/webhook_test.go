@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var (
+		attempts int32
+		payload  webhookPayload
+	)
+	w := newWebhookNotifier("", "secret", "node1")
+	w.backoff = 10 * time.Millisecond
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if sig := r.Header.Get(webhookSignatureHeader); sig != w.sign(body) {
+			t.Errorf("invalid signature: %q", sig)
+		}
+
+		// fail the first couple of attempts to exercise retries
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer s.Close()
+	w.url = s.URL
+
+	ev := Event{
+		Type:     SnapshotFailed,
+		Time:     time.Now(),
+		Name:     "node1",
+		Revision: 10,
+		Err:      errors.New("bucket not found"),
+	}
+	if err := w.notify(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatalf("expected 3 attempts, received %d", n)
+	}
+	expected := webhookPayload{
+		Type:     "SnapshotFailed",
+		Sender:   "node1",
+		Name:     "node1",
+		Revision: 10,
+		Error:    "bucket not found",
+	}
+	payload.Time = time.Time{}
+	if payload != expected {
+		t.Fatalf("expected payload %+v, received %+v", expected, payload)
+	}
+}
+
+func TestWebhookNotifyClientError(t *testing.T) {
+	var attempts int32
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		rw.WriteHeader(http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	w := newWebhookNotifier(s.URL, "", "node1")
+	w.backoff = 10 * time.Millisecond
+	if err := w.notify(context.Background(), Event{Type: MemberRemoved}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// client errors are not retried
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("expected 1 attempt, received %d", n)
+	}
+}
+
+func TestWebhookNotifyRetriesExhausted(t *testing.T) {
+	var attempts int32
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(webhookSignatureHeader) != "" {
+			t.Error("expected no signature without a secret")
+		}
+		atomic.AddInt32(&attempts, 1)
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	w := newWebhookNotifier(s.URL, "", "node1")
+	w.backoff = time.Millisecond
+	w.retries = 3
+	if err := w.notify(context.Background(), Event{Type: QuorumLost}); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatalf("expected 3 attempts, received %d", n)
+	}
+}
+
+func TestWebhookNotifyTimeout(t *testing.T) {
+	var attempts int32
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	// the backoff alone would take far longer than the timeout
+	w := newWebhookNotifier(s.URL, "", "node1")
+	w.backoff = 50 * time.Millisecond
+	w.maxBackoff = 1 * time.Second
+	w.retries = 100
+	w.timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	err := w.notify(context.Background(), Event{Type: QuorumLost})
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, received %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 1*time.Second {
+		t.Fatalf("expected notify to give up after the timeout, took %v", elapsed)
+	}
+	if n := atomic.LoadInt32(&attempts); n < 2 || n >= 100 {
+		t.Fatalf("expected a few attempts before the timeout, received %d", n)
+	}
+}
@@ -0,0 +1,235 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/log"
+)
+
+// snapshotBackend is implemented by each Snapshotter with the operations that
+// depend on where snapshots are stored. Loading, verifying, deleting and
+// pruning snapshots is built on top of them, and is the same for every
+// Snapshotter.
+type snapshotBackend interface {
+	// List returns all stored snapshots, ordered from oldest to newest.
+	List() ([]*Snapshot, error)
+
+	// latestName returns the name of the snapshot LATEST points to, or
+	// ErrSnapshotNotFound if there is none.
+	latestName(ctx context.Context) (string, error)
+
+	// readManifest returns the manifest for the snapshot with the provided
+	// name. Snapshots saved by older versions of e2d do not have a manifest,
+	// in which case it returns nil.
+	readManifest(ctx context.Context, name string) (*Manifest, error)
+
+	// openSnapshot returns the stored snapshot with the provided name, or
+	// ErrSnapshotNotFound. The snapshot is streamed within the context.
+	openSnapshot(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// removeSnapshot removes the snapshot with the provided name along with
+	// its manifest, or returns ErrSnapshotNotFound.
+	removeSnapshot(ctx context.Context, name string) error
+}
+
+// replaceBackend is implemented by each Snapshotter with the operations
+// needed to replace a stored snapshot (see replaceSnapshot).
+type replaceBackend interface {
+	snapshotBackend
+
+	// putObject stores the contents of r under the provided name, alongside
+	// the snapshots, replacing anything already stored under it. The object
+	// must not become visible until it has been stored in its entirety. The
+	// sizeHint is used the same as Manifest.DatabaseSize.
+	putObject(ctx context.Context, name string, r io.Reader, sizeHint int64) error
+
+	// moveObject replaces the object dst with the object src, of the
+	// provided size, which is then removed. It returns ErrSnapshotNotFound if
+	// src does not exist.
+	moveObject(ctx context.Context, src, dst string, size int64) error
+}
+
+// replaceSuffix names the new snapshot while Replace moves it into place.
+const replaceSuffix = "replace"
+
+func replacementName(name string) string {
+	return name + "." + replaceSuffix
+}
+
+// replaceSnapshot replaces the snapshot named by the provided manifest, which
+// is updated to describe the new snapshot. Neither the snapshot nor its
+// manifest can be replaced atomically together, so the new snapshot is first
+// stored under a separate name, then the manifest is updated to accept either
+// snapshot (see Manifest.Replacing) before the new snapshot is moved into
+// place, and finally the manifest is updated to accept only the new one. An
+// interrupted replace therefore always leaves a snapshot that verifies, and
+// is finished by the next replace of the same snapshot.
+func replaceSnapshot(ctx context.Context, b replaceBackend, r io.Reader, m *Manifest) error {
+	if _, ok := parseSnapshotName(m.Name); !ok {
+		return errors.Wrapf(ErrSnapshotNotFound, "%#v is not a snapshot name", m.Name)
+	}
+	tmp := replacementName(m.Name)
+	if m.Replacing() {
+		// the new snapshot is only removed once moved into place, so
+		// if it is gone the manifest already matches the stored snapshot
+		if err := b.moveObject(ctx, tmp, m.Name, m.Size); err != nil && errors.Cause(err) != ErrSnapshotNotFound {
+			return errors.Wrap(err, "cannot finish interrupted replace")
+		}
+		m.ReplacedSize, m.ReplacedSHA256 = 0, ""
+	}
+	hr := newHashingReader(&contextReader{ctx: ctx, r: r})
+	if err := b.putObject(ctx, tmp, hr, m.DatabaseSize); err != nil {
+		return errors.Wrap(err, "cannot upload snapshot")
+	}
+
+	replacedSize, replacedSHA256 := m.Size, m.SHA256
+	hr.finish(m)
+
+	// snapshots saved by older versions of e2d have no manifest to verify
+	// them, so there is nothing to keep accepting the old snapshot
+	if replacedSHA256 != "" {
+		m.ReplacedSize, m.ReplacedSHA256 = replacedSize, replacedSHA256
+		if err := putManifest(ctx, b, m); err != nil {
+			return err
+		}
+	}
+	if err := b.moveObject(ctx, tmp, m.Name, m.Size); err != nil {
+		return errors.Wrap(err, "cannot move snapshot into place")
+	}
+	m.ReplacedSize, m.ReplacedSHA256 = 0, ""
+	return putManifest(ctx, b, m)
+}
+
+func putManifest(ctx context.Context, b replaceBackend, m *Manifest) error {
+	data, err := m.generate()
+	if err != nil {
+		return err
+	}
+	if err := b.putObject(ctx, manifestName(m.Name), bytes.NewReader(data), int64(len(data))); err != nil {
+		return errors.Wrap(err, "cannot upload snapshot manifest")
+	}
+	return nil
+}
+
+// copyObject copies the object src to dst by streaming it through this
+// process, for backends where objects cannot be renamed.
+func copyObject(ctx context.Context, b replaceBackend, src, dst string, size int64) error {
+	r, err := b.openSnapshot(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return b.putObject(ctx, dst, r, size)
+}
+
+// resolveLatest returns the name of the snapshot LATEST points to.
+func resolveLatest(b snapshotBackend) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	return b.latestName(ctx)
+}
+
+// loadLatest returns the snapshot LATEST points to.
+func loadLatest(b snapshotBackend) (io.ReadCloser, error) {
+	name, err := resolveLatest(b)
+	if err != nil {
+		return nil, err
+	}
+	return openVerified(b, name)
+}
+
+// loadAt returns the snapshot identified by id (see Find).
+func loadAt(b snapshotBackend, id string) (io.ReadCloser, error) {
+	snapshots, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+	s, err := Find(snapshots, id)
+	if err != nil {
+		return nil, err
+	}
+	return openVerified(b, s.Name)
+}
+
+// openVerified returns the snapshot with the provided name, verifying it
+// against its manifest when one exists. The snapshot is streamed (see
+// openStream), so the request is only cancelled once the returned reader is
+// closed or the download stalls.
+func openVerified(b snapshotBackend, name string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	m, err := b.readManifest(ctx, name)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	r, err := openStream(func(ctx context.Context) (io.ReadCloser, error) {
+		return b.openSnapshot(ctx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		log.Warn("snapshot has no manifest, skipping checksum verification", zap.String("snapshot", name))
+	}
+	return newVerifyingReadCloser(r, m), nil
+}
+
+// deleteSnapshot removes the snapshot with the provided name, along with its
+// manifest. The snapshot that LATEST points to cannot be deleted.
+func deleteSnapshot(b snapshotBackend, name string) error {
+	if _, ok := parseSnapshotName(name); !ok {
+		return errors.Wrapf(ErrSnapshotNotFound, "%#v is not a snapshot name", name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	latest, err := b.latestName(ctx)
+	if err != nil && errors.Cause(err) != ErrSnapshotNotFound {
+		return err
+	}
+	if latest == name {
+		return errors.Wrap(ErrSnapshotInUse, name)
+	}
+	return b.removeSnapshot(ctx, name)
+}
+
+// pruneSnapshots removes the snapshots, and their manifests, that were taken
+// before the provided time. The snapshot that LATEST points to is never
+// removed, nor is the snapshot that was just saved.
+func pruneSnapshots(b snapshotBackend, saved string, before time.Time) error {
+	snapshots, err := b.List()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	latest, err := b.latestName(ctx)
+	if err != nil && errors.Cause(err) != ErrSnapshotNotFound {
+		return err
+	}
+	for _, s := range snapshots {
+		if !s.Timestamp.Before(before) {
+			continue
+		}
+		if s.Name == latest || s.Name == saved {
+			log.Debug("keeping expired snapshot, it is the latest snapshot", zap.String("snapshot", s.Name))
+			continue
+		}
+		if err := b.removeSnapshot(ctx, s.Name); err != nil {
+			if errors.Cause(err) == ErrSnapshotNotFound {
+				continue
+			}
+			return err
+		}
+		log.Info("pruned expired snapshot", zap.String("snapshot", s.Name))
+	}
+	return nil
+}
@@ -6,6 +6,8 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"io"
 )
@@ -21,6 +23,25 @@ func NewEncryptionKey() *[32]byte {
 	return &key
 }
 
+var ErrInvalidCAKey = errors.New("invalid ca key")
+
+// NewKeyFromCAKey derives a 256-bit key for Encrypt() and Decrypt() from a PEM
+// encoded RSA CA private key, so that every member with the CA key derives
+// the same key.
+func NewKeyFromCAKey(data []byte) (*[32]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidCAKey
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return nil, err
+	}
+	key := [32]byte{}
+	h := sha512.Sum512_256(block.Bytes)
+	copy(key[:], h[:])
+	return &key, nil
+}
+
 // NewRandomIV generates a random 128-bit IV for use with AES encryption. It
 // panics if the source of randomness fails.
 func NewRandomIV() []byte {
@@ -33,6 +54,9 @@ func NewRandomIV() []byte {
 
 // Encrypt encrypts data using 256-bit AES-CTR and provides message
 // authentication by signing the data with HMAC-512_256.
+//
+// Deprecated: it is only kept to test decrypting snapshots saved by older
+// versions of e2d, use EncryptEnvelope instead.
 func Encrypt(in io.Reader, out io.Writer, key *[32]byte) error {
 	block, err := aes.NewCipher(key[:])
 	if err != nil {
@@ -0,0 +1,397 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// The envelope format encrypts data in chunks with AES-256-GCM, following the
+// STREAM construction, so every chunk is authenticated before any of its
+// plaintext is returned, and reordered, duplicated or truncated chunks are
+// detected. It replaces the format of Encrypt(), which can only authenticate
+// the data once all of it has been decrypted.
+//
+// An envelope starts with a header:
+//
+//	magic "E2DENC" | version (1) | key ID (8) | chunk size (4) | salt (32) |
+//	provider name length (1) | provider name | wrapped key length (2) | wrapped key
+//
+// followed by the chunks, each a 4 byte length of the sealed chunk, with the
+// high bit set for the last chunk, and the sealed chunk itself. The data is
+// encrypted with a random data key, which is stored in the header wrapped by
+// the KeyProvider named there, and the key ID identifies the key the provider
+// wrapped it with. The chunks are encrypted with a key derived from the data
+// key and the random salt. The nonce of each chunk is its index and whether it
+// is the last chunk, and the header is authenticated as additional data with
+// every chunk.
+//
+// Version 1 envelopes have no provider name or wrapped key, and are encrypted
+// directly with the key from the keyring identified by the key ID.
+
+// EnvelopeMagic starts every envelope.
+var EnvelopeMagic = []byte("E2DENC")
+
+const (
+	// EnvelopeVersion is the version of the envelopes written by
+	// EncryptEnvelope.
+	EnvelopeVersion = 2
+
+	// envelopeVersionDirect is the version of envelopes encrypted directly
+	// with a key from the keyring, written by older versions of e2d.
+	envelopeVersionDirect = 1
+
+	// DefaultChunkSize is the amount of plaintext sealed in each chunk.
+	DefaultChunkSize = 64 << 10
+
+	// maxChunkSize limits the memory used when decrypting an envelope with a
+	// corrupt header.
+	maxChunkSize = 16 << 20
+
+	// EnvelopeHeaderSize is the size of the start of the header common to
+	// every version, which includes the key ID.
+	EnvelopeHeaderSize = 6 + 1 + 8 + 4 + envelopeSaltSize
+
+	// maxWrappedKeySize is far larger than the wrapped keys of any provider,
+	// and limits the memory used when reading a corrupt header.
+	maxWrappedKeySize = 4 << 10
+
+	envelopeSaltSize = 32
+	lastChunkFlag    = 1 << 31
+)
+
+var (
+	ErrInvalidEnvelope    = errors.New("invalid encryption envelope")
+	ErrUnsupportedVersion = errors.New("unsupported encryption envelope version")
+	ErrTruncated          = errors.New("encrypted data is truncated")
+	ErrUnknownKey         = errors.New("encryption key not found")
+)
+
+// KeyID identifies the key used to encrypt an envelope.
+type KeyID [8]byte
+
+// NewKeyID returns the ID of an encryption key. It is derived from the key,
+// so it can be used to find the key needed to decrypt an envelope without
+// revealing anything about the key itself.
+func NewKeyID(key *[32]byte) KeyID {
+	h := hmac.New(sha256.New, key[:])
+	h.Write([]byte("e2d key id"))
+	var id KeyID
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+func (id KeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// EnvelopeHeader is the header at the start of an envelope.
+type EnvelopeHeader struct {
+	Version   byte
+	KeyID     KeyID
+	ChunkSize uint32
+
+	// Provider is the name of the KeyProvider that wrapped the data key, and
+	// WrappedKey is the wrapped data key. Both are empty for version 1.
+	Provider   string
+	WrappedKey []byte
+
+	salt []byte
+	raw  []byte
+}
+
+func newEnvelopeHeader(version byte, id KeyID) (*EnvelopeHeader, error) {
+	h := &EnvelopeHeader{
+		Version:   version,
+		KeyID:     id,
+		ChunkSize: DefaultChunkSize,
+		salt:      make([]byte, envelopeSaltSize),
+	}
+	if _, err := io.ReadFull(rand.Reader, h.salt); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// marshal encodes the header, which is also the additional data
+// authenticated with every chunk.
+func (h *EnvelopeHeader) marshal() []byte {
+	raw := make([]byte, 0, EnvelopeHeaderSize+3+len(h.Provider)+len(h.WrappedKey))
+	raw = append(raw, EnvelopeMagic...)
+	raw = append(raw, h.Version)
+	raw = append(raw, h.KeyID[:]...)
+	raw = append(raw, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(raw[15:], h.ChunkSize)
+	raw = append(raw, h.salt...)
+	if h.Version == envelopeVersionDirect {
+		return raw
+	}
+	raw = append(raw, byte(len(h.Provider)))
+	raw = append(raw, h.Provider...)
+	raw = append(raw, 0, 0)
+	binary.BigEndian.PutUint16(raw[len(raw)-2:], uint16(len(h.WrappedKey)))
+	return append(raw, h.WrappedKey...)
+}
+
+func readFull(in io.Reader, buf []byte) error {
+	if _, err := io.ReadFull(in, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	return nil
+}
+
+// parseEnvelopeHeader parses the start of the header common to every
+// version.
+func parseEnvelopeHeader(raw []byte) (*EnvelopeHeader, error) {
+	if len(raw) < EnvelopeHeaderSize {
+		return nil, ErrTruncated
+	}
+	if !bytes.HasPrefix(raw, EnvelopeMagic) {
+		return nil, ErrInvalidEnvelope
+	}
+	h := &EnvelopeHeader{
+		Version:   raw[6],
+		ChunkSize: binary.BigEndian.Uint32(raw[15:19]),
+		salt:      raw[19:EnvelopeHeaderSize],
+		raw:       raw[:EnvelopeHeaderSize],
+	}
+	copy(h.KeyID[:], raw[7:15])
+	if h.Version != EnvelopeVersion && h.Version != envelopeVersionDirect {
+		return nil, errors.Wrapf(ErrUnsupportedVersion, "version %d", h.Version)
+	}
+	if h.ChunkSize == 0 || h.ChunkSize > maxChunkSize {
+		return nil, errors.Wrapf(ErrInvalidEnvelope, "chunk size %d", h.ChunkSize)
+	}
+	return h, nil
+}
+
+// EnvelopeKeyID returns the key ID from the first EnvelopeHeaderSize bytes of
+// an envelope, so it can be found without reading the whole header.
+func EnvelopeKeyID(prefix []byte) (KeyID, error) {
+	h, err := parseEnvelopeHeader(prefix)
+	if err != nil {
+		return KeyID{}, err
+	}
+	return h.KeyID, nil
+}
+
+// ReadEnvelopeHeader reads the header at the start of an envelope, leaving in
+// at the first chunk.
+func ReadEnvelopeHeader(in io.Reader) (*EnvelopeHeader, error) {
+	raw := make([]byte, EnvelopeHeaderSize)
+	if err := readFull(in, raw); err != nil {
+		return nil, err
+	}
+	h, err := parseEnvelopeHeader(raw)
+	if err != nil || h.Version == envelopeVersionDirect {
+		return h, err
+	}
+	var n [1]byte
+	if err := readFull(in, n[:]); err != nil {
+		return nil, err
+	}
+	name := make([]byte, n[0])
+	if err := readFull(in, name); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if err := readFull(in, l[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint16(l[:])
+	if size == 0 || size > maxWrappedKeySize {
+		return nil, errors.Wrapf(ErrInvalidEnvelope, "wrapped key size %d", size)
+	}
+	h.Provider = string(name)
+	h.WrappedKey = make([]byte, size)
+	if err := readFull(in, h.WrappedKey); err != nil {
+		return nil, err
+	}
+	h.raw = h.marshal()
+	return h, nil
+}
+
+// DataKey returns the key the chunks are encrypted with. For version 1 this
+// is the key from the keyring with the header's key ID, otherwise it is the
+// data key unwrapped by the provider named in the header. When p is a
+// Keyring, the provider is found in the keyring.
+func (h *EnvelopeHeader) DataKey(ctx context.Context, p KeyProvider) (*[32]byte, error) {
+	keys, isKeyring := p.(*Keyring)
+	if h.Version == envelopeVersionDirect {
+		if key := keys.Lookup(h.KeyID); key != nil {
+			return key, nil
+		}
+		return nil, errors.Wrapf(ErrUnknownKey, "encrypted with key %s", h.KeyID)
+	}
+	if isKeyring {
+		p = keys.Provider(h.Provider)
+	}
+	if p == nil || p.Name() != h.Provider {
+		return nil, errors.Wrapf(ErrUnknownKey, "data key wrapped by %s key provider", h.Provider)
+	}
+	key, err := p.UnwrapKey(ctx, h.WrappedKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot unwrap data key with %s key provider", h.Provider)
+	}
+	return key, nil
+}
+
+func (h *EnvelopeHeader) aead(key *[32]byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("e2d envelope"))
+	mac.Write(h.salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(i uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, i)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// EncryptEnvelope encrypts data into an envelope, using 256-bit AES-GCM with
+// each chunk of the data authenticated separately. The data is encrypted with
+// a new random data key, which is wrapped by the provider.
+func EncryptEnvelope(ctx context.Context, in io.Reader, out io.Writer, p KeyProvider) error {
+	key := NewEncryptionKey()
+	wrapped, err := p.WrapKey(ctx, key)
+	if err != nil {
+		return errors.Wrapf(err, "cannot wrap data key with %s key provider", p.Name())
+	}
+	if len(p.Name()) > 255 {
+		return errors.Errorf("key provider name is too long: %#v", p.Name())
+	}
+	if len(wrapped) == 0 || len(wrapped) > maxWrappedKeySize {
+		return errors.Errorf("invalid wrapped key size from %s key provider: %d", p.Name(), len(wrapped))
+	}
+	h, err := newEnvelopeHeader(EnvelopeVersion, p.KeyID())
+	if err != nil {
+		return err
+	}
+	h.Provider = p.Name()
+	h.WrappedKey = wrapped
+	return h.encrypt(in, out, key)
+}
+
+// encrypt writes the header followed by the data encrypted with key.
+func (h *EnvelopeHeader) encrypt(in io.Reader, out io.Writer, key *[32]byte) error {
+	h.raw = h.marshal()
+	if _, err := out.Write(h.raw); err != nil {
+		return err
+	}
+	aead, err := h.aead(key)
+	if err != nil {
+		return err
+	}
+
+	// the reader must hold a full chunk, so that peeking past the end of a
+	// chunk does not read anything more than needed
+	br := bufio.NewReaderSize(in, int(h.ChunkSize))
+	buf := make([]byte, h.ChunkSize)
+	sealed := make([]byte, 4, 4+int(h.ChunkSize)+aead.Overhead())
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			if _, err := br.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+		sealed = aead.Seal(sealed[:4], chunkNonce(i, last), buf[:n], h.raw)
+		l := uint32(len(sealed) - 4)
+		if last {
+			l |= lastChunkFlag
+		}
+		binary.BigEndian.PutUint32(sealed, l)
+		if _, err := out.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Decrypt decrypts the chunks following the header with the key returned by
+// DataKey. The plaintext of each chunk is only written to out once it has been
+// authenticated, and an error is returned if the chunks end before the last
+// one.
+func (h *EnvelopeHeader) Decrypt(in io.Reader, out io.Writer, key *[32]byte) error {
+	aead, err := h.aead(key)
+	if err != nil {
+		return err
+	}
+	maxSealed := h.ChunkSize + uint32(aead.Overhead())
+	sealed := make([]byte, maxSealed)
+	plaintext := make([]byte, h.ChunkSize)
+	for i := uint64(0); ; i++ {
+		var lb [4]byte
+		if _, err := io.ReadFull(in, lb[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrTruncated
+			}
+			return err
+		}
+		l := binary.BigEndian.Uint32(lb[:])
+		last := l&lastChunkFlag != 0
+		l &^= lastChunkFlag
+		if l > maxSealed {
+			return ErrMessageAuthFailed
+		}
+		if _, err := io.ReadFull(in, sealed[:l]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrTruncated
+			}
+			return err
+		}
+		p, err := aead.Open(plaintext[:0], chunkNonce(i, last), sealed[:l], h.raw)
+		if err != nil {
+			return ErrMessageAuthFailed
+		}
+		if _, err := out.Write(p); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// DecryptEnvelope decrypts an envelope encrypted with EncryptEnvelope, using
+// the provider to unwrap its data key.
+func DecryptEnvelope(ctx context.Context, in io.Reader, out io.Writer, p KeyProvider) error {
+	h, err := ReadEnvelopeHeader(in)
+	if err != nil {
+		return err
+	}
+	key, err := h.DataKey(ctx, p)
+	if err != nil {
+		return err
+	}
+	return h.Decrypt(in, out, key)
+}
@@ -0,0 +1,285 @@
+package crypto
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Keyring holds the keys used for snapshot encryption. The data key of each
+// snapshot is always wrapped with the active key, while any key in the
+// keyring can unwrap it, so keys can be rotated without losing access to older
+// snapshots. A nil Keyring has no keys.
+//
+// A Keyring is itself a KeyProvider, named "local", unless an external
+// provider is set with SetProvider, in which case new data keys are wrapped
+// by that provider instead. The keys in the keyring can still decrypt
+// snapshots saved before the provider was set.
+type Keyring struct {
+	active   *[32]byte
+	keys     map[KeyID]*[32]byte
+	legacy   *[32]byte
+	provider KeyProvider
+}
+
+// LocalKeyProvider is the name of the KeyProvider that wraps data keys with
+// the keys in a Keyring.
+const LocalKeyProvider = "local"
+
+// NewKeyring returns a keyring with the provided active key, which is also
+// used for decryption, along with any additional keys that are only used for
+// decryption.
+func NewKeyring(active *[32]byte, keys ...*[32]byte) *Keyring {
+	k := &Keyring{keys: make(map[KeyID]*[32]byte)}
+	if active != nil {
+		k.active = active
+		k.Add(active)
+	}
+	for _, key := range keys {
+		k.Add(key)
+	}
+	return k
+}
+
+// Add adds a key used only for decryption. The first key added becomes the
+// active key if there is not one already.
+func (k *Keyring) Add(key *[32]byte) {
+	if k.active == nil {
+		k.active = key
+	}
+	k.keys[NewKeyID(key)] = key
+}
+
+// SetLegacy adds the key used to decrypt snapshots encrypted by older versions
+// of e2d, which do not identify their key. These were always encrypted with
+// the key derived from the CA key.
+func (k *Keyring) SetLegacy(key *[32]byte) {
+	k.Add(key)
+	k.legacy = key
+}
+
+// Active returns the key used for encryption, or nil if there are no keys.
+func (k *Keyring) Active() *[32]byte {
+	if k == nil {
+		return nil
+	}
+	return k.active
+}
+
+// ActiveID returns the ID of the active key.
+func (k *Keyring) ActiveID() KeyID {
+	if k.Active() == nil {
+		return KeyID{}
+	}
+	return NewKeyID(k.active)
+}
+
+// Lookup returns the key with the provided ID, or nil if it is not in the
+// keyring.
+func (k *Keyring) Lookup(id KeyID) *[32]byte {
+	if k == nil {
+		return nil
+	}
+	return k.keys[id]
+}
+
+// Legacy returns the key used to decrypt snapshots encrypted by older
+// versions of e2d, which is the active key unless one was set with
+// SetLegacy.
+func (k *Keyring) Legacy() *[32]byte {
+	if k == nil {
+		return nil
+	}
+	if k.legacy != nil {
+		return k.legacy
+	}
+	return k.active
+}
+
+// SetProvider sets the external provider used to wrap the data keys of new
+// snapshots.
+func (k *Keyring) SetProvider(p KeyProvider) {
+	k.provider = p
+}
+
+// Provider returns the provider with the provided name, which is either the
+// keyring itself or the external provider, or nil if there is none.
+func (k *Keyring) Provider(name string) KeyProvider {
+	switch {
+	case k == nil:
+		return nil
+	case k.provider != nil && k.provider.Name() == name:
+		return k.provider
+	case name == LocalKeyProvider && len(k.keys) > 0:
+		return localKeyProvider{k}
+	}
+	return nil
+}
+
+// CanEncrypt reports whether the keyring has an active key or external
+// provider to encrypt with.
+func (k *Keyring) CanEncrypt() bool {
+	return k != nil && (k.provider != nil || k.active != nil)
+}
+
+// wrapper returns the provider that wraps new data keys.
+func (k *Keyring) wrapper() KeyProvider {
+	if k != nil && k.provider != nil {
+		return k.provider
+	}
+	return localKeyProvider{k}
+}
+
+// Name returns the name of the provider that wraps new data keys.
+func (k *Keyring) Name() string {
+	return k.wrapper().Name()
+}
+
+// KeyID returns the ID of the key that new data keys are wrapped with, which
+// is the ID of the active key unless an external provider is set.
+func (k *Keyring) KeyID() KeyID {
+	return k.wrapper().KeyID()
+}
+
+// WrapKey wraps a data key with the active key, or the external provider.
+func (k *Keyring) WrapKey(ctx context.Context, key *[32]byte) ([]byte, error) {
+	return k.wrapper().WrapKey(ctx, key)
+}
+
+// UnwrapKey unwraps a data key wrapped by WrapKey.
+func (k *Keyring) UnwrapKey(ctx context.Context, wrapped []byte) (*[32]byte, error) {
+	return k.wrapper().UnwrapKey(ctx, wrapped)
+}
+
+// Len returns the number of keys in the keyring.
+func (k *Keyring) Len() int {
+	if k == nil {
+		return 0
+	}
+	return len(k.keys)
+}
+
+// localKeyProvider wraps data keys with AES-GCM using the keys in a keyring.
+// The wrapped key is the ID of the key it was wrapped with, followed by the
+// nonce and the sealed data key.
+type localKeyProvider struct {
+	keys *Keyring
+}
+
+func (p localKeyProvider) Name() string {
+	return LocalKeyProvider
+}
+
+func (p localKeyProvider) KeyID() KeyID {
+	return p.keys.ActiveID()
+}
+
+func wrappingAEAD(key *[32]byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("e2d key wrap"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (p localKeyProvider) WrapKey(ctx context.Context, key *[32]byte) ([]byte, error) {
+	active := p.keys.Active()
+	if active == nil {
+		return nil, errors.Wrap(ErrUnknownKey, "keyring has no active key")
+	}
+	aead, err := wrappingAEAD(active)
+	if err != nil {
+		return nil, err
+	}
+	id := NewKeyID(active)
+	wrapped := make([]byte, len(id)+aead.NonceSize(), len(id)+aead.NonceSize()+len(key)+aead.Overhead())
+	copy(wrapped, id[:])
+	nonce := wrapped[len(id):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(wrapped, nonce, key[:], id[:]), nil
+}
+
+func (p localKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte) (*[32]byte, error) {
+	var id KeyID
+	if len(wrapped) < len(id) {
+		return nil, ErrInvalidEnvelope
+	}
+	copy(id[:], wrapped)
+	key := p.keys.Lookup(id)
+	if key == nil {
+		return nil, errors.Wrapf(ErrUnknownKey, "wrapped with key %s", id)
+	}
+	aead, err := wrappingAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	wrapped = wrapped[len(id):]
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrInvalidEnvelope
+	}
+	b, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], id[:])
+	if err != nil || len(b) != 32 {
+		return nil, ErrMessageAuthFailed
+	}
+	dataKey := &[32]byte{}
+	copy(dataKey[:], b)
+	return dataKey, nil
+}
+
+// EncodeKey returns the encoding of the key used by ParseKeys.
+func EncodeKey(key *[32]byte) string {
+	return base64.StdEncoding.EncodeToString(key[:])
+}
+
+// ParseKeys parses a list of base64 encoded 32 byte keys separated by
+// newlines or commas, ignoring blank lines and lines starting with #, into a
+// keyring. The first key is the active key. A key can be generated with e.g.
+// `head -c 32 /dev/urandom | base64`.
+func ParseKeys(data []byte) (*Keyring, error) {
+	k := NewKeyring(nil)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, s := range strings.Split(line, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil || len(b) != 32 {
+				return nil, errors.Errorf("line %d: key must be 32 bytes encoded with base64", i+1)
+			}
+			key := &[32]byte{}
+			copy(key[:], b)
+			k.Add(key)
+		}
+	}
+	if k.Len() == 0 {
+		return nil, errors.New("no keys found")
+	}
+	return k, nil
+}
+
+// ReadKeyFile reads a keyring from a file in the format used by ParseKeys.
+func ReadKeyFile(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeys(data)
+}
@@ -0,0 +1,47 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	first, second, third := NewEncryptionKey(), NewEncryptionKey(), NewEncryptionKey()
+	data := strings.Join([]string{
+		"# rotated 2020-06-01",
+		EncodeKey(first),
+		"",
+		EncodeKey(second) + ", " + EncodeKey(third),
+	}, "\n")
+	keys, err := ParseKeys([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Len() != 3 || *keys.Active() != *first {
+		t.Fatalf("unexpected keyring: %d keys, active key %s", keys.Len(), keys.ActiveID())
+	}
+	if keys.Lookup(NewKeyID(third)) == nil || *keys.Lookup(NewKeyID(third)) != *third || keys.Lookup(NewKeyID(NewEncryptionKey())) != nil {
+		t.Fatal("unexpected key lookup")
+	}
+
+	// the legacy key decrypts snapshots that do not identify their key
+	if *keys.Legacy() != *first {
+		t.Fatal("expected the active key to be the legacy key")
+	}
+	legacy := NewEncryptionKey()
+	keys.SetLegacy(legacy)
+	if keys.Legacy() != legacy || *keys.Active() != *first || keys.Lookup(NewKeyID(legacy)) != legacy {
+		t.Fatal("unexpected legacy key")
+	}
+
+	for _, data := range []string{"", "# no keys", "c2hvcnQ=", "not base64"} {
+		if _, err := ParseKeys([]byte(data)); err == nil {
+			t.Fatalf("expected error parsing %q", data)
+		}
+	}
+
+	var nilKeys *Keyring
+	if nilKeys.Active() != nil || nilKeys.Lookup(NewKeyID(first)) != nil || nilKeys.Len() != 0 {
+		t.Fatal("expected nil keyring to have no keys")
+	}
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/pkg/errors"
+
+	e2daws "github.com/criticalstack/e2d/pkg/provider/aws"
+)
+
+// KMSKeyProvider is the name of the KeyProvider that wraps data keys with AWS
+// KMS.
+const KMSKeyProvider = "aws-kms"
+
+// kmsEncryptionContext is bound to every wrapped key, so KMS ciphertexts
+// created for anything else cannot be used as a data key.
+var kmsEncryptionContext = map[string]*string{
+	"e2d": aws.String("snapshot"),
+}
+
+type KMSConfig struct {
+	// KeyID is the ID, ARN or alias of the KMS key data keys are wrapped
+	// with, e.g. alias/etcd-snapshots.
+	KeyID string
+
+	// Region of the KMS key, which defaults to the EC2 instance region.
+	Region string
+
+	// Endpoint overrides the KMS endpoint, e.g. for a VPC endpoint.
+	Endpoint string
+}
+
+// AmazonKMS wraps data keys with a symmetric AWS KMS key, using the default
+// AWS credential chain.
+type AmazonKMS struct {
+	kmsiface.KMSAPI
+
+	keyID string
+	id    KeyID
+}
+
+func NewAmazonKMS(cfg *KMSConfig) (*AmazonKMS, error) {
+	if cfg.KeyID == "" {
+		return nil, errors.New("must provide kms key id")
+	}
+	awsCfg := &aws.Config{}
+	if cfg.Region != "" {
+		awsCfg.Region = aws.String(cfg.Region)
+	} else {
+		var err error
+		awsCfg, err = e2daws.NewConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot determine kms region")
+		}
+	}
+	if cfg.Endpoint != "" {
+		awsCfg.Endpoint = aws.String(cfg.Endpoint)
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return newAmazonKMS(kms.New(sess), cfg.KeyID), nil
+}
+
+func newAmazonKMS(api kmsiface.KMSAPI, keyID string) *AmazonKMS {
+	return &AmazonKMS{
+		KMSAPI: api,
+		keyID:  keyID,
+		id:     newExternalKeyID(KMSKeyProvider + "/" + keyID),
+	}
+}
+
+func (k *AmazonKMS) Name() string {
+	return KMSKeyProvider
+}
+
+func (k *AmazonKMS) KeyID() KeyID {
+	return k.id
+}
+
+// WrapKey encrypts the data key with the KMS key. The wrapped key is the
+// ciphertext blob returned by KMS, which identifies the key, so it can be
+// unwrapped even if the KMS key used for new snapshots is changed.
+func (k *AmazonKMS) WrapKey(ctx context.Context, key *[32]byte) ([]byte, error) {
+	resp, err := k.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(k.keyID),
+		Plaintext:         key[:],
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+func (k *AmazonKMS) UnwrapKey(ctx context.Context, wrapped []byte) (*[32]byte, error) {
+	resp, err := k.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob:    wrapped,
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Plaintext) != 32 {
+		return nil, errors.New("kms returned an invalid data key")
+	}
+	key := &[32]byte{}
+	copy(key[:], resp.Plaintext)
+	return key, nil
+}
@@ -0,0 +1,35 @@
+package crypto
+
+import (
+	"context"
+	"crypto/sha256"
+)
+
+// KeyProvider wraps the random data key that each envelope is encrypted with,
+// so the keys protecting snapshots can be held by an external key manager
+// rather than by e2d. The wrapped key is stored in the envelope header along
+// with the name of the provider, which must be able to unwrap it again when
+// the envelope is decrypted.
+type KeyProvider interface {
+	// Name identifies the provider in the envelope header, e.g. "local".
+	Name() string
+
+	// KeyID identifies the key that new data keys are wrapped with.
+	KeyID() KeyID
+
+	// WrapKey encrypts a data key.
+	WrapKey(ctx context.Context, key *[32]byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key wrapped by WrapKey, including ones
+	// wrapped with keys that have since been rotated.
+	UnwrapKey(ctx context.Context, wrapped []byte) (*[32]byte, error)
+}
+
+// newExternalKeyID returns the ID for a key held by an external key manager,
+// derived from a name that identifies the key there.
+func newExternalKeyID(name string) KeyID {
+	sum := sha256.Sum256([]byte(name))
+	var id KeyID
+	copy(id[:], sum[:])
+	return id
+}
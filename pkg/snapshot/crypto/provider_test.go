@@ -0,0 +1,257 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/pkg/errors"
+)
+
+func encryptEnvelope(t *testing.T, plaintext []byte, p KeyProvider) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if err := EncryptEnvelope(context.Background(), bytes.NewReader(plaintext), &out, p); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func decryptEnvelope(data []byte, p KeyProvider) ([]byte, error) {
+	var out bytes.Buffer
+	err := DecryptEnvelope(context.Background(), bytes.NewReader(data), &out, p)
+	return out.Bytes(), err
+}
+
+// testKeyProvider checks that data encrypted with the provider decrypts with
+// it, and is identified in the header as wrapped by it.
+func testKeyProvider(t *testing.T, p KeyProvider) {
+	t.Helper()
+	plaintext := make([]byte, DefaultChunkSize+100)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := encryptEnvelope(t, plaintext, p)
+	h, err := ReadEnvelopeHeader(bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != EnvelopeVersion || h.Provider != p.Name() || h.KeyID != p.KeyID() {
+		t.Fatalf("unexpected header: version %d, provider %q, key %s", h.Version, h.Provider, h.KeyID)
+	}
+	if bytes.Contains(h.WrappedKey, plaintext[:16]) {
+		t.Fatal("wrapped key contains plaintext")
+	}
+	out, err := decryptEnvelope(ciphertext, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, out) {
+		t.Fatal("after Decrypt differs")
+	}
+
+	// every envelope has its own data key
+	other := encryptEnvelope(t, plaintext, p)
+	h2, err := ReadEnvelopeHeader(bytes.NewReader(other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(h.WrappedKey, h2.WrappedKey) {
+		t.Fatal("expected a new data key for every envelope")
+	}
+}
+
+func TestLocalKeyProvider(t *testing.T) {
+	old, key := NewEncryptionKey(), NewEncryptionKey()
+	testKeyProvider(t, NewKeyring(key))
+
+	ciphertext := encryptEnvelope(t, []byte("testing"), NewKeyring(old))
+	if out, err := decryptEnvelope(ciphertext, NewKeyring(key, old)); err != nil || string(out) != "testing" {
+		t.Fatalf("cannot decrypt with rotated keyring: %v", err)
+	}
+	if _, err := decryptEnvelope(ciphertext, NewKeyring(key)); errors.Cause(err) != ErrUnknownKey {
+		t.Fatalf("expected ErrUnknownKey, received %v", err)
+	}
+
+	// tampering with the wrapped key is detected
+	h, err := ReadEnvelopeHeader(bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.WrappedKey[len(h.WrappedKey)-1] ^= 0xff
+	if _, err := h.DataKey(context.Background(), NewKeyring(old)); errors.Cause(err) != ErrMessageAuthFailed {
+		t.Fatalf("expected ErrMessageAuthFailed, received %v", err)
+	}
+}
+
+func TestEnvelopeVersionDirect(t *testing.T) {
+	key := NewEncryptionKey()
+
+	// envelopes saved by older versions of e2d are encrypted directly with a
+	// key from the keyring
+	h, err := newEnvelopeHeader(envelopeVersionDirect, NewKeyID(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := h.encrypt(bytes.NewReader([]byte("testing")), &buf, key); err != nil {
+		t.Fatal(err)
+	}
+	id, err := EnvelopeKeyID(buf.Bytes()[:EnvelopeHeaderSize])
+	if err != nil || id != NewKeyID(key) {
+		t.Fatalf("expected key ID %s, received %s: %v", NewKeyID(key), id, err)
+	}
+	out, err := decryptEnvelope(buf.Bytes(), NewKeyring(NewEncryptionKey(), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "testing" {
+		t.Fatalf("unexpected plaintext: %q", out)
+	}
+	if _, err := decryptEnvelope(buf.Bytes(), NewKeyring(NewEncryptionKey())); errors.Cause(err) != ErrUnknownKey {
+		t.Fatalf("expected ErrUnknownKey, received %v", err)
+	}
+}
+
+// newTestVault returns a stand-in for the transit secrets engine of a Vault
+// dev server, which "encrypts" by handing out opaque ciphertexts.
+func newTestVault(t *testing.T, token string) *httptest.Server {
+	var mu sync.Mutex
+	plaintexts := make(map[string]string)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError := func(code int, msg string) {
+			w.WriteHeader(code)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{msg}})
+		}
+		if r.Method != http.MethodPost {
+			writeError(http.StatusMethodNotAllowed, "unsupported operation")
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != token {
+			writeError(http.StatusForbidden, "permission denied")
+			return
+		}
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(http.StatusBadRequest, err.Error())
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		var data map[string]string
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/snapshots":
+			ciphertext := fmt.Sprintf("vault:v1:%d", len(plaintexts))
+			plaintexts[ciphertext] = req["plaintext"]
+			data = map[string]string{"ciphertext": ciphertext}
+		case "/v1/transit/decrypt/snapshots":
+			plaintext, ok := plaintexts[req["ciphertext"]]
+			if !ok {
+				writeError(http.StatusBadRequest, "invalid ciphertext")
+				return
+			}
+			data = map[string]string{"plaintext": plaintext}
+		default:
+			writeError(http.StatusNotFound, "no handler for route")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestVaultTransit(t *testing.T) {
+	srv := newTestVault(t, "root")
+	defer srv.Close()
+
+	v, err := NewVaultTransit(&VaultConfig{Address: srv.URL, Token: "root", Key: "snapshots"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeyProvider(t, v)
+
+	// the keyring wraps new data keys with the provider once it is set, but
+	// can still decrypt snapshots wrapped with its own keys
+	key := NewEncryptionKey()
+	local := encryptEnvelope(t, []byte("local"), NewKeyring(key))
+	keys := NewKeyring(key)
+	keys.SetProvider(v)
+	if keys.KeyID() != v.KeyID() {
+		t.Fatalf("expected key ID %s, received %s", v.KeyID(), keys.KeyID())
+	}
+	wrapped := encryptEnvelope(t, []byte("vault"), keys)
+	for data, expected := range map[string]string{string(local): "local", string(wrapped): "vault"} {
+		out, err := decryptEnvelope([]byte(data), keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != expected {
+			t.Fatalf("expected %q, received %q", expected, out)
+		}
+	}
+	if _, err := decryptEnvelope(wrapped, NewKeyring(key)); errors.Cause(err) != ErrUnknownKey {
+		t.Fatalf("expected ErrUnknownKey, received %v", err)
+	}
+
+	v, err = NewVaultTransit(&VaultConfig{Address: srv.URL, Token: "invalid", Key: "snapshots"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptEnvelope(wrapped, v); err == nil {
+		t.Fatal("expected error with invalid vault token")
+	}
+}
+
+// fakeKMS wraps keys by XORing them with a fixed pad, checking the key ID and
+// encryption context like KMS does.
+type fakeKMS struct {
+	kmsiface.KMSAPI
+
+	keyID string
+}
+
+func (f *fakeKMS) EncryptWithContext(ctx aws.Context, in *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	if aws.StringValue(in.KeyId) != f.keyID || aws.StringValue(in.EncryptionContext["e2d"]) != "snapshot" {
+		return nil, errors.New("invalid encrypt request")
+	}
+	blob := append([]byte(f.keyID+":"), in.Plaintext...)
+	for i := len(f.keyID) + 1; i < len(blob); i++ {
+		blob[i] ^= 0x5c
+	}
+	return &kms.EncryptOutput{CiphertextBlob: blob, KeyId: in.KeyId}, nil
+}
+
+func (f *fakeKMS) DecryptWithContext(ctx aws.Context, in *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	prefix := []byte(f.keyID + ":")
+	if !bytes.HasPrefix(in.CiphertextBlob, prefix) || aws.StringValue(in.EncryptionContext["e2d"]) != "snapshot" {
+		return nil, errors.New("InvalidCiphertextException")
+	}
+	plaintext := append([]byte{}, in.CiphertextBlob[len(prefix):]...)
+	for i := range plaintext {
+		plaintext[i] ^= 0x5c
+	}
+	return &kms.DecryptOutput{Plaintext: plaintext}, nil
+}
+
+func TestAmazonKMS(t *testing.T) {
+	k := newAmazonKMS(&fakeKMS{keyID: "alias/etcd"}, "alias/etcd")
+	testKeyProvider(t, k)
+
+	other := newAmazonKMS(&fakeKMS{keyID: "alias/other"}, "alias/other")
+	if k.KeyID() == other.KeyID() {
+		t.Fatal("expected different key IDs for different kms keys")
+	}
+	ciphertext := encryptEnvelope(t, []byte("testing"), k)
+	if _, err := decryptEnvelope(ciphertext, other); err == nil {
+		t.Fatal("expected error unwrapping with a different kms key")
+	}
+}
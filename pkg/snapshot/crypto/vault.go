@@ -0,0 +1,189 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// VaultKeyProvider is the name of the KeyProvider that wraps data keys with
+// the HashiCorp Vault transit secrets engine.
+const VaultKeyProvider = "vault"
+
+type VaultConfig struct {
+	// Address is the url of the Vault server, e.g.
+	// https://vault.example.com:8200.
+	Address string
+
+	// Token authenticates requests to Vault. It must be allowed to update
+	// <mount>/encrypt/<key> and <mount>/decrypt/<key>.
+	Token string
+
+	// Namespace is the Vault Enterprise namespace of the transit mount.
+	Namespace string
+
+	// Mount is the path the transit secrets engine is mounted at, which
+	// defaults to "transit".
+	Mount string
+
+	// Key is the name of the transit key data keys are wrapped with.
+	Key string
+
+	// CAFile is a PEM encoded CA certificate bundle used to verify the
+	// server, instead of the system roots.
+	CAFile string
+}
+
+// VaultTransit wraps data keys with a key from the Vault transit secrets
+// engine, so the key never leaves Vault. Data keys wrapped with older
+// versions of the transit key can still be unwrapped after it is rotated in
+// Vault, since the ciphertext records the key version.
+type VaultTransit struct {
+	client *http.Client
+	addr   *url.URL
+	token  string
+	ns     string
+	mount  string
+	key    string
+	id     KeyID
+}
+
+func NewVaultTransit(cfg *VaultConfig) (*VaultTransit, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("must provide vault address")
+	}
+	if cfg.Key == "" {
+		return nil, errors.New("must provide vault transit key")
+	}
+	u, err := url.Parse(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("vault address must use http or https: %#v", cfg.Address)
+	}
+	v := &VaultTransit{
+		client: &http.Client{Timeout: 30 * time.Second},
+		addr:   u,
+		token:  cfg.Token,
+		ns:     cfg.Namespace,
+		mount:  strings.Trim(cfg.Mount, "/"),
+		key:    cfg.Key,
+	}
+	if v.mount == "" {
+		v.mount = "transit"
+	}
+	v.id = newExternalKeyID(strings.Join([]string{VaultKeyProvider, u.Host, cfg.Namespace, v.mount, v.key}, "/"))
+	if cfg.CAFile != "" {
+		data, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read ca file: %#v", cfg.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificates found in ca file: %#v", cfg.CAFile)
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+		v.client.Transport = t
+	}
+	return v, nil
+}
+
+func (v *VaultTransit) Name() string {
+	return VaultKeyProvider
+}
+
+func (v *VaultTransit) KeyID() KeyID {
+	return v.id
+}
+
+// WrapKey encrypts the data key with the transit key. The wrapped key is the
+// ciphertext returned by Vault, e.g. "vault:v1:...".
+func (v *VaultTransit) WrapKey(ctx context.Context, key *[32]byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := v.do(ctx, "encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(key[:]),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Ciphertext == "" {
+		return nil, errors.New("vault returned no ciphertext")
+	}
+	return []byte(resp.Ciphertext), nil
+}
+
+func (v *VaultTransit) UnwrapKey(ctx context.Context, wrapped []byte) (*[32]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	err := v.do(ctx, "decrypt", map[string]string{
+		"ciphertext": string(wrapped),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil || len(b) != 32 {
+		return nil, errors.New("vault returned an invalid data key")
+	}
+	key := &[32]byte{}
+	copy(key[:], b)
+	return key, nil
+}
+
+// do sends a request to the transit endpoint for the operation, decoding the
+// data of the response into out.
+func (v *VaultTransit) do(ctx context.Context, op string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	u := *v.addr
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/" + v.mount + "/" + op + "/" + url.PathEscape(v.key)
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.ns != "" {
+		req.Header.Set("X-Vault-Namespace", v.ns)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode == http.StatusOK {
+		return errors.Wrap(err, "cannot decode vault response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(result.Errors) > 0 {
+			return errors.Errorf("vault transit %s failed: %s: %s", op, resp.Status, strings.Join(result.Errors, ", "))
+		}
+		return errors.Errorf("vault transit %s failed: %s", op, resp.Status)
+	}
+	return errors.Wrap(json.Unmarshal(result.Data, out), "cannot decode vault response")
+}
@@ -0,0 +1,161 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const manifestSuffix = "manifest"
+
+var ErrChecksumMismatch = errors.New("snapshot checksum mismatch")
+
+// Manifest contains the metadata for a stored snapshot. It is written next to
+// the snapshot on Save and used to verify the snapshot on Load.
+//
+// The caller of Save provides the details of the snapshot being taken (e.g.
+// Revision and Leader), while the Snapshotter fills in the details of what was
+// actually stored (Name, Created, Size and SHA256). The size and checksum are
+// of the stored bytes, so after any compression or encryption is applied.
+type Manifest struct {
+	Name       string    `json:"name"`
+	Created    time.Time `json:"created"`
+	Revision   int64     `json:"revision"`
+	ClusterID  string    `json:"clusterID,omitempty"`
+	Leader     string    `json:"leader,omitempty"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	Version    string    `json:"version,omitempty"`
+	Compressed bool      `json:"compressed"`
+	Encrypted  bool      `json:"encrypted"`
+
+	// Compression is the name of the codec the snapshot was compressed with.
+	// It is empty for snapshots compressed with gzip by older versions of
+	// e2d.
+	Compression string `json:"compression,omitempty"`
+
+	// KeyID identifies the key the snapshot was encrypted with, which is
+	// also stored in the snapshot itself.
+	KeyID string `json:"keyID,omitempty"`
+
+	// DatabaseSize is the size of the etcd database, before any compression
+	// or encryption. Save also uses it as a hint for the size of the snapshot
+	// when choosing upload part sizes.
+	DatabaseSize int64 `json:"databaseSize,omitempty"`
+
+	// ReplacedSize and ReplacedSHA256 are the size and checksum of the
+	// snapshot that was stored before Replace started, and are only set
+	// while it is in progress. Either snapshot is verified against the
+	// manifest, so an interrupted Replace never leaves the stored snapshot
+	// without a matching manifest.
+	ReplacedSize   int64  `json:"replacedSize,omitempty"`
+	ReplacedSHA256 string `json:"replacedSHA256,omitempty"`
+}
+
+// Replacing reports whether the snapshot was being replaced when Replace was
+// interrupted, in which case the stored snapshot may still be the one it
+// replaced.
+func (m *Manifest) Replacing() bool {
+	return m.ReplacedSHA256 != ""
+}
+
+func manifestName(name string) string {
+	return name + "." + manifestSuffix
+}
+
+func (m *Manifest) generate() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+func (m *Manifest) read(input []byte) error {
+	return json.Unmarshal(input, m)
+}
+
+// hashingReader computes the size and SHA-256 checksum of everything read
+// through it.
+type hashingReader struct {
+	io.Reader
+	h hash.Hash
+	n int64
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{Reader: r, h: sha256.New()}
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+func (r *hashingReader) sum() string {
+	return hex.EncodeToString(r.h.Sum(nil))
+}
+
+// finish sets the size and checksum of what has been read on the manifest.
+func (r *hashingReader) finish(m *Manifest) {
+	m.Size = r.n
+	m.SHA256 = r.sum()
+}
+
+type verifyingReadCloser struct {
+	*hashingReader
+	c io.Closer
+	m *Manifest
+}
+
+// newVerifyingReadCloser wraps a stored snapshot so that its size and checksum
+// are checked against the provided manifest, or the snapshot it was replacing
+// (see Manifest.Replacing). Once the end of the snapshot is reached, Read
+// returns ErrChecksumMismatch instead of io.EOF if they do not match. If no
+// manifest is provided, the snapshot is returned as-is.
+func newVerifyingReadCloser(r io.ReadCloser, m *Manifest) io.ReadCloser {
+	if m == nil {
+		return r
+	}
+	return &verifyingReadCloser{hashingReader: newHashingReader(r), c: r, m: m}
+}
+
+func (r *verifyingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.hashingReader.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	sum := r.sum()
+	if r.m.Replacing() && r.n == r.m.ReplacedSize && sum == r.m.ReplacedSHA256 {
+		return n, err
+	}
+	if r.n != r.m.Size {
+		return n, errors.Wrapf(ErrChecksumMismatch, "%s: expected %d bytes, read %d", r.m.Name, r.m.Size, r.n)
+	}
+	if sum != r.m.SHA256 {
+		return n, errors.Wrapf(ErrChecksumMismatch, "%s: expected sha256 %s, computed %s", r.m.Name, r.m.SHA256, sum)
+	}
+	return n, err
+}
+
+func (r *verifyingReadCloser) Close() error {
+	return r.c.Close()
+}
+
+// contextReader stops reading once the context is done, for copies that do
+// not otherwise observe a context.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
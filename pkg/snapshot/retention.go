@@ -0,0 +1,114 @@
+package snapshot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// RetentionPolicy describes which snapshots to keep in a
+// grandfather-father-son style rotation. Each count is the number of most
+// recent hours, days, weeks and months for which the newest snapshot taken
+// during that period is kept. A count of zero keeps nothing for that period.
+// All periods are in UTC, and weeks are ISO 8601 weeks.
+type RetentionPolicy struct {
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// IsZero returns true when the policy does not keep any periods, meaning
+// retention is disabled.
+func (p RetentionPolicy) IsZero() bool {
+	return p.Hourly <= 0 && p.Daily <= 0 && p.Weekly <= 0 && p.Monthly <= 0
+}
+
+type retentionPeriod struct {
+	count int
+	key   func(time.Time) string
+}
+
+func (p RetentionPolicy) periods() []retentionPeriod {
+	return []retentionPeriod{
+		{p.Hourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+}
+
+// Apply splits snapshots into the ones kept by the policy and the ones that
+// have expired. The newest snapshot is always kept, regardless of the policy,
+// so that there is always something to restore from. Both lists are ordered
+// from oldest to newest.
+func (p RetentionPolicy) Apply(snapshots []*Snapshot) (keep, expired []*Snapshot) {
+	if len(snapshots) == 0 {
+		return nil, nil
+	}
+	sorted := make([]*Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sortSnapshots(sorted)
+
+	kept := make(map[*Snapshot]bool)
+	kept[sorted[len(sorted)-1]] = true
+	for _, period := range p.periods() {
+		remaining := period.count
+		last := ""
+
+		// walk from newest to oldest, keeping the first (i.e. newest)
+		// snapshot seen in each period until enough periods are covered
+		for i := len(sorted) - 1; i >= 0 && remaining > 0; i-- {
+			k := period.key(sorted[i].Timestamp.UTC())
+			if k == last {
+				continue
+			}
+			last = k
+			kept[sorted[i]] = true
+			remaining--
+		}
+	}
+	for _, s := range sorted {
+		if kept[s] {
+			keep = append(keep, s)
+		} else {
+			expired = append(expired, s)
+		}
+	}
+	return keep, expired
+}
+
+// ApplyRetention deletes the snapshots stored by s that are expired according
+// to the provided policy, and returns the deleted snapshots. It does nothing
+// if the policy is zero. An error deleting one snapshot does not prevent the
+// others from being deleted.
+func ApplyRetention(s Snapshotter, p RetentionPolicy) ([]*Snapshot, error) {
+	if p.IsZero() {
+		return nil, nil
+	}
+	snapshots, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	_, expired := p.Apply(snapshots)
+	deleted := make([]*Snapshot, 0)
+	var errs []string
+	for _, snap := range expired {
+		if err := s.Delete(snap.Name); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", snap.Name, err))
+			continue
+		}
+		log.Info("deleted expired snapshot", zap.String("snapshot", snap.Name))
+		deleted = append(deleted, snap)
+	}
+	if len(errs) > 0 {
+		return deleted, errors.Errorf("cannot delete expired snapshots: %v", errs)
+	}
+	return deleted, nil
+}
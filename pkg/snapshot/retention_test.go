@@ -0,0 +1,123 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRetentionPolicyApply(t *testing.T) {
+	// snapshots every 6 hours from 2020-05-01 00:00 to 2020-06-10 18:00 UTC
+	start := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	snapshots := make([]*Snapshot, 0)
+	for ts := start; !ts.After(time.Date(2020, 6, 10, 18, 0, 0, 0, time.UTC)); ts = ts.Add(6 * time.Hour) {
+		snapshots = append(snapshots, &Snapshot{Name: newSnapshotName(ts), Timestamp: ts})
+	}
+	date := func(month time.Month, day, hour int) string {
+		return newSnapshotName(time.Date(2020, month, day, hour, 0, 0, 0, time.UTC))
+	}
+
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		expected []string
+	}{
+		{
+			name:     "zero keeps newest",
+			policy:   RetentionPolicy{},
+			expected: []string{date(6, 10, 18)},
+		},
+		{
+			name:     "hourly",
+			policy:   RetentionPolicy{Hourly: 2},
+			expected: []string{date(6, 10, 12), date(6, 10, 18)},
+		},
+		{
+			name:     "daily",
+			policy:   RetentionPolicy{Daily: 3},
+			expected: []string{date(6, 8, 18), date(6, 9, 18), date(6, 10, 18)},
+		},
+		{
+			// 2020-06-07 is the last day of ISO week 23
+			name:     "weekly",
+			policy:   RetentionPolicy{Weekly: 2},
+			expected: []string{date(6, 7, 18), date(6, 10, 18)},
+		},
+		{
+			name:     "monthly",
+			policy:   RetentionPolicy{Monthly: 12},
+			expected: []string{date(5, 31, 18), date(6, 10, 18)},
+		},
+		{
+			name:     "overlapping periods",
+			policy:   RetentionPolicy{Hourly: 1, Daily: 2, Monthly: 2},
+			expected: []string{date(5, 31, 18), date(6, 9, 18), date(6, 10, 18)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, expired := tt.policy.Apply(snapshots)
+			names := make([]string, 0)
+			for _, s := range keep {
+				names = append(names, s.Name)
+			}
+			if diff := cmp.Diff(tt.expected, names); diff != "" {
+				t.Errorf("retention: kept snapshots differ: (-want +got)\n%s", diff)
+			}
+			if len(keep)+len(expired) != len(snapshots) {
+				t.Errorf("expected %d snapshots in total, received %d", len(snapshots), len(keep)+len(expired))
+			}
+		})
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileSnapshotter(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{
+		newSnapshotName(time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)),
+		newSnapshotName(time.Date(2020, 6, 2, 10, 0, 0, 0, time.UTC)),
+		newSnapshotName(time.Date(2020, 6, 2, 11, 0, 0, 0, time.UTC)),
+		newSnapshotName(time.Date(2020, 6, 3, 10, 0, 0, 0, time.UTC)),
+	}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the snapshot LATEST points to is never deleted, even when expired
+	if err := os.Symlink(names[0], filepath.Join(dir, "etcd.snapshot.LATEST")); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := ApplyRetention(fs, RetentionPolicy{Daily: 2})
+	if err == nil {
+		t.Fatal("expected error deleting the latest snapshot")
+	}
+	if len(deleted) != 1 || deleted[0].Name != names[1] {
+		t.Fatalf("expected only %s to be deleted, received %v", names[1], deleted)
+	}
+	snapshots, err := fs.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := make([]string, 0)
+	for _, s := range snapshots {
+		remaining = append(remaining, s.Name)
+	}
+	if diff := cmp.Diff([]string{names[0], names[2], names[3]}, remaining); diff != "" {
+		t.Fatalf("retention: remaining snapshots differ: (-want +got)\n%s", diff)
+	}
+}
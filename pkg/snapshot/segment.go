@@ -0,0 +1,376 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/lease"
+	"go.etcd.io/etcd/mvcc"
+	"go.etcd.io/etcd/mvcc/backend"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"go.etcd.io/etcd/pkg/traceutil"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
+)
+
+const segmentFilename = "etcd.segment"
+
+var (
+	ErrInvalidSegment = errors.New("invalid segment")
+	ErrSegmentGap     = errors.New("segments are missing revisions")
+)
+
+// Segment describes an incremental segment stored by a Snapshotter. A segment
+// contains every change made to the etcd keyspace after StartRevision, up to
+// and including EndRevision, so segments can be replayed in order on top of a
+// full snapshot to bring it forward to a later revision.
+//
+// Revisions alone do not identify the changes in a segment, since a cluster
+// restored to an older snapshot reuses the revisions after it for different
+// changes. Segments are therefore only replayed on top of the snapshot named
+// by Base, taken of the cluster identified by ClusterID.
+type Segment struct {
+	Name          string `json:"name"`
+	ClusterID     string `json:"clusterID"`
+	Base          string `json:"base"`
+	StartRevision int64  `json:"startRevision"`
+	EndRevision   int64  `json:"endRevision"`
+	Size          int64  `json:"size"`
+}
+
+// newSegmentName returns the name of the provided segment, which is made up
+// of the id of its base snapshot, its cluster id and the revisions it covers.
+// The revisions are zero padded so that the segments following on from the
+// same snapshot sort by revision.
+func newSegmentName(seg *Segment) (string, error) {
+	if _, ok := parseSnapshotName(seg.Base); !ok {
+		return "", errors.Wrapf(ErrInvalidSegment, "%#v is not a snapshot name", seg.Base)
+	}
+	if seg.ClusterID == "" || strings.ContainsAny(seg.ClusterID, ".-") {
+		return "", errors.Wrapf(ErrInvalidSegment, "invalid cluster id: %#v", seg.ClusterID)
+	}
+	id := strings.TrimPrefix(seg.Base, snapshotFilename+".")
+	return fmt.Sprintf("%s.%s.%s.%020d-%020d", segmentFilename, id, seg.ClusterID, seg.StartRevision, seg.EndRevision), nil
+}
+
+// parseSegmentName returns the segment with the provided name. It returns
+// false if the name is not a valid segment name.
+func parseSegmentName(name string) (*Segment, bool) {
+	if !strings.HasPrefix(name, segmentFilename+".") {
+		return nil, false
+	}
+	parts := strings.Split(strings.TrimPrefix(name, segmentFilename+"."), ".")
+	if len(parts) < 3 {
+		return nil, false
+	}
+	n := len(parts)
+	base := snapshotFilename + "." + strings.Join(parts[:n-2], ".")
+	if _, ok := parseSnapshotName(base); !ok || parts[n-2] == "" {
+		return nil, false
+	}
+	revs := strings.Split(parts[n-1], "-")
+	if len(revs) != 2 {
+		return nil, false
+	}
+	start, err := strconv.ParseInt(revs[0], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	end, err := strconv.ParseInt(revs[1], 10, 64)
+	if err != nil || end <= start {
+		return nil, false
+	}
+	return &Segment{Name: name, ClusterID: parts[n-2], Base: base, StartRevision: start, EndRevision: end}, true
+}
+
+// follows reports whether the segment follows on from the snapshot with the
+// provided name and manifest, which is nil for snapshots saved by older
+// versions of e2d.
+func (seg *Segment) follows(base string, m *Manifest) bool {
+	if seg.Base != base {
+		return false
+	}
+	return m == nil || m.ClusterID == "" || seg.ClusterID == m.ClusterID
+}
+
+func sortSegments(segments []*Segment) {
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].StartRevision == segments[j].StartRevision {
+			return segments[i].EndRevision < segments[j].EndRevision
+		}
+		return segments[i].StartRevision < segments[j].StartRevision
+	})
+}
+
+// segmentMagic starts every encoded segment, and identifies the version of
+// the encoding.
+var segmentMagic = []byte("E2DSEG1\n")
+
+// EncodeSegment encodes watch events into a segment. Each event is written
+// with its length, and the segment ends with a sha256 hash of everything
+// before it, so a truncated or corrupt segment is never replayed even when it
+// is stored without compression or encryption.
+func EncodeSegment(events []mvccpb.Event) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(segmentMagic)
+	lbuf := make([]byte, binary.MaxVarintLen64)
+	for i := range events {
+		data, err := events[i].Marshal()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(lbuf[:binary.PutUvarint(lbuf, uint64(len(data)))])
+		buf.Write(data)
+	}
+	h := sha256.Sum256(buf.Bytes())
+	buf.Write(h[:])
+	return buf.Bytes(), nil
+}
+
+// DecodeSegment decodes the watch events from a segment encoded with
+// EncodeSegment.
+func DecodeSegment(data []byte) ([]mvccpb.Event, error) {
+	if len(data) < len(segmentMagic)+sha256.Size || !bytes.HasPrefix(data, segmentMagic) {
+		return nil, errors.Wrap(ErrInvalidSegment, "unrecognized format")
+	}
+	data, expected := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if h := sha256.Sum256(data); !bytes.Equal(h[:], expected) {
+		return nil, errors.Wrap(ErrInvalidSegment, "sha256 mismatch")
+	}
+	data = data[len(segmentMagic):]
+	events := make([]mvccpb.Event, 0)
+	for len(data) > 0 {
+		l, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < l {
+			return nil, errors.Wrap(ErrInvalidSegment, "truncated event")
+		}
+		var ev mvccpb.Event
+		if err := ev.Unmarshal(data[n : n+int(l)]); err != nil {
+			return nil, errors.Wrap(ErrInvalidSegment, err.Error())
+		}
+		if ev.Kv == nil {
+			return nil, errors.Wrap(ErrInvalidSegment, "event has no key")
+		}
+		events = append(events, ev)
+		data = data[n+int(l):]
+	}
+	return events, nil
+}
+
+// ReadSegment loads the segment with the provided name from the snapshot
+// backup, decompressing and decrypting it as needed, and decodes its events.
+func ReadSegment(s Snapshotter, name string, keys *crypto.Keyring) ([]mvccpb.Event, error) {
+	r, err := s.LoadSegment(name)
+	if err != nil {
+		return nil, err
+	}
+	dec := snapshotutil.NewDecompressorReadCloser(r)
+	dec = snapshotutil.NewDecrypterReadCloser(dec, keys)
+	defer dec.Close()
+
+	data, err := ioutil.ReadAll(dec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read segment %s", name)
+	}
+	events, err := DecodeSegment(data)
+	if err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	return events, nil
+}
+
+// ReplayStatus describes the segments replayed onto a database.
+type ReplayStatus struct {
+	// Segments is the number of segments that were replayed.
+	Segments int `json:"segments"`
+
+	// StartRevision is the revision of the database before replaying, and
+	// Revision the revision it was brought forward to.
+	StartRevision int64 `json:"startRevision"`
+	Revision      int64 `json:"revision"`
+}
+
+// ReplaySegments brings the etcd database file at path, extracted from the
+// snapshot with the provided name, forward by replaying the segments that
+// follow on from that snapshot, up to and including the target revision, or
+// every stored segment when target is zero. Segments following on from any
+// other snapshot, or taken of a different cluster, are ignored. The database
+// must have already been checked with VerifyDatabase, and the provided status
+// is used for its revision. When any segment is replayed, the sha256 hash at
+// the end of the database is removed, since it no longer matches.
+//
+// Each revision is applied as a single transaction, the same as it was
+// originally, so the replayed database ends up with the same revisions. If a
+// revision is missing from the stored segments, the database is left at the
+// last revision before it and ErrSegmentGap is returned.
+//
+// Leases are not part of the watch events, so a lease granted after the
+// snapshot was taken cannot be restored. Keys attached to one are dropped, the
+// same as when a lease expires, rather than being kept forever.
+func ReplaySegments(s Snapshotter, keys *crypto.Keyring, path, base string, status *DatabaseStatus, target int64) (*ReplayStatus, error) {
+	rs := &ReplayStatus{StartRevision: status.Revision, Revision: status.Revision}
+	if target != 0 && target <= status.Revision {
+		return rs, nil
+	}
+	m, err := s.LoadManifest(base)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.ListSegments()
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]*Segment, 0)
+	for _, seg := range stored {
+		if !seg.follows(base, m) {
+			log.Debug("skipping segment, it does not follow on from the snapshot", zap.String("segment", seg.Name), zap.String("snapshot", base))
+			continue
+		}
+		if seg.EndRevision <= status.Revision || (target != 0 && seg.StartRevision >= target) {
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	if len(segments) == 0 {
+		return rs, nil
+	}
+	if status.HashChecked {
+		if err := stripDatabaseHash(path); err != nil {
+			return nil, err
+		}
+	}
+
+	be := backend.NewDefaultBackend(path)
+	defer be.Close()
+
+	// a lessor never timeouts leases
+	lessor := lease.NewLessor(zap.NewNop(), be, lease.LessorConfig{MinLeaseTTL: math.MaxInt64})
+	defer lessor.Stop()
+
+	mvs := mvcc.NewStore(zap.NewNop(), be, lessor, nil, mvcc.StoreConfig{CompactionBatchLimit: math.MaxInt32})
+	defer mvs.Close()
+	defer mvs.Commit()
+
+	for _, seg := range segments {
+		if seg.StartRevision > rs.Revision {
+			return rs, errors.Wrapf(ErrSegmentGap, "expected revision %d, next segment starts after %d", rs.Revision+1, seg.StartRevision)
+		}
+		events, err := ReadSegment(s, seg.Name, keys)
+		if err != nil {
+			return rs, err
+		}
+		log.Debug("replaying segment", zap.String("segment", seg.Name), zap.Int("events", len(events)))
+		for len(events) > 0 {
+			rev := events[0].Kv.ModRevision
+			n := 1
+			for n < len(events) && events[n].Kv.ModRevision == rev {
+				n++
+			}
+			txn := events[:n]
+			events = events[n:]
+
+			if rev <= rs.Revision {
+				continue
+			}
+			if target != 0 && rev > target {
+				break
+			}
+			if rev != rs.Revision+1 {
+				return rs, errors.Wrapf(ErrSegmentGap, "expected revision %d, segment %s continues at %d", rs.Revision+1, seg.Name, rev)
+			}
+			if err := applyRevision(mvs, lessor, txn); err != nil {
+				return rs, errors.Wrapf(err, "cannot replay revision %d", rev)
+			}
+			rs.Revision = rev
+		}
+		rs.Segments++
+		if target != 0 && rs.Revision >= target {
+			break
+		}
+	}
+	return rs, nil
+}
+
+// applyRevision applies the events of a single revision in one transaction.
+func applyRevision(mvs mvcc.KV, lessor lease.Lessor, events []mvccpb.Event) error {
+	txn := mvs.Write(traceutil.TODO())
+	for _, ev := range events {
+		switch ev.Type {
+		case mvccpb.PUT:
+			id := lease.LeaseID(ev.Kv.Lease)
+			if id != lease.NoLease && lessor.Lookup(id) == nil {
+				txn.DeleteRange(ev.Kv.Key, nil)
+				continue
+			}
+			txn.Put(ev.Kv.Key, ev.Kv.Value, id)
+		case mvccpb.DELETE:
+			txn.DeleteRange(ev.Kv.Key, nil)
+		}
+	}
+	if len(txn.Changes()) == 0 {
+		// nothing changed, e.g. when only keys that were dropped are
+		// deleted, so the key is put and deleted again to keep the revision
+		txn.Put(events[0].Kv.Key, nil, lease.NoLease)
+		txn.DeleteRange(events[0].Kv.Key, nil)
+	}
+	txn.End()
+	if rev := mvs.Rev(); rev != events[0].Kv.ModRevision {
+		return errors.Errorf("database is at revision %d after replaying", rev)
+	}
+	return nil
+}
+
+// stripDatabaseHash removes the sha256 hash from the end of the database file
+// at path.
+func stripDatabaseHash(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !hasDatabaseHash(info.Size()) {
+		return nil
+	}
+	return os.Truncate(path, info.Size()-sha256.Size)
+}
+
+// PruneSegments deletes the stored segments following on from a snapshot that
+// is no longer stored, since they can never be replayed. Segments are kept as
+// long as their snapshot, so any stored snapshot can be restored up to a
+// later revision.
+func PruneSegments(s Snapshotter) ([]*Segment, error) {
+	segments, err := s.ListSegments()
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]bool)
+	for _, snap := range snapshots {
+		stored[snap.Name] = true
+	}
+	pruned := make([]*Segment, 0)
+	for _, seg := range segments {
+		if stored[seg.Base] {
+			continue
+		}
+		if err := s.DeleteSegment(seg.Name); err != nil && errors.Cause(err) != ErrSnapshotNotFound {
+			return pruned, err
+		}
+		pruned = append(pruned, seg)
+	}
+	return pruned, nil
+}
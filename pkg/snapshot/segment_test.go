@@ -0,0 +1,210 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/lease"
+	"go.etcd.io/etcd/mvcc"
+	"go.etcd.io/etcd/mvcc/backend"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
+)
+
+func TestSegmentName(t *testing.T) {
+	newName := func(start, end int64) string {
+		t.Helper()
+		name, err := newSegmentName(&Segment{ClusterID: "cdf818194e3a8c32", Base: "etcd.snapshot.1591012800.000000001", StartRevision: start, EndRevision: end})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	name := newName(9, 10)
+	seg, ok := parseSegmentName(name)
+	if !ok || seg.StartRevision != 9 || seg.EndRevision != 10 {
+		t.Fatalf("cannot parse segment name %q: %v", name, seg)
+	}
+	if seg.Base != "etcd.snapshot.1591012800.000000001" || seg.ClusterID != "cdf818194e3a8c32" {
+		t.Fatalf("unexpected base snapshot or cluster id parsed from %q: %v", name, seg)
+	}
+	if newName(9, 10) > newName(10, 11) {
+		t.Fatal("expected segment names to sort by revision")
+	}
+	for _, seg := range []*Segment{{ClusterID: "abc", Base: "etcd.segment.1"}, {Base: "etcd.snapshot.1591012800"}, {ClusterID: "a.b", Base: "etcd.snapshot.1591012800"}} {
+		if _, err := newSegmentName(seg); errors.Cause(err) != ErrInvalidSegment {
+			t.Errorf("expected ErrInvalidSegment for %+v, received %v", seg, err)
+		}
+	}
+	for _, name := range []string{
+		"etcd.snapshot.1591012800",
+		"etcd.segment.10",
+		"etcd.segment.10-9",
+		"etcd.segment.a-b",
+		"etcd.segment.1591012800.abc.10-9",
+		"etcd.segment.x.abc.9-10",
+		"etcd.segment.1591012800..9-10",
+	} {
+		if _, ok := parseSegmentName(name); ok {
+			t.Errorf("expected %q to not be a segment name", name)
+		}
+	}
+}
+
+func TestEncodeSegment(t *testing.T) {
+	events := []mvccpb.Event{
+		{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte("a"), Value: []byte("1"), ModRevision: 2}},
+		{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte("b"), ModRevision: 3}},
+	}
+	data, err := EncodeSegment(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeSegment(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[1].Type != mvccpb.DELETE || string(decoded[0].Kv.Value) != "1" {
+		t.Fatalf("unexpected events decoded: %v", decoded)
+	}
+	for _, data := range [][]byte{data[:len(data)-1], append([]byte("E2DSEG0\n"), data[8:]...)} {
+		if _, err := DecodeSegment(data); errors.Cause(err) != ErrInvalidSegment {
+			t.Fatalf("expected ErrInvalidSegment, received %v", err)
+		}
+	}
+}
+
+// newTestStore writes the provided keys to a new etcd database at path, one
+// revision each, and returns the revision of the database.
+func newTestStore(t *testing.T, path string, keys ...string) int64 {
+	t.Helper()
+
+	be := backend.NewDefaultBackend(path)
+	defer be.Close()
+	lessor := lease.NewLessor(zap.NewNop(), be, lease.LessorConfig{MinLeaseTTL: math.MaxInt64})
+	defer lessor.Stop()
+	mvs := mvcc.NewStore(zap.NewNop(), be, lessor, nil, mvcc.StoreConfig{})
+	defer mvs.Close()
+	for _, k := range keys {
+		mvs.Put([]byte(k), []byte(k), lease.NoLease)
+	}
+	mvs.Commit()
+	return mvs.Rev()
+}
+
+func TestReplaySegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "db")
+	rev := newTestStore(t, path, "a", "b")
+	s, err := NewFileSnapshotter(filepath.Join(dir, "snapshots"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &Manifest{ClusterID: "abc"}
+	if err := s.Save(context.Background(), f, base); err != nil {
+		t.Fatal(err)
+	}
+	key := crypto.NewEncryptionKey()
+	save := func(seg *Segment, events ...mvccpb.Event) {
+		t.Helper()
+		data, err := EncodeSegment(events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := snapshotutil.NewEncrypterReadCloser(ioutil.NopCloser(bytes.NewReader(data)), crypto.NewKeyring(key))
+		defer r.Close()
+		if err := s.SaveSegment(context.Background(), r, seg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segment := func(start, end int64) *Segment {
+		return &Segment{ClusterID: "abc", Base: base.Name, StartRevision: start, EndRevision: end}
+	}
+	put := func(k string, rev int64) mvccpb.Event {
+		return mvccpb.Event{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte(k), Value: []byte(k + "2"), ModRevision: rev}}
+	}
+	leased := func(k string, rev int64) mvccpb.Event {
+		ev := put(k, rev)
+		ev.Kv.Lease = 1
+		return ev
+	}
+	del := func(k string, rev int64) mvccpb.Event {
+		return mvccpb.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: []byte(k), ModRevision: rev}}
+	}
+
+	// the first segment overlaps with the database, and the second has a
+	// transaction with more than one change, one of which is attached to a
+	// lease that is not in the database, so the key is dropped before it is
+	// deleted again
+	save(segment(rev-1, rev+1), put("b", rev), put("a", rev+1))
+	save(segment(rev+1, rev+4), del("b", rev+2), leased("c", rev+3), put("d", rev+3), del("c", rev+4))
+	save(segment(rev+5, rev+6), put("e", rev+6))
+
+	// segments following on from another snapshot, or taken of another
+	// cluster, reuse the same revisions but must never be replayed
+	stale := segment(rev, rev+2)
+	stale.Base = "etcd.snapshot.1591012800.000000000"
+	save(stale, put("x", rev+1), put("y", rev+2))
+	other := segment(rev+3, rev+5)
+	other.ClusterID = "def"
+	save(other, put("x", rev+4), put("y", rev+5))
+
+	read := func() map[string]string {
+		t.Helper()
+		be := backend.NewDefaultBackend(path)
+		defer be.Close()
+		lessor := lease.NewLessor(zap.NewNop(), be, lease.LessorConfig{MinLeaseTTL: math.MaxInt64})
+		defer lessor.Stop()
+		mvs := mvcc.NewStore(zap.NewNop(), be, lessor, nil, mvcc.StoreConfig{})
+		defer mvs.Close()
+		res, err := mvs.Range([]byte{0}, []byte{0xff}, mvcc.RangeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		kvs := make(map[string]string)
+		for _, kv := range res.KVs {
+			kvs[string(kv.Key)] = string(kv.Value)
+		}
+		return kvs
+	}
+	rs, err := ReplaySegments(s, crypto.NewKeyring(key), path, base.Name, &DatabaseStatus{Revision: rev}, rev+2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Revision != rev+2 || rs.Segments != 2 {
+		t.Fatalf("unexpected replay status: %+v", rs)
+	}
+	if kvs := read(); len(kvs) != 1 || kvs["a"] != "a2" {
+		t.Fatalf("unexpected keys after replay: %v", kvs)
+	}
+
+	// the last segment does not follow on, so the replay stops before it
+	rs, err = ReplaySegments(s, crypto.NewKeyring(key), path, base.Name, &DatabaseStatus{Revision: rev + 2}, 0)
+	if errors.Cause(err) != ErrSegmentGap {
+		t.Fatalf("expected ErrSegmentGap, received %v", err)
+	}
+	if rs.Revision != rev+4 {
+		t.Fatalf("unexpected replay status: %+v", rs)
+	}
+	if kvs := read(); len(kvs) != 2 || kvs["d"] != "d2" {
+		t.Fatalf("unexpected keys after replay: %v", kvs)
+	}
+}
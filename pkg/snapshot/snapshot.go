@@ -1,23 +1,177 @@
 package snapshot
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/url"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 type Snapshotter interface {
+	// Load returns the latest snapshot. If the snapshot has a manifest, the
+	// returned reader fails with ErrChecksumMismatch once fully read if the
+	// snapshot does not match it.
 	Load() (io.ReadCloser, error)
-	Save(io.ReadCloser) error
+
+	// Latest returns the name of the latest snapshot, which is the snapshot
+	// returned by Load, or ErrSnapshotNotFound if there is none.
+	Latest() (string, error)
+
+	// LoadAt returns the snapshot identified by id, which is either the name
+	// of a snapshot or a point in time (see Find). It is verified against its
+	// manifest in the same way as Load.
+	LoadAt(id string) (io.ReadCloser, error)
+
+	// List returns all stored snapshots, ordered from oldest to newest.
+	List() ([]*Snapshot, error)
+
+	// Delete removes the snapshot with the provided name, along with its
+	// manifest. The snapshot that LATEST points to cannot be deleted.
+	Delete(name string) error
+
+	// Save stores a new snapshot along with its manifest. The provided
+	// manifest is updated with the name, size and checksum of the stored
+	// snapshot. The snapshot is streamed, so the context must allow enough
+	// time for the entire snapshot to be uploaded.
+	Save(context.Context, io.ReadCloser, *Manifest) error
+
+	// LoadManifest returns the manifest of the snapshot with the provided
+	// name, or nil if it was saved by an older version of e2d without one.
+	LoadManifest(name string) (*Manifest, error)
+
+	// Replace overwrites the stored snapshot named by the provided manifest,
+	// e.g. to re-encrypt it, keeping the time it was created. The manifest is
+	// updated with the size and checksum of the new snapshot and saved along
+	// with it. Neither LATEST nor retention are affected.
+	Replace(context.Context, io.ReadCloser, *Manifest) error
+
+	// SaveSegment stores an incremental segment, named after its base
+	// snapshot, cluster and the revisions it covers, alongside the snapshots.
+	// The size of the provided segment is used as a hint in the same way as
+	// Manifest.DatabaseSize, and is updated along with its name once stored.
+	SaveSegment(context.Context, io.Reader, *Segment) error
+
+	// ListSegments returns all stored segments, ordered by revision.
+	ListSegments() ([]*Segment, error)
+
+	// LoadSegment returns the segment with the provided name.
+	LoadSegment(name string) (io.ReadCloser, error)
+
+	// DeleteSegment removes the segment with the provided name.
+	DeleteSegment(name string) error
+}
+
+// Snapshot describes a snapshot stored by a Snapshotter.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	Size      int64     `json:"size"`
+}
+
+// newSnapshotName returns the name of a snapshot taken at time t. The name
+// includes the nanoseconds, zero-padded so names still sort by time, since
+// more than one snapshot can be taken within the same second.
+func newSnapshotName(t time.Time) string {
+	return fmt.Sprintf("%s.%d.%09d", snapshotFilename, t.Unix(), t.Nanosecond())
+}
+
+// parseSnapshotName returns the time a snapshot was taken from its name. It
+// returns false if the name is not a valid snapshot name. Names of snapshots
+// saved by older versions only include the seconds, and are still accepted.
+func parseSnapshotName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, snapshotFilename+".") {
+		return time.Time{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, snapshotFilename+"."), ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var nsec int64
+	if len(parts) == 2 {
+		if len(parts[1]) != 9 {
+			return time.Time{}, false
+		}
+		nsec, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || nsec < 0 {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(sec, nsec).UTC(), true
+}
+
+func sortSnapshots(snapshots []*Snapshot) {
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp.Before(snapshots[j].Timestamp)
+	})
+}
+
+// Find returns the snapshot identified by id. The id can be the exact name of
+// a snapshot (e.g. etcd.snapshot.1591012800.000000000), or a point in time
+// given as either a unix timestamp or RFC3339 time, in which case the newest
+// snapshot taken at or before that time is returned. Given the same list of
+// snapshots, the result is always the same.
+func Find(snapshots []*Snapshot, id string) (*Snapshot, error) {
+	for _, s := range snapshots {
+		if s.Name == id {
+			return s, nil
+		}
+	}
+	var t time.Time
+	if sec, err := strconv.ParseInt(id, 10, 64); err == nil {
+		t = time.Unix(sec, 0)
+	} else if t, err = time.Parse(time.RFC3339, id); err != nil {
+		return nil, errors.Wrapf(ErrSnapshotNotFound, "%#v is not a snapshot name, unix timestamp or RFC3339 time", id)
+	}
+	if t.Nanosecond() == 0 {
+		// snapshots taken within the given second count as taken at it
+		t = t.Add(time.Second - 1)
+	}
+	var found *Snapshot
+	for _, s := range snapshots {
+		if s.Timestamp.After(t) {
+			continue
+		}
+		if found == nil || s.Timestamp.After(found.Timestamp) {
+			found = s
+		}
+	}
+	if found == nil {
+		return nil, errors.Wrapf(ErrSnapshotNotFound, "no snapshot taken at or before %s", t.UTC().Format(time.RFC3339))
+	}
+	return found, nil
+}
+
+// Resolve returns the name of the snapshot identified by id (see Find), or of
+// the latest snapshot when id is empty.
+func Resolve(s Snapshotter, id string) (string, error) {
+	if id == "" {
+		return s.Latest()
+	}
+	snapshots, err := s.List()
+	if err != nil {
+		return "", err
+	}
+	snap, err := Find(snapshots, id)
+	if err != nil {
+		return "", err
+	}
+	return snap.Name, nil
 }
 
 var schemes = []string{
 	"file://",
 	"s3://",
+	"gs://",
+	"azblob://",
 	"http://",
 	"https://",
 }
@@ -36,6 +190,9 @@ type Type int
 const (
 	FileType Type = iota
 	S3Type
+	GCSType
+	AzureType
+	HTTPType
 )
 
 const snapshotFilename = "etcd.snapshot"
@@ -45,16 +202,27 @@ type URL struct {
 	Type   Type
 	Bucket string
 	Path   string
+
+	// Endpoint is the scheme and host for storage addressed by http(s) url,
+	// either an S3-compatible service like DigitalOcean Spaces, or a generic
+	// HTTP object server.
+	Endpoint string
 }
 
+// digitalOceanSpacesDomain is the domain of the DigitalOcean Spaces
+// S3-compatible endpoints, e.g. nyc3.digitaloceanspaces.com.
+const digitalOceanSpacesDomain = ".digitaloceanspaces.com"
+
 var (
-	ErrInvalidScheme  = errors.New("invalid scheme")
+	ErrInvalidScheme        = errors.New("invalid scheme")
 	ErrInvalidDirectoryPath = errors.New("path must be a directory")
-	ErrCannotParseURL = errors.New("cannot parse url")
+	ErrCannotParseURL       = errors.New("cannot parse url")
+	ErrSnapshotNotFound     = errors.New("snapshot not found")
+	ErrSnapshotInUse        = errors.New("snapshot is the latest snapshot")
 )
 
 type LatestFile struct {
-	Path	string
+	Path      string
 	Timestamp string
 }
 
@@ -69,8 +237,13 @@ func (l *LatestFile) read(input []byte) error {
 
 // ParseSnapshotBackupURL deconstructs a uri into a type prefix and a bucket
 // example inputs and outputs:
-//   file://file                                -> file://, file
-//   s3://bucket                                -> s3://, bucket
+//
+//	file://file                                -> file://, file
+//	s3://bucket                                -> s3://, bucket
+//	gs://bucket/path/                          -> gs://, bucket, path/
+//	azblob://container/path/                   -> azblob://, container, path/
+//	https://nyc3.digitaloceanspaces.com/bucket -> s3://, bucket (with endpoint)
+//	https://host/path/                         -> https://, path/ (with endpoint)
 func ParseSnapshotBackupURL(s string) (*URL, error) {
 	if !hasValidScheme(s) {
 		return nil, errors.Wrapf(ErrInvalidScheme, "url does not specify valid scheme: %#v", s)
@@ -89,16 +262,51 @@ func ParseSnapshotBackupURL(s string) (*URL, error) {
 			Type: FileType,
 			Path: filepath.Join(u.Host, u.Path),
 		}, nil
-	case "s3":
+	case "s3", "gs", "azblob":
 		path := strings.TrimPrefix(u.Path, "/")
 		if !strings.HasSuffix(path, "/") && path != "" {
 			return nil, ErrInvalidDirectoryPath
 		}
+		t := S3Type
+		switch strings.ToLower(u.Scheme) {
+		case "gs":
+			t = GCSType
+		case "azblob":
+			t = AzureType
+		}
 		return &URL{
-			Type:   S3Type,
+			Type:   t,
 			Bucket: u.Host,
 			Path:   path,
 		}, nil
+	case "http", "https":
+		if !strings.HasSuffix(strings.ToLower(u.Hostname()), digitalOceanSpacesDomain) {
+			if !strings.HasSuffix(u.Path, "/") {
+				return nil, ErrInvalidDirectoryPath
+			}
+			return &URL{
+				Type:     HTTPType,
+				Path:     strings.TrimPrefix(u.Path, "/"),
+				Endpoint: u.Scheme + "://" + u.Host,
+			}, nil
+		}
+		parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+		if parts[0] == "" {
+			return nil, errors.Wrapf(ErrCannotParseURL, "url must include a bucket: %#v", s)
+		}
+		path := ""
+		if len(parts) == 2 {
+			path = parts[1]
+		}
+		if !strings.HasSuffix(path, "/") && path != "" {
+			return nil, ErrInvalidDirectoryPath
+		}
+		return &URL{
+			Type:     S3Type,
+			Bucket:   parts[0],
+			Path:     path,
+			Endpoint: "https://" + u.Host,
+		}, nil
 	}
 	return nil, errors.Wrap(ErrCannotParseURL, s)
 }
@@ -4,64 +4,127 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"go.uber.org/zap"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/criticalstack/e2d/pkg/log"
 	e2daws "github.com/criticalstack/e2d/pkg/provider/aws"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 )
 
-func newAWSConfig(name string) (*aws.Config, error) {
-	if name != "" {
-		return e2daws.NewConfigWithRoleSession(name)
+// newAWSConfig returns the config for the credentials of the role session, or
+// the default credential chain, with the region, endpoint and addressing style
+// overridden as configured.
+func newAWSConfig(cfg *AmazonConfig) (*aws.Config, error) {
+	if cfg.RoleSessionName != "" && cfg.AccessKey != "" {
+		return nil, errors.New("cannot use both a role session and static credentials")
+	}
+	var awsCfg *aws.Config
+	switch {
+	case cfg.RoleSessionName != "":
+		var err error
+		awsCfg, err = e2daws.NewConfigWithRoleSession(cfg.RoleSessionName)
+		if err != nil {
+			return nil, err
+		}
+	case cfg.Endpoint == "" && cfg.Region == "":
+		var err error
+		awsCfg, err = e2daws.NewConfig()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		// the region does not need to be found with the EC2 instance
+		// metadata, so this also works outside of EC2
+		awsCfg = &aws.Config{}
+	}
+	if cfg.Region != "" {
+		awsCfg.Region = aws.String(cfg.Region)
+	}
+	if aws.StringValue(awsCfg.Region) == "" {
+		// S3-compatible services generally ignore the region, but the SDK
+		// requires one to sign requests
+		awsCfg.Region = aws.String("us-east-1")
+	}
+	if cfg.Endpoint != "" {
+		awsCfg.Endpoint = aws.String(cfg.Endpoint)
+		awsCfg.S3ForcePathStyle = aws.Bool(cfg.ForcePathStyle)
+	}
+	if cfg.AccessKey != "" {
+		awsCfg.Credentials = credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, "")
 	}
-	return e2daws.NewConfig()
+	return awsCfg, nil
 }
 
 type AmazonConfig struct {
 	RoleSessionName string
 	Bucket          string
 	Key             string
-	RetentionDays   int64
+	RetentionTime   time.Duration
+
+	// Endpoint is the url of an S3-compatible service (e.g. DigitalOcean
+	// Spaces, MinIO or Ceph RGW) to use instead of AWS S3.
+	Endpoint string
+
+	// Region overrides the region, which is otherwise found with the EC2
+	// instance metadata, or defaults to us-east-1 when Endpoint is set.
+	Region string
+
+	// ForcePathStyle addresses buckets as part of the url path
+	// (<endpoint>/<bucket>/<key>) rather than the host name, which is needed
+	// by most self-hosted S3-compatible services.
+	ForcePathStyle bool
+
+	// AccessKey and SecretKey are static credentials to use instead of the
+	// default credential chain.
+	AccessKey string
+	SecretKey string
 }
 
+// s3MaxUploadPartSize is the largest part S3 accepts in a multipart upload.
+const s3MaxUploadPartSize = 5 * 1024 * 1024 * 1024
+
 type AmazonSnapshotter struct {
 	*s3.S3
 	*s3manager.Downloader
 	*s3manager.Uploader
 
-	bucket, key string
+	bucket, key   string
+	retentionTime time.Duration
 }
 
 func NewAmazonSnapshotter(cfg *AmazonConfig) (*AmazonSnapshotter, error) {
-	awsCfg, err := newAWSConfig(cfg.RoleSessionName)
+	awsCfg, err := newAWSConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return newAmazonSnapshotter(awsCfg, cfg.Bucket, cfg.Key, cfg.RetentionDays)
+	return newAmazonSnapshotter(awsCfg, cfg.Bucket, cfg.Key, cfg.RetentionTime)
 }
 
-func newAmazonSnapshotter(cfg *aws.Config, bucket, key string, retentionDays int64) (*AmazonSnapshotter, error) {
+func newAmazonSnapshotter(cfg *aws.Config, bucket, key string, retentionTime time.Duration) (*AmazonSnapshotter, error) {
 	sess, err := session.NewSession(cfg)
 	if err != nil {
 		return nil, err
 	}
 	s3conn := s3.New(sess)
 	s := &AmazonSnapshotter{
-		S3:         s3conn,
-		Downloader: s3manager.NewDownloader(sess),
-		Uploader:   s3manager.NewUploader(sess),
-		bucket:     bucket,
-		key:        key,
+		S3:            s3conn,
+		Downloader:    s3manager.NewDownloader(sess),
+		Uploader:      s3manager.NewUploader(sess),
+		bucket:        bucket,
+		key:           key,
+		retentionTime: retentionTime,
 	}
 
 	// Ensure that the bucket exists
@@ -80,104 +143,249 @@ func newAmazonSnapshotter(cfg *aws.Config, bucket, key string, retentionDays int
 				return nil, errors.Errorf("bucket could not be accessed: %v", err)
 			}
 		}
+		return nil, errors.Errorf("bucket could not be accessed: %v", err)
 	}
 
-	// optionally setup retention
-	if retentionDays > 0 {
-		// TODO: figure out how to prevent deleting snapshots from s3 if etcd hasn't written a snapshot in a while
-		input := &s3.PutBucketLifecycleConfigurationInput{
-			Bucket: aws.String(bucket),
-			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
-				Rules: []*s3.LifecycleRule{
-					{
-						Expiration: &s3.LifecycleExpiration{
-							Days: aws.Int64(retentionDays),
-						},
-						Filter: &s3.LifecycleRuleFilter{
-							Prefix: aws.String(key),
-						},
-						ID:     aws.String(fmt.Sprintf("E2DLifecycle-%s", key)),
-						Status: aws.String("Enabled"),
-					},
-				},
-			},
-		}
-
-		_, err := s3conn.PutBucketLifecycleConfiguration(input)
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to put bucket lifecycle policy")
-		}
+	// The lifecycle rule created by older versions of e2d expires every
+	// object under the key, including the latest snapshot, so it is removed
+	// now that retention is applied by e2d instead.
+	if err := s.removeLegacyLifecycleRule(); err != nil {
+		log.Warn("cannot remove bucket lifecycle rule created by an older version of e2d, it will expire every snapshot including the latest and should be deleted manually",
+			zap.String("bucket", bucket),
+			zap.String("rule", legacyLifecycleRuleID(key)),
+			zap.Error(err),
+		)
 	}
-
 	return s, nil
 }
 
-func (s *AmazonSnapshotter) Load() (io.ReadCloser, error) {
-	tmpFile, err := ioutil.TempFile("", "snapshot.download")
+// legacyLifecycleRuleID returns the ID of the bucket lifecycle rule older
+// versions of e2d created to expire the snapshots stored under key.
+func legacyLifecycleRuleID(key string) string {
+	return fmt.Sprintf("E2DLifecycle-%s", key)
+}
+
+// removeLegacyLifecycleRule removes the lifecycle rule created by older
+// versions of e2d from the bucket, keeping any other rules.
+func (s *AmazonSnapshotter) removeLegacyLifecycleRule() error {
+	out, err := s.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.bucket),
+	})
 	if err != nil {
-		return nil, err
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchLifecycleConfiguration" {
+			return nil
+		}
+		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-	defer cancel()
+	id := legacyLifecycleRuleID(s.key)
+	rules := make([]*s3.LifecycleRule, 0)
+	for _, rule := range out.Rules {
+		if aws.StringValue(rule.ID) != id {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == len(out.Rules) {
+		return nil
+	}
+	log.Info("removing bucket lifecycle rule created by an older version of e2d",
+		zap.String("bucket", s.bucket),
+		zap.String("rule", id),
+	)
+	if len(rules) == 0 {
+		_, err := s.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(s.bucket),
+		})
+		return err
+	}
+	_, err = s.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: rules,
+		},
+	})
+	return err
+}
 
+func (s *AmazonSnapshotter) Load() (io.ReadCloser, error) {
+	return loadLatest(s)
+}
+
+func (s *AmazonSnapshotter) Latest() (string, error) {
+	return resolveLatest(s)
+}
+
+// latest downloads the latest snapshot pointer file.
+func (s *AmazonSnapshotter) latest(ctx context.Context) (*LatestFile, error) {
 	// generate the filename to the snapshot pointer file
 	latestPath := s.key + fmt.Sprintf("%s.%s", snapshotFilename, latestSuffix)
 
-	// download the latest snapshot pointer file
-	var latestFilePath string
 	buf := aws.NewWriteAtBuffer([]byte{})
-	if _, err = s.DownloadWithContext(ctx, buf, &s3.GetObjectInput{
+	if _, err := s.DownloadWithContext(ctx, buf, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(latestPath),
-	}); err == nil {
-		l := &LatestFile{}
-		err := l.read(buf.Bytes())
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to unmarshal latest backup pointer file")
+	}); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, errors.Wrap(ErrSnapshotNotFound, "unable to retrieve latest backup pointer file")
 		}
-		log.Debug("Received latestFile", zap.String("path", l.Path), zap.String("timestamp", l.Timestamp))
-		latestFilePath = l.Path
-	} else {
 		return nil, errors.Wrap(err, "unable to retrieve latest backup pointer file")
 	}
+	l := &LatestFile{}
+	if err := l.read(buf.Bytes()); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal latest backup pointer file")
+	}
+	log.Debug("Received latestFile", zap.String("path", l.Path), zap.String("timestamp", l.Timestamp))
+	return l, nil
+}
+
+func (s *AmazonSnapshotter) LoadAt(id string) (io.ReadCloser, error) {
+	return loadAt(s, id)
+}
 
-	// download the latest snapshot
-	if _, err = s.DownloadWithContext(ctx, tmpFile, &s3.GetObjectInput{
+func (s *AmazonSnapshotter) latestName(ctx context.Context) (string, error) {
+	l, err := s.latest(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(l.Path, s.key), nil
+}
+
+func (s *AmazonSnapshotter) openSnapshot(ctx context.Context, name string) (io.ReadCloser, error) {
+	out, err := s.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(latestFilePath),
+		Key:    aws.String(s.key + name),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return nil, errors.Wrapf(err, "cannot download file: %v", s.key+name)
+	}
+	return out.Body, nil
+}
+
+func (s *AmazonSnapshotter) readManifest(ctx context.Context, name string) (*Manifest, error) {
+	buf := aws.NewWriteAtBuffer([]byte{})
+	if _, err := s.DownloadWithContext(ctx, buf, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key + manifestName(name)),
 	}); err != nil {
-		tmpFile.Close()
-		return nil, errors.Wrapf(err, "cannot download file: %v", s.key)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "cannot download manifest for snapshot %s", name)
 	}
-	if _, err := tmpFile.Seek(0, 0); err != nil {
-		return nil, err
+	m := &Manifest{}
+	if err := m.read(buf.Bytes()); err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal manifest for snapshot %s", name)
 	}
-	return tmpFile, nil
+	return m, nil
 }
 
-func (s *AmazonSnapshotter) Save(r io.ReadCloser) error {
-	defer r.Close()
+func (s *AmazonSnapshotter) List() ([]*Snapshot, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
+	snapshots := make([]*Snapshot, 0)
+	err := s.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key + snapshotFilename + "."),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(obj.Key), s.key)
+			t, ok := parseSnapshotName(name)
+			if !ok {
+				continue
+			}
+			snapshots = append(snapshots, &Snapshot{
+				Name:      name,
+				Timestamp: t,
+				Size:      aws.Int64Value(obj.Size),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list snapshots")
+	}
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+func (s *AmazonSnapshotter) Delete(name string) error {
+	return deleteSnapshot(s, name)
+}
+
+func (s *AmazonSnapshotter) removeSnapshot(ctx context.Context, name string) error {
+	out, err := s.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Delete: &s3.Delete{
+			Objects: []*s3.ObjectIdentifier{
+				{Key: aws.String(s.key + name)},
+				{Key: aws.String(s.key + manifestName(name))},
+			},
+			Quiet: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot delete snapshot %s", name)
+	}
+	if len(out.Errors) > 0 {
+		return errors.Errorf("cannot delete %s: %s", aws.StringValue(out.Errors[0].Key), aws.StringValue(out.Errors[0].Message))
+	}
+	return nil
+}
+
+func (s *AmazonSnapshotter) Save(ctx context.Context, r io.ReadCloser, m *Manifest) error {
+	defer r.Close()
+
+	if m == nil {
+		m = &Manifest{}
+	}
+
 	// generate the filenames
 	backupTimestamp := time.Now().UTC()
-	snapshotPath := s.key + fmt.Sprintf("%s.%d", snapshotFilename, backupTimestamp.Unix())
+	name := newSnapshotName(backupTimestamp)
+	snapshotPath := s.key + name
 	latestPath := s.key + fmt.Sprintf("%s.%s", snapshotFilename, latestSuffix)
 
-	// upload the snapshot itself
+	// upload the snapshot itself, with parts large enough to fit the
+	// expected size, and retrying each part rather than the entire upload
+	hr := newHashingReader(r)
 	_, err := s.UploadWithContext(ctx, &s3manager.UploadInput{
-		Body:   r,
+		Body:   hr,
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(snapshotPath),
+	}, func(u *s3manager.Uploader) {
+		u.PartSize = partSize(m.DatabaseSize, s3manager.MinUploadPartSize, s3MaxUploadPartSize, s3manager.MaxUploadParts)
+		u.RequestOptions = append(u.RequestOptions, func(r *request.Request) {
+			r.Retryer = client.DefaultRetryer{NumMaxRetries: uploadAttempts - 1}
+		})
 	})
+	if err != nil {
+		return errors.Wrap(err, "cannot upload snapshot")
+	}
+
+	// upload the manifest before the latest pointer file, so the latest
+	// snapshot always has one
+	m.Name = name
+	m.Created = backupTimestamp
+	hr.finish(m)
+	manifestContent, err := m.generate()
 	if err != nil {
 		return err
 	}
+	_, err = s.UploadWithContext(ctx, &s3manager.UploadInput{
+		Body:   bytes.NewReader(manifestContent),
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key + manifestName(name)),
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot upload snapshot manifest")
+	}
 
 	// upload the latest snapshot pointer file
 	latestFile := &LatestFile{
-		Path: snapshotPath,
+		Path:      snapshotPath,
 		Timestamp: backupTimestamp.Format("2006-01-02T15:04:05-0700"),
 	}
 	latestContent, err := latestFile.generate()
@@ -185,9 +393,151 @@ func (s *AmazonSnapshotter) Save(r io.ReadCloser) error {
 		return err
 	}
 	_, err = s.UploadWithContext(ctx, &s3manager.UploadInput{
-		Body:	bytes.NewReader(latestContent),
+		Body:   bytes.NewReader(latestContent),
 		Bucket: aws.String(s.bucket),
-		Key:	aws.String(latestPath),
+		Key:    aws.String(latestPath),
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot upload latest backup pointer file")
+	}
+
+	// purge old snapshots
+	if s.retentionTime > 0 {
+		if err := pruneSnapshots(s, name, backupTimestamp.Add(-s.retentionTime)); err != nil {
+			log.Warn("cannot prune snapshots", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *AmazonSnapshotter) LoadManifest(name string) (*Manifest, error) {
+	if _, ok := parseSnapshotName(name); !ok {
+		return nil, errors.Wrapf(ErrSnapshotNotFound, "%#v is not a snapshot name", name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	return s.readManifest(ctx, name)
+}
+
+// Replace replaces the snapshot (see replaceSnapshot). The new snapshot is
+// copied into place through this process, since a single copy request is
+// limited to 5GB.
+func (s *AmazonSnapshotter) Replace(ctx context.Context, r io.ReadCloser, m *Manifest) error {
+	defer r.Close()
+	return replaceSnapshot(ctx, s, r, m)
+}
+
+func (s *AmazonSnapshotter) putObject(ctx context.Context, name string, r io.Reader, sizeHint int64) error {
+	_, err := s.UploadWithContext(ctx, &s3manager.UploadInput{
+		Body:   r,
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key + name),
+	}, func(u *s3manager.Uploader) {
+		u.PartSize = partSize(sizeHint, s3manager.MinUploadPartSize, s3MaxUploadPartSize, s3manager.MaxUploadParts)
+		u.RequestOptions = append(u.RequestOptions, func(r *request.Request) {
+			r.Retryer = client.DefaultRetryer{NumMaxRetries: uploadAttempts - 1}
+		})
 	})
 	return err
 }
+
+func (s *AmazonSnapshotter) moveObject(ctx context.Context, src, dst string, size int64) error {
+	if err := copyObject(ctx, s, src, dst, size); err != nil {
+		return err
+	}
+	if _, err := s.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key + src),
+	}); err != nil {
+		return errors.Wrapf(err, "cannot delete %s", src)
+	}
+	return nil
+}
+
+func (s *AmazonSnapshotter) SaveSegment(ctx context.Context, r io.Reader, seg *Segment) error {
+	name, err := newSegmentName(seg)
+	if err != nil {
+		return err
+	}
+	seg.Name = name
+	hr := newHashingReader(r)
+	_, err = s.UploadWithContext(ctx, &s3manager.UploadInput{
+		Body:   hr,
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key + seg.Name),
+	}, func(u *s3manager.Uploader) {
+		u.RequestOptions = append(u.RequestOptions, func(r *request.Request) {
+			r.Retryer = client.DefaultRetryer{NumMaxRetries: uploadAttempts - 1}
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot upload segment")
+	}
+	seg.Size = hr.n
+	return nil
+}
+
+func (s *AmazonSnapshotter) ListSegments() ([]*Segment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	segments := make([]*Segment, 0)
+	err := s.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key + segmentFilename + "."),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			seg, ok := parseSegmentName(strings.TrimPrefix(aws.StringValue(obj.Key), s.key))
+			if !ok {
+				continue
+			}
+			seg.Size = aws.Int64Value(obj.Size)
+			segments = append(segments, seg)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list segments")
+	}
+	sortSegments(segments)
+	return segments, nil
+}
+
+func (s *AmazonSnapshotter) LoadSegment(name string) (io.ReadCloser, error) {
+	if _, ok := parseSegmentName(name); !ok {
+		return nil, errors.Wrapf(ErrSnapshotNotFound, "%#v is not a segment name", name)
+	}
+	r, err := openStream(func(ctx context.Context) (io.ReadCloser, error) {
+		out, err := s.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.key + name),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out.Body, nil
+	})
+	if err != nil {
+		if aerr, ok := errors.Cause(err).(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return nil, errors.Wrapf(err, "cannot download segment %s", name)
+	}
+	return r, nil
+}
+
+func (s *AmazonSnapshotter) DeleteSegment(name string) error {
+	if _, ok := parseSegmentName(name); !ok {
+		return errors.Wrapf(ErrSnapshotNotFound, "%#v is not a segment name", name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	if _, err := s.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key + name),
+	}); err != nil {
+		return errors.Wrapf(err, "cannot delete segment %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	azureAPIVersion = "2019-12-12"
+
+	// azureMinBlockSize and azureMaxBlockSize bound the size of each block
+	// uploaded with Put Block, and blobs can have up to azureMaxBlocks blocks.
+	// The block size is chosen from the size hint so that large snapshots
+	// still fit.
+	azureMinBlockSize = 4 * 1024 * 1024
+	azureMaxBlockSize = 100 * 1024 * 1024
+	azureMaxBlocks    = 50000
+)
+
+type AzureConfig struct {
+	Account   string
+	Container string
+	Key       string
+
+	// AccountKey is the base64 encoded shared key for the storage account.
+	// Either AccountKey or SASToken must be provided.
+	AccountKey string
+
+	// SASToken is a shared access signature with read, write, delete and list
+	// permissions on the container.
+	SASToken string
+
+	// Endpoint overrides the blob service endpoint of the storage account,
+	// which defaults to https://<account>.blob.core.windows.net. This can be
+	// used with emulators like Azurite, e.g.
+	// http://127.0.0.1:10000/devstoreaccount1.
+	Endpoint string
+
+	RetentionTime time.Duration
+}
+
+// AzureSnapshotter stores snapshots in an Azure Blob Storage container using
+// the Blob service REST API.
+type AzureSnapshotter struct {
+	objectSnapshotter
+}
+
+func NewAzureSnapshotter(cfg *AzureConfig) (*AzureSnapshotter, error) {
+	if cfg.Account == "" {
+		return nil, errors.New("must provide azure storage account")
+	}
+	if cfg.AccountKey == "" && cfg.SASToken == "" {
+		return nil, errors.New("must provide either azure storage account key or sas token")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", cfg.Account)
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + cfg.Container)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid azure storage endpoint: %#v", endpoint)
+	}
+	store := &azureStore{
+		client:    http.DefaultClient,
+		container: u,
+		account:   cfg.Account,
+	}
+	if cfg.AccountKey != "" {
+		store.key, err = base64.StdEncoding.DecodeString(cfg.AccountKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "azure storage account key must be base64 encoded")
+		}
+	} else {
+		store.sas, err = url.ParseQuery(strings.TrimPrefix(cfg.SASToken, "?"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid azure sas token")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	// Ensure that the container exists
+	q := url.Values{}
+	q.Set("restype", "container")
+	resp, err := store.do(ctx, http.MethodGet, "", q, nil, nil)
+	if err != nil {
+		if errors.Cause(err) == errObjectNotFound {
+			return nil, errors.Errorf("container %s does not exist", cfg.Container)
+		}
+		return nil, errors.Errorf("container could not be accessed: %v", err)
+	}
+	resp.Body.Close()
+
+	return &AzureSnapshotter{
+		objectSnapshotter: objectSnapshotter{
+			store:         store,
+			prefix:        cfg.Key,
+			retentionTime: cfg.RetentionTime,
+		},
+	}, nil
+}
+
+type azureStore struct {
+	client    *http.Client
+	container *url.URL
+	account   string
+	key       []byte
+	sas       url.Values
+}
+
+// do performs a request against the blob with the provided key, or the
+// container itself if the key is empty.
+func (s *azureStore) do(ctx context.Context, method, key string, q url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.container
+	if key != "" {
+		u.Path += "/" + key
+	}
+	if s.key == nil {
+		for k, v := range s.sas {
+			q[k] = v
+		}
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	if s.key != nil {
+		req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", s.account, s.sign(req, int64(len(body)))))
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// sign returns the Shared Key signature for the request, see:
+// https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func (s *azureStore) sign(req *http.Request, contentLength int64) string {
+	length := ""
+	if contentLength > 0 {
+		length = strconv.FormatInt(contentLength, 10)
+	}
+	headers := make([]string, 0)
+	for k := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			headers = append(headers, k)
+		}
+	}
+	sort.Strings(headers)
+
+	var b strings.Builder
+	for _, v := range []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	} {
+		b.WriteString(v + "\n")
+	}
+	for _, k := range headers {
+		b.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+	b.WriteString("/" + s.account + req.URL.EscapedPath())
+	q := req.URL.Query()
+	params := make([]string, 0, len(q))
+	for k := range q {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		values := q[k]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(values, ","))
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *azureStore) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, url.Values{}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// put uploads the blob as a series of blocks, since the size is not known
+// ahead of time. Each block is retried on its own, and the blob is only
+// created once the block list is committed.
+func (s *azureStore) put(ctx context.Context, key string, r io.Reader, sizeHint int64) error {
+	blocks := make([]string, 0)
+	buf := make([]byte, partSize(sizeHint, azureMinBlockSize, azureMaxBlockSize, azureMaxBlocks))
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if len(blocks) == azureMaxBlocks {
+				return errors.Errorf("blob exceeds %d blocks", azureMaxBlocks)
+			}
+			id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(blocks))))
+			q := url.Values{}
+			q.Set("comp", "block")
+			q.Set("blockid", id)
+			if err := retry(ctx, func() error {
+				resp, err := s.do(ctx, http.MethodPut, key, q, nil, buf[:n])
+				if err != nil {
+					return err
+				}
+				return resp.Body.Close()
+			}); err != nil {
+				return errors.Wrapf(err, "cannot upload block %d", len(blocks))
+			}
+			blocks = append(blocks, id)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var body bytes.Buffer
+	body.WriteString(xml.Header + "<BlockList>")
+	for _, id := range blocks {
+		body.WriteString("<Latest>" + id + "</Latest>")
+	}
+	body.WriteString("</BlockList>")
+	q := url.Values{}
+	q.Set("comp", "blocklist")
+	h := http.Header{}
+	h.Set("Content-Type", "application/xml")
+	if err := retry(ctx, func() error {
+		resp, err := s.do(ctx, http.MethodPut, key, q, h, body.Bytes())
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}); err != nil {
+		return errors.Wrap(err, "cannot commit block list")
+	}
+	return nil
+}
+
+func (s *azureStore) list(ctx context.Context, prefix string) ([]objectInfo, error) {
+	objects := make([]objectInfo, 0)
+	q := url.Values{}
+	q.Set("restype", "container")
+	q.Set("comp", "list")
+	q.Set("prefix", prefix)
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Blobs []struct {
+				Name          string `xml:"Name"`
+				ContentLength int64  `xml:"Properties>Content-Length"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot decode blob list")
+		}
+		for _, blob := range page.Blobs {
+			objects = append(objects, objectInfo{Key: blob.Name, Size: blob.ContentLength})
+		}
+		if page.NextMarker == "" {
+			return objects, nil
+		}
+		q.Set("marker", page.NextMarker)
+	}
+}
+
+func (s *azureStore) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, url.Values{}, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
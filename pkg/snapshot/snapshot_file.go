@@ -1,81 +1,357 @@
 package snapshot
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/criticalstack/e2d/pkg/log"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
 )
 
 type FileSnapshotter struct {
-	path string
+	path          string
 	retentionTime time.Duration
 }
 
 func NewFileSnapshotter(path string, retentionTime time.Duration) (*FileSnapshotter, error) {
-	// TODO: check if path is a directory
-
-	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil && !os.IsExist(err) {
-		return nil, errors.Wrapf(err, "cannot create snapshot directory: %#v", filepath.Dir(path))
+	if err := os.MkdirAll(path, 0700); err != nil && !os.IsExist(err) {
+		return nil, errors.Wrapf(err, "cannot create snapshot directory: %#v", path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errors.Wrapf(ErrInvalidDirectoryPath, "%#v", path)
 	}
 	return &FileSnapshotter{path: path, retentionTime: retentionTime}, nil
 }
 
+// tmpFilePrefix is the prefix of the temporary files snapshots are written to
+// before being renamed into place. They are hidden so they are never mistaken
+// for snapshots.
+const tmpFilePrefix = ".etcd.snapshot.tmp-"
+
 func (fs *FileSnapshotter) Load() (io.ReadCloser, error) {
-	// read the latest symlink
-	latestSymlink := filepath.Join(fs.path, fmt.Sprintf("%s.%s", snapshotFilename, latestSuffix))
-	return os.Open(latestSymlink)
+	return loadLatest(fs)
+}
+
+func (fs *FileSnapshotter) Latest() (string, error) {
+	return resolveLatest(fs)
+}
+
+func (fs *FileSnapshotter) LoadAt(id string) (io.ReadCloser, error) {
+	return loadAt(fs, id)
+}
+
+func (fs *FileSnapshotter) latestSymlink() string {
+	return filepath.Join(fs.path, fmt.Sprintf("%s.%s", snapshotFilename, latestSuffix))
+}
+
+// latestName reads the target of the LATEST symlink.
+func (fs *FileSnapshotter) latestName(ctx context.Context) (string, error) {
+	target, err := os.Readlink(fs.latestSymlink())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.Wrap(ErrSnapshotNotFound, "no latest snapshot")
+		}
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+func (fs *FileSnapshotter) openSnapshot(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(fs.path, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return nil, err
+	}
+	return f, nil
 }
 
-func (fs *FileSnapshotter) Save(r io.ReadCloser) error {
+func (fs *FileSnapshotter) readManifest(ctx context.Context, name string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(fs.path, manifestName(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "cannot read manifest for snapshot %s", name)
+	}
+	m := &Manifest{}
+	if err := m.read(data); err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal manifest for snapshot %s", name)
+	}
+	return m, nil
+}
+
+func (fs *FileSnapshotter) List() ([]*Snapshot, error) {
+	files, err := ioutil.ReadDir(fs.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list snapshot directory")
+	}
+	snapshots := make([]*Snapshot, 0)
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		t, ok := parseSnapshotName(f.Name())
+		if !ok {
+			continue
+		}
+		snapshots = append(snapshots, &Snapshot{
+			Name:      f.Name(),
+			Timestamp: t,
+			Size:      f.Size(),
+		})
+	}
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+func (fs *FileSnapshotter) Delete(name string) error {
+	return deleteSnapshot(fs, name)
+}
+
+func (fs *FileSnapshotter) removeSnapshot(ctx context.Context, name string) error {
+	if err := os.Remove(filepath.Join(fs.path, name)); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return err
+	}
+	if err := os.Remove(filepath.Join(fs.path, manifestName(name))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Save writes the snapshot so that a crash at any point never leaves a partial
+// snapshot behind where Load could find it. The snapshot and its manifest are
+// each written to a temporary file, synced to disk and renamed into place,
+// and only then is the LATEST symlink atomically replaced to point to the new
+// snapshot.
+func (fs *FileSnapshotter) Save(ctx context.Context, r io.ReadCloser, m *Manifest) error {
 	defer r.Close()
+	if m == nil {
+		m = &Manifest{}
+	}
 
 	// generate the filenames
 	backupTimestamp := time.Now().UTC()
-	snapshotFile := filepath.Join(fs.path, fmt.Sprintf("%s.%s", snapshotFilename, strconv.FormatInt(backupTimestamp.Unix(), 10)))
-	latestSymlink := filepath.Join(fs.path, fmt.Sprintf("%s.%s", snapshotFilename, latestSuffix))
+	name := newSnapshotName(backupTimestamp)
 
 	// make the snapshot
-	f, err := os.OpenFile(snapshotFile, os.O_RDWR|os.O_CREATE, 0600)
+	hr := newHashingReader(&contextReader{ctx: ctx, r: r})
+	if err := fs.writeFile(name, hr); err != nil {
+		return errors.Wrap(err, "cannot write snapshot")
+	}
+
+	// write the manifest alongside the snapshot
+	m.Name = name
+	m.Created = backupTimestamp
+	hr.finish(m)
+	data, err := m.generate()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	if err := fs.writeFile(manifestName(name), bytes.NewReader(data)); err != nil {
+		return errors.Wrap(err, "cannot write snapshot manifest")
+	}
 
-	// update the symlink to point to the latest snapshot
-	if _, err := os.Lstat(latestSymlink); err == nil {
-		err = os.Remove(latestSymlink)
-		if err != nil {
-			return errors.Wrap(err, "can't remove latest symlink")
-		}
+	// update the symlink to point to the latest snapshot. The target is
+	// relative so the snapshot directory can be moved or mounted elsewhere.
+	tmpSymlink := filepath.Join(fs.path, tmpFilePrefix+latestSuffix)
+	if err := os.Remove(tmpSymlink); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "can't remove temporary latest symlink")
 	}
-	if err = os.Symlink(snapshotFile, latestSymlink); err != nil {
+	if err := os.Symlink(name, tmpSymlink); err != nil {
 		return errors.Wrap(err, "can't create latest symlink")
 	}
-
-	_, err = io.Copy(f, r)
+	if err := os.Rename(tmpSymlink, fs.latestSymlink()); err != nil {
+		return errors.Wrap(err, "can't replace latest symlink")
+	}
+	if err := syncDir(fs.path); err != nil {
+		return err
+	}
 
 	// purge old snapshots
 	if fs.retentionTime > 0 {
-		files, err := ioutil.ReadDir(fs.path)
-		if err != nil {
-			return errors.Wrap(err, "unable to list snapshot directory during pruning")
+		if err := fs.prune(name, backupTimestamp.Add(-fs.retentionTime)); err != nil {
+			log.Warn("cannot prune snapshots", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (fs *FileSnapshotter) LoadManifest(name string) (*Manifest, error) {
+	if _, ok := parseSnapshotName(name); !ok {
+		return nil, errors.Wrapf(ErrSnapshotNotFound, "%#v is not a snapshot name", name)
+	}
+	return fs.readManifest(context.Background(), name)
+}
+
+// Replace replaces the snapshot (see replaceSnapshot), writing each file the
+// same as Save and renaming the new snapshot into place.
+func (fs *FileSnapshotter) Replace(ctx context.Context, r io.ReadCloser, m *Manifest) error {
+	defer r.Close()
+	if _, ok := parseSnapshotName(m.Name); !ok {
+		return errors.Wrapf(ErrSnapshotNotFound, "%#v is not a snapshot name", m.Name)
+	}
+	if _, err := os.Stat(filepath.Join(fs.path, m.Name)); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Wrap(ErrSnapshotNotFound, m.Name)
+		}
+		return err
+	}
+	return replaceSnapshot(ctx, fs, r, m)
+}
+
+func (fs *FileSnapshotter) putObject(ctx context.Context, name string, r io.Reader, sizeHint int64) error {
+	return fs.writeFile(name, &contextReader{ctx: ctx, r: r})
+}
+
+func (fs *FileSnapshotter) moveObject(ctx context.Context, src, dst string, size int64) error {
+	if err := os.Rename(filepath.Join(fs.path, src), filepath.Join(fs.path, dst)); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Wrap(ErrSnapshotNotFound, src)
+		}
+		return err
+	}
+	return syncDir(fs.path)
+}
+
+// writeFile atomically writes the contents of r to the file with the provided
+// name in the snapshot directory.
+func (fs *FileSnapshotter) writeFile(name string, r io.Reader) error {
+	f, err := ioutil.TempFile(fs.path, tmpFilePrefix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// only succeeds when the file was not renamed into place
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(fs.path, name)); err != nil {
+		return err
+	}
+	return syncDir(fs.path)
+}
+
+// prune removes the snapshots taken before the provided time (see
+// pruneSnapshots). Temporary files left behind by a crash during Save are also
+// removed once they are older than the retention time.
+func (fs *FileSnapshotter) prune(saved string, before time.Time) error {
+	files, err := ioutil.ReadDir(fs.path)
+	if err != nil {
+		return errors.Wrap(err, "unable to list snapshot directory during pruning")
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
 		}
-		for _, f := range files {
-			if (f.Mode()&os.ModeSymlink != os.ModeSymlink) && strings.HasPrefix(f.Name(), snapshotFilename) && time.Now().Sub(f.ModTime()) > fs.retentionTime {
-				// prune the file
-				log.Warnf("Would have deleted %s", f.Name())
-				//_ = os.Remove(f.Name())
+		if strings.HasPrefix(f.Name(), tmpFilePrefix) && f.ModTime().Before(before) {
+			log.Info("removing stale temporary snapshot file", zap.String("file", f.Name()))
+			if err := os.Remove(filepath.Join(fs.path, f.Name())); err != nil && !os.IsNotExist(err) {
+				return err
 			}
 		}
 	}
+	return pruneSnapshots(fs, saved, before)
+}
 
-	return err
+// syncDir flushes the directory entries of the provided directory to disk, so
+// that files created or renamed within it survive a crash.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return errors.Wrapf(err, "cannot sync directory: %#v", path)
+	}
+	return nil
+}
+
+func (fs *FileSnapshotter) SaveSegment(ctx context.Context, r io.Reader, seg *Segment) error {
+	name, err := newSegmentName(seg)
+	if err != nil {
+		return err
+	}
+	seg.Name = name
+	hr := newHashingReader(&contextReader{ctx: ctx, r: r})
+	if err := fs.writeFile(seg.Name, hr); err != nil {
+		return errors.Wrap(err, "cannot write segment")
+	}
+	seg.Size = hr.n
+	return nil
+}
+
+func (fs *FileSnapshotter) ListSegments() ([]*Segment, error) {
+	files, err := ioutil.ReadDir(fs.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list snapshot directory")
+	}
+	segments := make([]*Segment, 0)
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		seg, ok := parseSegmentName(f.Name())
+		if !ok {
+			continue
+		}
+		seg.Size = f.Size()
+		segments = append(segments, seg)
+	}
+	sortSegments(segments)
+	return segments, nil
+}
+
+func (fs *FileSnapshotter) LoadSegment(name string) (io.ReadCloser, error) {
+	if _, ok := parseSegmentName(name); !ok {
+		return nil, errors.Wrapf(ErrSnapshotNotFound, "%#v is not a segment name", name)
+	}
+	f, err := os.Open(filepath.Join(fs.path, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs *FileSnapshotter) DeleteSegment(name string) error {
+	if _, ok := parseSegmentName(name); !ok {
+		return errors.Wrapf(ErrSnapshotNotFound, "%#v is not a segment name", name)
+	}
+	if err := os.Remove(filepath.Join(fs.path, name)); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,343 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	gcsReadWriteScope  = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+type GCSConfig struct {
+	Bucket string
+	Key    string
+
+	// Endpoint overrides the Google Cloud Storage API endpoint. This is meant
+	// for emulators like fake-gcs-server, so requests are not authenticated
+	// when it is set.
+	Endpoint string
+
+	// CredentialsFile is the path to a service account key file. When empty,
+	// the application default credentials are used.
+	CredentialsFile string
+
+	RetentionTime time.Duration
+}
+
+// GCSSnapshotter stores snapshots in a Google Cloud Storage bucket using the
+// JSON API.
+type GCSSnapshotter struct {
+	objectSnapshotter
+}
+
+func NewGCSSnapshotter(cfg *GCSConfig) (*GCSSnapshotter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	store := &gcsStore{
+		client:   http.DefaultClient,
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/"),
+		bucket:   cfg.Bucket,
+	}
+	if store.endpoint == "" {
+		store.endpoint = gcsDefaultEndpoint
+		creds, err := gcsCredentials(ctx, cfg.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		store.client = oauth2.NewClient(context.Background(), creds.TokenSource)
+	}
+
+	// Ensure that the bucket exists
+	req, err := http.NewRequest(http.MethodGet, store.endpoint+"/storage/v1/b/"+url.PathEscape(cfg.Bucket), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := store.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Errorf("bucket could not be accessed: %v", err)
+	}
+	if err := checkResponse(resp); err != nil {
+		switch {
+		case errors.Cause(err) == errObjectNotFound:
+			return nil, errors.Errorf("bucket %s does not exist", cfg.Bucket)
+		case resp.StatusCode == http.StatusForbidden:
+			return nil, errors.Errorf("access to bucket %s forbidden", cfg.Bucket)
+		default:
+			return nil, errors.Errorf("bucket could not be accessed: %v", err)
+		}
+	}
+	resp.Body.Close()
+
+	return &GCSSnapshotter{
+		objectSnapshotter: objectSnapshotter{
+			store:         store,
+			prefix:        cfg.Key,
+			retentionTime: cfg.RetentionTime,
+		},
+	}, nil
+}
+
+func gcsCredentials(ctx context.Context, path string) (*google.Credentials, error) {
+	if path == "" {
+		creds, err := google.FindDefaultCredentials(ctx, gcsReadWriteScope)
+		return creds, errors.Wrap(err, "cannot find google application default credentials")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read google credentials file: %#v", path)
+	}
+	creds, err := google.CredentialsFromJSON(ctx, data, gcsReadWriteScope)
+	return creds, errors.Wrapf(err, "cannot parse google credentials file: %#v", path)
+}
+
+type gcsStore struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+}
+
+func (s *gcsStore) objectURL(key string) string {
+	return s.endpoint + "/storage/v1/b/" + url.PathEscape(s.bucket) + "/o/" + url.PathEscape(key)
+}
+
+func (s *gcsStore) do(ctx context.Context, method, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *gcsStore) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// put uses a resumable upload, sending the object in chunks so memory use is
+// bounded. When a chunk fails, the upload is resumed from the last byte the
+// server received instead of starting over.
+func (s *gcsStore) put(ctx context.Context, key string, r io.Reader, sizeHint int64) error {
+	q := url.Values{}
+	q.Set("uploadType", "resumable")
+	q.Set("name", key)
+	resp, err := s.do(ctx, http.MethodPost, s.endpoint+"/upload/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+q.Encode(), nil)
+	if err != nil {
+		return errors.Wrap(err, "cannot start resumable upload")
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return errors.New("cannot start resumable upload: no session url returned")
+	}
+
+	// the next chunk is read ahead to know when the current chunk is the
+	// last one, since the total size must be sent along with it
+	cur, next := make([]byte, gcsChunkSize), make([]byte, gcsChunkSize)
+	n, err := io.ReadFull(r, cur)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	var offset int64
+	for {
+		var m int
+		if n == gcsChunkSize {
+			m, err = io.ReadFull(r, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+		}
+		last := m == 0
+		resume := false
+		if err := retry(ctx, func() error {
+			err := s.putChunk(ctx, session, cur[:n], offset, last, resume)
+			resume = true
+			return err
+		}); err != nil {
+			return errors.Wrapf(err, "cannot upload chunk at offset %d", offset)
+		}
+		if last {
+			return nil
+		}
+		offset += int64(n)
+		cur, next, n = next, cur, m
+	}
+}
+
+// gcsChunkSize is the size of each chunk of a resumable upload, which must be
+// a multiple of 256KiB.
+const gcsChunkSize = 32 * 256 * 1024
+
+// putChunk uploads the chunk starting at offset. When resuming after a
+// previous attempt failed, the upload status is queried first and only the
+// part of the chunk the server has not received yet is sent.
+func (s *gcsStore) putChunk(ctx context.Context, session string, chunk []byte, offset int64, last, resume bool) error {
+	received := offset
+	if resume {
+		var completed bool
+		var err error
+		received, completed, err = s.uploadStatus(ctx, session)
+		if err != nil {
+			return err
+		}
+		if completed {
+			// the last chunk was received, but the response to it was lost
+			if last {
+				return nil
+			}
+			return errors.Errorf("upload completed before the chunk at offset %d was sent", offset)
+		}
+		if received < offset || received > offset+int64(len(chunk)) {
+			return errors.Errorf("server has %d bytes, cannot resume chunk at offset %d", received, offset)
+		}
+		chunk = chunk[received-offset:]
+		if len(chunk) == 0 && !last {
+			return nil
+		}
+	}
+	total := "*"
+	if last {
+		total = strconv.FormatInt(received+int64(len(chunk)), 10)
+	}
+	contentRange := "bytes */" + total
+	if len(chunk) > 0 {
+		contentRange = fmt.Sprintf("bytes %d-%d/%s", received, received+int64(len(chunk))-1, total)
+	}
+	req, err := http.NewRequest(http.MethodPut, session, bytes.NewReader(chunk))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Range", contentRange)
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusPermanentRedirect && !last {
+		resp.Body.Close()
+
+		// the server may persist only part of the chunk, in which case the
+		// remainder is sent again when resuming
+		persisted, err := parseUploadRange(resp.Header.Get("Range"))
+		if err != nil {
+			return err
+		}
+		if end := received + int64(len(chunk)); persisted < end {
+			return errors.Errorf("server persisted %d of %d bytes", persisted, end)
+		}
+		return nil
+	}
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// uploadStatus returns the number of bytes of a resumable upload the server
+// has received, or whether the upload has already completed.
+func (s *gcsStore) uploadStatus(ctx context.Context, session string) (int64, bool, error) {
+	req, err := http.NewRequest(http.MethodPut, session, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Range", "bytes */*")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, false, err
+	}
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		if err := checkResponse(resp); err != nil {
+			return 0, false, err
+		}
+		resp.Body.Close()
+		return 0, true, nil
+	}
+	resp.Body.Close()
+	received, err := parseUploadRange(resp.Header.Get("Range"))
+	return received, false, err
+}
+
+// parseUploadRange returns the number of bytes of a resumable upload the
+// server has persisted from the Range header of an incomplete upload. The
+// header is only present once some data has been received, in the form
+// bytes=0-<last byte>.
+func parseUploadRange(rng string) (int64, error) {
+	if rng == "" {
+		return 0, nil
+	}
+	i := strings.LastIndex(rng, "-")
+	if i < 0 {
+		return 0, errors.Errorf("invalid range returned for upload: %#v", rng)
+	}
+	end, err := strconv.ParseInt(rng[i+1:], 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid range returned for upload: %#v", rng)
+	}
+	return end + 1, nil
+}
+
+func (s *gcsStore) list(ctx context.Context, prefix string) ([]objectInfo, error) {
+	objects := make([]objectInfo, 0)
+	q := url.Values{}
+	q.Set("prefix", prefix)
+	for {
+		resp, err := s.do(ctx, http.MethodGet, s.endpoint+"/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+				Size int64  `json:"size,string"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot decode object list")
+		}
+		for _, item := range page.Items {
+			objects = append(objects, objectInfo{Key: item.Name, Size: item.Size})
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		q.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (s *gcsStore) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
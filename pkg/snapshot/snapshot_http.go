@@ -0,0 +1,189 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type HTTPConfig struct {
+	// URL is the directory snapshots are stored in, e.g.
+	// https://backups.example.com/etcd/. It must end with a slash.
+	URL string
+
+	// BearerToken is sent in the Authorization header of every request. It
+	// cannot be used along with Username and Password.
+	BearerToken string
+
+	// Username and Password are used for basic authentication.
+	Username string
+	Password string
+
+	// CAFile is a PEM encoded CA certificate bundle used to verify the
+	// server, instead of the system roots.
+	CAFile string
+
+	RetentionTime time.Duration
+}
+
+// HTTPSnapshotter stores snapshots on a generic HTTP object server, like a
+// WebDAV server, using PUT, GET and DELETE requests. Listing snapshots, which
+// is needed to restore anything other than the latest snapshot and for
+// retention, uses the WebDAV PROPFIND method.
+type HTTPSnapshotter struct {
+	objectSnapshotter
+}
+
+func NewHTTPSnapshotter(cfg *HTTPConfig) (*HTTPSnapshotter, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Wrapf(ErrInvalidScheme, "url must use http or https: %#v", cfg.URL)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		return nil, ErrInvalidDirectoryPath
+	}
+	if cfg.BearerToken != "" && cfg.Username != "" {
+		return nil, errors.New("cannot use both bearer token and basic authentication")
+	}
+	store := &httpStore{
+		client:   http.DefaultClient,
+		dir:      u,
+		token:    cfg.BearerToken,
+		username: cfg.Username,
+		password: cfg.Password,
+	}
+	if cfg.CAFile != "" {
+		data, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read ca file: %#v", cfg.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificates found in ca file: %#v", cfg.CAFile)
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+		store.client = &http.Client{Transport: t}
+	}
+	return &HTTPSnapshotter{
+		objectSnapshotter: objectSnapshotter{
+			store:         store,
+			retentionTime: cfg.RetentionTime,
+		},
+	}, nil
+}
+
+type httpStore struct {
+	client   *http.Client
+	dir      *url.URL
+	token    string
+	username string
+	password string
+}
+
+func (s *httpStore) do(ctx context.Context, method, key string, header http.Header, body io.Reader) (*http.Response, error) {
+	u := *s.dir
+	u.Path += key
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	switch {
+	case s.token != "":
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	case s.username != "":
+		req.SetBasicAuth(s.username, s.password)
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *httpStore) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// put streams the object in a single request, since a generic server cannot be
+// expected to support any kind of multipart upload.
+func (s *httpStore) put(ctx context.Context, key string, r io.Reader, sizeHint int64) error {
+	h := http.Header{}
+	h.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(ctx, http.MethodPut, key, h, r)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?><propfind xmlns="DAV:"><prop><getcontentlength/></prop></propfind>`
+
+func (s *httpStore) list(ctx context.Context, prefix string) ([]objectInfo, error) {
+	h := http.Header{}
+	h.Set("Depth", "1")
+	h.Set("Content-Type", "application/xml")
+	resp, err := s.do(ctx, "PROPFIND", "", h, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing requires a server supporting WebDAV PROPFIND")
+	}
+	defer resp.Body.Close()
+
+	var ms struct {
+		Responses []struct {
+			Href          string `xml:"href"`
+			ContentLength string `xml:"propstat>prop>getcontentlength"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, errors.Wrap(err, "cannot decode PROPFIND response")
+	}
+	objects := make([]objectInfo, 0)
+	for _, r := range ms.Responses {
+		// the href is either an absolute path or url, and only entries
+		// directly within the directory are returned with a depth of 1
+		href, err := url.Parse(r.Href)
+		if err != nil || strings.HasSuffix(href.Path, "/") {
+			continue
+		}
+		key := path.Base(href.Path)
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		size, _ := strconv.ParseInt(r.ContentLength, 10, 64)
+		objects = append(objects, objectInfo{Key: key, Size: size})
+	}
+	return objects, nil
+}
+
+func (s *httpStore) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
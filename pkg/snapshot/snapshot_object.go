@@ -0,0 +1,445 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/criticalstack/e2d/pkg/log"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+var errObjectNotFound = errors.New("object not found")
+
+type objectInfo struct {
+	Key  string
+	Size int64
+}
+
+// objectStore is the minimal set of operations needed from an object storage
+// service to store snapshots. It is used for the backends that are
+// implemented directly against a REST API, rather than with an SDK.
+type objectStore interface {
+	// get returns the object with the provided key, or errObjectNotFound.
+	get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// put stores the contents of r as the object with the provided key. The
+	// exact size is not known ahead of time, only an estimate in sizeHint
+	// (which may be zero). The object must not become visible until it has
+	// been stored in its entirety.
+	put(ctx context.Context, key string, r io.Reader, sizeHint int64) error
+
+	// list returns all objects with keys starting with prefix.
+	list(ctx context.Context, prefix string) ([]objectInfo, error)
+
+	// delete removes the object with the provided key, or returns
+	// errObjectNotFound.
+	delete(ctx context.Context, key string) error
+}
+
+// objectSnapshotter implements Snapshotter on top of an objectStore, using the
+// same layout as the AmazonSnapshotter: snapshots and their manifests are
+// stored under the key prefix, along with a LATEST pointer file that is only
+// updated once a snapshot has been successfully stored.
+type objectSnapshotter struct {
+	store         objectStore
+	prefix        string
+	retentionTime time.Duration
+}
+
+func (s *objectSnapshotter) latestKey() string {
+	return s.prefix + fmt.Sprintf("%s.%s", snapshotFilename, latestSuffix)
+}
+
+func (s *objectSnapshotter) Load() (io.ReadCloser, error) {
+	return loadLatest(s)
+}
+
+func (s *objectSnapshotter) Latest() (string, error) {
+	return resolveLatest(s)
+}
+
+func (s *objectSnapshotter) LoadAt(id string) (io.ReadCloser, error) {
+	return loadAt(s, id)
+}
+
+// latest downloads the latest snapshot pointer file.
+func (s *objectSnapshotter) latest(ctx context.Context) (*LatestFile, error) {
+	r, err := s.store.get(ctx, s.latestKey())
+	if err != nil {
+		if errors.Cause(err) == errObjectNotFound {
+			return nil, errors.Wrap(ErrSnapshotNotFound, "unable to retrieve latest backup pointer file")
+		}
+		return nil, errors.Wrap(err, "unable to retrieve latest backup pointer file")
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to retrieve latest backup pointer file")
+	}
+	l := &LatestFile{}
+	if err := l.read(data); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal latest backup pointer file")
+	}
+	return l, nil
+}
+
+func (s *objectSnapshotter) latestName(ctx context.Context) (string, error) {
+	l, err := s.latest(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(l.Path, s.prefix), nil
+}
+
+func (s *objectSnapshotter) openSnapshot(ctx context.Context, name string) (io.ReadCloser, error) {
+	r, err := s.store.get(ctx, s.prefix+name)
+	if err != nil {
+		if errors.Cause(err) == errObjectNotFound {
+			return nil, errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return nil, errors.Wrapf(err, "cannot download snapshot %s", name)
+	}
+	return r, nil
+}
+
+func (s *objectSnapshotter) readManifest(ctx context.Context, name string) (*Manifest, error) {
+	r, err := s.store.get(ctx, s.prefix+manifestName(name))
+	if err != nil {
+		if errors.Cause(err) == errObjectNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "cannot download manifest for snapshot %s", name)
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot download manifest for snapshot %s", name)
+	}
+	m := &Manifest{}
+	if err := m.read(data); err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal manifest for snapshot %s", name)
+	}
+	return m, nil
+}
+
+func (s *objectSnapshotter) List() ([]*Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	objects, err := s.store.list(ctx, s.prefix+snapshotFilename+".")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list snapshots")
+	}
+	snapshots := make([]*Snapshot, 0)
+	for _, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, s.prefix)
+		t, ok := parseSnapshotName(name)
+		if !ok {
+			continue
+		}
+		snapshots = append(snapshots, &Snapshot{
+			Name:      name,
+			Timestamp: t,
+			Size:      obj.Size,
+		})
+	}
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+func (s *objectSnapshotter) Delete(name string) error {
+	return deleteSnapshot(s, name)
+}
+
+func (s *objectSnapshotter) removeSnapshot(ctx context.Context, name string) error {
+	if err := s.store.delete(ctx, s.prefix+name); err != nil {
+		if errors.Cause(err) == errObjectNotFound {
+			return errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return errors.Wrapf(err, "cannot delete snapshot %s", name)
+	}
+	if err := s.store.delete(ctx, s.prefix+manifestName(name)); err != nil && errors.Cause(err) != errObjectNotFound {
+		return errors.Wrapf(err, "cannot delete manifest for snapshot %s", name)
+	}
+	return nil
+}
+
+func (s *objectSnapshotter) Save(ctx context.Context, r io.ReadCloser, m *Manifest) error {
+	defer r.Close()
+
+	if m == nil {
+		m = &Manifest{}
+	}
+
+	// generate the filenames
+	backupTimestamp := time.Now().UTC()
+	name := newSnapshotName(backupTimestamp)
+	snapshotPath := s.prefix + name
+
+	// upload the snapshot itself
+	hr := newHashingReader(r)
+	if err := s.store.put(ctx, snapshotPath, hr, m.DatabaseSize); err != nil {
+		return errors.Wrap(err, "cannot upload snapshot")
+	}
+
+	// upload the manifest before the latest pointer file, so the latest
+	// snapshot always has one
+	m.Name = name
+	m.Created = backupTimestamp
+	hr.finish(m)
+	manifestContent, err := m.generate()
+	if err != nil {
+		return err
+	}
+	if err := s.store.put(ctx, s.prefix+manifestName(name), bytes.NewReader(manifestContent), int64(len(manifestContent))); err != nil {
+		return errors.Wrap(err, "cannot upload snapshot manifest")
+	}
+
+	// upload the latest snapshot pointer file
+	latestFile := &LatestFile{
+		Path:      snapshotPath,
+		Timestamp: backupTimestamp.Format("2006-01-02T15:04:05-0700"),
+	}
+	latestContent, err := latestFile.generate()
+	if err != nil {
+		return err
+	}
+	if err := s.store.put(ctx, s.latestKey(), bytes.NewReader(latestContent), int64(len(latestContent))); err != nil {
+		return errors.Wrap(err, "cannot upload latest backup pointer file")
+	}
+
+	// purge old snapshots
+	if s.retentionTime > 0 {
+		if err := pruneSnapshots(s, name, backupTimestamp.Add(-s.retentionTime)); err != nil {
+			log.Warn("cannot prune snapshots", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *objectSnapshotter) LoadManifest(name string) (*Manifest, error) {
+	if _, ok := parseSnapshotName(name); !ok {
+		return nil, errors.Wrapf(ErrSnapshotNotFound, "%#v is not a snapshot name", name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	return s.readManifest(ctx, name)
+}
+
+// Replace replaces the snapshot (see replaceSnapshot). Objects cannot be
+// renamed, so the new snapshot is copied into place through this process.
+func (s *objectSnapshotter) Replace(ctx context.Context, r io.ReadCloser, m *Manifest) error {
+	defer r.Close()
+	return replaceSnapshot(ctx, s, r, m)
+}
+
+func (s *objectSnapshotter) putObject(ctx context.Context, name string, r io.Reader, sizeHint int64) error {
+	return s.store.put(ctx, s.prefix+name, r, sizeHint)
+}
+
+func (s *objectSnapshotter) moveObject(ctx context.Context, src, dst string, size int64) error {
+	if err := copyObject(ctx, s, src, dst, size); err != nil {
+		return err
+	}
+	if err := s.store.delete(ctx, s.prefix+src); err != nil && errors.Cause(err) != errObjectNotFound {
+		return err
+	}
+	return nil
+}
+
+const (
+	// uploadAttempts is the number of times each part of a multipart upload
+	// is attempted, so a transient failure only needs the failed part to be
+	// sent again rather than the entire snapshot.
+	uploadAttempts = 5
+
+	// uploadMaxBackoff caps the time between attempts.
+	uploadMaxBackoff = 30 * time.Second
+)
+
+// retry calls fn until it succeeds, up to uploadAttempts times, with an
+// exponential backoff between attempts.
+func retry(ctx context.Context, fn func() error) error {
+	backoff := 1 * time.Second
+	var err error
+	for i := 0; i < uploadAttempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i == uploadAttempts-1 {
+			break
+		}
+		log.Debug("upload failed, retrying", zap.Error(err), zap.Duration("backoff", backoff))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		if backoff *= 2; backoff > uploadMaxBackoff {
+			backoff = uploadMaxBackoff
+		}
+	}
+	return err
+}
+
+// partSize returns the size of each part when uploading an object of roughly
+// sizeHint bytes in at most maxParts parts. The hint is doubled since it is
+// only an estimate. The result is never less than minSize or more than
+// maxSize.
+func partSize(sizeHint, minSize, maxSize int64, maxParts int) int64 {
+	size := 2 * sizeHint / int64(maxParts)
+	if size < minSize {
+		size = minSize
+	}
+	if size > maxSize {
+		size = maxSize
+	}
+	return size
+}
+
+// downloadIdleTimeout is how long a download can go without receiving any
+// data before it is cancelled. Snapshots can be too large to put a deadline
+// on the entire download, so only downloads that stall are cancelled.
+var downloadIdleTimeout = 1 * time.Minute
+
+// openStream starts a streamed download with open. The request is cancelled
+// once the returned reader is closed, or when waiting for the response or for
+// any read takes longer than downloadIdleTimeout.
+func openStream(open func(ctx context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &idleTimeoutReadCloser{
+		cancel:  cancel,
+		timeout: downloadIdleTimeout,
+		timer:   time.AfterFunc(downloadIdleTimeout, cancel),
+	}
+	body, err := open(ctx)
+	if !r.timer.Stop() && err == nil {
+		body.Close()
+		err = r.stalled()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r.ReadCloser = body
+	return r, nil
+}
+
+// idleTimeoutReadCloser cancels the context of a streamed request when a read
+// stalls, and once the response body is closed.
+type idleTimeoutReadCloser struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func (r *idleTimeoutReadCloser) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	n, err := r.ReadCloser.Read(p)
+	if !r.timer.Stop() {
+		return n, r.stalled()
+	}
+	return n, err
+}
+
+func (r *idleTimeoutReadCloser) stalled() error {
+	return errors.Errorf("download stalled, no data received for %s", r.timeout)
+}
+
+func (r *idleTimeoutReadCloser) Close() error {
+	r.timer.Stop()
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// checkResponse returns an error for any unsuccessful HTTP response, and
+// errObjectNotFound for a 404. The response body is closed on error.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.Wrapf(errObjectNotFound, "%s %s", resp.Request.Method, resp.Request.URL.Path)
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return errors.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+}
+
+func (s *objectSnapshotter) SaveSegment(ctx context.Context, r io.Reader, seg *Segment) error {
+	name, err := newSegmentName(seg)
+	if err != nil {
+		return err
+	}
+	seg.Name = name
+	hr := newHashingReader(r)
+	if err := s.store.put(ctx, s.prefix+seg.Name, hr, seg.Size); err != nil {
+		return errors.Wrap(err, "cannot upload segment")
+	}
+	seg.Size = hr.n
+	return nil
+}
+
+func (s *objectSnapshotter) ListSegments() ([]*Segment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	objects, err := s.store.list(ctx, s.prefix+segmentFilename+".")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list segments")
+	}
+	segments := make([]*Segment, 0)
+	for _, obj := range objects {
+		seg, ok := parseSegmentName(strings.TrimPrefix(obj.Key, s.prefix))
+		if !ok {
+			continue
+		}
+		seg.Size = obj.Size
+		segments = append(segments, seg)
+	}
+	sortSegments(segments)
+	return segments, nil
+}
+
+func (s *objectSnapshotter) LoadSegment(name string) (io.ReadCloser, error) {
+	if _, ok := parseSegmentName(name); !ok {
+		return nil, errors.Wrapf(ErrSnapshotNotFound, "%#v is not a segment name", name)
+	}
+	r, err := openStream(func(ctx context.Context) (io.ReadCloser, error) {
+		return s.store.get(ctx, s.prefix+name)
+	})
+	if err != nil {
+		if errors.Cause(err) == errObjectNotFound {
+			return nil, errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return nil, errors.Wrapf(err, "cannot download segment %s", name)
+	}
+	return r, nil
+}
+
+func (s *objectSnapshotter) DeleteSegment(name string) error {
+	if _, ok := parseSegmentName(name); !ok {
+		return errors.Wrapf(ErrSnapshotNotFound, "%#v is not a segment name", name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	if err := s.store.delete(ctx, s.prefix+name); err != nil {
+		if errors.Cause(err) == errObjectNotFound {
+			return errors.Wrap(ErrSnapshotNotFound, name)
+		}
+		return errors.Wrapf(err, "cannot delete segment %s", name)
+	}
+	return nil
+}
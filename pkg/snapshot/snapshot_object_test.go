@@ -0,0 +1,949 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+var (
+	testGCSEndpoint    = flag.String("test.gcs-endpoint", "", "run the GCS tests against this emulator endpoint (e.g. fake-gcs-server)")
+	testGCSBucket      = flag.String("test.gcs-bucket", "e2d", "bucket used for the GCS tests, it is created if missing")
+	testAzureEndpoint  = flag.String("test.azure-endpoint", "", "run the Azure tests against this emulator endpoint (e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite)")
+	testAzureAccount   = flag.String("test.azure-account", "devstoreaccount1", "storage account used for the Azure tests")
+	testAzureKey       = flag.String("test.azure-key", "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==", "storage account key used for the Azure tests")
+	testAzureContainer = flag.String("test.azure-container", "e2d", "container used for the Azure tests, it is created if missing")
+	testS3Endpoint     = flag.String("test.s3-endpoint", "", "run the S3 tests against this S3-compatible endpoint (e.g. http://127.0.0.1:9000 for MinIO)")
+	testS3Bucket       = flag.String("test.s3-bucket", "e2d", "bucket used for the S3 tests, it must already exist")
+	testS3AccessKey    = flag.String("test.s3-access-key", "minioadmin", "access key used for the S3 tests")
+	testS3SecretKey    = flag.String("test.s3-secret-key", "minioadmin", "secret key used for the S3 tests")
+)
+
+// memStore is an in-memory objectStore.
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string][]byte)}
+}
+
+func (s *memStore) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, errors.Wrap(errObjectNotFound, key)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memStore) put(ctx context.Context, key string, r io.Reader, sizeHint int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStore) list(ctx context.Context, prefix string) ([]objectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := make([]objectInfo, 0)
+	for k, v := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			objects = append(objects, objectInfo{Key: k, Size: int64(len(v))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *memStore) delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return errors.Wrap(errObjectNotFound, key)
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+// testSnapshotter checks the behavior shared by all Snapshotter
+// implementations.
+func testSnapshotter(t *testing.T, s Snapshotter) {
+	t.Helper()
+
+	if _, err := s.Load(); err == nil {
+		t.Fatal("expected error loading from empty snapshotter")
+	}
+	saved := make([]*Manifest, 0)
+	for _, data := range []string{"first", "second"} {
+		m := &Manifest{Revision: int64(len(saved) + 1)}
+		if err := s.Save(context.Background(), ioutil.NopCloser(strings.NewReader(data)), m); err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, m)
+	}
+
+	read := func(r io.ReadCloser, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if data := read(s.Load()); data != "second" {
+		t.Fatalf("expected latest snapshot %q, received %q", "second", data)
+	}
+	if data := read(s.LoadAt(saved[0].Name)); data != "first" {
+		t.Fatalf("expected snapshot %q, received %q", "first", data)
+	}
+
+	// replacing a snapshot keeps its name and when it was created, and
+	// updates its manifest so it is still verified
+	m, err := s.LoadManifest(saved[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Revision != 1 || !m.Created.Equal(saved[0].Created) {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+	m.KeyID = "replaced"
+	if err := s.Replace(context.Background(), ioutil.NopCloser(strings.NewReader("FIRST!")), m); err != nil {
+		t.Fatal(err)
+	}
+	if data := read(s.LoadAt(saved[0].Name)); data != "FIRST!" {
+		t.Fatalf("expected snapshot %q, received %q", "FIRST!", data)
+	}
+	if data := read(s.Load()); data != "second" {
+		t.Fatalf("expected latest snapshot %q, received %q", "second", data)
+	}
+	if m, err := s.LoadManifest(saved[0].Name); err != nil || m.KeyID != "replaced" || m.Size != int64(len("FIRST!")) {
+		t.Fatalf("unexpected manifest: %+v (%v)", m, err)
+	}
+
+	snapshots, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != saved[0].Name || snapshots[1].Name != saved[1].Name {
+		t.Fatalf("unexpected snapshots listed: %v", snapshots)
+	}
+	if snapshots[0].Size != int64(len("FIRST!")) {
+		t.Fatalf("expected snapshot size %d, received %d", len("FIRST!"), snapshots[0].Size)
+	}
+
+	if latest, err := s.Latest(); err != nil || latest != saved[1].Name {
+		t.Fatalf("expected latest snapshot %s, received %s (%v)", saved[1].Name, latest, err)
+	}
+	if err := s.Delete(saved[1].Name); errors.Cause(err) != ErrSnapshotInUse {
+		t.Fatalf("expected ErrSnapshotInUse, received %v", err)
+	}
+	if err := s.Delete(saved[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoadAt(saved[0].Name); errors.Cause(err) != ErrSnapshotNotFound {
+		t.Fatalf("expected ErrSnapshotNotFound, received %v", err)
+	}
+
+	// segments are stored alongside the snapshots without being listed as
+	// snapshots, and the last follows on from a snapshot that was deleted
+	for _, seg := range []*Segment{
+		{ClusterID: "abc", Base: saved[1].Name, StartRevision: 10, EndRevision: 20},
+		{ClusterID: "abc", Base: saved[1].Name, StartRevision: 2, EndRevision: 10},
+		{ClusterID: "abc", Base: saved[0].Name, StartRevision: 20, EndRevision: 30},
+	} {
+		if err := s.SaveSegment(context.Background(), strings.NewReader("segment"), seg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segments, err := s.ListSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 || segments[0].StartRevision != 2 || segments[1].EndRevision != 20 || segments[0].Size != int64(len("segment")) {
+		t.Fatalf("unexpected segments listed: %v", segments)
+	}
+	if segments[2].Base != saved[0].Name || segments[2].ClusterID != "abc" {
+		t.Fatalf("unexpected segments listed: %v", segments)
+	}
+	if snapshots, err := s.List(); err != nil || len(snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot, received %v (%v)", snapshots, err)
+	}
+	if data := read(s.LoadSegment(segments[1].Name)); data != "segment" {
+		t.Fatalf("expected segment %q, received %q", "segment", data)
+	}
+	if _, err := PruneSegments(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoadSegment(segments[2].Name); errors.Cause(err) != ErrSnapshotNotFound {
+		t.Fatalf("expected ErrSnapshotNotFound, received %v", err)
+	}
+	for _, seg := range segments[:2] {
+		if err := s.DeleteSegment(seg.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileSnapshotter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileSnapshotter(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSnapshotter(t, fs)
+}
+
+func TestObjectSnapshotter(t *testing.T) {
+	store := newMemStore()
+	s := &objectSnapshotter{store: store, prefix: "backups/"}
+	testSnapshotter(t, s)
+
+	// corrupting a stored snapshot must fail verification
+	l, err := s.latest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.objects[l.Path] = []byte("SECOND")
+	r, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); errors.Cause(err) != ErrChecksumMismatch {
+		t.Fatalf("expected ErrChecksumMismatch, received %v", err)
+	}
+}
+
+// stallingStore is an objectStore whose snapshot downloads stop sending data
+// after the first byte, until the request is cancelled.
+type stallingStore struct {
+	*memStore
+}
+
+func (s *stallingStore) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.memStore.get(ctx, key)
+	if err != nil || strings.HasSuffix(key, manifestSuffix) || strings.HasSuffix(key, latestSuffix) {
+		return r, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer r.Close()
+		if _, err := io.CopyN(pw, r, 1); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		<-ctx.Done()
+		pw.CloseWithError(ctx.Err())
+	}()
+	return pr, nil
+}
+
+func TestObjectSnapshotterStalledDownload(t *testing.T) {
+	defer func(d time.Duration) { downloadIdleTimeout = d }(downloadIdleTimeout)
+	downloadIdleTimeout = 100 * time.Millisecond
+
+	store := &stallingStore{newMemStore()}
+	s := &objectSnapshotter{store: store, prefix: "backups/"}
+	if err := s.Save(context.Background(), ioutil.NopCloser(strings.NewReader("snapshot")), nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err == nil || !strings.Contains(err.Error(), "download stalled") {
+		t.Fatalf("expected stalled download error, received %v", err)
+	}
+}
+
+// failingStore is an objectStore that fails to store the object with the
+// provided key.
+type failingStore struct {
+	*memStore
+	key string
+}
+
+func (s *failingStore) put(ctx context.Context, key string, r io.Reader, sizeHint int64) error {
+	if key == s.key {
+		return errors.New("interrupted")
+	}
+	return s.memStore.put(ctx, key, r, sizeHint)
+}
+
+func TestObjectSnapshotterInterruptedReplace(t *testing.T) {
+	store := &failingStore{memStore: newMemStore()}
+	s := &objectSnapshotter{store: store, prefix: "backups/"}
+	m := &Manifest{}
+	if err := s.Save(context.Background(), ioutil.NopCloser(strings.NewReader("first")), m); err != nil {
+		t.Fatal(err)
+	}
+	read := func() string {
+		t.Helper()
+		r, err := s.LoadAt(m.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// the new snapshot cannot be moved into place, which leaves the old
+	// snapshot verifying against the updated manifest
+	store.key = s.prefix + m.Name
+	if err := s.Replace(context.Background(), ioutil.NopCloser(strings.NewReader("second")), m); err == nil {
+		t.Fatal("expected error replacing snapshot")
+	}
+	if data := read(); data != "first" {
+		t.Fatalf("expected snapshot %q, received %q", "first", data)
+	}
+	m, err := s.LoadManifest(m.Name)
+	if err != nil || !m.Replacing() {
+		t.Fatalf("expected the manifest to be replacing, received %+v (%v)", m, err)
+	}
+
+	// the new snapshot also verifies, had it been moved into place
+	store.key = ""
+	tmp := store.objects[s.prefix+replacementName(m.Name)]
+	store.objects[s.prefix+m.Name] = tmp
+	if data := read(); data != "second" {
+		t.Fatalf("expected snapshot %q, received %q", "second", data)
+	}
+
+	// replacing it again finishes the interrupted replace first
+	if err := s.Replace(context.Background(), ioutil.NopCloser(strings.NewReader("third")), m); err != nil {
+		t.Fatal(err)
+	}
+	if data := read(); data != "third" {
+		t.Fatalf("expected snapshot %q, received %q", "third", data)
+	}
+	if m, err := s.LoadManifest(m.Name); err != nil || m.Replacing() {
+		t.Fatalf("expected the replace to be finished, received %+v (%v)", m, err)
+	}
+	if _, ok := store.objects[s.prefix+replacementName(m.Name)]; ok {
+		t.Fatal("expected the new snapshot to be removed once moved into place")
+	}
+}
+
+func TestObjectSnapshotterPrune(t *testing.T) {
+	store := newMemStore()
+	s := &objectSnapshotter{store: store, prefix: "backups/", retentionTime: time.Hour}
+
+	// the expired snapshot LATEST points to is kept until a newer one is
+	// saved
+	now := time.Now()
+	old := []string{
+		newSnapshotName(now.Add(-3 * time.Hour)),
+		newSnapshotName(now.Add(-2 * time.Hour)),
+	}
+	for _, name := range old {
+		store.objects["backups/"+name] = []byte(name)
+		store.objects["backups/"+manifestName(name)] = []byte("{}")
+	}
+	latest, err := (&LatestFile{Path: "backups/" + old[1]}).generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.objects[s.latestKey()] = latest
+	if err := pruneSnapshots(s, "", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name != old[1] {
+		t.Fatalf("expected only %s to be kept, received %v", old[1], snapshots)
+	}
+	if _, ok := store.objects["backups/"+manifestName(old[0])]; ok {
+		t.Fatal("expected manifest of pruned snapshot to be removed")
+	}
+
+	if err := s.Save(context.Background(), ioutil.NopCloser(strings.NewReader("new")), nil); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err = s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name == old[1] {
+		t.Fatalf("expected only the new snapshot to be kept, received %v", snapshots)
+	}
+}
+
+func TestGCSSnapshotter(t *testing.T) {
+	if *testGCSEndpoint == "" {
+		t.Skip("-test.gcs-endpoint not set")
+	}
+
+	// the emulator starts without any buckets
+	resp, err := http.Post(*testGCSEndpoint+"/storage/v1/b", "application/json", strings.NewReader(fmt.Sprintf(`{"name":%q}`, *testGCSBucket)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		t.Fatalf("cannot create bucket %s: %s", *testGCSBucket, resp.Status)
+	}
+
+	s, err := NewGCSSnapshotter(&GCSConfig{
+		Bucket:   *testGCSBucket,
+		Key:      "test-" + time.Now().Format("20060102150405") + "/",
+		Endpoint: *testGCSEndpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSnapshotter(t, s)
+
+	// objects larger than a chunk are uploaded in several requests
+	data := make([]byte, 2*gcsChunkSize+1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := s.store.put(context.Background(), s.prefix+"large", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := s.store.get(context.Background(), s.prefix+"large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	received, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatalf("expected %d bytes downloaded, received %d differing bytes", len(data), len(received))
+	}
+}
+
+// TestGCSResumableUpload checks that a resumable upload spanning several
+// chunks continues from where the server left off after a failed request.
+func TestGCSResumableUpload(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []byte
+		done     bool
+		failed   bool
+		lost     bool
+	)
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", "http://"+srv.Listener.Addr().String()+"/session")
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		if len(data) == 0 && !failed {
+			// emulators like fake-gcs-server complete the upload when
+			// queried, so the status is only queried to resume
+			t.Errorf("unexpected upload status query before a failed request")
+		}
+		if len(data) > 0 {
+			var start, end int64
+			var total string
+			if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%s", &start, &end, &total); err != nil || start != int64(len(received)) || end-start+1 != int64(len(data)) {
+				t.Errorf("unexpected content range %q with %d bytes received", r.Header.Get("Content-Range"), len(received))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// only store half of the second chunk and fail the request
+			if !failed && start > 0 {
+				failed = true
+				received = append(received, data[:len(data)/2]...)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			received = append(received, data...)
+			done = total != "*"
+
+			// complete the upload, but lose the response
+			if done && !lost {
+				lost = true
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if done {
+			return
+		}
+		if len(received) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(received)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+	})
+	srv.Start()
+	defer srv.Close()
+
+	data := make([]byte, 2*gcsChunkSize+1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	store := &gcsStore{client: http.DefaultClient, endpoint: srv.URL, bucket: "e2d"}
+	if err := store.put(context.Background(), "snapshot", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if !failed || !lost || !done {
+		t.Fatalf("expected failed requests and a completed upload, received failed=%v lost=%v done=%v", failed, lost, done)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatalf("expected %d bytes uploaded, received %d differing bytes", len(data), len(received))
+	}
+}
+
+func TestAzureSnapshotter(t *testing.T) {
+	if *testAzureEndpoint == "" {
+		t.Skip("-test.azure-endpoint not set")
+	}
+
+	// the emulator starts without any containers, and replies 409 with a
+	// custom status text when it already exists
+	key, err := base64.StdEncoding.DecodeString(*testAzureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(strings.TrimSuffix(*testAzureEndpoint, "/") + "/" + *testAzureContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &azureStore{client: http.DefaultClient, container: u, account: *testAzureAccount, key: key}
+	q := url.Values{}
+	q.Set("restype", "container")
+	resp, err := store.do(context.Background(), http.MethodPut, "", q, nil, nil)
+	if err != nil && !strings.Contains(err.Error(), ": 409 ") {
+		t.Fatalf("cannot create container %s: %v", *testAzureContainer, err)
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	s, err := NewAzureSnapshotter(&AzureConfig{
+		Account:    *testAzureAccount,
+		Container:  *testAzureContainer,
+		Key:        "test-" + time.Now().Format("20060102150405") + "/",
+		AccountKey: *testAzureKey,
+		Endpoint:   *testAzureEndpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSnapshotter(t, s)
+}
+
+func TestAzureSign(t *testing.T) {
+	key := []byte("secret")
+	store := &azureStore{account: "myaccount", key: key}
+
+	cases := []struct {
+		method        string
+		url           string
+		header        map[string]string
+		contentLength int64
+		expected      string
+	}{
+		{
+			method:        http.MethodPut,
+			url:           "https://myaccount.blob.core.windows.net/etcd/backups/etcd.snapshot.1?comp=block&blockid=MDAwMDAwMDA%3D",
+			header:        map[string]string{"x-ms-date": "Mon, 01 Jun 2020 12:00:00 GMT", "x-ms-version": azureAPIVersion},
+			contentLength: 5,
+			expected: "PUT\n\n\n5\n\n\n\n\n\n\n\n\n" +
+				"x-ms-date:Mon, 01 Jun 2020 12:00:00 GMT\n" +
+				"x-ms-version:2019-12-12\n" +
+				"/myaccount/etcd/backups/etcd.snapshot.1\n" +
+				"blockid:MDAwMDAwMDA=\n" +
+				"comp:block",
+		},
+		{
+			method: http.MethodGet,
+			url:    "https://myaccount.blob.core.windows.net/etcd?restype=container&comp=list&prefix=backups%2F",
+			header: map[string]string{"Content-Type": "application/xml", "Range": "bytes=0-9", "X-Ms-Version": azureAPIVersion, "x-ms-date": "Mon, 01 Jun 2020 12:00:00 GMT"},
+			expected: "GET\n\n\n\n\napplication/xml\n\n\n\n\n\nbytes=0-9\n" +
+				"x-ms-date:Mon, 01 Jun 2020 12:00:00 GMT\n" +
+				"x-ms-version:2019-12-12\n" +
+				"/myaccount/etcd\n" +
+				"comp:list\n" +
+				"prefix:backups/\n" +
+				"restype:container",
+		},
+	}
+	for _, tc := range cases {
+		req, err := http.NewRequest(tc.method, tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(tc.expected))
+		expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		if sig := store.sign(req, tc.contentLength); sig != expected {
+			t.Errorf("%s %s: expected signature %s, received %s", tc.method, tc.url, expected, sig)
+		}
+	}
+}
+
+// azureServer is a minimal Blob service, storing blobs uploaded as blocks and
+// listing them a couple at a time to exercise paging.
+type azureServer struct {
+	t         *testing.T
+	key       []byte
+	mu        sync.Mutex
+	blocks    map[string][]byte
+	blobs     map[string][]byte
+	committed [][]string
+}
+
+func (s *azureServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "SharedKey devstoreaccount1:"+s.signature(r) || r.Header.Get("x-ms-version") != azureAPIVersion || r.Header.Get("x-ms-date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "AuthenticationFailed")
+		return
+	}
+	q := r.URL.Query()
+	key := strings.TrimPrefix(r.URL.Path, "/e2d")
+	key = strings.TrimPrefix(key, "/")
+	switch {
+	case r.Method == http.MethodGet && q.Get("comp") == "list":
+		names := make([]string, 0)
+		for name := range s.blobs {
+			if strings.HasPrefix(name, q.Get("prefix")) && name > q.Get("marker") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="e2d"><Blobs>`)
+		for i, name := range names {
+			if i == 2 {
+				break
+			}
+			fmt.Fprintf(w, "<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length><BlobType>BlockBlob</BlobType></Properties></Blob>", name, len(s.blobs[name]))
+		}
+		fmt.Fprint(w, "</Blobs>")
+		if len(names) > 2 {
+			fmt.Fprintf(w, "<NextMarker>%s</NextMarker>", names[1])
+		} else {
+			fmt.Fprint(w, "<NextMarker />")
+		}
+		fmt.Fprint(w, "</EnumerationResults>")
+	case r.Method == http.MethodGet && q.Get("restype") == "container":
+	case r.Method == http.MethodGet:
+		data, ok := s.blobs[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		data, _ := ioutil.ReadAll(r.Body)
+		if r.ContentLength != int64(len(data)) {
+			s.t.Errorf("expected Content-Length %d, received %d", len(data), r.ContentLength)
+		}
+		s.blocks[key+"/"+q.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+			s.t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var blob []byte
+		for _, id := range list.Latest {
+			data, ok := s.blocks[key+"/"+id]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			blob = append(blob, data...)
+		}
+		s.blobs[key] = blob
+		s.committed = append(s.committed, list.Latest)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		if _, ok := s.blobs[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.blobs, key)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// signature computes the Shared Key signature of a request as received by the
+// Blob service, following the string-to-sign from the service documentation
+// rather than reusing azureStore.sign.
+func (s *azureServer) signature(r *http.Request) string {
+	length := ""
+	if r.ContentLength > 0 {
+		length = fmt.Sprint(r.ContentLength)
+	}
+	lines := []string{
+		r.Method,
+		r.Header.Get("Content-Encoding"),
+		r.Header.Get("Content-Language"),
+		length,
+		r.Header.Get("Content-MD5"),
+		r.Header.Get("Content-Type"),
+		r.Header.Get("Date"),
+		r.Header.Get("If-Modified-Since"),
+		r.Header.Get("If-Match"),
+		r.Header.Get("If-None-Match"),
+		r.Header.Get("If-Unmodified-Since"),
+		r.Header.Get("Range"),
+	}
+
+	msHeaders := make(map[string]string)
+	names := make([]string, 0)
+	for k, v := range r.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-ms-") {
+			msHeaders[k] = strings.TrimSpace(strings.Join(v, ","))
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		lines = append(lines, k+":"+msHeaders[k])
+	}
+
+	path := r.RequestURI
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	resource := "/devstoreaccount1" + path
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		s.t.Error(err)
+	}
+	params := make([]string, 0)
+	for k, v := range query {
+		sort.Strings(v)
+		params = append(params, strings.ToLower(k)+":"+strings.Join(v, ","))
+	}
+	sort.Strings(params)
+	for _, p := range params {
+		resource += "\n" + p
+	}
+	lines = append(lines, resource)
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TestAzureStore checks the Blob service requests against a fake server:
+// uploading as blocks and committing them, and paging through blob lists.
+func TestAzureStore(t *testing.T) {
+	key, err := base64.StdEncoding.DecodeString(*testAzureKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &azureServer{t: t, key: key, blocks: make(map[string][]byte), blobs: make(map[string][]byte)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// requests signed with another key are rejected
+	if _, err := NewAzureSnapshotter(&AzureConfig{
+		Account:    "devstoreaccount1",
+		Container:  "e2d",
+		AccountKey: base64.StdEncoding.EncodeToString([]byte("secret")),
+		Endpoint:   ts.URL,
+	}); err == nil || !strings.Contains(err.Error(), "AuthenticationFailed") {
+		t.Fatalf("expected authentication to fail, received %v", err)
+	}
+
+	s, err := NewAzureSnapshotter(&AzureConfig{
+		Account:    "devstoreaccount1",
+		Container:  "e2d",
+		Key:        "backups/",
+		AccountKey: *testAzureKey,
+		Endpoint:   ts.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSnapshotter(t, s)
+
+	// blobs larger than a block are committed in order
+	data := make([]byte, 2*azureMinBlockSize+1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	store := s.store.(*azureStore)
+	srv.committed = nil
+	if err := store.put(context.Background(), "large", bytes.NewReader(data), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.committed) != 1 || len(srv.committed[0]) != 3 {
+		t.Fatalf("expected 1 block list of 3 blocks, received %v", srv.committed)
+	}
+	if !bytes.Equal(srv.blobs["large"], data) {
+		t.Fatal("committed blob differs")
+	}
+
+	// several pages are listed
+	for _, name := range []string{"list/a", "list/b", "list/c", "list/d", "list/e"} {
+		srv.blobs[name] = []byte(name)
+	}
+	objects, err := store.list(context.Background(), "list/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 5 || objects[0].Key != "list/a" || objects[4].Key != "list/e" || objects[2].Size != int64(len("list/c")) {
+		t.Fatalf("unexpected objects listed: %v", objects)
+	}
+}
+
+func TestNewAWSConfig(t *testing.T) {
+	cfg, err := newAWSConfig(&AmazonConfig{Endpoint: "http://minio:9000", ForcePathStyle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(cfg.Region) != "us-east-1" || aws.StringValue(cfg.Endpoint) != "http://minio:9000" || !aws.BoolValue(cfg.S3ForcePathStyle) {
+		t.Fatalf("unexpected config: %v", cfg)
+	}
+	cfg, err = newAWSConfig(&AmazonConfig{Endpoint: "https://nyc3.digitaloceanspaces.com", Region: "nyc3", AccessKey: "key", SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(cfg.Region) != "nyc3" || cfg.Credentials == nil {
+		t.Fatalf("unexpected config: %v", cfg)
+	}
+
+	// static credentials would silently replace the role session
+	if _, err := newAWSConfig(&AmazonConfig{RoleSessionName: "e2d", AccessKey: "key"}); err == nil {
+		t.Fatal("expected error using both a role session and static credentials")
+	}
+}
+
+func TestAmazonSnapshotterRemovesLegacyLifecycleRule(t *testing.T) {
+	var put string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["lifecycle"]; !ok {
+			// the bucket exists
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprint(w, `<LifecycleConfiguration>`+
+				`<Rule><ID>E2DLifecycle-backup/</ID><Filter><Prefix>backup/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule>`+
+				`<Rule><ID>other</ID><Filter><Prefix>other/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>7</Days></Expiration></Rule>`+
+				`</LifecycleConfiguration>`)
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(r.Body)
+			put = string(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	cfg, err := newAWSConfig(&AmazonConfig{Endpoint: srv.URL, ForcePathStyle: true, AccessKey: "key", SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAmazonSnapshotter(cfg, "bucket", "backup/", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(put, "<ID>other</ID>") || strings.Contains(put, "E2DLifecycle") {
+		t.Fatalf("expected only the legacy rule to be removed, received %s", put)
+	}
+}
+
+func TestAmazonSnapshotterCompatible(t *testing.T) {
+	if *testS3Endpoint == "" {
+		t.Skip("-test.s3-endpoint not set")
+	}
+	s, err := NewAmazonSnapshotter(&AmazonConfig{
+		Bucket:         *testS3Bucket,
+		Key:            "test-" + time.Now().Format("20060102150405") + "/",
+		Endpoint:       *testS3Endpoint,
+		ForcePathStyle: true,
+		AccessKey:      *testS3AccessKey,
+		SecretKey:      *testS3SecretKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSnapshotter(t, s)
+}
+
+func TestHTTPSnapshotter(t *testing.T) {
+	dav := &webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	if err := dav.FileSystem.Mkdir(context.Background(), "/etcd", 0700); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	if _, err := NewHTTPSnapshotter(&HTTPConfig{URL: srv.URL + "/etcd"}); err != ErrInvalidDirectoryPath {
+		t.Fatalf("expected ErrInvalidDirectoryPath, received %v", err)
+	}
+	s, err := NewHTTPSnapshotter(&HTTPConfig{URL: srv.URL + "/etcd/", BearerToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSnapshotter(t, s)
+}
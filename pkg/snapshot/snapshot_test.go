@@ -1,7 +1,16 @@
 package snapshot
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/errors"
@@ -51,6 +60,46 @@ func TestParseSnapshotBackupURL(t *testing.T) {
 			url:      "s3://abc/backupdir/",
 			expected: &URL{Type: S3Type, Bucket: "abc", Path: "backupdir/"},
 		},
+		{
+			name:     "gcs bucket with prefix",
+			url:      "gs://abc/backupdir/",
+			expected: &URL{Type: GCSType, Bucket: "abc", Path: "backupdir/"},
+		},
+		{
+			name:     "azure container with prefix",
+			url:      "azblob://abc/backupdir/",
+			expected: &URL{Type: AzureType, Bucket: "abc", Path: "backupdir/"},
+		},
+		{
+			name:     "digitalocean spaces bucket",
+			url:      "https://nyc3.digitaloceanspaces.com/abc",
+			expected: &URL{Type: S3Type, Bucket: "abc", Path: "", Endpoint: "https://nyc3.digitaloceanspaces.com"},
+		},
+		{
+			name:     "digitalocean spaces bucket with prefix",
+			url:      "https://nyc3.digitaloceanspaces.com/abc/backupdir/",
+			expected: &URL{Type: S3Type, Bucket: "abc", Path: "backupdir/", Endpoint: "https://nyc3.digitaloceanspaces.com"},
+		},
+		{
+			name:        "digitalocean spaces with no bucket (should fail)",
+			url:         "https://nyc3.digitaloceanspaces.com/",
+			expectedErr: ErrCannotParseURL,
+		},
+		{
+			name:     "http directory",
+			url:      "https://backups.example.com:8443/etcd/",
+			expected: &URL{Type: HTTPType, Path: "etcd/", Endpoint: "https://backups.example.com:8443"},
+		},
+		{
+			name:        "http with no directory (should fail)",
+			url:         "http://backups.example.com/etcd",
+			expectedErr: ErrInvalidDirectoryPath,
+		},
+		{
+			name:        "gcs with no directory (should fail)",
+			url:         "gs://abc/backupdir",
+			expectedErr: ErrInvalidDirectoryPath,
+		},
 		{
 			name:     "s3 with no directory (should fail)",
 			url:      "s3://abc/backupdir",
@@ -70,3 +119,242 @@ func TestParseSnapshotBackupURL(t *testing.T) {
 		})
 	}
 }
+
+func TestFind(t *testing.T) {
+	snapshots := []*Snapshot{
+		{Name: "etcd.snapshot.1000", Timestamp: time.Unix(1000, 0).UTC()},
+		{Name: "etcd.snapshot.2000", Timestamp: time.Unix(2000, 0).UTC()},
+		{Name: "etcd.snapshot.3000", Timestamp: time.Unix(3000, 0).UTC()},
+		{Name: "etcd.snapshot.3000.250000000", Timestamp: time.Unix(3000, 250000000).UTC()},
+		{Name: "etcd.snapshot.3000.500000000", Timestamp: time.Unix(3000, 500000000).UTC()},
+	}
+	tests := []struct {
+		name        string
+		id          string
+		expected    string
+		expectedErr error
+	}{
+		{name: "exact name", id: "etcd.snapshot.2000", expected: "etcd.snapshot.2000"},
+		{name: "exact unix time", id: "2000", expected: "etcd.snapshot.2000"},
+		{name: "unix time between snapshots", id: "2999", expected: "etcd.snapshot.2000"},
+		{name: "unix time after newest", id: "5000", expected: "etcd.snapshot.3000.500000000"},
+		{name: "exact name within second", id: "etcd.snapshot.3000.250000000", expected: "etcd.snapshot.3000.250000000"},
+		{name: "unix time includes second", id: "3000", expected: "etcd.snapshot.3000.500000000"},
+		{name: "rfc3339 within second", id: time.Unix(3000, 300000000).UTC().Format(time.RFC3339Nano), expected: "etcd.snapshot.3000.250000000"},
+		{name: "rfc3339", id: time.Unix(1500, 0).UTC().Format(time.RFC3339), expected: "etcd.snapshot.1000"},
+		{name: "before oldest", id: "999", expectedErr: ErrSnapshotNotFound},
+		{name: "unknown name", id: "etcd.snapshot.LATEST", expectedErr: ErrSnapshotNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Find(snapshots, tt.id)
+			if errors.Cause(err) != tt.expectedErr {
+				t.Fatalf("expected error %v, received %v", tt.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if s.Name != tt.expected {
+				t.Fatalf("expected %s, received %s", tt.expected, s.Name)
+			}
+		})
+	}
+}
+
+func TestSnapshotName(t *testing.T) {
+	now := time.Now()
+	names := []string{
+		newSnapshotName(now.Add(-time.Second).Truncate(time.Second)),
+		newSnapshotName(now.Truncate(time.Second)),
+		newSnapshotName(now),
+		newSnapshotName(now.Add(1)),
+	}
+	if names[2] == names[3] {
+		t.Fatalf("expected unique names within the same second, received %s", names[2])
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Fatalf("expected names to sort by time, received %v", names)
+	}
+	for _, name := range names {
+		if _, ok := parseSnapshotName(name); !ok {
+			t.Fatalf("expected valid snapshot name: %s", name)
+		}
+	}
+	if ts, ok := parseSnapshotName(names[2]); !ok || !ts.Equal(now) {
+		t.Fatalf("expected %v, received %v", now, ts)
+	}
+
+	// names of snapshots saved by older versions
+	if ts, ok := parseSnapshotName("etcd.snapshot.1591012800"); !ok || !ts.Equal(time.Unix(1591012800, 0)) {
+		t.Fatalf("expected legacy snapshot name to be valid, received %v", ts)
+	}
+	for _, name := range []string{"etcd.snapshot.LATEST", "etcd.snapshot.1591012800.manifest", "etcd.snapshot.1591012800.000000000.manifest", "etcd.snapshot.1591012800.5", "etcd.segment.1-2"} {
+		if _, ok := parseSnapshotName(name); ok {
+			t.Fatalf("expected invalid snapshot name: %s", name)
+		}
+	}
+}
+
+func TestFileSnapshotterLoadAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"etcd.snapshot.3000", "etcd.snapshot.1000", "etcd.snapshot.2000", "unrelated"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs, err := NewFileSnapshotter(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := fs.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, s := range snapshots {
+		names = append(names, s.Name)
+	}
+	expected := []string{"etcd.snapshot.1000", "etcd.snapshot.2000", "etcd.snapshot.3000"}
+	if diff := cmp.Diff(expected, names); diff != "" {
+		t.Fatalf("snapshot: List differs: (-want +got)\n%s", diff)
+	}
+
+	r, err := fs.LoadAt("2500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "etcd.snapshot.2000" {
+		t.Fatalf("expected etcd.snapshot.2000, received %s", data)
+	}
+}
+
+func TestFileSnapshotterManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileSnapshotter(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("snapshot data")
+	m := &Manifest{Revision: 10, Leader: "node1", Compressed: true}
+	if err := fs.Save(context.Background(), ioutil.NopCloser(bytes.NewReader(data)), m); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if m.Size != int64(len(data)) || m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected manifest size/checksum: %d %s", m.Size, m.SHA256)
+	}
+	stored, err := fs.readManifest(context.Background(), m.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(m, stored); diff != "" {
+		t.Fatalf("snapshot: stored manifest differs: (-want +got)\n%s", diff)
+	}
+
+	r, err := fs.LoadAt(m.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatalf("expected snapshot to verify, received %v", err)
+	}
+	r.Close()
+
+	// corrupt the stored snapshot without changing its size
+	if err := ioutil.WriteFile(filepath.Join(dir, m.Name), []byte("snapshot DATA"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err = fs.LoadAt(m.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); errors.Cause(err) != ErrChecksumMismatch {
+		t.Fatalf("expected ErrChecksumMismatch, received %v", err)
+	}
+}
+
+func TestFileSnapshotterPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := NewFileSnapshotter(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := []string{
+		newSnapshotName(now.Add(-3 * time.Hour)),
+		newSnapshotName(now.Add(-2 * time.Hour)),
+	}
+	for _, name := range append(old, manifestName(old[0]), tmpFilePrefix+"1234") {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, name), now.Add(-3*time.Hour), now.Add(-3*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// LATEST pointing to an expired snapshot must survive pruning
+	if err := os.Symlink(old[1], filepath.Join(dir, "etcd.snapshot.LATEST")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.prune("", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	expected := []string{old[1], "etcd.snapshot.LATEST"}
+	if diff := cmp.Diff(expected, files); diff != "" {
+		t.Fatalf("snapshot: files after prune differ: (-want +got)\n%s", diff)
+	}
+
+	// a successful save moves LATEST, allowing the previous snapshot to be
+	// pruned
+	if err := fs.Save(context.Background(), ioutil.NopCloser(bytes.NewReader([]byte("data"))), nil); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := fs.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name == old[1] {
+		t.Fatalf("expected only the new snapshot to remain, received %v", snapshots)
+	}
+	r, err := fs.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Fatalf("expected latest snapshot to contain %q, received %q", "data", data)
+	}
+}
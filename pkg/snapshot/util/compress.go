@@ -0,0 +1,141 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Codec compresses snapshots and segments. A compressed stream is identified
+// by the magic bytes the codec writes at its start, so it can be decompressed
+// without knowing which codec was used.
+type Codec interface {
+	// Name is used to select the codec, e.g. "zstd".
+	Name() string
+
+	// Magic returns the bytes at the start of every stream compressed by
+	// the codec.
+	Magic() []byte
+
+	// NewWriter returns a writer compressing into w. When fast is set the
+	// data is not expected to compress, e.g. because it is encrypted, so
+	// the codec should spend as little time on it as possible.
+	NewWriter(w io.Writer, fast bool) (io.WriteCloser, error)
+
+	// NewReader returns a reader decompressing r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[string]Codec)
+)
+
+// RegisterCodec makes a codec available by name, and detected when
+// decompressing. It replaces any codec registered with the same name.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+// LookupCodec returns the codec registered with the provided name.
+func LookupCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownCodec, "%#v (must be one of %v)", name, codecNames())
+	}
+	return c, nil
+}
+
+// CodecNames returns the names of the registered codecs, sorted.
+func CodecNames() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecNames()
+}
+
+func codecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DetectCodec returns the codec the stream is compressed with, or nil if it
+// is not compressed with any registered codec.
+func DetectCodec(r *io.ReadCloser) Codec {
+	codecsMu.RLock()
+	registered := make([]Codec, 0, len(codecs))
+	n := 0
+	for _, name := range codecNames() {
+		registered = append(registered, codecs[name])
+		if l := len(codecs[name].Magic()); l > n {
+			n = l
+		}
+	}
+	codecsMu.RUnlock()
+
+	magic := peek(r, n)
+	for _, c := range registered {
+		if bytes.HasPrefix(magic, c.Magic()) {
+			return c
+		}
+	}
+	return nil
+}
+
+// IsCompressed reports whether the stream is compressed with any registered
+// codec.
+func IsCompressed(r *io.ReadCloser) bool {
+	return DetectCodec(r) != nil
+}
+
+// NewCompressorReadCloser wraps a data stream with compression by the
+// provided codec. If encryption is also detected, the codec is asked to
+// spend as little time as possible, since encrypted data does not compress.
+func NewCompressorReadCloser(r io.ReadCloser, c Codec) io.ReadCloser {
+	fast := IsEncrypted(&r)
+	return pipe(func(w io.Writer) error {
+		defer r.Close()
+		cw, err := c.NewWriter(w, fast)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(cw, r); err != nil {
+			cw.Close()
+			return err
+		}
+		return cw.Close()
+	})
+}
+
+// NewDecompressorReadCloser wraps a data stream with decompression by the
+// codec detected from the start of the stream. Streams that are not
+// compressed are returned as is.
+func NewDecompressorReadCloser(r io.ReadCloser) io.ReadCloser {
+	c := DetectCodec(&r)
+	if c == nil {
+		return r
+	}
+	cr, err := c.NewReader(r)
+	if err != nil {
+		return &readCloser{r, func() error { return err }}
+	}
+	return &readCloser{
+		Reader: cr,
+		closeFunc: func() error {
+			defer r.Close()
+			return cr.Close()
+		},
+	}
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+
+	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
+)
+
+func TestCodecs(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("etcd snapshot "), 10000)
+
+	if diff := cmp.Diff([]string{"gzip", "lz4", "zstd"}, CodecNames()); diff != "" {
+		t.Fatalf("unexpected codecs: (-want +got)\n%s", diff)
+	}
+	for _, name := range CodecNames() {
+		t.Run(name, func(t *testing.T) {
+			c, err := LookupCodec(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			enc := NewCompressorReadCloser(ioutil.NopCloser(bytes.NewReader(plaintext)), c)
+			defer enc.Close()
+			compressed, err := ioutil.ReadAll(enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(compressed, c.Magic()) {
+				t.Fatalf("expected magic %x, received %x", c.Magic(), compressed[:len(c.Magic())])
+			}
+			if len(compressed) >= len(plaintext)/10 {
+				t.Fatalf("expected compression, received %d bytes from %d", len(compressed), len(plaintext))
+			}
+
+			r := ioutil.NopCloser(bytes.NewReader(compressed))
+			if detected := DetectCodec(&r); detected == nil || detected.Name() != name {
+				t.Fatalf("expected %s to be detected, received %v", name, detected)
+			}
+			dec := NewDecompressorReadCloser(r)
+			defer dec.Close()
+			out, err := ioutil.ReadAll(dec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plaintext, out) {
+				t.Fatal("after decompression differs")
+			}
+
+			// encrypted snapshots are compressed as well, so they are still
+			// detected, but nothing is gained from it
+			enc = NewEncrypterReadCloser(ioutil.NopCloser(bytes.NewReader(plaintext)), crypto.NewKeyring(crypto.NewEncryptionKey()))
+			enc = NewCompressorReadCloser(enc, c)
+			defer enc.Close()
+			compressed, err = ioutil.ReadAll(enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = ioutil.NopCloser(bytes.NewReader(compressed))
+			if !IsCompressed(&r) {
+				t.Fatal("expected encrypted snapshot to be compressed")
+			}
+		})
+	}
+
+	if _, err := LookupCodec("bzip2"); errors.Cause(err) != ErrUnknownCodec {
+		t.Fatalf("expected ErrUnknownCodec, received %v", err)
+	}
+}
+
+func TestDecompressorGzip(t *testing.T) {
+	plaintext := []byte("testing")
+
+	// snapshots compressed by older versions of e2d are plain gzip streams
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dec := NewDecompressorReadCloser(ioutil.NopCloser(&buf))
+	defer dec.Close()
+	out, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(plaintext, out); diff != "" {
+		t.Errorf("after decompression differs: (-want +got)\n%s", diff)
+	}
+
+	// data that is not compressed is returned as is
+	dec = NewDecompressorReadCloser(ioutil.NopCloser(bytes.NewReader(plaintext)))
+	defer dec.Close()
+	out, err = ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(plaintext, out); diff != "" {
+		t.Errorf("uncompressed data differs: (-want +got)\n%s", diff)
+	}
+}
@@ -2,52 +2,144 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
-	"errors"
 	"io"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
 )
 
+// encryptedSnapshotHeader starts snapshots encrypted by older versions of e2d,
+// which used crypto.Encrypt instead of an envelope.
 var encryptedSnapshotHeader = []byte("ENCRYPTED:")
 
-func isEncrypted(r *io.ReadCloser) bool {
-	return bytes.Equal(
-		peek(r, len(encryptedSnapshotHeader)),
-		encryptedSnapshotHeader,
-	)
+type encryptionFormat int
+
+const (
+	notEncrypted encryptionFormat = iota
+	legacyEncrypted
+	envelopeEncrypted
+)
+
+// detectEncryption returns the format of the encrypted data at the start of
+// the stream. Both formats are detected by their first 6 bytes.
+func detectEncryption(r *io.ReadCloser) encryptionFormat {
+	magic := peek(r, len(crypto.EnvelopeMagic))
+	switch {
+	case magic == nil:
+		return notEncrypted
+	case bytes.Equal(magic, crypto.EnvelopeMagic):
+		return envelopeEncrypted
+	case bytes.Equal(magic, encryptedSnapshotHeader[:len(magic)]):
+		return legacyEncrypted
+	}
+	return notEncrypted
+}
+
+// IsEncrypted reports whether the stream is encrypted, in either format.
+func IsEncrypted(r *io.ReadCloser) bool {
+	return detectEncryption(r) != notEncrypted
+}
+
+// EncryptionKeyID returns the ID of the key the stream was encrypted with,
+// and whether it is encrypted at all. For envelopes with a wrapped data key
+// this is the ID of the key the provider wrapped it with. Data encrypted by
+// older versions of e2d does not identify its key, so the zero KeyID is
+// returned for it.
+func EncryptionKeyID(r *io.ReadCloser) (crypto.KeyID, bool) {
+	switch detectEncryption(r) {
+	case notEncrypted:
+		return crypto.KeyID{}, false
+	case legacyEncrypted:
+		return crypto.KeyID{}, true
+	}
+	id, err := crypto.EnvelopeKeyID(peek(r, crypto.EnvelopeHeaderSize))
+	if err != nil {
+		return crypto.KeyID{}, true
+	}
+	return id, true
+}
+
+// keyProviderTimeout bounds wrapping or unwrapping the data key, which makes a
+// request to the provider when it is Vault or KMS.
+const keyProviderTimeout = 30 * time.Second
+
+// NewEncrypterReadCloser wraps a data stream with encryption using a new
+// data key wrapped by the provided KeyProvider, which is usually a
+// crypto.Keyring. The data is encrypted into an envelope, which authenticates
+// it in chunks as it is decrypted.
+func NewEncrypterReadCloser(r io.ReadCloser, p crypto.KeyProvider) io.ReadCloser {
+	return pipe(func(w io.Writer) error {
+		defer r.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), keyProviderTimeout)
+		defer cancel()
+		// the context is only used to wrap the data key, so it does not
+		// bound streaming the data itself
+		return crypto.EncryptEnvelope(ctx, r, w, p)
+	})
+}
+
+var (
+	ErrNoEncryptionKey = errors.New("no encryption key provided")
+	ErrUnknownKey      = crypto.ErrUnknownKey
+)
+
+// hasKeys reports whether the provider can decrypt anything at all.
+func hasKeys(p crypto.KeyProvider) bool {
+	if keys, ok := p.(*crypto.Keyring); ok {
+		return keys.Len() > 0 || keys.CanEncrypt()
+	}
+	return p != nil
 }
 
-// NewEncrypterReadCloser wraps a data stream with encryption using the
-// provided key. The size of the stream is required ahead of time to provide an
-// offset for the message authentication signature.
-func NewEncrypterReadCloser(r io.ReadCloser, key *[32]byte, size int64) io.ReadCloser {
+// NewDecrypterReadCloser wraps a data stream with decryption, using the
+// provided KeyProvider to unwrap the data key it was encrypted with. When the
+// provider is a crypto.Keyring, data encrypted directly with a key from the
+// keyring by older versions of e2d is also decrypted. Data that is not
+// encrypted is returned as is.
+func NewDecrypterReadCloser(r io.ReadCloser, p crypto.KeyProvider) io.ReadCloser {
+	switch detectEncryption(&r) {
+	case notEncrypted:
+		return r
+	case legacyEncrypted:
+		keys, _ := p.(*crypto.Keyring)
+		return newLegacyDecrypterReadCloser(r, keys.Legacy())
+	}
 	return pipe(func(w io.Writer) error {
 		defer r.Close()
-		if _, err := w.Write(encryptedSnapshotHeader); err != nil {
+		h, err := crypto.ReadEnvelopeHeader(r)
+		if err != nil {
 			return err
 		}
-		if _, err := w.Write(putVarint(size)); err != nil {
+		if !hasKeys(p) {
+			return ErrNoEncryptionKey
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), keyProviderTimeout)
+		key, err := h.DataKey(ctx, p)
+		cancel()
+		if err != nil {
 			return err
 		}
-		return crypto.Encrypt(r, w, key)
+		return h.Decrypt(r, w, key)
 	})
 }
 
-var ErrNoEncryptionKey = errors.New("no encryption key provided")
-
-// NewDecrypterReadCloser wraps a data stream with decryption using the
-// provided key.
-func NewDecrypterReadCloser(r io.ReadCloser, key *[32]byte) io.ReadCloser {
-	if !isEncrypted(&r) {
-		return r
-	}
+// newLegacyDecrypterReadCloser decrypts data encrypted by older versions of
+// e2d. The message authentication is only checked once all of the data has
+// been decrypted.
+func newLegacyDecrypterReadCloser(r io.ReadCloser, key *[32]byte) io.ReadCloser {
 	return pipe(func(w io.Writer) error {
 		defer r.Close()
 		header := make([]byte, len(encryptedSnapshotHeader))
 		if _, err := io.ReadFull(r, header); err != nil {
 			return err
 		}
+		if !bytes.Equal(header, encryptedSnapshotHeader) {
+			return crypto.ErrInvalidEnvelope
+		}
 		if key == nil {
 			return ErrNoEncryptionKey
 		}
@@ -2,12 +2,14 @@ package util
 
 import (
 	"bytes"
+	"crypto/rand"
 	"io"
 	"io/ioutil"
 	"testing"
 
 	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
 	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
 )
 
 func TestSnapshotEncrypter(t *testing.T) {
@@ -15,7 +17,7 @@ func TestSnapshotEncrypter(t *testing.T) {
 	r := ioutil.NopCloser(bytes.NewReader(plaintext))
 
 	key := crypto.NewEncryptionKey()
-	enc := NewEncrypterReadCloser(r, key, int64(len(plaintext)))
+	enc := NewEncrypterReadCloser(r, crypto.NewKeyring(key))
 
 	defer enc.Close()
 
@@ -26,7 +28,7 @@ func TestSnapshotEncrypter(t *testing.T) {
 
 	r = ioutil.NopCloser(bytes.NewReader(out.Bytes()))
 
-	dec := NewDecrypterReadCloser(r, key)
+	dec := NewDecrypterReadCloser(r, crypto.NewKeyring(key))
 	defer dec.Close()
 
 	out.Reset()
@@ -44,7 +46,7 @@ func TestSnapshotEncrypterMessageAuth(t *testing.T) {
 	r := ioutil.NopCloser(bytes.NewReader(plaintext))
 
 	key := crypto.NewEncryptionKey()
-	enc := NewEncrypterReadCloser(r, key, int64(len(plaintext)))
+	enc := NewEncrypterReadCloser(r, crypto.NewKeyring(key))
 	defer enc.Close()
 
 	var out bytes.Buffer
@@ -59,7 +61,7 @@ func TestSnapshotEncrypterMessageAuth(t *testing.T) {
 
 	r = ioutil.NopCloser(bytes.NewReader(bad))
 
-	dec := NewDecrypterReadCloser(r, key)
+	dec := NewDecrypterReadCloser(r, crypto.NewKeyring(key))
 	defer dec.Close()
 
 	out.Reset()
@@ -75,7 +77,7 @@ func TestSnapshotGzipEncrypter(t *testing.T) {
 	r := ioutil.NopCloser(bytes.NewReader(plaintext))
 
 	key := crypto.NewEncryptionKey()
-	enc := NewEncrypterReadCloser(r, key, int64(len(plaintext)))
+	enc := NewEncrypterReadCloser(r, crypto.NewKeyring(key))
 	enc = NewGzipReadCloser(enc)
 
 	defer enc.Close()
@@ -87,8 +89,8 @@ func TestSnapshotGzipEncrypter(t *testing.T) {
 
 	r = ioutil.NopCloser(bytes.NewReader(out.Bytes()))
 
-	dec := NewGunzipReadCloser(r)
-	dec = NewDecrypterReadCloser(dec, key)
+	dec := NewDecompressorReadCloser(r)
+	dec = NewDecrypterReadCloser(dec, crypto.NewKeyring(key))
 	defer dec.Close()
 
 	out.Reset()
@@ -100,3 +102,128 @@ func TestSnapshotGzipEncrypter(t *testing.T) {
 		t.Errorf("after Decrypt differs: (-want +got)\n%s", diff)
 	}
 }
+
+func TestSnapshotEncrypterChunks(t *testing.T) {
+	plaintext := make([]byte, 3*crypto.DefaultChunkSize+100)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	key := crypto.NewEncryptionKey()
+	enc := NewEncrypterReadCloser(ioutil.NopCloser(bytes.NewReader(plaintext)), crypto.NewKeyring(key))
+	defer enc.Close()
+
+	ciphertext, err := ioutil.ReadAll(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypt := func(data []byte) ([]byte, error) {
+		dec := NewDecrypterReadCloser(ioutil.NopCloser(bytes.NewReader(data)), crypto.NewKeyring(key))
+		defer dec.Close()
+		return ioutil.ReadAll(dec)
+	}
+
+	out, err := decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, out) {
+		t.Fatal("after Decrypt differs")
+	}
+
+	h, err := crypto.ReadEnvelopeHeader(bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.KeyID != crypto.NewKeyID(key) {
+		t.Fatalf("expected key ID %s, received %s", crypto.NewKeyID(key), h.KeyID)
+	}
+
+	// dropping the last chunk must be detected, and only authenticated
+	// chunks are returned before the error
+	// each chunk is prefixed with its length and sealed with a 16 byte tag
+	sealed := 4 + crypto.DefaultChunkSize + 16
+	last := 4 + len(plaintext)%crypto.DefaultChunkSize + 16
+	headerSize := len(ciphertext) - 3*sealed - last
+	out, err = decrypt(ciphertext[:len(ciphertext)-last])
+	if err != crypto.ErrTruncated {
+		t.Fatalf("expected ErrTruncated, received %v", err)
+	}
+	if !bytes.Equal(plaintext[:len(out)], out) || len(out) != 3*crypto.DefaultChunkSize {
+		t.Fatalf("expected %d authenticated bytes, received %d", 3*crypto.DefaultChunkSize, len(out))
+	}
+
+	// swapping chunks must be detected
+	swapped := append([]byte{}, ciphertext...)
+	copy(swapped[headerSize:], ciphertext[headerSize+sealed:headerSize+2*sealed])
+	copy(swapped[headerSize+sealed:], ciphertext[headerSize:headerSize+sealed])
+	out, err = decrypt(swapped)
+	if err != crypto.ErrMessageAuthFailed {
+		t.Fatalf("expected ErrMessageAuthFailed, received %v", err)
+	}
+	if len(out) != 0 {
+		t.Fatalf("expected no unauthenticated data, received %d bytes", len(out))
+	}
+}
+
+func TestSnapshotDecrypterLegacy(t *testing.T) {
+	plaintext := []byte("testing")
+	key := crypto.NewEncryptionKey()
+
+	// snapshots encrypted by older versions of e2d
+	var legacy bytes.Buffer
+	legacy.Write(encryptedSnapshotHeader)
+	legacy.Write(putVarint(int64(len(plaintext))))
+	if err := crypto.Encrypt(bytes.NewReader(plaintext), &legacy, key); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecompressorReadCloser(NewGzipReadCloser(ioutil.NopCloser(&legacy)))
+	dec = NewDecrypterReadCloser(dec, crypto.NewKeyring(key))
+	defer dec.Close()
+
+	out, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(plaintext, out); diff != "" {
+		t.Errorf("after Decrypt differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestSnapshotDecrypterKeyring(t *testing.T) {
+	plaintext := []byte("testing")
+	old, key := crypto.NewEncryptionKey(), crypto.NewEncryptionKey()
+
+	enc := NewEncrypterReadCloser(ioutil.NopCloser(bytes.NewReader(plaintext)), crypto.NewKeyring(old))
+	defer enc.Close()
+	ciphertext, err := ioutil.ReadAll(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := ioutil.NopCloser(bytes.NewReader(ciphertext))
+	if id, ok := EncryptionKeyID(&r); !ok || id != crypto.NewKeyID(old) {
+		t.Fatalf("expected key ID %s, received %s", crypto.NewKeyID(old), id)
+	}
+
+	// any key in the keyring decrypts, not only the active key
+	dec := NewDecrypterReadCloser(r, crypto.NewKeyring(key, old))
+	defer dec.Close()
+	out, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(plaintext, out); diff != "" {
+		t.Errorf("after Decrypt differs: (-want +got)\n%s", diff)
+	}
+
+	dec = NewDecrypterReadCloser(ioutil.NopCloser(bytes.NewReader(ciphertext)), crypto.NewKeyring(key))
+	defer dec.Close()
+	if _, err := ioutil.ReadAll(dec); errors.Cause(err) != ErrUnknownKey {
+		t.Fatalf("expected ErrUnknownKey, received %v", err)
+	}
+	dec = NewDecrypterReadCloser(ioutil.NopCloser(bytes.NewReader(ciphertext)), nil)
+	defer dec.Close()
+	if _, err := ioutil.ReadAll(dec); err != ErrNoEncryptionKey {
+		t.Fatalf("expected ErrNoEncryptionKey, received %v", err)
+	}
+}
@@ -1,57 +1,50 @@
 package util
 
 import (
-	"bytes"
 	"compress/gzip"
 	"io"
 )
 
-var gzipMagicHeader = []byte{'\x1f', '\x8b'}
+// Gzip is the codec used by older versions of e2d, which compresses with
+// gzip at the best compression level.
+var Gzip Codec = gzipCodec{}
 
-func isCompressed(r *io.ReadCloser) bool {
-	return bytes.Equal(
-		peek(r, len(gzipMagicHeader)),
-		gzipMagicHeader,
-	)
+func init() {
+	RegisterCodec(Gzip)
 }
 
-func newGzipReadCloser(r io.ReadCloser, level int) io.ReadCloser {
-	return pipe(func(w io.Writer) error {
-		defer r.Close()
-		gw, err := gzip.NewWriterLevel(w, level)
-		if err != nil {
-			return err
-		}
-		if _, err := io.Copy(gw, r); err != nil {
-			return err
-		}
-		return gw.Close()
-	})
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (gzipCodec) Magic() []byte {
+	return []byte{'\x1f', '\x8b'}
+}
+
+func (gzipCodec) NewWriter(w io.Writer, fast bool) (io.WriteCloser, error) {
+	if fast {
+		return gzip.NewWriterLevel(w, gzip.NoCompression)
+	}
+	return gzip.NewWriterLevel(w, gzip.BestCompression)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
 }
 
 // NewGzipReadCloser wraps a data stream with a gzip.Writer. If encryption is
 // also detected, gzip should not use compression.
 func NewGzipReadCloser(r io.ReadCloser) io.ReadCloser {
-	if isEncrypted(&r) {
-		return newGzipReadCloser(r, gzip.NoCompression)
-	}
-	return newGzipReadCloser(r, gzip.BestCompression)
+	return NewCompressorReadCloser(r, Gzip)
 }
 
-// NewGunzipReadCloser wraps a data stream with a gzip.Reader.
+// NewGunzipReadCloser wraps a data stream with decompression. Streams that are
+// not compressed are returned as is.
+//
+// Deprecated: snapshots can be compressed with any registered codec, use
+// NewDecompressorReadCloser instead.
 func NewGunzipReadCloser(r io.ReadCloser) io.ReadCloser {
-	if !isCompressed(&r) {
-		return r
-	}
-	gr, err := gzip.NewReader(r)
-	if err != nil {
-		return &readCloser{r, func() error { return err }}
-	}
-	return &readCloser{
-		Reader: gr,
-		closeFunc: func() error {
-			defer r.Close()
-			return gr.Close()
-		},
-	}
+	return NewDecompressorReadCloser(r)
 }
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// LZ4 compresses with the LZ4 frame format, which compresses less than zstd
+// but is the fastest to both compress and decompress.
+var LZ4 Codec = lz4Codec{}
+
+func init() {
+	RegisterCodec(LZ4)
+}
+
+// lz4Magic starts every LZ4 frame.
+var lz4Magic = []byte{0x04, 0x22, 0x4d, 0x18}
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() string {
+	return "lz4"
+}
+
+func (lz4Codec) Magic() []byte {
+	return lz4Magic
+}
+
+// NewWriter ignores fast, since LZ4 is only used at its fastest level.
+func (lz4Codec) NewWriter(w io.Writer, fast bool) (io.WriteCloser, error) {
+	lw := lz4.NewWriter(w)
+	if err := lw.Apply(lz4.CompressionLevelOption(lz4.Fast)); err != nil {
+		return nil, err
+	}
+	return lw, nil
+}
+
+func (lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(lz4.NewReader(r)), nil
+}
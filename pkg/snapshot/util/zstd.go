@@ -0,0 +1,48 @@
+package util
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstd compresses with Zstandard at its default level, which compresses etcd
+// databases about as well as gzip at its best level in a fraction of the
+// time.
+var Zstd Codec = zstdCodec{}
+
+func init() {
+	RegisterCodec(Zstd)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string {
+	return "zstd"
+}
+
+func (zstdCodec) Magic() []byte {
+	return []byte{'\x28', '\xb5', '\x2f', '\xfd'}
+}
+
+func (zstdCodec) NewWriter(w io.Writer, fast bool) (io.WriteCloser, error) {
+	level := zstd.SpeedDefault
+	if fast {
+		level = zstd.SpeedFastest
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{
+		Reader: zr,
+		closeFunc: func() error {
+			zr.Close()
+			return nil
+		},
+	}, nil
+}
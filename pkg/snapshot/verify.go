@@ -0,0 +1,117 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
+	"github.com/pkg/errors"
+	etcdsnapshot "go.etcd.io/etcd/clientv3/snapshot"
+)
+
+var ErrDatabaseHashMismatch = errors.New("snapshot database hash mismatch")
+
+// DatabaseStatus describes the etcd database contained in a snapshot.
+type DatabaseStatus struct {
+	Revision  int64  `json:"revision"`
+	TotalKeys int    `json:"totalKeys"`
+	Size      int64  `json:"size"`
+	Hash      uint32 `json:"hash"`
+
+	// HashChecked is true when the database ends with the sha256 hash etcd
+	// appends to snapshots and it was verified. Snapshots saved by older
+	// versions of e2d do not include it.
+	HashChecked bool `json:"hashChecked"`
+}
+
+// Extract decodes a stored snapshot, decompressing and decrypting it as
+// needed, and writes the etcd database to the file at path. The snapshot is
+// decrypted with the key it was encrypted with from the provided keyring, and
+// decryption fails if the message authentication does not match. The stored
+// snapshot is read in its entirety so that it is verified against its
+// manifest, even if decoding stops short of the end (e.g. trailing data after
+// gzip).
+func Extract(r io.ReadCloser, keys *crypto.Keyring, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the decoders close the reader they wrap once done, which would prevent
+	// reading what remains of r afterwards
+	dec := snapshotutil.NewDecompressorReadCloser(ioutil.NopCloser(r))
+	dec = snapshotutil.NewDecrypterReadCloser(dec, keys)
+	defer dec.Close()
+
+	if _, err := io.Copy(f, dec); err != nil {
+		return err
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// hasDatabaseHash reports whether a database of the provided size ends with
+// a sha256 hash. This is the same check etcd uses, which relies on the
+// database itself always being a multiple of the 512 byte page size.
+func hasDatabaseHash(size int64) bool {
+	return size%512 == sha256.Size
+}
+
+// checkDatabaseHash verifies the sha256 hash at the end of the database file
+// at path, if there is one.
+func checkDatabaseHash(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if !hasDatabaseHash(info.Size()) {
+		return false, nil
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, info.Size()-sha256.Size); err != nil {
+		return false, err
+	}
+	expected := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(f, expected); err != nil {
+		return false, err
+	}
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return false, errors.Wrapf(ErrDatabaseHashMismatch, "expected sha256 %x, computed %x", expected, h.Sum(nil))
+	}
+	return true, nil
+}
+
+// VerifyDatabase checks that the etcd database file at path is restorable.
+// The sha256 hash appended by etcd is verified when present, then the
+// database is opened to check the integrity of every page and to compute the
+// same hash, revision and key count reported by etcdctl snapshot status.
+func VerifyDatabase(path string) (*DatabaseStatus, error) {
+	hashChecked, err := checkDatabaseHash(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := etcdsnapshot.NewV3(nil).Status(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read snapshot database")
+	}
+	return &DatabaseStatus{
+		Revision:    s.Revision,
+		TotalKeys:   s.TotalKey,
+		Size:        s.TotalSize,
+		Hash:        s.Hash,
+		HashChecked: hashChecked,
+	}, nil
+}
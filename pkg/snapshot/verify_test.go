@@ -0,0 +1,130 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/criticalstack/e2d/pkg/snapshot/crypto"
+	snapshotutil "github.com/criticalstack/e2d/pkg/snapshot/util"
+)
+
+// newTestDatabase returns the contents of a database with the same layout as
+// etcd, containing a single key at the provided revision.
+func newTestDatabase(t *testing.T, dir string, rev int64) []byte {
+	t.Helper()
+
+	path := filepath.Join(dir, "db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("key"))
+		if err != nil {
+			return err
+		}
+		k := make([]byte, 17)
+		binary.BigEndian.PutUint64(k, uint64(rev))
+		k[8] = '_'
+		return b.Put(k, []byte("value"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifyDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := newTestDatabase(t, dir, 5)
+	h := sha256.Sum256(data)
+	withHash := append(append([]byte{}, data...), h[:]...)
+	key := crypto.NewEncryptionKey()
+
+	encode := func(data []byte) []byte {
+		r := snapshotutil.NewEncrypterReadCloser(ioutil.NopCloser(bytes.NewReader(data)), crypto.NewKeyring(key))
+		r = snapshotutil.NewGzipReadCloser(r)
+		defer r.Close()
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	path := filepath.Join(dir, "snapshot")
+	extract := func(data []byte, keys *crypto.Keyring) error {
+		return Extract(ioutil.NopCloser(bytes.NewReader(data)), keys, path)
+	}
+
+	if err := extract(encode(withHash), crypto.NewKeyring(key)); err != nil {
+		t.Fatal(err)
+	}
+	status, err := VerifyDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Revision != 5 || status.TotalKeys != 1 || !status.HashChecked {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// snapshots saved by older versions of e2d have no hash
+	if err := extract(data, nil); err != nil {
+		t.Fatal(err)
+	}
+	status, err = VerifyDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Revision != 5 || status.HashChecked {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	withHash[100] ^= 0xff
+	if err := extract(withHash, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyDatabase(path); errors.Cause(err) != ErrDatabaseHashMismatch {
+		t.Fatalf("expected ErrDatabaseHashMismatch, received %v", err)
+	}
+
+	// the key a snapshot was encrypted with is identified, so decrypting
+	// with any other key fails before decrypting anything
+	if err := extract(encode(data), crypto.NewKeyring(crypto.NewEncryptionKey())); errors.Cause(err) != snapshotutil.ErrUnknownKey {
+		t.Fatalf("expected ErrUnknownKey, received %v", err)
+	}
+
+	// older snapshots do not identify their key, so the legacy key is used
+	// and the message authentication fails
+	var legacy bytes.Buffer
+	legacy.WriteString("ENCRYPTED:")
+	size := make([]byte, binary.MaxVarintLen64)
+	legacy.Write(size[:binary.PutVarint(size, int64(len(data)))])
+	if err := crypto.Encrypt(bytes.NewReader(data), &legacy, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := extract(legacy.Bytes(), crypto.NewKeyring(key)); err != nil {
+		t.Fatal(err)
+	}
+	if err := extract(legacy.Bytes(), crypto.NewKeyring(crypto.NewEncryptionKey())); err != crypto.ErrMessageAuthFailed {
+		t.Fatalf("expected ErrMessageAuthFailed, received %v", err)
+	}
+}